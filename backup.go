@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupDirName      = "backups"
+	backupFilePrefix   = "kiki-"
+	backupFileExt      = ".tar.gz"
+	snapshotIDLayout   = "20060102-150405"
+	backupArchiveLimit = 256 << 20
+
+	defaultBackupDaily  = 7
+	defaultBackupWeekly = 4
+)
+
+// Snapshot is a compressed point-in-time copy of all tasks and notes
+type Snapshot struct {
+	ID        string
+	Path      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// SnapshotDiff summarizes what restoring a snapshot would change
+type SnapshotDiff struct {
+	TasksAdded   []string
+	TasksRemoved []string
+	TasksChanged []string
+	NotesAdded   []string
+	NotesRemoved []string
+	NotesChanged []string
+}
+
+// Empty reports whether restoring would change nothing
+func (d SnapshotDiff) Empty() bool {
+	return len(d.TasksAdded)+len(d.TasksRemoved)+len(d.TasksChanged)+
+		len(d.NotesAdded)+len(d.NotesRemoved)+len(d.NotesChanged) == 0
+}
+
+// GetBackupDir returns the directory that holds snapshots
+func GetBackupDir() string {
+	return filepath.Join(GetConfigDir(), backupDirName)
+}
+
+// ListSnapshots returns all snapshots, newest first
+func ListSnapshots() ([]Snapshot, error) {
+	return listSnapshotsIn(GetBackupDir())
+}
+
+func listSnapshotsIn(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup dir: %w", err)
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileExt) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, backupFilePrefix), backupFileExt)
+		createdAt, err := time.ParseInLocation(snapshotIDLayout, id, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		snapshots = append(snapshots, Snapshot{
+			ID:        id,
+			Path:      filepath.Join(dir, name),
+			CreatedAt: createdAt,
+			Size:      info.Size(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// CreateSnapshot writes a snapshot of the current tasks and notes
+func CreateSnapshot(repo Repository) (*Snapshot, error) {
+	return createSnapshotAt(repo, time.Now())
+}
+
+func createSnapshotAt(repo Repository, at time.Time) (*Snapshot, error) {
+	tasks, err := repo.LoadTasks()
+	if err != nil {
+		return nil, err
+	}
+	notes, err := repo.LoadNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	vault := NewVault()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name string
+		list any
+	}{
+		{tasksFile, tasks},
+		{notesFile, notes},
+	}
+	for _, f := range files {
+		data, err := json.MarshalIndent(f.list, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize %s: %w", f.name, err)
+		}
+		if data, err = vault.Seal(data); err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", f.name, err)
+		}
+		header := &tar.Header{Name: f.name, Mode: dataFilePerm, Size: int64(len(data)), ModTime: at}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	if err := errors.Join(tw.Close(), gz.Close()); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(GetBackupDir(), configDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create backup dir: %w", err)
+	}
+	id := at.Format(snapshotIDLayout)
+	path := filepath.Join(GetBackupDir(), backupFilePrefix+id+backupFileExt)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("snapshot %s already exists", id)
+	}
+	if err := writeFileAtomic(path, buf.Bytes(), dataFilePerm); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{ID: id, Path: path, CreatedAt: at, Size: int64(buf.Len())}, nil
+}
+
+// EnsureDailySnapshot creates today's snapshot if there is none yet and prunes
+// old snapshots. The bool return reports whether a snapshot was created.
+func EnsureDailySnapshot(repo Repository) (*Snapshot, bool, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(snapshots) > 0 && isTodayTime(snapshots[0].CreatedAt) {
+		return &snapshots[0], false, nil
+	}
+
+	snapshot, err := CreateSnapshot(repo)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := PruneSnapshots(); err != nil {
+		return snapshot, true, err
+	}
+	return snapshot, true, nil
+}
+
+// PruneSnapshots deletes snapshots outside the daily and weekly retention
+// windows and returns the IDs it removed
+func PruneSnapshots() ([]string, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	keep := snapshotsToKeep(snapshots, appConfig.BackupDaily, appConfig.BackupWeekly)
+	var removed []string
+	for _, s := range snapshots {
+		if keep[s.ID] {
+			continue
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", s.ID, err)
+		}
+		removed = append(removed, s.ID)
+	}
+	return removed, nil
+}
+
+// snapshotsToKeep picks the newest snapshot of each of the last daily days
+// and of each of the last weekly ISO weeks. snapshots must be newest first.
+func snapshotsToKeep(snapshots []Snapshot, daily, weekly int) map[string]bool {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, s := range snapshots {
+		day := s.CreatedAt.Format(dateLayout)
+		if !days[day] && len(days) < daily {
+			days[day] = true
+			keep[s.ID] = true
+		}
+		year, week := s.CreatedAt.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < weekly {
+			weeks[weekKey] = true
+			keep[s.ID] = true
+		}
+	}
+	return keep
+}
+
+// FindSnapshot returns the snapshot with the given ID
+func FindSnapshot(id string) (*Snapshot, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.ID == id {
+			return &s, nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot with id %q", id)
+}
+
+// ReadSnapshot loads the tasks and notes stored in a snapshot
+func ReadSnapshot(snapshot *Snapshot) (*TaskList, *NoteList, error) {
+	return readSnapshot(snapshot, NewVault())
+}
+
+func readSnapshot(snapshot *Snapshot, vault *Vault) (*TaskList, *NoteList, error) {
+	f, err := os.Open(snapshot.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	tasks := &TaskList{Tasks: []Task{}}
+	notes := &NoteList{Notes: []Note{}}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, backupArchiveLimit))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+
+		var target any
+		var migrations []schemaMigration
+		switch header.Name {
+		case tasksFile:
+			target, migrations = tasks, taskSchemaMigrations
+		case notesFile:
+			target, migrations = notes, noteSchemaMigrations
+		default:
+			continue
+		}
+		if data, err = vault.Open(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt %s in snapshot: %w", header.Name, err)
+		}
+		// Snapshots keep the schema of the day they were taken, so older ones
+		// are migrated like data files and newer ones refused
+		if err := decodeDataFile(data, migrations, target); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s in snapshot: %w", header.Name, err)
+		}
+	}
+	return tasks, notes, nil
+}
+
+// DiffSnapshot compares a snapshot against the current data
+func DiffSnapshot(repo Repository, snapshot *Snapshot) (*SnapshotDiff, error) {
+	snapTasks, snapNotes, err := ReadSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	curTasks, err := repo.LoadTasks()
+	if err != nil {
+		return nil, err
+	}
+	curNotes, err := repo.LoadNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SnapshotDiff{}
+	diff.TasksAdded, diff.TasksRemoved, diff.TasksChanged, err = diffTitles(curTasks.Tasks, snapTasks.Tasks,
+		func(t Task) (string, string) { return t.ID, t.Title })
+	if err != nil {
+		return nil, err
+	}
+	diff.NotesAdded, diff.NotesRemoved, diff.NotesChanged, err = diffTitles(curNotes.Notes, snapNotes.Notes,
+		func(n Note) (string, string) { return n.ID, n.Title })
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// diffTitles returns the titles of records that restoring target over current
+// would add, remove, or change
+func diffTitles[T any](current, target []T, key func(T) (string, string)) ([]string, []string, []string, error) {
+	currentImages := make(map[string][]byte, len(current))
+	currentTitles := make(map[string]string, len(current))
+	for _, rec := range current {
+		id, title := key(rec)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to serialize record: %w", err)
+		}
+		currentImages[id] = data
+		currentTitles[id] = title
+	}
+
+	var added, removed, changed []string
+	seen := make(map[string]bool, len(target))
+	for _, rec := range target {
+		id, title := key(rec)
+		seen[id] = true
+		old, exists := currentImages[id]
+		if !exists {
+			added = append(added, title)
+			continue
+		}
+		if !sameJSON(old, rec) {
+			changed = append(changed, title)
+		}
+	}
+	for _, rec := range current {
+		id, _ := key(rec)
+		if !seen[id] {
+			removed = append(removed, currentTitles[id])
+		}
+	}
+	return added, removed, changed, nil
+}
+
+// RestoreSnapshot replaces all tasks and notes with the snapshot contents.
+// The change is journaled as one entry, so a single kiki undo reverts it.
+func RestoreSnapshot(repo *JournaledRepository, snapshot *Snapshot) error {
+	tasks, notes, err := ReadSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	return repo.Replace(tasks, notes, "restore_snapshot: "+snapshot.ID)
+}
+
+// rewriteSnapshot passes the contents of every file in a snapshot through fn
+// and replaces the archive with the result
+func rewriteSnapshot(path string, fn func(data []byte) ([]byte, error)) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	var buf bytes.Buffer
+	out := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(out)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, backupArchiveLimit))
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		if data, err = fn(data); err != nil {
+			return fmt.Errorf("%s in snapshot: %w", header.Name, err)
+		}
+		header.Size = int64(len(data))
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	if err := errors.Join(tw.Close(), out.Close()); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return writeFileAtomic(path, buf.Bytes(), dataFilePerm)
+}
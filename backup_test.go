@@ -0,0 +1,259 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestSnapshot archives the given files as a snapshot
+func writeTestSnapshot(t *testing.T, files map[string]string) *Snapshot {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "20260101-000000.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content))}); err != nil {
+			t.Fatalf("failed to write snapshot header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write snapshot: %v", err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			t.Fatalf("failed to close snapshot: %v", err)
+		}
+	}
+	return &Snapshot{ID: "20260101-000000", Path: path}
+}
+
+func TestSnapshotsToKeep(t *testing.T) {
+	t.Run("keeps newest per day and per week within the limits", func(t *testing.T) {
+		// arrange
+		base := time.Date(2026, 3, 20, 12, 0, 0, 0, time.Local) // Friday
+		var snapshots []Snapshot
+		for i := 0; i < 30; i++ {
+			at := base.AddDate(0, 0, -i)
+			snapshots = append(snapshots, Snapshot{ID: at.Format(snapshotIDLayout), CreatedAt: at})
+		}
+		extra := base.Add(-time.Hour)
+		snapshots = append([]Snapshot{snapshots[0], {ID: extra.Format(snapshotIDLayout), CreatedAt: extra}}, snapshots[1:]...)
+
+		// act
+		keep := snapshotsToKeep(snapshots, 3, 2)
+
+		// assert
+		for i := 0; i < 3; i++ {
+			id := base.AddDate(0, 0, -i).Format(snapshotIDLayout)
+			if !keep[id] {
+				t.Fatalf("expected daily snapshot %s to be kept", id)
+			}
+		}
+		if keep[extra.Format(snapshotIDLayout)] {
+			t.Fatalf("expected older snapshot from the same day to be pruned")
+		}
+		// The previous ISO week ends on Sunday 2026-03-15
+		lastWeek := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local).Format(snapshotIDLayout)
+		if !keep[lastWeek] {
+			t.Fatalf("expected weekly snapshot %s to be kept", lastWeek)
+		}
+		if len(keep) != 4 {
+			t.Fatalf("expected 4 snapshots kept, got %d", len(keep))
+		}
+	})
+}
+
+func TestSnapshots(t *testing.T) {
+	t.Run("create, diff and restore a snapshot", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"keep", "delete later"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		if _, err := repo.AddNote("note", "content", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		snapshot, err := CreateSnapshot(repo)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		err = repo.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Completed = true
+			tasks.Tasks = tasks.Tasks[:1]
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to modify tasks: %v", err)
+		}
+		if _, err := repo.AddTask("new", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+
+		// act
+		diff, err := DiffSnapshot(repo, snapshot)
+		if err != nil {
+			t.Fatalf("failed to diff snapshot: %v", err)
+		}
+		restoreErr := RestoreSnapshot(repo, snapshot)
+
+		// assert
+		if !equalStrings(diff.TasksAdded, []string{"delete later"}) {
+			t.Fatalf("expected [delete later] added back, got %v", diff.TasksAdded)
+		}
+		if !equalStrings(diff.TasksRemoved, []string{"new"}) {
+			t.Fatalf("expected [new] removed, got %v", diff.TasksRemoved)
+		}
+		if !equalStrings(diff.TasksChanged, []string{"keep"}) {
+			t.Fatalf("expected [keep] changed, got %v", diff.TasksChanged)
+		}
+		if len(diff.NotesAdded)+len(diff.NotesRemoved)+len(diff.NotesChanged) != 0 {
+			t.Fatalf("expected no note changes, got %+v", diff)
+		}
+		if restoreErr != nil {
+			t.Fatalf("failed to restore snapshot: %v", restoreErr)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"keep", "delete later"}) {
+			t.Fatalf("expected snapshot tasks restored, got %v", got)
+		}
+	})
+
+	t.Run("restoring tasks and notes is one change that one undo reverts", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("before", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		snapshot, err := CreateSnapshot(repo)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		if _, err := repo.AddTask("after", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddNote("after", "", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		if err := RestoreSnapshot(repo, snapshot); err != nil {
+			t.Fatalf("failed to restore snapshot: %v", err)
+		}
+
+		// act
+		reverted, err := repo.Undo(1)
+
+		// assert
+		if err != nil || len(reverted) != 1 || reverted[0].Summary != "restore_snapshot: "+snapshot.ID {
+			t.Fatalf("expected the restore undone, got %+v: %v", reverted, err)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"before", "after"}) {
+			t.Fatalf("expected both tasks back, got %v", got)
+		}
+		notes, err := repo.LoadNotes()
+		if err != nil || len(notes.Notes) != 1 {
+			t.Fatalf("expected the note back, got %+v: %v", notes, err)
+		}
+		added, err := repo.AddTask("next", nil, "", nil)
+		if err != nil || added.ShortID() != "t3" {
+			t.Fatalf("expected short IDs to keep counting past the restore, got %+v: %v", added, err)
+		}
+	})
+
+	t.Run("a failed notes restore puts the tasks back", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		snapshot, err := CreateSnapshot(repo)
+		if err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		if _, err := repo.AddTask("current", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddNote("current", "", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		failing := NewJournaledRepository(failingSaveRepository{Repository: repo.Repository, failNotes: true},
+			repo.journal, newTestLogger())
+
+		// act
+		err = RestoreSnapshot(failing, snapshot)
+
+		// assert
+		if err == nil {
+			t.Fatalf("expected the failed notes save to be reported")
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"current"}) {
+			t.Fatalf("expected the tasks rolled back, got %v", got)
+		}
+		items, err := repo.History(1)
+		if err != nil || len(items) != 1 || items[0].Entry.Summary != "add_note: current" {
+			t.Fatalf("expected the failed restore left out of history, got %+v: %v", items, err)
+		}
+	})
+
+	t.Run("reading an older snapshot migrates it", func(t *testing.T) {
+		// arrange
+		snapshot := writeTestSnapshot(t, map[string]string{tasksFile: `{"schema_version": 3, "tasks": [
+			{"id": "a", "title": "Old", "created_at": "2026-01-01T00:00:00Z"}]}`})
+
+		// act
+		tasks, _, err := ReadSnapshot(snapshot)
+
+		// assert
+		if err != nil || len(tasks.Tasks) != 1 || tasks.Tasks[0].ShortID() != "t1" || tasks.LastNumber != 1 {
+			t.Fatalf("expected the task numbered t1, got %+v: %v", tasks, err)
+		}
+	})
+
+	t.Run("reading a snapshot from a newer version fails", func(t *testing.T) {
+		// arrange
+		snapshot := writeTestSnapshot(t, map[string]string{notesFile: `{"schema_version": 99, "notes": []}`})
+
+		// act
+		_, _, err := ReadSnapshot(snapshot)
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "newer than supported") {
+			t.Fatalf("expected a newer schema error, got %v", err)
+		}
+	})
+
+	t.Run("EnsureDailySnapshot creates at most one snapshot per day", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+
+		// act
+		first, createdFirst, err := EnsureDailySnapshot(repo)
+		if err != nil {
+			t.Fatalf("failed to ensure snapshot: %v", err)
+		}
+		second, createdSecond, err := EnsureDailySnapshot(repo)
+		if err != nil {
+			t.Fatalf("failed to ensure snapshot: %v", err)
+		}
+
+		// assert
+		if !createdFirst || createdSecond {
+			t.Fatalf("expected only the first call to create a snapshot")
+		}
+		if first.ID != second.ID {
+			t.Fatalf("expected the same snapshot, got %s and %s", first.ID, second.ID)
+		}
+		snapshots, err := ListSnapshots()
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %v", err)
+		}
+		if len(snapshots) != 1 {
+			t.Fatalf("expected 1 snapshot, got %d", len(snapshots))
+		}
+	})
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	copilot "github.com/github/copilot-sdk/go"
+	"golang.org/x/term"
+)
+
+const (
+	chatPrompt         = "kiki> "
+	chatContinuePrompt = "  ... "
+	chatHistoryFile    = "chat_history"
+	chatHistoryLimit   = 500
+	stateDirPerm       = 0o700
+)
+
+const chatHelp = `Type a prompt and press Enter. End a line with \ to continue it on the next one;
+pasted text is sent when you press Enter after it.
+
+  /tasks [filter]   list tasks (all, today, incomplete or completed)
+  /model [name]     show the model, or start a new session with another one
+  /refresh          start a new session for today
+  /help             show this help
+  /quit             leave the chat (or press Ctrl+D)`
+
+// GetStateDir returns the directory for machine-local state such as input
+// history: $XDG_STATE_HOME/kiki, or ~/.local/state/kiki
+func GetStateDir() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("get home dir: %w", err)
+		}
+		stateHome = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateHome, kikiDir), nil
+}
+
+// Chat keeps one Copilot session open across many prompts
+type Chat struct {
+	kiki         *Kiki
+	session      *copilot.Session
+	systemPrompt string
+	// resumed is set until the system prompt has been re-injected into a resumed session
+	resumed bool
+}
+
+// StartChat opens today's session for a chat
+func (k *Kiki) StartChat() (*Chat, error) {
+	c := &Chat{kiki: k}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Chat) open() error {
+	c.systemPrompt = systemPrompt()
+	session, resumed, err := c.kiki.getOrCreateSession(c.systemPrompt)
+	if err != nil {
+		return err
+	}
+	c.session, c.resumed = session, resumed
+	return nil
+}
+
+// Send streams the response to prompt to out, using the streaming handler of Kiki.Run
+func (c *Chat) Send(prompt string, out io.Writer) (string, error) {
+	if c.resumed {
+		prompt = fmt.Sprintf("%s\n\n%s", c.systemPrompt, prompt)
+	}
+	response, err := c.kiki.send(c.session, prompt, out)
+	if err != nil {
+		return "", err
+	}
+	c.resumed = false
+	return response, nil
+}
+
+// Refresh deletes today's session, like 'kiki refresh', and opens a new one
+func (c *Chat) Refresh() (bool, error) {
+	c.kiki.destroySession(c.session)
+	found, err := c.kiki.RefreshSession()
+	if err != nil {
+		return false, errors.Join(fmt.Errorf("refreshing session: %w", err), c.open())
+	}
+	return found, c.open()
+}
+
+// Model returns the model new sessions are created with
+func (c *Chat) Model() string {
+	return c.kiki.model
+}
+
+// SetModel switches to model. A session keeps the model it was created with,
+// so this starts a new session.
+func (c *Chat) SetModel(model string) error {
+	models, err := c.kiki.client.ListModels()
+	if err != nil {
+		return fmt.Errorf("listing models: %w", err)
+	}
+	if err := saveModelCache(models); err != nil {
+		c.kiki.logger.Error("failed to cache models", "error", err)
+	}
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.ID)
+	}
+	if !containsString(ids, model) {
+		return fmt.Errorf("model %s is not available (available: %s)", model, strings.Join(ids, ", "))
+	}
+	c.kiki.model = model
+	_, err = c.Refresh()
+	return err
+}
+
+// Close releases the session. The conversation stays on the server for later prompts.
+func (c *Chat) Close() {
+	c.kiki.destroySession(c.session)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// conversation is the part of Chat the REPL drives
+type conversation interface {
+	Send(prompt string, out io.Writer) (string, error)
+	Refresh() (bool, error)
+	Model() string
+	SetModel(model string) error
+}
+
+// lineReader reads one line of chat input. pasted reports that the line was
+// part of a paste, which the REPL treats as a continued line.
+type lineReader interface {
+	ReadLine(prompt string) (line string, pasted bool, err error)
+}
+
+// terminalReader edits lines in a terminal, with input history. The terminal
+// is in raw mode only while a line is read, so responses print normally.
+type terminalReader struct {
+	fd   int
+	term *term.Terminal
+}
+
+func newTerminalReader(in, out *os.File, history term.History) *terminalReader {
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, out}, chatPrompt)
+	t.History = history
+	return &terminalReader{fd: int(in.Fd()), term: t}
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, bool, error) {
+	state, err := term.MakeRaw(r.fd)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to enter raw mode: %w", err)
+	}
+	defer func() { _ = term.Restore(r.fd, state) }()
+	if width, height, err := term.GetSize(r.fd); err == nil {
+		_ = r.term.SetSize(width, height)
+	}
+	r.term.SetBracketedPasteMode(true)
+	defer r.term.SetBracketedPasteMode(false)
+
+	r.term.SetPrompt(prompt)
+	line, err := r.term.ReadLine()
+	if errors.Is(err, term.ErrPasteIndicator) {
+		return line, true, nil
+	}
+	return line, false, err
+}
+
+// plainReader reads piped input without prompts or editing
+type plainReader struct {
+	r *bufio.Reader
+}
+
+func (r *plainReader) ReadLine(string) (string, bool, error) {
+	line, err := r.r.ReadString('\n')
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), false, nil
+}
+
+// chatHistory keeps the most recent input lines in a file, so history survives between chats
+type chatHistory struct {
+	path    string
+	entries []string // oldest first
+	logger  *slog.Logger
+}
+
+// loadChatHistory reads the history file, dropping entries past chatHistoryLimit
+func loadChatHistory(path string, logger *slog.Logger) (*chatHistory, error) {
+	h := &chatHistory{path: path, logger: logger}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat history: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > chatHistoryLimit {
+		h.entries = h.entries[len(h.entries)-chatHistoryLimit:]
+		if err := writeFileAtomic(path, []byte(strings.Join(h.entries, "\n")+"\n"), dataFilePerm); err != nil {
+			return nil, fmt.Errorf("failed to trim chat history: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// Add records a line, skipping blanks and repeats of the previous line
+func (h *chatHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > chatHistoryLimit {
+		h.entries = h.entries[1:]
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, dataFilePerm)
+	if err != nil {
+		h.logger.Error("failed to open chat history", "error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, entry); err != nil {
+		h.logger.Error("failed to write chat history", "error", err)
+	}
+}
+
+func (h *chatHistory) Len() int {
+	return len(h.entries)
+}
+
+// At returns the idx-th most recent entry
+func (h *chatHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// chatREPL reads prompts and slash commands until /quit or end of input
+type chatREPL struct {
+	chat  conversation
+	tools *ToolHandler
+	in    lineReader
+	out   io.Writer
+}
+
+func (r *chatREPL) run() error {
+	for {
+		input, err := r.readInput()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+
+		input = strings.TrimSpace(input)
+		switch {
+		case input == "":
+			continue
+		case strings.HasPrefix(input, "/"):
+			quit, err := r.command(input)
+			if err != nil {
+				if _, err := fmt.Fprintf(r.out, "❌ %v\n", err); err != nil {
+					return fmt.Errorf("writing chat output: %w", err)
+				}
+			}
+			if quit {
+				return nil
+			}
+		default:
+			if _, err := r.chat.Send(input, r.out); err != nil {
+				if _, err := fmt.Fprintf(r.out, "❌ %v\n", err); err != nil {
+					return fmt.Errorf("writing chat output: %w", err)
+				}
+			}
+		}
+	}
+}
+
+// readInput reads one prompt, joining lines that end with a backslash or were pasted together
+func (r *chatREPL) readInput() (string, error) {
+	var lines []string
+	prompt := chatPrompt
+	for {
+		line, pasted, err := r.in.ReadLine(prompt)
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+		continued := strings.HasSuffix(line, `\`)
+		if continued {
+			line = strings.TrimSuffix(line, `\`)
+		}
+		lines = append(lines, line)
+		if !continued && !pasted {
+			return strings.Join(lines, "\n"), nil
+		}
+		prompt = chatContinuePrompt
+	}
+}
+
+// command runs a slash command and reports whether the chat should end
+func (r *chatREPL) command(input string) (bool, error) {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/quit", "/exit":
+		return true, nil
+	case "/help":
+		_, err := fmt.Fprintln(r.out, chatHelp)
+		return false, err
+	case "/refresh":
+		found, err := r.chat.Refresh()
+		if err != nil {
+			return false, err
+		}
+		message := "🔄 Started a new session."
+		if !found {
+			message = "🔄 No session to refresh; this one is new."
+		}
+		_, err = fmt.Fprintln(r.out, message)
+		return false, err
+	case "/model":
+		if arg == "" {
+			_, err := fmt.Fprintf(r.out, "Model: %s\n", r.chat.Model())
+			return false, err
+		}
+		if err := r.chat.SetModel(arg); err != nil {
+			return false, err
+		}
+		_, err := fmt.Fprintf(r.out, "🔄 Started a new session with %s.\n", arg)
+		return false, err
+	case "/tasks":
+		filter := arg
+		if filter == "" {
+			filter = "incomplete"
+		}
+		result, err := r.tools.listTasks(ListTasksParams{Filter: filter})
+		if err != nil {
+			return false, fmt.Errorf("listing tasks: %w", err)
+		}
+		return false, printTasks(r.out, result.Tasks)
+	default:
+		return false, fmt.Errorf("unknown command %s; type /help for the list", name)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeConversation struct {
+	prompts   []string
+	model     string
+	refreshes int
+}
+
+func (f *fakeConversation) Send(prompt string, out io.Writer) (string, error) {
+	f.prompts = append(f.prompts, prompt)
+	return "ok", nil
+}
+
+func (f *fakeConversation) Refresh() (bool, error) {
+	f.refreshes++
+	return true, nil
+}
+
+func (f *fakeConversation) Model() string { return f.model }
+
+func (f *fakeConversation) SetModel(model string) error {
+	if model != "gpt-4.1" {
+		return errors.New("model is not available")
+	}
+	f.model = model
+	return nil
+}
+
+func newTestREPL(t *testing.T, input string) (*chatREPL, *fakeConversation, *strings.Builder) {
+	t.Helper()
+	chat := &fakeConversation{model: defaultModel}
+	out := &strings.Builder{}
+	repl := &chatREPL{
+		chat:  chat,
+		tools: NewToolHandler(newTestJournaledRepository(t), newTestLogger()),
+		in:    &plainReader{r: bufio.NewReader(strings.NewReader(input))},
+		out:   out,
+	}
+	return repl, chat, out
+}
+
+func TestChatREPL(t *testing.T) {
+	t.Run("sends prompts and joins continued lines", func(t *testing.T) {
+		// arrange
+		repl, chat, _ := newTestREPL(t, "add task: buy milk\n\nnote: first line\\\nsecond line\n")
+
+		// act
+		err := repl.run()
+
+		// assert
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(chat.prompts) != 2 || chat.prompts[1] != "note: first line\nsecond line" {
+			t.Fatalf("unexpected prompts: %q", chat.prompts)
+		}
+	})
+
+	t.Run("runs slash commands until /quit", func(t *testing.T) {
+		// arrange
+		repl, chat, out := newTestREPL(t, "/tasks\n/model gpt-4.1\n/model nope\n/refresh\n/bogus\n/quit\nnever sent\n")
+		if _, err := repl.tools.addTask(AddTaskParams{Title: "Water plants"}); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+
+		// act
+		err := repl.run()
+
+		// assert
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(chat.prompts) != 0 || chat.model != "gpt-4.1" || chat.refreshes != 1 {
+			t.Fatalf("unexpected chat state: %+v", chat)
+		}
+		for _, want := range []string{"t1 [ ] Water plants", "gpt-4.1", "not available", "unknown command /bogus"} {
+			if !strings.Contains(out.String(), want) {
+				t.Fatalf("expected output to contain %q, got:\n%s", want, out.String())
+			}
+		}
+	})
+}
+
+func TestChatHistory(t *testing.T) {
+	t.Run("persists entries between chats and keeps the newest", func(t *testing.T) {
+		// arrange
+		path := filepath.Join(t.TempDir(), chatHistoryFile)
+		var lines []string
+		for i := 0; i < chatHistoryLimit+5; i++ {
+			lines = append(lines, "old")
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), dataFilePerm); err != nil {
+			t.Fatalf("failed to write history: %v", err)
+		}
+		history, err := loadChatHistory(path, newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+
+		// act
+		history.Add("list my tasks")
+		history.Add("list my tasks")
+		history.Add("  ")
+		reloaded, err := loadChatHistory(path, newTestLogger())
+
+		// assert
+		if err != nil {
+			t.Fatalf("failed to reload history: %v", err)
+		}
+		if reloaded.Len() != chatHistoryLimit || reloaded.At(0) != "list my tasks" || reloaded.At(1) != "old" {
+			t.Fatalf("unexpected history: %d entries, newest %q", reloaded.Len(), reloaded.At(0))
+		}
+	})
+}
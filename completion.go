@@ -0,0 +1,420 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	copilot "github.com/github/copilot-sdk/go"
+	"github.com/spf13/cobra"
+)
+
+const (
+	modelCacheFile = "models.json"
+	// modelCacheTTL is how long the cached model list is used before Kiki refreshes it
+	modelCacheTTL = 24 * time.Hour
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate a shell completion script",
+	Long: `Prints a completion script for your shell. Besides commands and flags it
+completes profile names, --model values, task and note titles and IDs, list
+filters and existing tags. Completion reads the data files directly and never
+starts Copilot; encrypted data is only completed while the key is cached.
+
+  bash:        source <(kiki completion bash)
+  zsh:         kiki completion zsh > "${fpath[1]}/_kiki"
+  fish:        kiki completion fish > ~/.config/fish/completions/kiki.fish
+  powershell:  kiki completion powershell | Out-String | Invoke-Expression`,
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCompletion(cmd.Root(), args[0])
+	},
+}
+
+func runCompletion(root *cobra.Command, shell string) error {
+	var err error
+	switch shell {
+	case "bash":
+		err = root.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		err = root.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = root.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		err = root.GenPowerShellCompletionWithDesc(os.Stdout)
+	}
+	if err != nil {
+		return fmt.Errorf("writing completion output: %w", err)
+	}
+	return nil
+}
+
+// registerCompletions adds the dynamic completions to the commands defined in main.go
+func registerCompletions() {
+	for _, cmd := range []*cobra.Command{rootCmd, chatCmd, tuiCmd, doctorCmd} {
+		_ = cmd.RegisterFlagCompletionFunc("model", completeModels)
+	}
+	_ = rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles(true))
+	_ = rootCmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(
+		[]string{outputText, outputJSON, outputQuiet}, cobra.ShellCompDirectiveNoFileComp))
+	_ = migrateCmd.RegisterFlagCompletionFunc("to", cobra.FixedCompletions(
+		[]string{backendSQLite}, cobra.ShellCompDirectiveNoFileComp))
+	for _, cmd := range []*cobra.Command{taskLsCmd, searchCmd} {
+		_ = cmd.RegisterFlagCompletionFunc("scope", cobra.FixedCompletions(
+			[]string{storeProject, storeGlobal, scopeAll}, cobra.ShellCompDirectiveNoFileComp))
+	}
+	for _, cmd := range []*cobra.Command{taskAddCmd, taskEditCmd} {
+		_ = cmd.RegisterFlagCompletionFunc("priority", cobra.FixedCompletions(
+			validPriorities, cobra.ShellCompDirectiveNoFileComp))
+		_ = cmd.RegisterFlagCompletionFunc("due", cobra.NoFileCompletions)
+	}
+	for _, cmd := range []*cobra.Command{taskAddCmd, taskEditCmd, noteAddCmd, noteLsCmd} {
+		_ = cmd.RegisterFlagCompletionFunc("tag", completeTags)
+	}
+
+	profileUseCmd.ValidArgsFunction = completeProfiles(true)
+	profileDeleteCmd.ValidArgsFunction = completeProfiles(false)
+	taskDoneCmd.ValidArgsFunction = completeRecords(journalKindTasks, func(r completionRecord) bool {
+		return !r.Completed && !r.Trashed()
+	})
+	taskRmCmd.ValidArgsFunction = completeRecords(journalKindTasks, completionRecord.Live)
+	taskEditCmd.ValidArgsFunction = completeRecords(journalKindTasks, completionRecord.Live)
+	noteShowCmd.ValidArgsFunction = completeRecords(journalKindNotes, completionRecord.Live)
+	noteRmCmd.ValidArgsFunction = completeRecords(journalKindNotes, completionRecord.Live)
+	trashRestoreCmd.ValidArgsFunction = completeRecords("", completionRecord.Trashed)
+	configGetCmd.ValidArgsFunction = completeConfig
+	configSetCmd.ValidArgsFunction = completeConfig
+}
+
+// completeConfig completes a setting name, then the values it accepts
+func completeConfig(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch {
+	case len(args) == 0:
+		var keys []string
+		for _, s := range configSettings {
+			keys = append(keys, s.Key+"\t"+s.Description)
+		}
+		return keys, cobra.ShellCompDirectiveNoFileComp
+	case len(args) > 1 || cmd != configSetCmd:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	switch args[0] {
+	case "model":
+		return completeModels(cmd, args, toComplete)
+	case "log_level":
+		return []string{"debug", "info", "warn", "error"}, cobra.ShellCompDirectiveNoFileComp
+	case "default_priority":
+		return validPriorities, cobra.ShellCompDirectiveNoFileComp
+	case "data_dir":
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	default:
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeProfiles lists the profiles, leaving out the default one when it
+// cannot be chosen, as with 'profile delete'
+func completeProfiles(withDefault bool) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		profiles, err := ListProfiles()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		if !withDefault {
+			profiles = profiles[1:]
+		}
+		return profiles, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeModels lists the models cached the last time Kiki listed them, or the
+// configured model before that has happened
+func completeModels(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cache, err := loadModelCache()
+	if err != nil || len(cache.Models) == 0 {
+		return []string{appConfig.Model}, cobra.ShellCompDirectiveNoFileComp
+	}
+	return cache.Models, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTags lists the tags in use on tasks and notes that are not in the trash
+func completeTags(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	seen := map[string]bool{}
+	var tags []string
+	for _, record := range loadCompletionRecords("") {
+		if record.Trashed() {
+			continue
+		}
+		for _, tag := range record.Tags {
+			if !seen[tag] && strings.HasPrefix(tag, toComplete) {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRecords completes the first argument with the IDs of the records that
+// keep accepts, described by their titles. Once the typed text no longer matches
+// an ID, titles starting with it are offered instead.
+func completeRecords(kind string, keep func(completionRecord) bool) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var records []completionRecord
+		for _, record := range loadCompletionRecords(kind) {
+			if keep(record) {
+				records = append(records, record)
+			}
+		}
+		return matchCompletionRecords(records, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func matchCompletionRecords(records []completionRecord, toComplete string) []string {
+	var ids, titles []string
+	seen := map[string]bool{}
+	prefix := strings.ToLower(toComplete)
+	for _, record := range records {
+		id := record.ShortID()
+		if id == "" {
+			id = record.ID
+		}
+		switch {
+		// A lone t or n is more likely the start of a title than of a short ID
+		case toComplete == "" || len(toComplete) > 1 && strings.HasPrefix(id, prefix):
+			ids = append(ids, id+"\t"+record.Title)
+		case strings.HasPrefix(record.ID, toComplete):
+			ids = append(ids, record.ID+"\t"+record.Title)
+		case strings.HasPrefix(strings.ToLower(record.Title), prefix) && !seen[record.Title]:
+			seen[record.Title] = true
+			titles = append(titles, record.Title)
+		}
+	}
+	if len(ids) > 0 {
+		return ids
+	}
+	return titles
+}
+
+// completionRecord is the part of a task or note that completion needs
+type completionRecord struct {
+	ID        string   `json:"id"`
+	Number    int      `json:"number"`
+	Title     string   `json:"title"`
+	Completed bool     `json:"completed"`
+	Tags      []string `json:"tags"`
+	DeletedAt *string  `json:"deleted_at"`
+	kind      string
+	store     string
+}
+
+// ShortID is the record's short reference, such as t42, or g:t42 for a
+// global record inside a project
+func (r completionRecord) ShortID() string {
+	if r.kind == journalKindNotes {
+		return storeShortID(shortID(noteShortIDPrefix, r.Number), r.store)
+	}
+	return storeShortID(shortID(taskShortIDPrefix, r.Number), r.store)
+}
+
+// Trashed reports whether the record is in the trash
+func (r completionRecord) Trashed() bool {
+	return r.DeletedAt != nil
+}
+
+// Live reports whether the record is not in the trash
+func (r completionRecord) Live() bool {
+	return !r.Trashed()
+}
+
+// loadCompletionRecords reads the tasks or notes, or both when kind is empty,
+// of the active store and, inside a project, the global store. Completion must
+// be quick and must never prompt or write, so records are read straight from
+// the data files: encrypted files are skipped unless the key is cached, and
+// anything that cannot be read is left out.
+func loadCompletionRecords(kind string) []completionRecord {
+	dirs := map[string]string{"": GetConfigDir()}
+	if ProjectDir() != "" {
+		dirs = map[string]string{storeProject: GetConfigDir(), storeGlobal: GetGlobalConfigDir()}
+	}
+	kinds := []string{journalKindTasks, journalKindNotes}
+	if kind != "" {
+		kinds = []string{kind}
+	}
+
+	var records []completionRecord
+	for _, store := range []string{"", storeProject, storeGlobal} {
+		dir, ok := dirs[store]
+		if !ok {
+			continue
+		}
+		for _, k := range kinds {
+			loaded, err := readCompletionRecords(dir, k)
+			if err != nil {
+				continue
+			}
+			for i := range loaded {
+				loaded[i].store = store
+			}
+			records = append(records, loaded...)
+		}
+	}
+	return records
+}
+
+func readCompletionRecords(dir, kind string) ([]completionRecord, error) {
+	if backendAt(dir) == backendSQLite {
+		return readSQLiteCompletionRecords(dir, kind)
+	}
+
+	file := tasksFile
+	if kind == journalKindNotes {
+		file = notesFile
+	}
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	if isSealed(data) {
+		key := newVaultAt(dir).cachedKey()
+		if key == nil {
+			return nil, errors.New("encrypted data is locked")
+		}
+		if data, err = openWithKey(key, data); err != nil {
+			return nil, err
+		}
+	}
+	var list struct {
+		Tasks []completionRecord `json:"tasks"`
+		Notes []completionRecord `json:"notes"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	records := append(list.Tasks, list.Notes...)
+	for i := range records {
+		records[i].kind = kind
+	}
+	return records, nil
+}
+
+// sqliteCompletionQueries select the completion fields of each kind in insertion order
+var sqliteCompletionQueries = map[string]string{
+	journalKindTasks: `SELECT id, number, title, completed, tags, deleted_at FROM tasks ORDER BY seq`,
+	journalKindNotes: `SELECT id, number, title, 0, tags, deleted_at FROM notes ORDER BY seq`,
+}
+
+// readSQLiteCompletionRecords queries the database read-only, without the
+// schema upgrade that opening it for storage does
+func readSQLiteCompletionRecords(dir, kind string) (records []completionRecord, err error) {
+	dsn := fmt.Sprintf("file:%s?mode=ro", filepath.ToSlash(filepath.Join(dir, sqliteFile)))
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, db.Close()) }()
+
+	rows, err := db.Query(sqliteCompletionQueries[kind])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			record = completionRecord{kind: kind}
+			tags   string
+		)
+		if err := rows.Scan(&record.ID, &record.Number, &record.Title, &record.Completed, &tags, &record.DeletedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &record.Tags); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// modelCache is the model list saved for completing --model without starting a client
+type modelCache struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Models    []string  `json:"models"`
+}
+
+func modelCachePath() (string, error) {
+	dir, err := GetStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, modelCacheFile), nil
+}
+
+func loadModelCache() (*modelCache, error) {
+	path, err := modelCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cache modelCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", modelCacheFile, err)
+	}
+	return &cache, nil
+}
+
+// saveModelCache records the available models for completion
+func saveModelCache(models []copilot.ModelInfo) error {
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.ID)
+	}
+	sort.Strings(ids)
+	data, err := json.MarshalIndent(modelCache{FetchedAt: time.Now(), Models: ids}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", modelCacheFile, err)
+	}
+	path, err := modelCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), stateDirPerm); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return writeFileAtomic(path, data, dataFilePerm)
+}
+
+// refreshModelCache lists the models while the client is running anyway, at
+// most once per modelCacheTTL
+func (k *Kiki) refreshModelCache() {
+	if cache, err := loadModelCache(); err == nil && time.Since(cache.FetchedAt) < modelCacheTTL {
+		return
+	}
+	models, err := k.client.ListModels()
+	if err != nil {
+		k.logger.Debug("failed to list models for completion", "error", err)
+		return
+	}
+	if err := saveModelCache(models); err != nil {
+		k.logger.Error("failed to cache models", "error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	copilot "github.com/github/copilot-sdk/go"
+)
+
+func TestCompletion(t *testing.T) {
+	t.Run("completes open task short IDs with titles, then titles", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		open, err := repo.AddTask("Deploy the API", nil, "high", []string{"work"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		done, err := repo.AddTask("Deploy the docs", nil, "low", []string{"docs", "work"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddNote("Deploy checklist", "", []string{"ops"}); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		if _, err := handler.completeTask(CompleteTaskParams{Query: done.ID}); err != nil {
+			t.Fatalf("failed to complete task: %v", err)
+		}
+
+		// act
+		byID, _ := taskDoneCmd.ValidArgsFunction(taskDoneCmd, nil, "")
+		byUUID, _ := taskDoneCmd.ValidArgsFunction(taskDoneCmd, nil, open.ID[:8])
+		byTitle, _ := taskDoneCmd.ValidArgsFunction(taskDoneCmd, nil, "deploy")
+		rm, _ := taskRmCmd.ValidArgsFunction(taskRmCmd, nil, "deploy the")
+		tags, _ := completeTags(taskAddCmd, nil, "")
+
+		// assert
+		if !reflect.DeepEqual(byID, []string{"t1\tDeploy the API"}) {
+			t.Fatalf("expected only the open task, got %v", byID)
+		}
+		if !reflect.DeepEqual(byUUID, []string{open.ID + "\tDeploy the API"}) {
+			t.Fatalf("expected the full ID for an ID prefix, got %v", byUUID)
+		}
+		if !reflect.DeepEqual(byTitle, []string{"Deploy the API"}) {
+			t.Fatalf("expected the title of the open task, got %v", byTitle)
+		}
+		if !reflect.DeepEqual(rm, []string{"Deploy the API", "Deploy the docs"}) {
+			t.Fatalf("expected both task titles, got %v", rm)
+		}
+		if !reflect.DeepEqual(tags, []string{"docs", "ops", "work"}) {
+			t.Fatalf("expected the tags in use, got %v", tags)
+		}
+	})
+
+	t.Run("skips encrypted data while the key is not cached", func(t *testing.T) {
+		// arrange
+		newTestVaultRepository(t)
+		if _, err := EncryptData(newTestLogger(), "correct horse"); err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+		unlocked := loadCompletionRecords(journalKindNotes)
+		if err := NewVault().Lock(); err != nil {
+			t.Fatalf("failed to lock: %v", err)
+		}
+
+		// act
+		locked := loadCompletionRecords(journalKindNotes)
+
+		// assert
+		if len(unlocked) != 1 || unlocked[0].Title != "Infra" {
+			t.Fatalf("expected the note while unlocked, got %+v", unlocked)
+		}
+		if len(locked) != 0 {
+			t.Fatalf("expected nothing while locked, got %+v", locked)
+		}
+	})
+
+	t.Run("completes models from the cache, falling back to the default", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_STATE_HOME", t.TempDir())
+		before, _ := completeModels(rootCmd, nil, "")
+
+		// act
+		err := saveModelCache([]copilot.ModelInfo{{ID: "gpt-5"}, {ID: "claude-sonnet-4"}})
+		after, _ := completeModels(rootCmd, nil, "")
+
+		// assert
+		if err != nil {
+			t.Fatalf("failed to save cache: %v", err)
+		}
+		if !reflect.DeepEqual(before, []string{defaultModel}) {
+			t.Fatalf("expected the default model without a cache, got %v", before)
+		}
+		if !reflect.DeepEqual(after, []string{"claude-sonnet-4", "gpt-5"}) {
+			t.Fatalf("expected the cached models, got %v", after)
+		}
+	})
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	configFile = "config.toml"
+
+	defaultSessionTimeout = "2m"
+	maxPersonaLength      = 1000
+
+	sourceDefault = "default"
+)
+
+// Config holds the settings from KIKI_* environment variables, config.toml
+// and the built-in defaults, in that order of precedence. Commands with a
+// matching flag, such as --model, let the flag win.
+type Config struct {
+	Model           string
+	SessionTimeout  time.Duration
+	DataDir         string
+	LogLevel        slog.Level
+	Persona         string
+	DefaultPriority string
+
+	TrashRetentionDays int
+	BackupDaily        int
+	BackupWeekly       int
+	KeyCacheMinutes    int
+
+	// values holds each setting as written and sources where it came from:
+	// an environment variable, config.toml or the default
+	values  map[string]string
+	sources map[string]string
+}
+
+// Value returns a setting as written
+func (c *Config) Value(key string) string {
+	return c.values[key]
+}
+
+// Source says where a setting came from
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// appConfig is the configuration in effect. It holds the defaults until a
+// command loads the configuration.
+var appConfig = defaultConfig()
+
+// configSetting documents a config.toml key and applies its value to a Config
+type configSetting struct {
+	Key         string
+	Env         string
+	Default     string
+	Description string
+	apply       func(c *Config, value string) error
+}
+
+var configSettings = []configSetting{
+	{
+		Key: "model", Env: "KIKI_MODEL", Default: defaultModel,
+		Description: "Model for new Copilot sessions",
+		apply: func(c *Config, value string) error {
+			if strings.TrimSpace(value) == "" {
+				return errors.New("must not be empty")
+			}
+			c.Model = value
+			return nil
+		},
+	},
+	{
+		Key: "session_timeout", Env: "KIKI_SESSION_TIMEOUT", Default: defaultSessionTimeout,
+		Description: "How long to wait for a response, such as 90s or 5m",
+		apply: func(c *Config, value string) error {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return errors.New("use a positive duration such as 90s or 5m")
+			}
+			c.SessionTimeout = timeout
+			return nil
+		},
+	},
+	{
+		Key: "data_dir", Env: "KIKI_DATA_DIR",
+		Description: "Data directory of the default profile, which also holds profiles/; empty means this directory",
+		apply: func(c *Config, value string) error {
+			dir, err := expandHome(value)
+			if err != nil {
+				return err
+			}
+			if dir != "" && !filepath.IsAbs(dir) {
+				return errors.New("use an absolute path or one starting with ~/")
+			}
+			c.DataDir = dir
+			return nil
+		},
+	},
+	{
+		Key: "log_level", Env: "KIKI_LOG_LEVEL", Default: "info",
+		Description: "Detail written to ~/.kiki/kiki.log: debug, info, warn or error",
+		apply: func(c *Config, value string) error {
+			levels := map[string]slog.Level{
+				"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError,
+			}
+			level, ok := levels[value]
+			if !ok {
+				return errors.New("use debug, info, warn or error")
+			}
+			c.LogLevel = level
+			return nil
+		},
+	},
+	{
+		Key: "persona", Env: "KIKI_PERSONA",
+		Description: "How Kiki should come across, replacing the built-in sarcasm; empty keeps it",
+		apply: func(c *Config, value string) error {
+			if len(value) > maxPersonaLength {
+				return fmt.Errorf("keep it under %d characters", maxPersonaLength)
+			}
+			c.Persona = strings.TrimSpace(value)
+			return nil
+		},
+	},
+	{
+		Key: "default_priority", Env: "KIKI_DEFAULT_PRIORITY", Default: "medium",
+		Description: "Priority of new tasks that do not set one: low, medium or high",
+		apply: func(c *Config, value string) error {
+			if !isValidPriority(value) {
+				return errors.New("use low, medium or high")
+			}
+			c.DefaultPriority = value
+			return nil
+		},
+	},
+	{
+		Key: "trash_retention_days", Env: "KIKI_TRASH_RETENTION_DAYS", Default: strconv.Itoa(defaultTrashRetentionDays),
+		Description: "Days deleted tasks and notes stay in the trash; 0 keeps them forever",
+		apply: func(c *Config, value string) (err error) {
+			c.TrashRetentionDays, err = parseWholeNumber(value)
+			return err
+		},
+	},
+	{
+		Key: "backup_daily", Env: "KIKI_BACKUP_DAILY", Default: strconv.Itoa(defaultBackupDaily),
+		Description: "How many days to keep the newest daily snapshot of",
+		apply: func(c *Config, value string) (err error) {
+			c.BackupDaily, err = parseWholeNumber(value)
+			return err
+		},
+	},
+	{
+		Key: "backup_weekly", Env: "KIKI_BACKUP_WEEKLY", Default: strconv.Itoa(defaultBackupWeekly),
+		Description: "How many weeks to keep the newest weekly snapshot of",
+		apply: func(c *Config, value string) (err error) {
+			c.BackupWeekly, err = parseWholeNumber(value)
+			return err
+		},
+	},
+	{
+		Key: "key_cache_minutes", Env: "KIKI_KEY_CACHE_MINUTES", Default: strconv.Itoa(defaultKeyCacheMins),
+		Description: "Minutes an unlocked encryption key is remembered; 0 asks for the passphrase every time",
+		apply: func(c *Config, value string) (err error) {
+			c.KeyCacheMinutes, err = parseWholeNumber(value)
+			return err
+		},
+	},
+}
+
+// parseWholeNumber reads a count that may be zero but not negative
+func parseWholeNumber(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("use a whole number such as 0 or 7")
+	}
+	return n, nil
+}
+
+func findConfigSetting(key string) (configSetting, bool) {
+	for _, s := range configSettings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return configSetting{}, false
+}
+
+func configKeys() []string {
+	keys := make([]string, 0, len(configSettings))
+	for _, s := range configSettings {
+		keys = append(keys, s.Key)
+	}
+	return keys
+}
+
+func unknownConfigKey(key string) error {
+	return fmt.Errorf("unknown setting '%s': use one of %s", key, strings.Join(configKeys(), ", "))
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home dir: %w", err)
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~")), nil
+}
+
+// ConfigPath returns config.toml in the top-level kiki directory, so the
+// settings apply to every profile
+func ConfigPath() string {
+	return filepath.Join(getKikiRoot(), configFile)
+}
+
+func defaultConfig() *Config {
+	c := &Config{values: map[string]string{}, sources: map[string]string{}}
+	for _, s := range configSettings {
+		_ = s.apply(c, s.Default)
+		c.values[s.Key], c.sources[s.Key] = s.Default, sourceDefault
+	}
+	return c
+}
+
+// LoadConfig reads config.toml and the environment and checks every value
+func LoadConfig() (*Config, error) {
+	path := ConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	file, err := parseConfig(data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{values: map[string]string{}, sources: map[string]string{}}
+	for _, s := range configSettings {
+		value, source := s.Default, sourceDefault
+		if v, ok := file[s.Key]; ok {
+			value, source = v, path
+		}
+		if v := os.Getenv(s.Env); v != "" {
+			value, source = v, s.Env
+		}
+		if err := s.apply(c, value); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s' in %s: %w", s.Key, value, source, err)
+		}
+		c.values[s.Key], c.sources[s.Key] = value, source
+	}
+	return c, nil
+}
+
+// parseConfig decodes config.toml, rejecting unknown keys and values that are not strings
+func parseConfig(data []byte, path string) (map[string]string, error) {
+	var raw map[string]any
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		setting, ok := findConfigSetting(key)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", path, unknownConfigKey(key))
+		}
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a quoted string, like %s = %q", path, key, key, setting.Default)
+		}
+		values[key] = text
+	}
+	return values, nil
+}
+
+// loadAppConfig makes the configuration take effect for the running command.
+// A --model flag that was not given takes the configured model.
+func loadAppConfig() error {
+	c, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("%w (fix it with 'kiki config edit')", err)
+	}
+	appConfig = c
+	logLevel.Set(c.LogLevel)
+	if model == "" {
+		model = c.Model
+	}
+	return nil
+}
+
+// configTemplate documents every setting, commented out at its default
+func configTemplate() []byte {
+	var b bytes.Buffer
+	b.WriteString("# Kiki configuration, shared by every profile.\n")
+	b.WriteString("# Environment variables override these settings, and command-line flags override both.\n")
+	for _, s := range configSettings {
+		fmt.Fprintf(&b, "\n# %s (%s)\n# %s", s.Description, s.Env, formatConfigLine(s.Key, s.Default))
+	}
+	return b.Bytes()
+}
+
+func formatConfigLine(key, value string) string {
+	var b bytes.Buffer
+	_ = toml.NewEncoder(&b).Encode(map[string]string{key: value})
+	return b.String()
+}
+
+// SetConfigValue writes key to config.toml, keeping the rest of the file as it
+// is. An empty value removes the key so the default applies again.
+func SetConfigValue(key, value string) error {
+	setting, ok := findConfigSetting(key)
+	if !ok {
+		return unknownConfigKey(key)
+	}
+	if value != "" {
+		if err := setting.apply(defaultConfig(), value); err != nil {
+			return fmt.Errorf("invalid %s '%s': %w", key, value, err)
+		}
+	}
+
+	path := ConfigPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = configTemplate(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	updated := setConfigLine(data, key, value)
+	if _, err := parseConfig(updated, path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), configDirPerm); err != nil {
+		return fmt.Errorf("failed to create kiki directory: %w", err)
+	}
+	return writeFileAtomic(path, updated, dataFilePerm)
+}
+
+// setConfigLine replaces the line that sets key, or removes it when value is
+// empty. A new key goes after its commented-out default, or else before the
+// first table so it stays at the top level.
+func setConfigLine(data []byte, key, value string) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	line := formatConfigLine(key, value)
+	setsKey := func(l string) bool {
+		name, _, found := strings.Cut(l, "=")
+		return found && strings.TrimSpace(name) == key
+	}
+
+	for i, l := range lines {
+		if setsKey(l) {
+			if value == "" {
+				return []byte(strings.Join(append(lines[:i], lines[i+1:]...), ""))
+			}
+			lines[i] = line
+			return []byte(strings.Join(lines, ""))
+		}
+	}
+	if value == "" {
+		return data
+	}
+
+	at := len(lines)
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "#") && setsKey(strings.TrimPrefix(trimmed, "#")) {
+			at = i + 1
+			break
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			at = i
+			break
+		}
+	}
+	if at > 0 && !strings.HasSuffix(lines[at-1], "\n") {
+		lines[at-1] += "\n"
+	}
+	lines = append(lines[:at], append([]string{line}, lines[at:]...)...)
+	return []byte(strings.Join(lines, ""))
+}
+
+// EditConfig opens config.toml in $VISUAL or $EDITOR, creating it from the
+// documented template first
+func EditConfig() error {
+	path := ConfigPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), configDirPerm); err != nil {
+			return fmt.Errorf("failed to create kiki directory: %w", err)
+		}
+		if err := writeFileAtomic(path, configTemplate(), dataFilePerm); err != nil {
+			return err
+		}
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run editor %s: %w", editor[0], err)
+	}
+	return nil
+}
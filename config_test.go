@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfigFile(t *testing.T, contents string) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, s := range configSettings {
+		t.Setenv(s.Env, "")
+	}
+	if contents == "" {
+		return
+	}
+	if err := os.MkdirAll(getKikiRoot(), configDirPerm); err != nil {
+		t.Fatalf("failed to create config dir: %v", err)
+	}
+	if err := os.WriteFile(ConfigPath(), []byte(contents), dataFilePerm); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+// keepAppConfig restores the loaded settings and the --model flag after commands under test load config.toml
+func keepAppConfig(t *testing.T) {
+	t.Helper()
+	saved, savedModel, savedLevel := appConfig, model, logLevel.Level()
+	t.Cleanup(func() {
+		appConfig, model = saved, savedModel
+		logLevel.Set(savedLevel)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("environment overrides the file, which overrides the defaults", func(t *testing.T) {
+		// arrange
+		newTestConfigFile(t, "model = \"gpt-5-mini\"\nsession_timeout = \"90s\"\n")
+		t.Setenv("KIKI_SESSION_TIMEOUT", "5m")
+
+		// act
+		config, err := LoadConfig()
+
+		// assert
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if config.Model != "gpt-5-mini" || config.Source("model") != ConfigPath() {
+			t.Fatalf("expected the model from the file, got %s from %s", config.Model, config.Source("model"))
+		}
+		if config.SessionTimeout != 5*time.Minute || config.Source("session_timeout") != "KIKI_SESSION_TIMEOUT" {
+			t.Fatalf("expected the timeout from the environment, got %s", config.SessionTimeout)
+		}
+		if config.DefaultPriority != "medium" || config.Source("default_priority") != sourceDefault {
+			t.Fatalf("expected the default priority, got %s", config.DefaultPriority)
+		}
+	})
+
+	t.Run("rejects invalid values, unknown keys and non-strings", func(t *testing.T) {
+		cases := map[string]string{
+			"default_priority = \"urgent\"\n": "invalid default_priority 'urgent'",
+			"backup_daily = \"-1\"\n":         "invalid backup_daily '-1'",
+			"colour = \"blue\"\n":             "unknown setting 'colour'",
+			"session_timeout = 120\n":         "session_timeout must be a quoted string",
+			"model = \"gpt\n":                 "failed to parse",
+		}
+		for contents, want := range cases {
+			// arrange
+			newTestConfigFile(t, contents)
+
+			// act
+			_, err := LoadConfig()
+
+			// assert
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected %q for %q, got %v", want, contents, err)
+			}
+		}
+	})
+}
+
+func TestSetConfigValue(t *testing.T) {
+	t.Run("creates the documented file and edits single lines", func(t *testing.T) {
+		// arrange
+		newTestConfigFile(t, "")
+
+		// act
+		setErr := SetConfigValue("model", "gpt-5-mini")
+		if err := SetConfigValue("log_level", "debug"); err != nil {
+			t.Fatalf("failed to set log_level: %v", err)
+		}
+		if err := SetConfigValue("log_level", ""); err != nil {
+			t.Fatalf("failed to reset log_level: %v", err)
+		}
+		invalidErr := SetConfigValue("log_level", "loud")
+		data, readErr := os.ReadFile(ConfigPath())
+
+		// assert
+		if setErr != nil || readErr != nil {
+			t.Fatalf("unexpected errors: %v, %v", setErr, readErr)
+		}
+		contents := string(data)
+		if !strings.Contains(contents, "# model = \"gpt-4.1\"\nmodel = \"gpt-5-mini\"\n") {
+			t.Fatalf("expected the model after its documented default:\n%s", contents)
+		}
+		if strings.Contains(contents, "log_level = \"debug\"") {
+			t.Fatalf("expected log_level to be removed:\n%s", contents)
+		}
+		if invalidErr == nil || !strings.Contains(invalidErr.Error(), "use debug, info, warn or error") {
+			t.Fatalf("expected a validation error, got %v", invalidErr)
+		}
+	})
+
+	t.Run("keeps comments and adds new keys above tables", func(t *testing.T) {
+		// arrange
+		lines := "# mine\nmodel = \"a\" # inline\n[extra]\n"
+
+		// act
+		replaced := string(setConfigLine([]byte(lines), "model", "b"))
+		added := string(setConfigLine([]byte(lines), "persona", "calm"))
+
+		// assert
+		if replaced != "# mine\nmodel = \"b\"\n[extra]\n" {
+			t.Fatalf("unexpected replacement:\n%s", replaced)
+		}
+		if added != "# mine\nmodel = \"a\" # inline\npersona = \"calm\"\n[extra]\n" {
+			t.Fatalf("unexpected addition:\n%s", added)
+		}
+	})
+}
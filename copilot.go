@@ -14,21 +14,19 @@ import (
 //go:embed system_prompt.txt
 var systemPromptTemplate string
 
-const sessionTimeout = 2 * time.Minute
-
 // Kiki wraps the Copilot client for the CLI assistant
 type Kiki struct {
 	client  *copilot.Client
-	storage *Storage
+	storage Repository
 	tools   *ToolHandler
 	logger  *slog.Logger
 	model   string
 }
 
 // NewKiki creates a new Kiki instance
-func NewKiki(storage *Storage, logger *slog.Logger, model string) (*Kiki, error) {
+func NewKiki(storage Repository, logger *slog.Logger, model string) (*Kiki, error) {
 	if model == "" {
-		model = defaultModel
+		model = appConfig.Model
 	}
 	client := copilot.NewClient(nil)
 	if err := client.Start(); err != nil {
@@ -46,9 +44,16 @@ func NewKiki(storage *Storage, logger *slog.Logger, model string) (*Kiki, error)
 	}, nil
 }
 
-// Close shuts down the Copilot client
+// SetGlobalStore lists the profile store next to an active project store
+func (k *Kiki) SetGlobalStore(global Repository) {
+	k.tools.SetGlobalStore(global)
+}
+
+// Close shuts down the Copilot client, refreshing the model list used for
+// completion first when it is out of date
 func (k *Kiki) Close() {
 	if k.client != nil {
+		k.refreshModelCache()
 		k.client.Stop()
 	}
 }
@@ -78,8 +83,11 @@ func (k *Kiki) RefreshSession() (bool, error) {
 	return found, nil
 }
 
-// getDailySessionID returns a session ID for today (one session per day)
+// getDailySessionID returns a session ID for today (one session per day and profile)
 func getDailySessionID() string {
+	if profile := ActiveProfile(); profile != defaultProfile {
+		return fmt.Sprintf("kiki-%s-%s", profile, todayString())
+	}
 	return fmt.Sprintf("kiki-%s", todayString())
 }
 
@@ -121,31 +129,47 @@ func todayString() string {
 	return time.Now().Format(dateLayout)
 }
 
+// systemPrompt fills in today's date and adds the configured persona, if any
+func systemPrompt() string {
+	prompt := fmt.Sprintf(systemPromptTemplate, todayString())
+	if appConfig.Persona == "" {
+		return prompt
+	}
+	return fmt.Sprintf("%s\n\n## Persona\nThe user configured this persona. It replaces the personality described above; "+
+		"keep the guardrails and the rules for tools:\n%s", prompt, appConfig.Persona)
+}
+
 // Run sends a prompt to Kiki and returns the response
 func (k *Kiki) Run(prompt string, out io.Writer) (string, error) {
-	fullSystemPrompt := fmt.Sprintf(systemPromptTemplate, todayString())
+	fullSystemPrompt := systemPrompt()
 	session, resumed, err := k.getOrCreateSession(fullSystemPrompt)
 	if err != nil {
 		return "", err
 	}
-	defer func(session *copilot.Session) {
-		err := session.Destroy()
-		if err != nil {
-			k.logger.Error("failed to close session")
-		}
-	}(session)
+	defer k.destroySession(session)
 
 	// If resuming a session, re-inject the system prompt to ensure persona persistence
 	if resumed {
 		prompt = fmt.Sprintf("%s\n\n%s", fullSystemPrompt, prompt)
 	}
 
+	return k.send(session, prompt, out)
+}
+
+func (k *Kiki) destroySession(session *copilot.Session) {
+	if err := session.Destroy(); err != nil {
+		k.logger.Error("failed to close session")
+	}
+}
+
+// send streams the response to prompt to out and returns it once the session is idle
+func (k *Kiki) send(session *copilot.Session, prompt string, out io.Writer) (string, error) {
 	// Collect response
 	var responseBuilder strings.Builder
 	var sessionError error
 
 	// Set up streaming handler
-	session.On(func(event copilot.SessionEvent) {
+	unsubscribe := session.On(func(event copilot.SessionEvent) {
 		switch event.Type {
 		case "assistant.message_delta":
 			if event.Data.DeltaContent != nil {
@@ -166,9 +190,10 @@ func (k *Kiki) Run(prompt string, out io.Writer) (string, error) {
 			}
 		}
 	})
+	defer unsubscribe()
 
 	// Send the prompt and wait for completion (2 minute timeout)
-	_, err = session.SendAndWait(copilot.MessageOptions{Prompt: prompt}, sessionTimeout)
+	_, err := session.SendAndWait(copilot.MessageOptions{Prompt: prompt}, appConfig.SessionTimeout)
 	if err != nil {
 		if sessionError != nil {
 			return "", sessionError
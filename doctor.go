@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	copilot "github.com/github/copilot-sdk/go"
+)
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+
+	copilotCLIEnv     = "COPILOT_CLI_PATH"
+	defaultCopilotCLI = "copilot"
+	doctorProbeFile   = ".kiki-doctor-probe"
+)
+
+// copilotClient is the part of the Copilot client that kiki doctor exercises
+type copilotClient interface {
+	Start() error
+	Stop() []error
+	GetAuthStatus() (*copilot.GetAuthStatusResponse, error)
+	ListModels() ([]copilot.ModelInfo, error)
+}
+
+// DoctorCheck is the outcome of one diagnostic
+type DoctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+	// Hint tells the user how to fix a warning or failure
+	Hint string
+}
+
+// Doctor diagnoses the environment kiki runs in
+type Doctor struct {
+	model     string
+	newClient func() copilotClient
+	lookPath  func(string) (string, error)
+	// cacheModels saves the model list for completion; nil in tests
+	cacheModels func([]copilot.ModelInfo) error
+}
+
+// NewDoctor returns a doctor that checks the real Copilot client and the given model
+func NewDoctor(model string) *Doctor {
+	return &Doctor{
+		model:       model,
+		newClient:   func() copilotClient { return copilot.NewClient(nil) },
+		lookPath:    exec.LookPath,
+		cacheModels: saveModelCache,
+	}
+}
+
+// Run performs every check in order
+func (d *Doctor) Run() []DoctorCheck {
+	checks := []DoctorCheck{d.checkCopilotCLI()}
+	checks = append(checks, d.checkCopilotClient()...)
+	checks = append(checks, checkProfile())
+	checks = append(checks, checkDir("Data directory", GetConfigDir(), "run 'kiki init'"))
+	if logDir, err := GetLogDir(); err != nil {
+		checks = append(checks, DoctorCheck{Name: "Log directory", Status: checkFail, Detail: err.Error(),
+			Hint: "set HOME to your home directory"})
+	} else {
+		checks = append(checks, checkDir("Log directory", logDir, "run any kiki command to create it"))
+	}
+	return append(checks, checkDataFiles()...)
+}
+
+// checksFailed reports whether any check failed
+func checksFailed(checks []DoctorCheck) bool {
+	for _, c := range checks {
+		if c.Status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Doctor) checkCopilotCLI() DoctorCheck {
+	check := DoctorCheck{Name: "Copilot CLI"}
+	cli := os.Getenv(copilotCLIEnv)
+	if cli == "" {
+		cli = defaultCopilotCLI
+	}
+	path, err := d.lookPath(cli)
+	if err != nil {
+		check.Status, check.Detail = checkFail, fmt.Sprintf("%s not found", cli)
+		check.Hint = fmt.Sprintf("install the GitHub Copilot CLI or point %s at it", copilotCLIEnv)
+		return check
+	}
+	check.Status, check.Detail = checkPass, path
+	return check
+}
+
+// checkCopilotClient starts a client, then checks sign-in and the configured model
+func (d *Doctor) checkCopilotClient() []DoctorCheck {
+	start := DoctorCheck{Name: "Copilot client"}
+	auth := DoctorCheck{Name: "Copilot sign-in"}
+	model := DoctorCheck{Name: "Model"}
+
+	client := d.newClient()
+	if err := client.Start(); err != nil {
+		start.Status, start.Detail = checkFail, err.Error()
+		start.Hint = "make sure 'copilot --version' runs, then try again"
+		skipped := "skipped: the client did not start"
+		auth.Status, auth.Detail = checkWarn, skipped
+		model.Status, model.Detail = checkWarn, skipped
+		return []DoctorCheck{start, auth, model}
+	}
+	defer func() {
+		if errs := client.Stop(); len(errs) > 0 {
+			slog.Default().Error("failed to stop copilot client", "error", errors.Join(errs...))
+		}
+	}()
+	start.Status, start.Detail = checkPass, "started"
+
+	status, err := client.GetAuthStatus()
+	switch {
+	case err != nil:
+		auth.Status, auth.Detail = checkWarn, err.Error()
+		auth.Hint = "update the Copilot CLI; older versions cannot report sign-in status"
+	case !status.IsAuthenticated:
+		auth.Status, auth.Detail = checkFail, "not signed in"
+		if status.StatusMessage != nil {
+			auth.Detail = *status.StatusMessage
+		}
+		auth.Hint = "run 'copilot' and sign in with /login"
+	default:
+		auth.Status, auth.Detail = checkPass, "signed in"
+		if status.Login != nil {
+			auth.Detail = "signed in as " + *status.Login
+		}
+	}
+
+	models, err := client.ListModels()
+	if err != nil {
+		model.Status, model.Detail = checkWarn, err.Error()
+		model.Hint = "sign in first; the model list needs an authenticated client"
+		return []DoctorCheck{start, auth, model}
+	}
+	if d.cacheModels != nil {
+		if err := d.cacheModels(models); err != nil {
+			slog.Default().Error("failed to cache models", "error", err)
+		}
+	}
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		if m.ID == d.model {
+			model.Status, model.Detail = checkPass, d.model
+			return []DoctorCheck{start, auth, model}
+		}
+		ids = append(ids, m.ID)
+	}
+	sort.Strings(ids)
+	model.Status, model.Detail = checkFail, fmt.Sprintf("%s is not available", d.model)
+	model.Hint = "use --model with one of: " + strings.Join(ids, ", ")
+	return []DoctorCheck{start, auth, model}
+}
+
+func checkProfile() DoctorCheck {
+	check := DoctorCheck{Name: "Profile", Detail: ActiveProfile()}
+	if err := CheckActiveProfile(); err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		check.Hint = "create the profile or pick another with 'kiki profile use'"
+		return check
+	}
+	check.Status = checkPass
+	return check
+}
+
+// checkDir verifies that dir exists, can be written and is not writable by other users
+func checkDir(name, dir, missingHint string) DoctorCheck {
+	check := DoctorCheck{Name: name, Detail: dir}
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		check.Status, check.Hint = checkWarn, missingHint
+		check.Detail = dir + " does not exist"
+		return check
+	}
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+
+	probe := filepath.Join(dir, doctorProbeFile)
+	if err := os.WriteFile(probe, nil, dataFilePerm); err != nil {
+		check.Status, check.Detail = checkFail, fmt.Sprintf("%s is not writable", dir)
+		check.Hint = "fix the ownership or permissions of " + dir
+		return check
+	}
+	if err := os.Remove(probe); err != nil {
+		check.Status, check.Detail = checkWarn, err.Error()
+		check.Hint = "remove " + probe
+		return check
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o022 != 0 {
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("%s is writable by other users (%o)", dir, info.Mode().Perm())
+		check.Hint = fmt.Sprintf("chmod %o %s", configDirPerm, dir)
+		return check
+	}
+	check.Status = checkPass
+	return check
+}
+
+// checkDataFiles parses the data files without changing them: no schema
+// upgrade is written and a corrupt file is not recovered
+func checkDataFiles() []DoctorCheck {
+	dir := GetConfigDir()
+	if backendAt(dir) == backendSQLite {
+		check := DoctorCheck{Name: sqliteFile, Detail: "readable"}
+		storage, err := newSQLiteStorageAt(dir, slog.Default())
+		if err == nil {
+			if _, err = storage.LoadTasks(); err == nil {
+				_, err = storage.LoadNotes()
+			}
+			err = errors.Join(err, storage.Close())
+		}
+		check.Status = checkPass
+		if err != nil {
+			check.Status, check.Detail = checkFail, err.Error()
+			check.Hint = "restore a snapshot with 'kiki backup restore'"
+		}
+		return []DoctorCheck{check}
+	}
+
+	vault := newVaultAt(dir)
+	files := []struct {
+		name       string
+		migrations []schemaMigration
+		out        any
+	}{
+		{tasksFile, taskSchemaMigrations, &TaskList{}},
+		{notesFile, noteSchemaMigrations, &NoteList{}},
+	}
+	checks := make([]DoctorCheck, 0, len(files))
+	for _, f := range files {
+		check := DoctorCheck{Name: f.name}
+		path := filepath.Join(dir, f.name)
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			check.Status, check.Detail, check.Hint = checkWarn, "missing", "run 'kiki init'"
+			checks = append(checks, check)
+			continue
+		}
+
+		raw, err := os.ReadFile(path)
+		if err == nil {
+			var data []byte
+			if data, err = vault.Open(raw); err == nil {
+				err = decodeDataFile(data, f.migrations, f.out)
+			}
+		}
+		switch {
+		case err != nil:
+			check.Status, check.Detail = checkFail, err.Error()
+			check.Hint = "run 'kiki fsck' to recover the file"
+		case runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0:
+			check.Status = checkWarn
+			check.Detail = fmt.Sprintf("readable by other users (%o)", info.Mode().Perm())
+			check.Hint = fmt.Sprintf("chmod %o %s", dataFilePerm, path)
+		default:
+			check.Status, check.Detail = checkPass, "parses"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
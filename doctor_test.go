@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	copilot "github.com/github/copilot-sdk/go"
+)
+
+type fakeCopilotClient struct {
+	startErr error
+	auth     *copilot.GetAuthStatusResponse
+	models   []copilot.ModelInfo
+	stopped  bool
+}
+
+func (f *fakeCopilotClient) Start() error { return f.startErr }
+
+func (f *fakeCopilotClient) Stop() []error {
+	f.stopped = true
+	return nil
+}
+
+func (f *fakeCopilotClient) GetAuthStatus() (*copilot.GetAuthStatusResponse, error) {
+	return f.auth, nil
+}
+
+func (f *fakeCopilotClient) ListModels() ([]copilot.ModelInfo, error) {
+	return f.models, nil
+}
+
+func newTestDoctor(client *fakeCopilotClient, model string) *Doctor {
+	return &Doctor{
+		model:     model,
+		newClient: func() copilotClient { return client },
+		lookPath:  func(name string) (string, error) { return "/usr/bin/" + name, nil },
+	}
+}
+
+func checkByName(t *testing.T, checks []DoctorCheck, name string) DoctorCheck {
+	t.Helper()
+	for _, c := range checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %q check in %+v", name, checks)
+	return DoctorCheck{}
+}
+
+func TestDoctorCopilot(t *testing.T) {
+	t.Run("passes with a signed-in client and an available model", func(t *testing.T) {
+		// arrange
+		login := "octocat"
+		client := &fakeCopilotClient{
+			auth:   &copilot.GetAuthStatusResponse{IsAuthenticated: true, Login: &login},
+			models: []copilot.ModelInfo{{ID: "gpt-4.1"}, {ID: "claude-sonnet-4"}},
+		}
+
+		// act
+		checks := newTestDoctor(client, "gpt-4.1").checkCopilotClient()
+
+		// assert
+		for _, c := range checks {
+			if c.Status != checkPass {
+				t.Fatalf("expected %s to pass, got %+v", c.Name, c)
+			}
+		}
+		if checkByName(t, checks, "Copilot sign-in").Detail != "signed in as octocat" {
+			t.Fatalf("expected the login to be reported")
+		}
+		if !client.stopped {
+			t.Fatalf("expected the client to be stopped")
+		}
+	})
+
+	t.Run("fails on sign-in and unknown models with hints", func(t *testing.T) {
+		// arrange
+		client := &fakeCopilotClient{
+			auth:   &copilot.GetAuthStatusResponse{IsAuthenticated: false},
+			models: []copilot.ModelInfo{{ID: "gpt-4.1"}},
+		}
+
+		// act
+		checks := newTestDoctor(client, "gpt-9").checkCopilotClient()
+
+		// assert
+		auth := checkByName(t, checks, "Copilot sign-in")
+		model := checkByName(t, checks, "Model")
+		if auth.Status != checkFail || auth.Hint == "" {
+			t.Fatalf("expected sign-in to fail with a hint, got %+v", auth)
+		}
+		if model.Status != checkFail || model.Hint != "use --model with one of: gpt-4.1" {
+			t.Fatalf("expected the model to fail listing alternatives, got %+v", model)
+		}
+		if !checksFailed(checks) {
+			t.Fatalf("expected the checks to fail")
+		}
+	})
+
+	t.Run("skips the remaining checks when the client cannot start", func(t *testing.T) {
+		// arrange
+		client := &fakeCopilotClient{startErr: errors.New("exec: copilot: not found")}
+
+		// act
+		checks := newTestDoctor(client, "gpt-4.1").checkCopilotClient()
+
+		// assert
+		if checkByName(t, checks, "Copilot client").Status != checkFail ||
+			checkByName(t, checks, "Model").Status != checkWarn {
+			t.Fatalf("unexpected checks: %+v", checks)
+		}
+	})
+}
+
+func TestDoctorCommand(t *testing.T) {
+	t.Run("checks the configured model and data_dir when --model is not given", func(t *testing.T) {
+		// arrange
+		dataDir := t.TempDir()
+		newTestConfigFile(t, "model = \"gpt-5-mini\"\ndata_dir = \""+dataDir+"\"\n")
+		t.Setenv(profileEnv, "missing")
+		keepAppConfig(t)
+		model = ""
+		client := &fakeCopilotClient{
+			auth:   &copilot.GetAuthStatusResponse{IsAuthenticated: true},
+			models: []copilot.ModelInfo{{ID: "gpt-4.1"}, {ID: "gpt-5-mini"}},
+		}
+
+		// act
+		err := doctorCmd.PersistentPreRunE(doctorCmd, nil)
+		checks := newTestDoctor(client, doctorModel(model)).checkCopilotClient()
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected a missing profile not to stop doctor, got %v", err)
+		}
+		if checked := checkByName(t, checks, "Model"); checked.Status != checkPass || checked.Detail != "gpt-5-mini" {
+			t.Fatalf("expected the configured model to be checked, got %+v", checked)
+		}
+		if !strings.HasPrefix(GetGlobalConfigDir(), dataDir) {
+			t.Fatalf("expected the data directory under %s, got %s", dataDir, GetGlobalConfigDir())
+		}
+	})
+
+	t.Run("prefers --model over the model setting", func(t *testing.T) {
+		// arrange
+		keepAppConfig(t)
+		appConfig.Model = "gpt-5-mini"
+
+		// act
+		got := doctorModel("gpt-4.1")
+
+		// assert
+		if got != "gpt-4.1" {
+			t.Fatalf("expected the flag to win, got %s", got)
+		}
+	})
+}
+
+func TestDoctorDataFiles(t *testing.T) {
+	t.Run("flags unparseable and over-shared data files without changing them", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv(profileEnv, "")
+		if err := InitStorage(InitOptions{}); err != nil {
+			t.Fatalf("failed to init storage: %v", err)
+		}
+		tasksPath := filepath.Join(GetConfigDir(), tasksFile)
+		if err := os.WriteFile(tasksPath, []byte("{broken"), dataFilePerm); err != nil {
+			t.Fatalf("failed to damage tasks: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(GetConfigDir(), notesFile), 0o644); err != nil {
+			t.Fatalf("failed to chmod notes: %v", err)
+		}
+
+		// act
+		checks := checkDataFiles()
+
+		// assert
+		if c := checkByName(t, checks, tasksFile); c.Status != checkFail {
+			t.Fatalf("expected tasks.json to fail, got %+v", c)
+		}
+		if c := checkByName(t, checks, notesFile); c.Status != checkWarn {
+			t.Fatalf("expected notes.json to warn about permissions, got %+v", c)
+		}
+		if data, err := os.ReadFile(tasksPath); err != nil || string(data) != "{broken" {
+			t.Fatalf("expected tasks.json to be left alone")
+		}
+	})
+}
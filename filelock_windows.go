@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const lockRangeLen = 1
+
+// acquireFileLock takes an exclusive lock on f, blocking until it is available.
+func acquireFileLock(f *os.File) error {
+	overlapped := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, lockRangeLen, 0, overlapped)
+}
+
+// releaseFileLock releases the lock held on f.
+func releaseFileLock(f *os.File) error {
+	overlapped := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lockRangeLen, 0, overlapped)
+}
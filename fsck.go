@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	validPriorities = []string{"low", "medium", "high"}
+
+	// dueDateRepairLayouts are tried, in order, to rescue a due date not written as YYYY-MM-DD
+	dueDateRepairLayouts = []string{time.RFC3339, "2006/01/02", "2006-1-2", "2006.01.02"}
+)
+
+// FsckIssue is one problem found in a task or note
+type FsckIssue struct {
+	File    string
+	ID      string
+	Title   string
+	Problem string
+	// Repair says what repairing does about the problem
+	Repair string
+}
+
+// FsckResult lists the problems found in the data files
+type FsckResult struct {
+	Tasks    int
+	Notes    int
+	Issues   []FsckIssue
+	Repaired bool
+}
+
+// Fsck checks every task and note, including trashed ones, for duplicate IDs,
+// invalid priorities, malformed due dates and update times earlier than
+// creation times. With repair set, the problems are fixed through the
+// repository, so the repairs can be undone like any other change.
+func Fsck(repo Repository, repair bool) (*FsckResult, error) {
+	result := &FsckResult{Repaired: repair}
+	if !repair {
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			return nil, err
+		}
+		notes, err := repo.LoadNotes()
+		if err != nil {
+			return nil, err
+		}
+		_, taskIssues := checkTasks(tasks.Tasks, false)
+		_, noteIssues := checkNotes(notes.Notes, false)
+		result.Tasks, result.Notes = len(tasks.Tasks), len(notes.Notes)
+		result.Issues = append(taskIssues, noteIssues...)
+		return result, nil
+	}
+
+	err := repo.ModifyTasks(func(tasks *TaskList) error {
+		var issues []FsckIssue
+		result.Tasks = len(tasks.Tasks)
+		tasks.Tasks, issues = checkTasks(tasks.Tasks, true)
+		result.Issues = append(result.Issues, issues...)
+		if len(issues) == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		return nil, err
+	}
+	err = repo.ModifyNotes(func(notes *NoteList) error {
+		var issues []FsckIssue
+		result.Notes = len(notes.Notes)
+		notes.Notes, issues = checkNotes(notes.Notes, true)
+		result.Issues = append(result.Issues, issues...)
+		if len(issues) == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkTasks reports problems in tasks and, with repair set, returns the fixed tasks
+func checkTasks(tasks []Task, repair bool) ([]Task, []FsckIssue) {
+	var issues []FsckIssue
+	report := func(t Task, problem, fix string) {
+		issues = append(issues, FsckIssue{File: tasksFile, ID: t.ID, Title: t.Title, Problem: problem, Repair: fix})
+	}
+
+	tasks, dupIssues := dedupeRecords(tasksFile, tasks, taskReplica, func(t Task) string { return t.Title },
+		func(t *Task) { t.ID = generateID() }, repair)
+	issues = append(issues, dupIssues...)
+
+	for i := range tasks {
+		t := &tasks[i]
+		if !isValidPriority(t.Priority) {
+			fixed := strings.ToLower(strings.TrimSpace(t.Priority))
+			if !isValidPriority(fixed) {
+				fixed = "medium"
+			}
+			report(*t, fmt.Sprintf("invalid priority %q", t.Priority), fmt.Sprintf("set priority to %s", fixed))
+			if repair {
+				t.Priority = fixed
+			}
+		}
+		if t.DueDate != nil {
+			if _, err := time.Parse(dateLayout, *t.DueDate); err != nil {
+				fixed, ok := repairDueDate(*t.DueDate)
+				fix := "clear the due date"
+				if ok {
+					fix = "set due date to " + fixed
+				}
+				report(*t, fmt.Sprintf("malformed due date %q", *t.DueDate), fix)
+				if repair {
+					t.DueDate = nil
+					if ok {
+						t.DueDate = &fixed
+					}
+				}
+			}
+		}
+		if t.UpdatedAt.Before(t.CreatedAt) {
+			report(*t, "updated before it was created", "set the update time to the creation time")
+			if repair {
+				t.UpdatedAt = t.CreatedAt
+			}
+		}
+	}
+	return tasks, issues
+}
+
+// checkNotes reports problems in notes and, with repair set, returns the fixed notes
+func checkNotes(notes []Note, repair bool) ([]Note, []FsckIssue) {
+	notes, issues := dedupeRecords(notesFile, notes, noteReplica, func(n Note) string { return n.Title },
+		func(n *Note) { n.ID = generateID() }, repair)
+
+	for i := range notes {
+		n := &notes[i]
+		if n.UpdatedAt.Before(n.CreatedAt) {
+			issues = append(issues, FsckIssue{File: notesFile, ID: n.ID, Title: n.Title,
+				Problem: "updated before it was created", Repair: "set the update time to the creation time"})
+			if repair {
+				n.UpdatedAt = n.CreatedAt
+			}
+		}
+	}
+	return notes, issues
+}
+
+// dedupeRecords finds records sharing an ID. Exact copies are left out of the
+// result so their problems are reported once; with repair set, differing
+// records get a new ID.
+func dedupeRecords[T any](file string, records []T, r replica[T], title func(T) string, reassign func(*T), repair bool) ([]T, []FsckIssue) {
+	var issues []FsckIssue
+	seen := make(map[string][]byte, len(records))
+	kept := make([]T, 0, len(records))
+	for _, rec := range records {
+		id, _ := r.key(rec)
+		image, _ := json.Marshal(rec)
+		first, duplicate := seen[id]
+		if !duplicate {
+			seen[id] = image
+			kept = append(kept, rec)
+			continue
+		}
+
+		if bytes.Equal(image, first) {
+			issues = append(issues, FsckIssue{File: file, ID: id, Title: title(rec),
+				Problem: "duplicate ID (exact copy)", Repair: "remove the copy"})
+			continue
+		}
+		issues = append(issues, FsckIssue{File: file, ID: id, Title: title(rec),
+			Problem: "duplicate ID", Repair: "give it a new ID"})
+		if repair {
+			reassign(&rec)
+			newID, _ := r.key(rec)
+			seen[newID] = image
+		}
+		kept = append(kept, rec)
+	}
+	return kept, issues
+}
+
+func isValidPriority(priority string) bool {
+	for _, valid := range validPriorities {
+		if priority == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// repairDueDate rewrites a due date in a recognizable layout as YYYY-MM-DD
+func repairDueDate(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dueDateRepairLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.Format(dateLayout), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gitBinary        = "git"
+	gitDirName       = ".git"
+	gitRemoteName    = "origin"
+	gitDefaultBranch = "main"
+	gitIgnoreFile    = ".gitignore"
+	gitFallbackName  = "Kiki"
+	gitFallbackEmail = "kiki@localhost"
+
+	// gitIgnoreContents keeps machine-local state out of the synced history
+	gitIgnoreContents = `# Machine-local kiki state
+*.lock
+*.bak
+*.corrupt-*
+.*.tmp-*
+journal.jsonl
+kiki.db
+search-index.json
+backups/
+/profile
+/profiles/
+`
+)
+
+var errGitNotEnabled = errors.New("git history is not enabled; run 'kiki init --git' first")
+
+// GitStore versions the kiki data directory with git
+type GitStore struct {
+	dir    string
+	logger *slog.Logger
+	vault  *Vault
+}
+
+// NewGitStore returns the git store for the kiki config directory
+func NewGitStore(logger *slog.Logger) *GitStore {
+	return newGitStoreAt(GetConfigDir(), logger)
+}
+
+func newGitStoreAt(dir string, logger *slog.Logger) *GitStore {
+	return &GitStore{dir: dir, logger: logger, vault: newVaultAt(dir)}
+}
+
+// Enabled reports whether the data directory is a git repository
+func (g *GitStore) Enabled() bool {
+	info, err := os.Stat(filepath.Join(g.dir, gitDirName))
+	return err == nil && info.IsDir()
+}
+
+// Init turns the data directory into a git repository and records the current
+// data files in an initial commit. An empty remote leaves the remote unchanged.
+func (g *GitStore) Init(remote string) error {
+	if backendAt(g.dir) == backendSQLite {
+		return fmt.Errorf("git history needs the JSON backend, but data is stored in %s", sqliteFile)
+	}
+	if _, err := exec.LookPath(gitBinary); err != nil {
+		return fmt.Errorf("git history needs git installed: %w", err)
+	}
+
+	if !g.Enabled() {
+		if _, err := g.run("init", "--quiet"); err != nil {
+			return err
+		}
+		if _, err := g.run("symbolic-ref", "HEAD", "refs/heads/"+gitDefaultBranch); err != nil {
+			return err
+		}
+	}
+	ignorePath := filepath.Join(g.dir, gitIgnoreFile)
+	if _, err := os.Stat(ignorePath); os.IsNotExist(err) {
+		if err := os.WriteFile(ignorePath, []byte(gitIgnoreContents), dataFilePerm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", gitIgnoreFile, err)
+		}
+	}
+	if remote != "" {
+		if err := g.SetRemote(remote); err != nil {
+			return err
+		}
+	}
+	return g.Commit("init: track kiki data", gitIgnoreFile, tasksFile, notesFile, vaultFile)
+}
+
+// SetRemote points the origin remote at url
+func (g *GitStore) SetRemote(url string) error {
+	if _, err := g.run("remote", "get-url", gitRemoteName); err == nil {
+		_, err := g.run("remote", "set-url", gitRemoteName, url)
+		return err
+	}
+	_, err := g.run("remote", "add", gitRemoteName, url)
+	return err
+}
+
+// Commit stages the given files and commits them with message. Nothing is
+// committed when the files are unchanged.
+func (g *GitStore) Commit(message string, files ...string) error {
+	var existing []string
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(g.dir, f)); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	if _, err := g.run(append([]string{"add", "--"}, existing...)...); err != nil {
+		return err
+	}
+	if _, err := g.run("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	_, err := g.run(g.identityArgs("commit", "--quiet", "-m", message)...)
+	return err
+}
+
+// CommitHook commits the data files after every journaled change. With
+// encryption enabled the message names only the operation, not the record.
+func (g *GitStore) CommitHook(entry JournalEntry) {
+	message := entry.Summary
+	if g.vault.Enabled() {
+		message, _, _ = strings.Cut(message, ":")
+	}
+	err := withFileLock(filepath.Join(g.dir, lockFile), func() error {
+		return g.Commit(message, tasksFile, notesFile, vaultFile)
+	})
+	if err != nil {
+		g.logger.Error("failed to commit change", "summary", entry.Summary, "error", err)
+	}
+}
+
+// SyncResult describes what a sync did
+type SyncResult struct {
+	Pulled bool
+	Merged bool
+	Pushed bool
+}
+
+// Sync commits local changes, merges the remote branch record by record and
+// pushes the result
+func (g *GitStore) Sync() (*SyncResult, error) {
+	if !g.Enabled() {
+		return nil, errGitNotEnabled
+	}
+	if _, err := g.run("remote", "get-url", gitRemoteName); err != nil {
+		return nil, fmt.Errorf("no remote configured; run 'kiki sync --remote <url>' first")
+	}
+
+	result := &SyncResult{}
+	err := withFileLock(filepath.Join(g.dir, lockFile), func() error {
+		if err := g.Commit("sync: local changes", tasksFile, notesFile, vaultFile); err != nil {
+			return err
+		}
+		branch, err := g.run("rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return err
+		}
+		if _, err := g.run("fetch", "--quiet", gitRemoteName); err != nil {
+			return err
+		}
+
+		remoteRef := gitRemoteName + "/" + branch
+		if _, err := g.run("rev-parse", "--verify", "--quiet", remoteRef); err == nil {
+			pulled, merged, err := g.mergeRemote(remoteRef)
+			if err != nil {
+				return err
+			}
+			result.Pulled, result.Merged = pulled, merged
+		}
+
+		if _, err := g.run("push", "--quiet", "--set-upstream", gitRemoteName, "HEAD:"+branch); err != nil {
+			return err
+		}
+		result.Pushed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mergeRemote brings remoteRef into the current branch. Data files are merged
+// per record by ID instead of line by line. It reports whether anything was
+// pulled and whether a merge commit was needed.
+func (g *GitStore) mergeRemote(remoteRef string) (bool, bool, error) {
+	if _, err := g.run("merge-base", "--is-ancestor", remoteRef, "HEAD"); err == nil {
+		return false, false, nil
+	}
+	if _, err := g.run("merge-base", "--is-ancestor", "HEAD", remoteRef); err == nil {
+		_, err := g.run(g.identityArgs("merge", "--quiet", "--ff-only", remoteRef)...)
+		return err == nil, false, err
+	}
+
+	// Devices initialized separately share no history; merge them against an empty base
+	base, err := g.run("merge-base", "HEAD", remoteRef)
+	if err != nil {
+		base = ""
+	}
+	merged := make(map[string][]byte, 2)
+	mergers := map[string]func(base, ours, theirs []byte) ([]byte, error){
+		tasksFile: mergeTaskFiles,
+		notesFile: mergeNoteFiles,
+	}
+	for name, merge := range mergers {
+		versions := make([][]byte, 0, 3)
+		for _, rev := range []string{base, "HEAD", remoteRef} {
+			sealed, err := g.show(rev, name)
+			if err != nil {
+				return false, false, err
+			}
+			data, err := g.vault.Open(sealed)
+			if err != nil {
+				return false, false, fmt.Errorf("decrypting %s at %s: %w", name, rev, err)
+			}
+			versions = append(versions, data)
+		}
+		data, err := merge(versions[0], versions[1], versions[2])
+		if err != nil {
+			return false, false, fmt.Errorf("merging %s: %w", name, err)
+		}
+		if merged[name], err = g.vault.Seal(data); err != nil {
+			return false, false, fmt.Errorf("encrypting %s: %w", name, err)
+		}
+	}
+
+	// Let git merge everything else, then replace the data files with the record-level merge
+	_, mergeErr := g.run(g.identityArgs("merge", "--quiet", "--no-ff", "--no-commit", "--allow-unrelated-histories", remoteRef)...)
+	if _, err := g.run("rev-parse", "--verify", "--quiet", "MERGE_HEAD"); err != nil {
+		return false, false, fmt.Errorf("starting merge: %w", mergeErr)
+	}
+	for name, data := range merged {
+		if err := writeFileAtomic(filepath.Join(g.dir, name), data, dataFilePerm); err != nil {
+			return false, false, errors.Join(err, g.abortMerge())
+		}
+		if _, err := g.run("add", "--", name); err != nil {
+			return false, false, errors.Join(err, g.abortMerge())
+		}
+	}
+	if unmerged, err := g.run("diff", "--name-only", "--diff-filter=U"); err != nil || unmerged != "" {
+		return false, false, errors.Join(
+			fmt.Errorf("merge conflicts outside the data files: %s", unmerged), mergeErr, err, g.abortMerge())
+	}
+	if _, err := g.run(g.identityArgs("commit", "--quiet", "-m", "sync: merge "+remoteRef)...); err != nil {
+		return false, false, errors.Join(err, g.abortMerge())
+	}
+	return true, true, nil
+}
+
+func (g *GitStore) abortMerge() error {
+	_, err := g.run("merge", "--abort")
+	return err
+}
+
+// show returns a file's contents at rev, or nil when rev is empty or the file
+// does not exist there. Any other failure is an error, so a broken repository
+// is never merged as if the file had been deleted.
+func (g *GitStore) show(rev, name string) ([]byte, error) {
+	if rev == "" {
+		return nil, nil
+	}
+	listed, err := g.run("ls-tree", "--name-only", rev, "--", name)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at %s: %w", name, rev, err)
+	}
+	if listed == "" {
+		return nil, nil
+	}
+	out, err := g.runRaw("show", rev+":"+name)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s at %s: %w", name, rev, err)
+	}
+	return out, nil
+}
+
+// identityArgs falls back to a kiki identity when the user has not configured one
+func (g *GitStore) identityArgs(args ...string) []string {
+	if _, err := g.run("config", "user.email"); err == nil {
+		return args
+	}
+	return append([]string{"-c", "user.name=" + gitFallbackName, "-c", "user.email=" + gitFallbackEmail}, args...)
+}
+
+func (g *GitStore) run(args ...string) (string, error) {
+	out, err := g.runRaw(args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (g *GitStore) runRaw(args ...string) ([]byte, error) {
+	cmd := exec.Command(gitBinary, args...)
+	cmd.Dir = g.dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGitSync(t *testing.T) {
+	if _, err := exec.LookPath(gitBinary); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	t.Run("syncs two devices through a bare remote", func(t *testing.T) {
+		// arrange
+		remote := t.TempDir()
+		if out, err := exec.Command(gitBinary, "init", "--quiet", "--bare", remote).CombinedOutput(); err != nil {
+			t.Fatalf("failed to create bare remote: %v: %s", err, out)
+		}
+		laptop, desktop := t.TempDir(), t.TempDir()
+		device := func(configHome string) *JournaledRepository {
+			t.Helper()
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			repo, err := NewRepository(newTestLogger())
+			if err != nil {
+				t.Fatalf("failed to open repository: %v", err)
+			}
+			return repo
+		}
+		sync := func(configHome string) *SyncResult {
+			t.Helper()
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			result, err := NewGitStore(newTestLogger()).Sync()
+			if err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+			return result
+		}
+		for _, configHome := range []string{laptop, desktop} {
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			if err := InitStorage(InitOptions{Git: true, Remote: remote}); err != nil {
+				t.Fatalf("failed to init storage: %v", err)
+			}
+		}
+		if _, err := device(laptop).AddTask("Fix login bug", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		sync(laptop)
+		if _, err := device(desktop).AddTask("Write docs", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		sync(desktop)
+		err := device(laptop).ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Completed = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to complete task: %v", err)
+		}
+
+		// act
+		result := sync(laptop)
+
+		// assert
+		if !result.Merged || !result.Pushed {
+			t.Fatalf("expected a merge and a push, got %+v", result)
+		}
+		repo := device(laptop)
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"Fix login bug", "Write docs"}) {
+			t.Fatalf("expected both devices' tasks, got %v", got)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if !tasks.Tasks[0].Completed {
+			t.Fatalf("expected local completion to survive the merge")
+		}
+		log, err := NewGitStore(newTestLogger()).run("log", "--format=%s")
+		if err != nil {
+			t.Fatalf("failed to read git log: %v", err)
+		}
+		for _, want := range []string{"add_task: Fix login bug", "add_task: Write docs", "complete_task: Fix login bug"} {
+			if !strings.Contains(log, want) {
+				t.Fatalf("expected commit %q in log:\n%s", want, log)
+			}
+		}
+	})
+
+	t.Run("show reads a missing file as empty but reports git failures", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		if err := InitStorage(InitOptions{Git: true}); err != nil {
+			t.Fatalf("failed to init storage: %v", err)
+		}
+		store := NewGitStore(newTestLogger())
+
+		// act
+		tasks, tasksErr := store.show("HEAD", tasksFile)
+		missing, missingErr := store.show("HEAD", "nothing.json")
+		_, badRevErr := store.show(strings.Repeat("0", 40), tasksFile)
+
+		// assert
+		if tasksErr != nil || len(tasks) == 0 {
+			t.Fatalf("expected the committed tasks file, got %q: %v", tasks, tasksErr)
+		}
+		if missingErr != nil || missing != nil {
+			t.Fatalf("expected a missing file to read as empty, got %q: %v", missing, missingErr)
+		}
+		if badRevErr == nil || !strings.Contains(badRevErr.Error(), "reading "+tasksFile) {
+			t.Fatalf("expected an unreadable revision to fail, got %v", badRevErr)
+		}
+	})
+
+	t.Run("Sync fails when git history is not enabled", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+		// act
+		_, err := NewGitStore(newTestLogger()).Sync()
+
+		// assert
+		if err != errGitNotEnabled {
+			t.Fatalf("expected errGitNotEnabled, got %v", err)
+		}
+	})
+}
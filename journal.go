@@ -0,0 +1,951 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	journalFile     = "journal.jsonl"
+	journalLockFile = "journal.lock"
+
+	journalKindTasks = "tasks"
+	journalKindNotes = "notes"
+	// journalKindRestore entries replace tasks and notes together; each change names its own kind
+	journalKindRestore = "restore"
+
+	journalActionChange = "change"
+	journalActionUndo   = "undo"
+	journalActionRedo   = "redo"
+	// journalActionAbort cancels a change whose save failed after it was journaled
+	journalActionAbort = "abort"
+
+	// journalMaxLine bounds a single journal entry, which holds full before/after images
+	journalMaxLine = 16 << 20
+)
+
+// journalMaxBytes caps journal.jsonl; past it the oldest entries are dropped
+// until the journal is half that size, and they can no longer be undone
+var journalMaxBytes = 32 << 20
+
+var (
+	errNothingToUndo   = errors.New("nothing to undo")
+	errNothingToRedo   = errors.New("nothing to redo")
+	errJournalConflict = errors.New("changed since, by a later edit or another process")
+)
+
+// RecordChange holds the before and after images of one task or note.
+// A missing image means the record did not exist on that side of the change.
+type RecordChange struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind,omitempty"`
+	Index  int             `json:"index"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// JournalEntry is one line of the operation journal
+type JournalEntry struct {
+	ID      string         `json:"id"`
+	Time    time.Time      `json:"time"`
+	Action  string         `json:"action"`
+	Kind    string         `json:"kind"`
+	Summary string         `json:"summary"`
+	Target  string         `json:"target,omitempty"`
+	Changes []RecordChange `json:"changes,omitempty"`
+}
+
+// changeKind reports whether a change in the entry is to a task or a note
+func (e JournalEntry) changeKind(change RecordChange) string {
+	if change.Kind != "" {
+		return change.Kind
+	}
+	return e.Kind
+}
+
+// HistoryItem is a journal entry annotated with whether it is currently undone
+type HistoryItem struct {
+	Entry  JournalEntry
+	Undone bool
+}
+
+// Undoer reverts and reapplies journaled changes
+type Undoer interface {
+	Undo(n int) ([]JournalEntry, error)
+	Redo() (*JournalEntry, error)
+}
+
+// Journal is an append-only log of every change made to tasks and notes.
+// With encryption enabled each line is encrypted on its own.
+type Journal struct {
+	path     string
+	lockPath string
+	vault    *Vault
+}
+
+// NewJournal returns the journal stored in the kiki config directory
+func NewJournal() *Journal {
+	return newJournalAt(GetConfigDir())
+}
+
+func newJournalAt(basePath string) *Journal {
+	return &Journal{
+		path:     filepath.Join(basePath, journalFile),
+		lockPath: filepath.Join(basePath, journalLockFile),
+		vault:    newVaultAt(basePath),
+	}
+}
+
+// Append writes an entry to the end of the journal
+func (j *Journal) Append(entry JournalEntry) error {
+	return withFileLock(j.lockPath, func() error {
+		return j.append(entry)
+	})
+}
+
+func (j *Journal) append(entry JournalEntry) error {
+	data, err := j.encode(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, dataFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return errors.Join(fmt.Errorf("failed to write journal: %w", err), f.Close())
+	}
+	return f.Close()
+}
+
+// encode serializes an entry as one journal line, without the newline
+func (j *Journal) encode(entry JournalEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize journal entry: %w", err)
+	}
+	if data, err = j.vault.Seal(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt journal entry: %w", err)
+	}
+	return data, nil
+}
+
+// compact drops every image of the forgotten records, and entries left
+// without changes, then drops the oldest entries while the journal is over
+// journalMaxBytes. The caller must hold the journal lock.
+func (j *Journal) compact(forget map[string]bool) error {
+	entries, err := j.Entries()
+	if err != nil {
+		return err
+	}
+	lines := make([][]byte, 0, len(entries))
+	size := 0
+	for _, entry := range entries {
+		if entry.Action == journalActionChange && len(forget) > 0 {
+			kept := entry.Changes[:0]
+			for _, change := range entry.Changes {
+				if !forget[change.ID] {
+					kept = append(kept, change)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			entry.Changes = kept
+		}
+		line, err := j.encode(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+	}
+	// Entries only point back at older ones, so dropping the oldest never
+	// leaves an undo or redo without the change it reverts
+	if size > journalMaxBytes {
+		for len(lines) > 0 && size > journalMaxBytes/2 {
+			size -= len(lines[0]) + 1
+			lines = lines[1:]
+		}
+	}
+
+	var out bytes.Buffer
+	for _, line := range lines {
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return writeFileAtomic(j.path, out.Bytes(), dataFilePerm)
+}
+
+// oversized reports whether the journal has grown past journalMaxBytes
+func (j *Journal) oversized() bool {
+	info, err := os.Stat(j.path)
+	return err == nil && info.Size() > int64(journalMaxBytes)
+}
+
+// Entries reads the whole journal in order
+func (j *Journal) Entries() ([]JournalEntry, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, journalMaxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		line, err := j.vault.Open(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt journal: %w", err)
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse journal: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return entries, nil
+}
+
+// rewrite passes every journal line through fn under the journal lock and
+// reports whether there was a journal to rewrite
+func (j *Journal) rewrite(fn func(line []byte) ([]byte, error)) (bool, error) {
+	rewritten := false
+	err := withFileLock(j.lockPath, func() error {
+		data, err := os.ReadFile(j.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read journal: %w", err)
+		}
+		var out bytes.Buffer
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			converted, err := fn(line)
+			if err != nil {
+				return fmt.Errorf("failed to convert journal: %w", err)
+			}
+			out.Write(converted)
+			out.WriteByte('\n')
+		}
+		rewritten = true
+		return writeFileAtomic(j.path, out.Bytes(), dataFilePerm)
+	})
+	return rewritten, err
+}
+
+// journalStacks replays the journal into the changes that are currently applied
+// (oldest first) and the changes that were undone and can be redone (oldest first)
+func journalStacks(entries []JournalEntry) (done, undone []JournalEntry) {
+	byID := make(map[string]JournalEntry, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e
+	}
+	remove := func(stack []JournalEntry, id string) ([]JournalEntry, bool) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].ID == id {
+				return append(stack[:i], stack[i+1:]...), true
+			}
+		}
+		return stack, false
+	}
+
+	for _, e := range entries {
+		switch e.Action {
+		case journalActionChange:
+			done = append(done, e)
+			undone = nil
+		case journalActionUndo:
+			var ok bool
+			if done, ok = remove(done, e.Target); ok {
+				undone = append(undone, byID[e.Target])
+			}
+		case journalActionRedo:
+			var ok bool
+			if undone, ok = remove(undone, e.Target); ok {
+				done = append(done, byID[e.Target])
+			}
+		case journalActionAbort:
+			done, _ = remove(done, e.Target)
+		}
+	}
+	return done, undone
+}
+
+// JournaledRepository records every change made through a Repository in a Journal
+type JournaledRepository struct {
+	Repository
+	journal   *Journal
+	index     *SearchIndex
+	logger    *slog.Logger
+	observers []func(JournalEntry)
+}
+
+// NewJournaledRepository wraps repo so its changes are journaled, and kept up
+// to date in the search index next to the journal
+func NewJournaledRepository(repo Repository, journal *Journal, logger *slog.Logger) *JournaledRepository {
+	r := &JournaledRepository{
+		Repository: repo,
+		journal:    journal,
+		index:      newSearchIndexAt(filepath.Dir(journal.path), logger),
+		logger:     logger,
+	}
+	return r
+}
+
+// OnChange registers fn to be called after every journaled change, undo and redo
+func (r *JournaledRepository) OnChange(fn func(JournalEntry)) {
+	r.observers = append(r.observers, fn)
+}
+
+// ModifyTasks applies fn and journals the tasks it changed. The journal lock
+// is taken first, as undo and redo do, and the entry is appended while the
+// storage lock is still held.
+func (r *JournaledRepository) ModifyTasks(fn func(*TaskList) error) error {
+	return r.journaled(func(record recordFunc) error {
+		return r.Repository.ModifyTasks(func(tasks *TaskList) error {
+			beforeImages, err := recordImages(tasks.Tasks, taskID)
+			if err != nil {
+				return err
+			}
+			if err := fn(tasks); err != nil {
+				return err
+			}
+			// Number new tasks now so their journal images carry the short ID they are saved with
+			numberTasks(tasks)
+			changes, err := diffRecords(beforeImages, tasks.Tasks, taskID)
+			if err != nil {
+				return err
+			}
+			record(journalKindTasks, changes, &tasks.stamp)
+			return nil
+		})
+	})
+}
+
+// SaveTasks replaces all tasks and journals the difference
+func (r *JournaledRepository) SaveTasks(tasks *TaskList) error {
+	return r.ModifyTasks(func(current *TaskList) error {
+		current.Tasks = tasks.Tasks
+		current.LastNumber = max(current.LastNumber, tasks.LastNumber)
+		return nil
+	})
+}
+
+// AddTask creates a task and journals it
+func (r *JournaledRepository) AddTask(title string, dueDate *string, priority string, tags []string) (*Task, error) {
+	task := newTask(title, dueDate, priority, tags)
+	err := r.ModifyTasks(func(tasks *TaskList) error {
+		tasks.Tasks = append(tasks.Tasks, task)
+		numberTasks(tasks)
+		task = tasks.Tasks[len(tasks.Tasks)-1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ModifyNotes is ModifyTasks for notes
+func (r *JournaledRepository) ModifyNotes(fn func(*NoteList) error) error {
+	return r.journaled(func(record recordFunc) error {
+		return r.Repository.ModifyNotes(func(notes *NoteList) error {
+			beforeImages, err := recordImages(notes.Notes, noteID)
+			if err != nil {
+				return err
+			}
+			if err := fn(notes); err != nil {
+				return err
+			}
+			numberNotes(notes)
+			changes, err := diffRecords(beforeImages, notes.Notes, noteID)
+			if err != nil {
+				return err
+			}
+			record(journalKindNotes, changes, &notes.stamp)
+			return nil
+		})
+	})
+}
+
+// SaveNotes replaces all notes and journals the difference
+func (r *JournaledRepository) SaveNotes(notes *NoteList) error {
+	return r.ModifyNotes(func(current *NoteList) error {
+		current.Notes = notes.Notes
+		current.LastNumber = max(current.LastNumber, notes.LastNumber)
+		return nil
+	})
+}
+
+// AddNote creates a note and journals it
+func (r *JournaledRepository) AddNote(title, content string, tags []string) (*Note, error) {
+	note := newNote(title, content, tags)
+	err := r.ModifyNotes(func(notes *NoteList) error {
+		notes.Notes = append(notes.Notes, note)
+		numberNotes(notes)
+		note = notes.Notes[len(notes.Notes)-1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// UpdateTask goes through ModifyTasks so the change is journaled
+func (r *JournaledRepository) UpdateTask(id string, changes TaskChanges) (*Task, *Task, error) {
+	return updateTask(r, id, changes)
+}
+
+// UpdateNote goes through ModifyNotes so the change is journaled
+func (r *JournaledRepository) UpdateNote(id string, changes NoteChanges) (*Note, *Note, error) {
+	return updateNote(r, id, changes)
+}
+
+// Undo reverts the n most recent changes that are still applied, newest first
+func (r *JournaledRepository) Undo(n int) ([]JournalEntry, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("undo count must be at least 1, got %d", n)
+	}
+
+	var reverted, followUps []JournalEntry
+	err := withFileLock(r.journal.lockPath, func() error {
+		entries, err := r.journal.Entries()
+		if err != nil {
+			return err
+		}
+		done, _ := journalStacks(entries)
+		if len(done) == 0 {
+			return errNothingToUndo
+		}
+
+		for i := len(done) - 1; i >= 0 && len(reverted) < n; i-- {
+			target := done[i]
+			if err := r.applyImages(target, true); err != nil {
+				return fmt.Errorf("undoing %q: %w", target.Summary, err)
+			}
+			followUp := followUpEntry(journalActionUndo, target)
+			if err := r.journal.append(followUp); err != nil {
+				return err
+			}
+			reverted = append(reverted, target)
+			followUps = append(followUps, followUp)
+		}
+		return nil
+	})
+	r.notify(followUps...)
+	return reverted, err
+}
+
+// Redo reapplies the most recently undone change
+func (r *JournaledRepository) Redo() (*JournalEntry, error) {
+	var (
+		reapplied *JournalEntry
+		followUp  JournalEntry
+	)
+	err := withFileLock(r.journal.lockPath, func() error {
+		entries, err := r.journal.Entries()
+		if err != nil {
+			return err
+		}
+		_, undone := journalStacks(entries)
+		if len(undone) == 0 {
+			return errNothingToRedo
+		}
+
+		target := undone[len(undone)-1]
+		if err := r.applyImages(target, false); err != nil {
+			return fmt.Errorf("redoing %q: %w", target.Summary, err)
+		}
+		followUp = followUpEntry(journalActionRedo, target)
+		if err := r.journal.append(followUp); err != nil {
+			return err
+		}
+		reapplied = &target
+		return nil
+	})
+	if reapplied != nil {
+		r.notify(followUp)
+	}
+	return reapplied, err
+}
+
+// History returns up to limit journal entries, newest first. A limit of 0 returns all.
+func (r *JournaledRepository) History(limit int) ([]HistoryItem, error) {
+	entries, err := r.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+	_, undone := journalStacks(entries)
+	isUndone := make(map[string]bool, len(undone))
+	for _, e := range undone {
+		isUndone[e.ID] = true
+	}
+
+	// A change whose save failed never happened, so neither it nor its abort is shown
+	aborted := make(map[string]bool)
+	for _, e := range entries {
+		if e.Action == journalActionAbort {
+			aborted[e.Target] = true
+		}
+	}
+
+	items := make([]HistoryItem, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		if limit > 0 && len(items) == limit {
+			break
+		}
+		if entries[i].Action == journalActionAbort || aborted[entries[i].ID] {
+			continue
+		}
+		items = append(items, HistoryItem{Entry: entries[i], Undone: isUndone[entries[i].ID]})
+	}
+	return items, nil
+}
+
+// applyImages restores the before images (undo) or after images (redo) of an entry
+// through the wrapped repository, so the restore itself is not journaled as a
+// change, and applies them to the search index
+func (r *JournaledRepository) applyImages(entry JournalEntry, useBefore bool) error {
+	var before uint64
+	var after *uint64
+	var err error
+	switch entry.Kind {
+	case journalKindTasks:
+		err = r.Repository.ModifyTasks(func(tasks *TaskList) error {
+			before, after = tasks.stamp, &tasks.stamp
+			restored, err := restoreRecords(tasks.Tasks, entry.Changes, useBefore, taskID)
+			tasks.Tasks = restored
+			return err
+		})
+	case journalKindNotes:
+		err = r.Repository.ModifyNotes(func(notes *NoteList) error {
+			before, after = notes.stamp, &notes.stamp
+			restored, err := restoreRecords(notes.Notes, entry.Changes, useBefore, noteID)
+			notes.Notes = restored
+			return err
+		})
+	case journalKindRestore:
+		tasksPart, notesPart := splitRestoreEntry(entry)
+		if err := r.applyImages(tasksPart, useBefore); err != nil {
+			return err
+		}
+		if err := r.applyImages(notesPart, useBefore); err != nil {
+			if rollbackErr := r.applyImages(tasksPart, !useBefore); rollbackErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to roll back tasks: %w", rollbackErr))
+			}
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown journal kind %q", entry.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	r.index.Update(entry.Kind, entry.Changes, useBefore, before, *after)
+	return nil
+}
+
+// splitRestoreEntry separates a restore entry into its task and note changes
+func splitRestoreEntry(entry JournalEntry) (JournalEntry, JournalEntry) {
+	tasksPart, notesPart := entry, entry
+	tasksPart.Kind, tasksPart.Changes = journalKindTasks, nil
+	notesPart.Kind, notesPart.Changes = journalKindNotes, nil
+	for _, change := range entry.Changes {
+		if entry.changeKind(change) == journalKindNotes {
+			notesPart.Changes = append(notesPart.Changes, change)
+		} else {
+			tasksPart.Changes = append(tasksPart.Changes, change)
+		}
+	}
+	return tasksPart, notesPart
+}
+
+// Replace swaps all tasks and notes for the given lists as a single journaled
+// change, so one undo reverts both. When the notes cannot be saved the tasks
+// are put back. Short ID counters never move backwards.
+func (r *JournaledRepository) Replace(tasks *TaskList, notes *NoteList, summary string) error {
+	var taskChanges, noteChanges []RecordChange
+	var tasksBefore, notesBefore uint64
+	var tasksAfter, notesAfter *uint64
+	var entry *JournalEntry
+	err := withFileLock(r.journal.lockPath, func() error {
+		err := r.Repository.ModifyTasks(func(current *TaskList) error {
+			tasksBefore, tasksAfter = current.stamp, &current.stamp
+			beforeImages, err := recordImages(current.Tasks, taskID)
+			if err != nil {
+				return err
+			}
+			current.Tasks = append([]Task(nil), tasks.Tasks...)
+			current.LastNumber = max(current.LastNumber, tasks.LastNumber)
+			numberTasks(current)
+			taskChanges, err = diffRecords(beforeImages, current.Tasks, taskID)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore tasks: %w", err)
+		}
+
+		err = r.Repository.ModifyNotes(func(current *NoteList) error {
+			notesBefore, notesAfter = current.stamp, &current.stamp
+			beforeImages, err := recordImages(current.Notes, noteID)
+			if err != nil {
+				return err
+			}
+			current.Notes = append([]Note(nil), notes.Notes...)
+			current.LastNumber = max(current.LastNumber, notes.LastNumber)
+			numberNotes(current)
+			noteChanges, err = diffRecords(beforeImages, current.Notes, noteID)
+			if err != nil {
+				return err
+			}
+
+			changes := make([]RecordChange, 0, len(taskChanges)+len(noteChanges))
+			for _, change := range taskChanges {
+				change.Kind = journalKindTasks
+				changes = append(changes, change)
+			}
+			for _, change := range noteChanges {
+				change.Kind = journalKindNotes
+				changes = append(changes, change)
+			}
+			if len(changes) == 0 {
+				return nil
+			}
+			e := JournalEntry{
+				ID:      generateID(),
+				Time:    time.Now(),
+				Action:  journalActionChange,
+				Kind:    journalKindRestore,
+				Summary: summary,
+				Changes: changes,
+			}
+			if err := r.journal.append(e); err != nil {
+				r.logger.Error("failed to journal change", "summary", e.Summary, "error", err)
+			}
+			entry = &e
+			return nil
+		})
+		if err == nil {
+			r.index.Update(journalKindTasks, taskChanges, false, tasksBefore, *tasksAfter)
+			r.index.Update(journalKindNotes, noteChanges, false, notesBefore, *notesAfter)
+			return nil
+		}
+
+		// The search index is left alone; the rolled back tasks are saved with a
+		// new stamp, so the next search checks them again
+		err = fmt.Errorf("failed to restore notes: %w", err)
+		if entry != nil {
+			if abortErr := r.journal.append(followUpEntry(journalActionAbort, *entry)); abortErr != nil {
+				r.logger.Error("failed to journal abort", "summary", entry.Summary, "error", abortErr)
+			}
+			entry = nil
+		}
+		rollbackErr := r.Repository.ModifyTasks(func(current *TaskList) error {
+			restored, err := restoreRecords(current.Tasks, taskChanges, true, taskID)
+			current.Tasks = restored
+			return err
+		})
+		if rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back tasks: %w", rollbackErr))
+		}
+		return err
+	})
+	if entry != nil {
+		r.notify(*entry)
+	}
+	return err
+}
+
+// recordFunc journals a change before it is saved. stamp points at the data
+// stamp of the list being changed, which the save updates.
+type recordFunc func(kind string, changes []RecordChange, stamp *uint64)
+
+// journaled runs change under the journal lock. change saves through the
+// wrapped repository and calls record with what it changed before the save
+// is committed; when the save then fails the entry is cancelled again.
+// Journaling failures are logged rather than failing the change itself.
+func (r *JournaledRepository) journaled(change func(record recordFunc) error) error {
+	var entry *JournalEntry
+	var before uint64
+	var after *uint64
+	err := withFileLock(r.journal.lockPath, func() error {
+		var forget map[string]bool
+		err := change(func(kind string, changes []RecordChange, stamp *uint64) {
+			if len(changes) == 0 {
+				return
+			}
+			before, after = *stamp, stamp
+			e := JournalEntry{
+				ID:      generateID(),
+				Time:    time.Now(),
+				Action:  journalActionChange,
+				Kind:    kind,
+				Summary: summarizeChanges(kind, changes),
+				Changes: changes,
+			}
+			if err := r.journal.append(e); err != nil {
+				r.logger.Error("failed to journal change", "summary", e.Summary, "error", err)
+			}
+			entry, forget = &e, purgedRecords(kind, changes)
+		})
+		if err != nil {
+			if entry != nil {
+				abort := followUpEntry(journalActionAbort, *entry)
+				if abortErr := r.journal.append(abort); abortErr != nil {
+					r.logger.Error("failed to journal abort", "summary", entry.Summary, "error", abortErr)
+				}
+				entry = nil
+			}
+			return err
+		}
+		if len(forget) > 0 || r.journal.oversized() {
+			if err := r.journal.compact(forget); err != nil {
+				r.logger.Error("failed to compact journal", "error", err)
+			}
+		}
+		if entry != nil {
+			r.index.Update(entry.Kind, entry.Changes, false, before, *after)
+		}
+		return nil
+	})
+	if entry != nil {
+		r.notify(*entry)
+	}
+	return err
+}
+
+// purgedRecords returns the IDs of records a change removed from the trash
+// for good; the journal forgets them so it keeps no copy of purged data
+func purgedRecords(kind string, changes []RecordChange) map[string]bool {
+	var purged map[string]bool
+	for _, change := range changes {
+		if op, _ := describeChange(kind, change); strings.HasPrefix(op, "purge_") {
+			if purged == nil {
+				purged = make(map[string]bool)
+			}
+			purged[change.ID] = true
+		}
+	}
+	return purged
+}
+
+func (r *JournaledRepository) notify(entries ...JournalEntry) {
+	for _, entry := range entries {
+		for _, fn := range r.observers {
+			fn(entry)
+		}
+	}
+}
+
+func followUpEntry(action string, target JournalEntry) JournalEntry {
+	return JournalEntry{
+		ID:      generateID(),
+		Time:    time.Now(),
+		Action:  action,
+		Kind:    target.Kind,
+		Summary: fmt.Sprintf("%s %s", action, target.Summary),
+		Target:  target.ID,
+	}
+}
+
+func taskID(t Task) string { return t.ID }
+
+func noteID(n Note) string { return n.ID }
+
+type recordImage struct {
+	index int
+	data  json.RawMessage
+}
+
+func recordImages[T any](records []T, id func(T) string) (map[string]recordImage, error) {
+	images := make(map[string]recordImage, len(records))
+	for i, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record: %w", err)
+		}
+		images[id(rec)] = recordImage{index: i, data: data}
+	}
+	return images, nil
+}
+
+// diffRecords compares records against their earlier images and returns the
+// additions, updates and removals ordered by position
+func diffRecords[T any](before map[string]recordImage, after []T, id func(T) string) ([]RecordChange, error) {
+	var changes []RecordChange
+	seen := make(map[string]bool, len(after))
+	for i, rec := range after {
+		recID := id(rec)
+		seen[recID] = true
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record: %w", err)
+		}
+		old, existed := before[recID]
+		switch {
+		case !existed:
+			changes = append(changes, RecordChange{ID: recID, Index: i, After: data})
+		case !bytes.Equal(old.data, data):
+			changes = append(changes, RecordChange{ID: recID, Index: old.index, Before: old.data, After: data})
+		}
+	}
+	for recID, old := range before {
+		if !seen[recID] {
+			changes = append(changes, RecordChange{ID: recID, Index: old.index, Before: old.data})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Index < changes[j].Index })
+	return changes, nil
+}
+
+// restoreRecords applies one side of each change to records. Changes are
+// ordered by position, so reinserted records land where they were. A record
+// that no longer matches the other side was changed since, and restoring it
+// would lose that change, so it fails with errJournalConflict.
+func restoreRecords[T any](records []T, changes []RecordChange, useBefore bool, id func(T) string) ([]T, error) {
+	for _, change := range changes {
+		image, current := change.After, change.Before
+		if useBefore {
+			image, current = change.Before, change.After
+		}
+
+		pos := -1
+		for i, rec := range records {
+			if id(rec) == change.ID {
+				pos = i
+				break
+			}
+		}
+		same, err := matchesImage(records, pos, current)
+		if err != nil {
+			return records, err
+		}
+		if !same {
+			return records, fmt.Errorf("record %s %w", change.ID, errJournalConflict)
+		}
+
+		if len(image) == 0 {
+			if pos >= 0 {
+				records = append(records[:pos], records[pos+1:]...)
+			}
+			continue
+		}
+
+		var rec T
+		if err := json.Unmarshal(image, &rec); err != nil {
+			return records, fmt.Errorf("failed to parse journal image: %w", err)
+		}
+		if pos >= 0 {
+			records[pos] = rec
+			continue
+		}
+		index := change.Index
+		if index < 0 || index > len(records) {
+			index = len(records)
+		}
+		records = append(records, rec)
+		copy(records[index+1:], records[index:])
+		records[index] = rec
+	}
+	return records, nil
+}
+
+// matchesImage reports whether the record at pos, or its absence when pos is
+// negative, is what image describes. Clocks and short ID numbers are kept up
+// by storage rather than by the change, so they are not compared.
+func matchesImage[T any](records []T, pos int, image json.RawMessage) (bool, error) {
+	if pos < 0 || len(image) == 0 {
+		return pos < 0 && len(image) == 0, nil
+	}
+	data, err := json.Marshal(records[pos])
+	if err != nil {
+		return false, fmt.Errorf("failed to serialize record: %w", err)
+	}
+	var got, want map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		return false, fmt.Errorf("failed to parse record: %w", err)
+	}
+	if err := json.Unmarshal(image, &want); err != nil {
+		return false, fmt.Errorf("failed to parse journal image: %w", err)
+	}
+	for _, key := range []string{"clock", "number"} {
+		delete(got, key)
+		delete(want, key)
+	}
+	return reflect.DeepEqual(got, want), nil
+}
+
+// summarizeChanges describes a change set the way tools are named, e.g.
+// "delete_task: Fix login bug" or "complete_task: Deploy (+2 more)"
+func summarizeChanges(kind string, changes []RecordChange) string {
+	if len(changes) == 0 {
+		return ""
+	}
+	op, title := describeChange(kind, changes[0])
+	summary := fmt.Sprintf("%s: %s", op, title)
+	if len(changes) > 1 {
+		summary = fmt.Sprintf("%s (+%d more)", summary, len(changes)-1)
+	}
+	return summary
+}
+
+func describeChange(kind string, change RecordChange) (string, string) {
+	noun := "task"
+	if kind == journalKindNotes {
+		noun = "note"
+	}
+
+	var before, after struct {
+		Title     string     `json:"title"`
+		Completed bool       `json:"completed"`
+		DeletedAt *time.Time `json:"deleted_at"`
+	}
+	if len(change.Before) > 0 {
+		_ = json.Unmarshal(change.Before, &before)
+	}
+	if len(change.After) > 0 {
+		_ = json.Unmarshal(change.After, &after)
+	}
+
+	switch {
+	case len(change.Before) == 0:
+		return "add_" + noun, after.Title
+	case len(change.After) == 0 && before.DeletedAt != nil:
+		return "purge_" + noun, before.Title
+	case len(change.After) == 0, before.DeletedAt == nil && after.DeletedAt != nil:
+		return "delete_" + noun, before.Title
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		return "restore_" + noun, after.Title
+	case kind == journalKindTasks && !before.Completed && after.Completed:
+		return "complete_task", after.Title
+	default:
+		return "update_" + noun, after.Title
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJournaledRepository(t *testing.T) *JournaledRepository {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	storage, err := NewStorage(newTestLogger())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return NewJournaledRepository(storage, NewJournal(), newTestLogger())
+}
+
+func taskTitles(t *testing.T, repo Repository) []string {
+	t.Helper()
+	tasks, err := repo.LoadTasks()
+	if err != nil {
+		t.Fatalf("failed to load tasks: %v", err)
+	}
+	titles := make([]string, 0, len(tasks.Tasks))
+	for _, task := range tasks.Tasks {
+		titles = append(titles, task.Title)
+	}
+	return titles
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJournaledRepositoryUndo(t *testing.T) {
+	t.Run("undo restores a deleted task at its original position", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"one", "two", "three"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		err := repo.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = append(tasks.Tasks[:1], tasks.Tasks[2:]...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to delete task: %v", err)
+		}
+
+		// act
+		reverted, err := repo.Undo(1)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if len(reverted) != 1 || reverted[0].Summary != "delete_task: two" {
+			t.Fatalf("unexpected reverted entries: %+v", reverted)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"one", "two", "three"}) {
+			t.Fatalf("expected tasks restored in order, got %v", got)
+		}
+	})
+
+	t.Run("undo n reverts several changes and redo reapplies the latest undone", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"one", "two", "three"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+
+		// act
+		reverted, err := repo.Undo(2)
+		if err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+		afterUndo := taskTitles(t, repo)
+		redone, err := repo.Redo()
+		if err != nil {
+			t.Fatalf("failed to redo: %v", err)
+		}
+
+		// assert
+		if len(reverted) != 2 {
+			t.Fatalf("expected 2 reverted entries, got %d", len(reverted))
+		}
+		if !equalStrings(afterUndo, []string{"one"}) {
+			t.Fatalf("expected [one] after undo, got %v", afterUndo)
+		}
+		if redone.Summary != "add_task: two" {
+			t.Fatalf("expected to redo add_task: two, got %q", redone.Summary)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"one", "two"}) {
+			t.Fatalf("expected [one two] after redo, got %v", got)
+		}
+	})
+
+	t.Run("a new change clears the redo stack", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("one", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.Undo(1); err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+		if _, err := repo.AddNote("note", "content", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+
+		// act
+		_, err := repo.Redo()
+
+		// assert
+		if !errors.Is(err, errNothingToRedo) {
+			t.Fatalf("expected %v, got %v", errNothingToRedo, err)
+		}
+	})
+
+	t.Run("undo with empty journal reports nothing to undo", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+
+		// act
+		_, err := repo.Undo(1)
+
+		// assert
+		if !errors.Is(err, errNothingToUndo) {
+			t.Fatalf("expected %v, got %v", errNothingToUndo, err)
+		}
+	})
+}
+
+// failingSaveRepository runs changes and then fails to save the tasks, or
+// with failNotes the notes instead
+type failingSaveRepository struct {
+	Repository
+	failNotes bool
+}
+
+func (r failingSaveRepository) ModifyTasks(fn func(*TaskList) error) error {
+	if r.failNotes {
+		return r.Repository.ModifyTasks(fn)
+	}
+	tasks, err := r.LoadTasks()
+	if err != nil {
+		return err
+	}
+	if err := fn(tasks); err != nil {
+		return err
+	}
+	return errors.New("disk full")
+}
+
+func (r failingSaveRepository) ModifyNotes(fn func(*NoteList) error) error {
+	if !r.failNotes {
+		return r.Repository.ModifyNotes(fn)
+	}
+	notes, err := r.LoadNotes()
+	if err != nil {
+		return err
+	}
+	if err := fn(notes); err != nil {
+		return err
+	}
+	return errors.New("disk full")
+}
+
+func TestJournaledRepositoryConsistency(t *testing.T) {
+	t.Run("undo refuses to overwrite a record changed outside the journal", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("one", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		err := repo.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Priority = "high"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to edit task: %v", err)
+		}
+		err = repo.Repository.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Title = "renamed elsewhere"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to rename task: %v", err)
+		}
+
+		// act
+		_, err = repo.Undo(1)
+
+		// assert
+		if !errors.Is(err, errJournalConflict) {
+			t.Fatalf("expected %v, got %v", errJournalConflict, err)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"renamed elsewhere"}) {
+			t.Fatalf("expected the outside rename to survive, got %v", got)
+		}
+	})
+
+	t.Run("a change whose save fails is journaled as aborted", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		failing := NewJournaledRepository(failingSaveRepository{Repository: repo.Repository}, repo.journal, newTestLogger())
+
+		// act
+		_, addErr := failing.AddTask("lost", nil, "", nil)
+		_, undoErr := repo.Undo(1)
+		items, historyErr := repo.History(0)
+
+		// assert
+		if addErr == nil {
+			t.Fatalf("expected the failed save to be reported")
+		}
+		if !errors.Is(undoErr, errNothingToUndo) {
+			t.Fatalf("expected %v, got %v", errNothingToUndo, undoErr)
+		}
+		if historyErr != nil || len(items) != 0 {
+			t.Fatalf("expected no history, got %+v: %v", items, historyErr)
+		}
+	})
+
+	t.Run("purging a record drops its images from the journal", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("secret", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddTask("kept", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		if _, err := handler.deleteTask(DeleteTaskParams{Query: "secret"}); err != nil {
+			t.Fatalf("failed to delete task: %v", err)
+		}
+
+		// act
+		_, _, err := EmptyTrash(repo, time.Now().Add(time.Minute))
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		entries, err := repo.journal.Entries()
+		if err != nil {
+			t.Fatalf("failed to read journal: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Summary != "add_task: kept" {
+			t.Fatalf("expected only the kept task's entry, got %+v", entries)
+		}
+	})
+
+	t.Run("an oversized journal drops its oldest entries", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		defer func(max int) { journalMaxBytes = max }(journalMaxBytes)
+		journalMaxBytes = 4096
+		title := strings.Repeat("x", 200)
+
+		// act
+		for i := 0; i < 40; i++ {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+
+		// assert
+		entries, err := repo.journal.Entries()
+		if err != nil {
+			t.Fatalf("failed to read journal: %v", err)
+		}
+		if len(entries) == 0 || len(entries) >= 40 {
+			t.Fatalf("expected the oldest entries dropped, got %d entries", len(entries))
+		}
+		if repo.journal.oversized() {
+			t.Fatalf("expected the journal to stay under the cap")
+		}
+		if _, err := repo.Undo(len(entries)); err != nil {
+			t.Fatalf("expected the kept entries to undo, got %v", err)
+		}
+	})
+}
+
+func TestJournaledRepositoryHistory(t *testing.T) {
+	t.Run("history lists newest first and marks undone changes", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("one", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		err := repo.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Completed = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to complete task: %v", err)
+		}
+		if _, err := repo.Undo(1); err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+
+		// act
+		items, err := repo.History(0)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if len(items) != 3 {
+			t.Fatalf("expected 3 history items, got %d", len(items))
+		}
+		if items[0].Entry.Action != journalActionUndo {
+			t.Fatalf("expected newest entry to be the undo, got %q", items[0].Entry.Action)
+		}
+		if items[1].Entry.Summary != "complete_task: one" || !items[1].Undone {
+			t.Fatalf("expected complete_task marked undone, got %+v", items[1])
+		}
+		if items[2].Undone {
+			t.Fatalf("expected add_task to remain applied")
+		}
+	})
+}
@@ -15,6 +15,9 @@ const (
 	logFilePerm = 0o644
 )
 
+// logLevel is the level of the file logger, set from the log_level setting
+var logLevel = new(slog.LevelVar)
+
 // GetLogDir returns the directory used for log files (~/.kiki).
 func GetLogDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
@@ -42,7 +45,7 @@ func NewFileLogger() (*slog.Logger, io.Closer, error) {
 	}
 
 	handler := slog.NewTextHandler(file, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: logLevel,
 	})
 
 	return slog.New(handler), file, nil
@@ -1,24 +1,62 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
-	version   = "dev"
-	prompt    string
-	model     string
-	appLogger *slog.Logger
+	version      = "dev"
+	prompt       string
+	contextFiles []string
+	model        string
+	migrateTo    string
+	dryRun       bool
+	historyN     int
+	assumeYes    bool
+	initGit      bool
+	gitRemote    string
+	initProject  bool
+	fsckRepair   bool
+
+	taskDue         string
+	taskPriority    string
+	taskTags        []string
+	editTitle       string
+	editDue         string
+	editNoDue       bool
+	editPriority    string
+	editTags        []string
+	taskScope       string
+	noteTags        []string
+	noteTag         string
+	searchOnlyTasks bool
+	searchOnlyNotes bool
+	searchScope     string
+	searchLimit     int
+	appLogger       *slog.Logger
 )
 
 const (
 	exitFailureCode = 1
 	defaultModel    = "gpt-4.1"
+
+	defaultHistoryLimit = 20
+	historyTimeLayout   = "2006-01-02 15:04:05"
 )
 
 var rootCmd = &cobra.Command{
@@ -30,21 +68,61 @@ Examples:
   kiki -p "add task: buy milk tomorrow"
   kiki -p "list my tasks"
   kiki -p "what did I note about the API?"
+  git log --since=yesterday | kiki -p "make notes of what I shipped"
+  kiki -p "turn this into tasks" --file meeting.md
+  kiki chat
+  kiki task ls incomplete
   kiki init`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadAppConfig(); err != nil {
+			return err
+		}
+		return CheckActiveProfile()
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if prompt == "" {
 			return cmd.Help()
 		}
-		return runPrompt(appLogger, prompt)
+		return runPrompt(appLogger, prompt, contextFiles)
 	},
 }
 
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize Kiki configuration",
-	Long:  `Creates the Kiki configuration directory and initializes required files.`,
+	Long: `Creates the Kiki configuration directory and initializes required files.
+With --git, the directory also becomes a git repository and every change to
+tasks or notes is committed. With --project, a .kiki store is created in the
+current directory; kiki uses it from there and every directory below.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runInit(InitOptions{Git: initGit, Remote: gitRemote, Project: initProject})
+	},
+}
+
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Talk to Kiki in an interactive session",
+	Long: `Keeps one Copilot client and session open across many prompts. Lines can be
+edited, and earlier input is recalled with the arrow keys; the history is kept
+in the state directory ($XDG_STATE_HOME/kiki). End a line with \ to continue
+it on the next one. Type /help for the slash commands.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit()
+		return runChat(appLogger)
+	},
+}
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Browse and edit tasks and notes full screen",
+	Long: `Shows tasks and notes side by side. Tasks can be filtered like list_tasks,
+completed and reopened, and given a new priority or due date; both lists can be
+narrowed to a tag, and notes open in a full viewer. The bar at the bottom sends
+prompts to Kiki, and the lists refresh as its tools change them. Copilot is
+only started once the first prompt is sent.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTUI(appLogger)
 	},
 }
 
@@ -65,12 +143,564 @@ var refreshCmd = &cobra.Command{
 	},
 }
 
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate Kiki data files or storage backend",
+	Long: `Upgrades tasks.json and notes.json to the current schema version, backing up
+each file before it is changed. With --to, copies existing tasks and notes into
+another storage backend instead.
+
+Examples:
+  kiki migrate --dry-run
+  kiki migrate
+  kiki migrate --to sqlite`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateTo != "" {
+			return runMigrateBackend(migrateTo, dryRun)
+		}
+		return runMigrateSchema(dryRun)
+	},
+}
+
+var undoCmd = &cobra.Command{
+	Use:   "undo [n]",
+	Short: "Undo the last n changes to tasks and notes",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n := 1
+		if len(args) == 1 {
+			parsed, err := strconv.Atoi(args[0])
+			if err != nil || parsed < 1 {
+				return fmt.Errorf("invalid undo count %q", args[0])
+			}
+			n = parsed
+		}
+		return runUndo(n)
+	},
+}
+
+var redoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Redo the most recently undone change",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRedo()
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show recent changes to tasks and notes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runHistory(historyN)
+	},
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage deleted tasks and notes",
+	Long: `Deleted tasks and notes are kept in the trash until they are restored or purged.
+Items older than the retention period (the trash_retention_days setting, default 30)
+are purged automatically.`,
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tasks and notes in the trash",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrashList()
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <id or title>",
+	Short: "Restore a task or note from the trash",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrashRestore(args[0])
+	},
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Permanently delete everything in the trash",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTrashEmpty()
+	},
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Manage snapshots of tasks and notes",
+	Long: `Kiki takes a compressed snapshot of tasks and notes once a day, on the first
+prompt. It keeps the newest snapshot of each of the last 7 days and 4 weeks
+(the backup_daily and backup_weekly settings change those counts).`,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBackupList()
+	},
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Take a snapshot now",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBackupCreate()
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Restore tasks and notes from a snapshot",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBackupRestore(args[0], assumeYes)
+	},
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync tasks and notes with the git remote",
+	Long: `Commits local changes, pulls from the git remote, merges tasks and notes
+record by record and pushes the result. Requires 'kiki init --git'.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSync(gitRemote)
+	},
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <file>",
+	Short: "Merge a copy of tasks.json or notes.json into Kiki's data",
+	Long: `Merges a copy of tasks.json or notes.json, such as a conflict copy left by
+Dropbox or Syncthing, into Kiki's data by record ID. Conflict copies in the
+data directory are also merged automatically whenever Kiki loads its data.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMerge(args[0])
+	},
+}
+
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt tasks and notes with a passphrase",
+	Long: `Encrypts tasks, notes, the undo journal and snapshots with a key derived from
+a passphrase. The unlocked key is cached for key_cache_minutes (default 15)
+so Kiki does not ask on every command. Set KIKI_PASSPHRASE to skip the prompt.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runEncrypt()
+	},
+}
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Store tasks and notes as plaintext again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDecrypt()
+	},
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Forget the cached encryption key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLock()
+	},
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check tasks and notes for inconsistencies",
+	Long: `Checks every task and note, including the trash, for duplicate IDs, invalid
+priorities, malformed due dates and update times earlier than creation times.
+With --repair the problems are fixed; 'kiki undo' reverts the repairs.
+
+A data file that cannot be read at all is recovered automatically by any
+command: it is moved aside, intact records are salvaged, and missing records
+are filled in from the newest readable snapshot.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFsck(fsckRepair)
+	},
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the Copilot setup, directories and data files",
+	Long: `Checks that the Copilot CLI can be found and started, that you are signed in,
+that the model is available, that the data and log directories are writable with
+the expected permissions, and that the data files parse. Each failure or
+warning comes with a hint on how to fix it. Nothing is changed.`,
+	Args: cobra.NoArgs,
+	// Failed checks are not usage errors
+	SilenceUsage: true,
+	// The profile is one of the checks, so a missing profile must not stop the command
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return loadAppConfig()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDoctor(model)
+	},
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search tasks and notes, best matches first",
+	Long: `Searches the titles, tags and note content of tasks and notes. Every word must
+match, and words also find their other forms, such as deploy for deploying.
+
+  "exact phrase"       words next to each other
+  depl*                words starting with depl
+  tag:infra            only items with the tag
+  after:2026-01-01     only items created after the date
+  before:2026-02-01    only items created before the date
+
+Example: kiki search oauth "token refresh" tag:api after:2026-01-01`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSearch(strings.Join(args, " "))
+	},
+}
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Show or manage profiles",
+	Long: `Profiles keep separate tasks, notes and Copilot sessions, for example for work
+and personal use. The active profile is chosen with --profile, then KIKI_PROFILE,
+then 'kiki profile use'; otherwise the default profile is used.`,
+	Args: cobra.NoArgs,
+	// Profile commands work even when the selected profile does not exist yet
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return loadAppConfig()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProfileShow()
+	},
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProfileList()
+	},
+}
+
+var profileCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProfileCreate(args[0])
+	},
+}
+
+var profileDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a profile and all of its tasks and notes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProfileDelete(args[0], assumeYes)
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Switch the default profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProfileUse(args[0])
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show and change settings in config.toml",
+	Long: `Settings are read from KIKI_* environment variables, then config.toml in the
+kiki config directory, then the built-in defaults. Flags such as --model override
+all of them for a single command.`,
+	// config has to work while config.toml is invalid, so it can be fixed
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigList()
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every setting with its value and where it comes from",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigList()
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigGet(args[0])
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting in config.toml; an empty value restores the default",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigSet(args[0], args[1])
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Open config.toml in $VISUAL or $EDITOR",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigEdit()
+	},
+}
+
+var taskCmd = &cobra.Command{
+	Use:   "task",
+	Short: "Manage tasks directly, without Copilot",
+	Long: `Adds, lists, completes, deletes and edits tasks instantly and offline. Tasks are
+matched by short ID, ID or title and filtered exactly as the assistant does.`,
+}
+
+var taskAddCmd = &cobra.Command{
+	Use:   "add <title>",
+	Short: "Add a task",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTaskAdd(strings.Join(args, " "), taskDue, taskPriority, taskTags)
+	},
+}
+
+var taskLsCmd = &cobra.Command{
+	Use:   "ls [all|today|incomplete|completed]",
+	Short: "List tasks",
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{
+		"all\tevery task", "today\tdue or added today", "incomplete\topen tasks", "completed\tdone tasks",
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := "all"
+		if len(args) > 0 {
+			filter = args[0]
+		}
+		return runTaskList(filter, taskScope)
+	},
+}
+
+var taskDoneCmd = &cobra.Command{
+	Use:   "done <id or title>",
+	Short: "Mark a task as completed",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTaskDone(strings.Join(args, " "))
+	},
+}
+
+var taskRmCmd = &cobra.Command{
+	Use:   "rm <id or title>",
+	Short: "Move a task to the trash",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTaskRm(strings.Join(args, " "))
+	},
+}
+
+var taskEditCmd = &cobra.Command{
+	Use:   "edit <id or title>",
+	Short: "Change a task's title, due date, priority or tags",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changes := TaskChanges{ClearDueDate: editNoDue}
+		if cmd.Flags().Changed("title") {
+			changes.Title = &editTitle
+		}
+		if cmd.Flags().Changed("due") {
+			changes.DueDate = &editDue
+		}
+		if cmd.Flags().Changed("priority") {
+			changes.Priority = &editPriority
+		}
+		if cmd.Flags().Changed("tag") {
+			changes.Tags = editTags
+		}
+		return runTaskEdit(strings.Join(args, " "), changes)
+	},
+}
+
+var noteCmd = &cobra.Command{
+	Use:   "note",
+	Short: "Manage notes directly, without Copilot",
+	Long: `Adds, lists, searches, shows and deletes notes instantly and offline. Notes are
+matched by short ID, ID or title and filtered exactly as the assistant does.`,
+}
+
+var noteAddCmd = &cobra.Command{
+	Use:   "add <title> [content]",
+	Short: "Add a note",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		content := ""
+		if len(args) > 1 {
+			content = args[1]
+		}
+		return runNoteAdd(args[0], content, noteTags)
+	},
+}
+
+var noteLsCmd = &cobra.Command{
+	Use:       "ls [all|today]",
+	Short:     "List notes",
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"all\tevery note", "today\tnotes created today"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := "all"
+		if len(args) > 0 {
+			filter = args[0]
+		}
+		var tag *string
+		if cmd.Flags().Changed("tag") {
+			tag = &noteTag
+		}
+		return runNoteList(filter, tag)
+	},
+}
+
+var noteSearchCmd = &cobra.Command{
+	Use:   "search <keyword>",
+	Short: "Search notes, best matches first (see 'kiki search')",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNoteSearch(strings.Join(args, " "))
+	},
+}
+
+var noteShowCmd = &cobra.Command{
+	Use:   "show <id or title>",
+	Short: "Print a note in full",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNoteShow(strings.Join(args, " "))
+	},
+}
+
+var noteRmCmd = &cobra.Command{
+	Use:   "rm <id or title>",
+	Short: "Move a note to the trash",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNoteRm(strings.Join(args, " "))
+	},
+}
+
 func init() {
 	rootCmd.Flags().StringVarP(&prompt, "prompt", "p", "", "Send a prompt to Kiki")
-	rootCmd.Flags().StringVar(&model, "model", defaultModel, "Model to use for the session")
+	rootCmd.Flags().StringVar(&model, "model", "", "Model to use for the session (default: the model setting)")
+	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "Output format: text, json or quiet")
+	rootCmd.Flags().StringArrayVarP(&contextFiles, "file", "f", nil, "File to include with the prompt as context (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&profileFlag, "profile", "", "Profile to use (overrides KIKI_PROFILE)")
+	rootCmd.PersistentFlags().BoolVar(&globalFlag, "global", false, "Ignore any project store (.kiki) and use the profile's data")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "Target storage backend (sqlite)")
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would change without writing anything")
+	initCmd.Flags().BoolVar(&initGit, "git", false, "Version the data directory with git")
+	initCmd.Flags().StringVar(&gitRemote, "remote", "", "Git remote URL used by 'kiki sync'")
+	initCmd.Flags().BoolVar(&initProject, "project", false, "Create a project store (.kiki) in the current directory")
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(refreshCmd)
+	chatCmd.Flags().StringVar(&model, "model", "", "Model to use for the session (default: the model setting)")
+	rootCmd.AddCommand(chatCmd)
+	tuiCmd.Flags().StringVar(&model, "model", "", "Model to use for prompts (default: the model setting)")
+	rootCmd.AddCommand(tuiCmd)
+	historyCmd.Flags().IntVarP(&historyN, "limit", "n", defaultHistoryLimit, "Number of entries to show (0 for all)")
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(redoCmd)
+	rootCmd.AddCommand(historyCmd)
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	rootCmd.AddCommand(trashCmd)
+	backupRestoreCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Restore without asking for confirmation")
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	rootCmd.AddCommand(backupCmd)
+	syncCmd.Flags().StringVar(&gitRemote, "remote", "", "Set the git remote URL before syncing")
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(encryptCmd)
+	rootCmd.AddCommand(decryptCmd)
+	rootCmd.AddCommand(lockCmd)
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "Fix the problems found")
+	rootCmd.AddCommand(fsckCmd)
+	doctorCmd.Flags().StringVar(&model, "model", "", "Model to check for (default: the model setting)")
+	rootCmd.AddCommand(doctorCmd)
+	taskAddCmd.Flags().StringVar(&taskDue, "due", "", "Due date (YYYY-MM-DD)")
+	taskAddCmd.Flags().StringVar(&taskPriority, "priority", "", "Priority: low, medium or high (default: the default_priority setting)")
+	taskAddCmd.Flags().StringArrayVar(&taskTags, "tag", nil, "Tag to add (repeatable)")
+	taskLsCmd.Flags().StringVar(&taskScope, "scope", "", "Inside a project: project, global or all")
+	taskEditCmd.Flags().StringVar(&editTitle, "title", "", "New title")
+	taskEditCmd.Flags().StringVar(&editDue, "due", "", "New due date (YYYY-MM-DD)")
+	taskEditCmd.Flags().BoolVar(&editNoDue, "no-due", false, "Remove the due date")
+	taskEditCmd.Flags().StringVar(&editPriority, "priority", "", "New priority: low, medium or high")
+	taskEditCmd.Flags().StringArrayVar(&editTags, "tag", nil, "Replace the tags (repeatable)")
+	taskEditCmd.MarkFlagsMutuallyExclusive("due", "no-due")
+	taskCmd.AddCommand(taskAddCmd)
+	taskCmd.AddCommand(taskLsCmd)
+	taskCmd.AddCommand(taskDoneCmd)
+	taskCmd.AddCommand(taskRmCmd)
+	taskCmd.AddCommand(taskEditCmd)
+	rootCmd.AddCommand(taskCmd)
+	noteAddCmd.Flags().StringArrayVar(&noteTags, "tag", nil, "Tag to add (repeatable)")
+	noteLsCmd.Flags().StringVar(&noteTag, "tag", "", "Only notes with this tag")
+	noteCmd.AddCommand(noteAddCmd)
+	noteCmd.AddCommand(noteLsCmd)
+	noteCmd.AddCommand(noteSearchCmd)
+	noteCmd.AddCommand(noteShowCmd)
+	noteCmd.AddCommand(noteRmCmd)
+	rootCmd.AddCommand(noteCmd)
+	searchCmd.Flags().BoolVar(&searchOnlyTasks, "tasks", false, "Only search tasks")
+	searchCmd.Flags().BoolVar(&searchOnlyNotes, "notes", false, "Only search notes")
+	searchCmd.Flags().StringVar(&searchScope, "scope", "", "Inside a project: project, global or all")
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", searchLimitDefault, "Most results to show of each kind")
+	searchCmd.MarkFlagsMutuallyExclusive("tasks", "notes")
+	rootCmd.AddCommand(searchCmd)
+	profileDeleteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Delete without asking for confirmation")
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileCreateCmd)
+	profileCmd.AddCommand(profileDeleteCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	rootCmd.AddCommand(profileCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configEditCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(completionCmd)
+	registerCompletions()
 }
 
 func main() {
@@ -87,6 +717,7 @@ func main() {
 
 	appLogger = logger
 	slog.SetDefault(logger)
+	recoveryNotifier = printRecoveryReport
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -103,74 +734,1242 @@ func main() {
 		if _, printErr := fmt.Fprintln(os.Stderr, err); printErr != nil {
 			logger.Error("failed to write error", "error", printErr)
 		}
+		if errors.Is(err, errToolFailed) {
+			os.Exit(exitToolFailureCode)
+		}
 		os.Exit(exitFailureCode)
 	}
 }
 
-func runPrompt(logger *slog.Logger, prompt string) error {
-	storage, err := NewStorage(logger)
+func runPrompt(logger *slog.Logger, prompt string, files []string) error {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	sources, err := readPromptContext(os.Stdin, files)
 	if err != nil {
-		return fmt.Errorf("initializing storage: %w", err)
+		return err
+	}
+	for _, source := range sources {
+		if !source.Truncated() {
+			continue
+		}
+		if _, err := fmt.Fprintf(os.Stderr, "⚠️  %s is %d bytes; only the first %d were sent\n",
+			source.Name, source.Size, len(source.Content)); err != nil {
+			return fmt.Errorf("writing prompt output: %w", err)
+		}
 	}
 
-	kiki, err := NewKiki(storage, logger, model)
+	kiki, closeKiki, err := openKiki(logger)
 	if err != nil {
-		return fmt.Errorf("initializing Kiki: %w", err)
+		return err
 	}
-	defer kiki.Close()
+	defer closeKiki()
 
-	_, err = kiki.Run(prompt, os.Stdout)
-	if err != nil {
-		return fmt.Errorf("running prompt: %w", err)
+	recorder := newPromptRecorder()
+	kiki.Record(recorder)
+	out := io.Writer(os.Stdout)
+	if outputFormat != outputText {
+		out = io.Discard
+	}
+	started := time.Now()
+	message, runErr := kiki.Run(withPromptContext(prompt, sources), out)
+	report := recorder.report(message, kiki.model, getDailySessionID(), started)
+
+	if outputFormat == outputJSON {
+		if runErr != nil {
+			report.Error = runErr.Error()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("writing prompt output: %w", err)
+		}
+	}
+	if runErr != nil {
+		return fmt.Errorf("running prompt: %w", runErr)
+	}
+	if failed := report.FailedCalls(); failed > 0 {
+		return fmt.Errorf("%d of %d tool calls failed: %w", failed, len(report.ToolCalls), errToolFailed)
 	}
 	return nil
 }
 
-func runInit() error {
-	configDir := GetConfigDir()
-
-	if err := InitStorage(); err != nil {
-		return fmt.Errorf("initializing storage: %w", err)
+// openStores does the daily housekeeping and opens the active store and,
+// inside a project, the global store (nil otherwise)
+func openStores(logger *slog.Logger) (*JournaledRepository, Repository, func(), error) {
+	storage, err := NewRepository(logger)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("initializing storage: %w", err)
 	}
 
-	if _, err := fmt.Fprintln(os.Stdout, "✅ Kiki initialized successfully!"); err != nil {
-		return fmt.Errorf("writing init output: %w", err)
+	if tasks, notes, err := PurgeExpiredTrash(storage); err != nil {
+		logger.Error("failed to purge trash", "error", err)
+	} else if tasks+notes > 0 {
+		logger.Info("purged expired trash", "tasks", tasks, "notes", notes)
 	}
-	if _, err := fmt.Fprintf(os.Stdout, "📁 Config directory: %s\n", configDir); err != nil {
-		return fmt.Errorf("writing init output: %w", err)
+	if snapshot, created, err := EnsureDailySnapshot(storage); err != nil {
+		logger.Error("failed to take daily snapshot", "error", err)
+	} else if created {
+		logger.Info("took daily snapshot", "id", snapshot.ID)
 	}
-	if _, err := fmt.Fprintf(os.Stdout, "📝 Tasks file: %s/tasks.json\n", configDir); err != nil {
-		return fmt.Errorf("writing init output: %w", err)
+
+	global, err := NewGlobalRepository(logger)
+	if err != nil {
+		closeRepository(logger, storage)
+		return nil, nil, nil, fmt.Errorf("initializing global storage: %w", err)
 	}
-	if _, err := fmt.Fprintf(os.Stdout, "📝 Notes file: %s/notes.json\n", configDir); err != nil {
-		return fmt.Errorf("writing init output: %w", err)
+	return storage, global, func() {
+		closeRepository(logger, storage)
+		if global != nil {
+			closeRepository(logger, global)
+		}
+	}, nil
+}
+
+// openKiki starts Kiki on the stores opened by openStores
+func openKiki(logger *slog.Logger) (*Kiki, func(), error) {
+	storage, global, closeStores, err := openStores(logger)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
+	kiki, err := NewKiki(storage, logger, model)
+	if err != nil {
+		closeStores()
+		return nil, nil, fmt.Errorf("initializing Kiki: %w", err)
+	}
+	kiki.SetGlobalStore(global)
+	return kiki, func() {
+		kiki.Close()
+		closeStores()
+	}, nil
 }
 
-func runRefresh() error {
-	storage, err := NewStorage(appLogger)
+func runTUI(logger *slog.Logger) error {
+	storage, global, closeStores, err := openStores(logger)
 	if err != nil {
-		return fmt.Errorf("initializing storage: %w", err)
+		return err
 	}
+	defer closeStores()
 
-	kiki, err := NewKiki(storage, appLogger, model)
+	// Refresh the lists whenever storage changes, including from Kiki's tool calls
+	changes := make(chan struct{}, 1)
+	notify := func(JournalEntry) {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	storage.OnChange(notify)
+	if journaled, ok := global.(*JournaledRepository); ok {
+		journaled.OnChange(notify)
+	}
+
+	var kiki *Kiki
+	defer func() {
+		if kiki != nil {
+			kiki.Close()
+		}
+	}()
+	ask := func(prompt string, out io.Writer) error {
+		if kiki == nil {
+			k, err := NewKiki(storage, logger, model)
+			if err != nil {
+				return fmt.Errorf("initializing Kiki: %w", err)
+			}
+			k.SetGlobalStore(global)
+			kiki = k
+		}
+		_, err := kiki.Run(prompt, out)
+		return err
+	}
+
+	tools := NewToolHandler(storage, logger)
+	tools.SetGlobalStore(global)
+	if _, err := tea.NewProgram(newTUIModel(tools, ask, changes), tea.WithAltScreen()).Run(); err != nil {
+		return fmt.Errorf("running tui: %w", err)
+	}
+	return nil
+}
+
+func runChat(logger *slog.Logger) error {
+	kiki, closeKiki, err := openKiki(logger)
 	if err != nil {
-		return fmt.Errorf("initializing Kiki: %w", err)
+		return err
 	}
-	defer kiki.Close()
+	defer closeKiki()
 
-	found, err := kiki.RefreshSession()
+	chat, err := kiki.StartChat()
 	if err != nil {
-		return fmt.Errorf("refreshing session: %w", err)
+		return fmt.Errorf("starting chat: %w", err)
 	}
+	defer chat.Close()
 
-	message := "Kiki session refreshed."
-	if !found {
-		message = "No existing session found. Next prompt will start a fresh session."
+	repl := &chatREPL{chat: chat, tools: kiki.tools, out: os.Stdout}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		repl.in = &plainReader{r: bufio.NewReader(os.Stdin)}
+		return repl.run()
+	}
+
+	stateDir, err := GetStateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir, stateDirPerm); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	history, err := loadChatHistory(filepath.Join(stateDir, chatHistoryFile), logger)
+	if err != nil {
+		return err
+	}
+	repl.in = newTerminalReader(os.Stdin, os.Stdout, history)
+	if _, err := fmt.Fprintf(os.Stdout, "💬 Chatting with Kiki (%s). Type /help for commands, /quit to leave.\n", chat.Model()); err != nil {
+		return fmt.Errorf("writing chat output: %w", err)
+	}
+	return repl.run()
+}
+
+func runInit(opts InitOptions) error {
+	if err := InitStorage(opts); err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	configDir := GetConfigDir()
+
+	if _, err := fmt.Fprintln(os.Stdout, "✅ Kiki initialized successfully!"); err != nil {
+		return fmt.Errorf("writing init output: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📁 Config directory: %s\n", configDir); err != nil {
+		return fmt.Errorf("writing init output: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📝 Tasks file: %s/tasks.json\n", configDir); err != nil {
+		return fmt.Errorf("writing init output: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📝 Notes file: %s/notes.json\n", configDir); err != nil {
+		return fmt.Errorf("writing init output: %w", err)
+	}
+	if opts.Git || opts.Remote != "" {
+		if _, err := fmt.Fprintln(os.Stdout, "🌱 Git history enabled"); err != nil {
+			return fmt.Errorf("writing init output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runMerge(path string) error {
+	if activeBackend() == backendSQLite {
+		return fmt.Errorf("merging copies needs the JSON backend, but data is stored in %s", sqliteFile)
+	}
+	storage, err := NewStorage(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+
+	report, err := storage.MergeFile(path)
+	if err != nil {
+		return fmt.Errorf("merging %s: %w", path, err)
+	}
+	if !report.Changed() {
+		if _, err := fmt.Fprintf(os.Stdout, "🤝 %s had nothing new for %s\n", path, report.Kind); err != nil {
+			return fmt.Errorf("writing merge output: %w", err)
+		}
+		return nil
+	}
+	_, err = fmt.Fprintf(os.Stdout, "🤝 Merged %s into %s: %d added, %d updated, %d removed, %d conflicted copies kept\n",
+		path, report.Kind, report.Added, report.Updated, report.Removed, report.Conflicts)
+	if err != nil {
+		return fmt.Errorf("writing merge output: %w", err)
+	}
+	return nil
+}
+
+func runEncrypt() error {
+	passphrase, err := readPassphrase("🔑 New passphrase: ")
+	if err != nil {
+		return err
+	}
+	repeated, err := readPassphrase("🔑 Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	if passphrase != repeated {
+		return errors.New("passphrases do not match")
+	}
+
+	result, err := EncryptData(appLogger, passphrase)
+	if err != nil {
+		return fmt.Errorf("encrypting data: %w", err)
+	}
+	return printVaultResult("🔒 Encrypted", result)
+}
+
+func runDecrypt() error {
+	result, err := DecryptData(appLogger)
+	if err != nil {
+		return fmt.Errorf("decrypting data: %w", err)
+	}
+	return printVaultResult("🔓 Decrypted", result)
+}
+
+func printVaultResult(verb string, result *EncryptResult) error {
+	if _, err := fmt.Fprintf(os.Stdout, "%s %s and %d snapshot(s)\n", verb, strings.Join(result.Files, ", "), result.Snapshots); err != nil {
+		return fmt.Errorf("writing encryption output: %w", err)
+	}
+	if result.GitWarned {
+		if _, err := fmt.Fprintln(os.Stdout, "⚠️  Earlier git commits keep the data as it was when committed"); err != nil {
+			return fmt.Errorf("writing encryption output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runLock() error {
+	if err := NewVault().Lock(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(os.Stdout, "🔒 Cached key forgotten"); err != nil {
+		return fmt.Errorf("writing lock output: %w", err)
+	}
+	return nil
+}
+
+func runFsck(repair bool) error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	result, err := Fsck(repo, repair)
+	if err != nil {
+		return fmt.Errorf("checking data: %w", err)
+	}
+	for _, issue := range result.Issues {
+		action := "fix: " + issue.Repair
+		if repair {
+			action = "fixed: " + issue.Repair
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "⚠️  %s %s %q: %s (%s)\n",
+			issue.File, issue.ID, issue.Title, issue.Problem, action); err != nil {
+			return fmt.Errorf("writing fsck output: %w", err)
+		}
+	}
+
+	message := fmt.Sprintf("✅ Checked %d tasks and %d notes; no problems found", result.Tasks, result.Notes)
+	switch {
+	case len(result.Issues) > 0 && repair:
+		message = fmt.Sprintf("🔧 Repaired %d problems; 'kiki undo' reverts the repairs", len(result.Issues))
+	case len(result.Issues) > 0:
+		message = fmt.Sprintf("❌ Found %d problems; run 'kiki fsck --repair' to fix them", len(result.Issues))
+	}
+	if _, err := fmt.Fprintln(os.Stdout, message); err != nil {
+		return fmt.Errorf("writing fsck output: %w", err)
+	}
+	return nil
+}
+
+func runDoctor(model string) error {
+	checks := NewDoctor(doctorModel(model)).Run()
+	icons := map[string]string{checkPass: "✅", checkWarn: "⚠️ ", checkFail: "❌"}
+	for _, check := range checks {
+		if _, err := fmt.Fprintf(os.Stdout, "%s %s: %s\n", icons[check.Status], check.Name, check.Detail); err != nil {
+			return fmt.Errorf("writing doctor output: %w", err)
+		}
+		if check.Status != checkPass && check.Hint != "" {
+			if _, err := fmt.Fprintf(os.Stdout, "   → %s\n", check.Hint); err != nil {
+				return fmt.Errorf("writing doctor output: %w", err)
+			}
+		}
+	}
+	if checksFailed(checks) {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
+
+// doctorModel is the model doctor checks: the --model flag, or else the model setting
+func doctorModel(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return appConfig.Model
+}
+
+// printRecoveryReport tells the user that a corrupt data file was recovered and what was lost
+func printRecoveryReport(report RecoveryReport) {
+	lines := []string{
+		fmt.Sprintf("🩹 %s was corrupt (%s)", report.File, report.Cause),
+		fmt.Sprintf("   The damaged file was kept as %s", report.CorruptCopy),
+		fmt.Sprintf("   Salvaged %d intact records from it", report.Salvaged),
+	}
+	if report.Unreadable > 0 {
+		lines = append(lines, fmt.Sprintf("   Lost %d damaged records that could not be read", report.Unreadable))
+	}
+	switch {
+	case report.FromSnapshot > 0:
+		lines = append(lines, fmt.Sprintf("   Restored %d records from snapshot %s; changes to them since %s are lost",
+			report.FromSnapshot, report.Snapshot, report.SnapshotAt.Format(historyTimeLayout)))
+	case report.Snapshot == "" && (report.Salvaged == 0 || report.Unreadable > 0):
+		lines = append(lines, "   No readable snapshot was available to fill in missing records")
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(os.Stderr, line); err != nil {
+			appLogger.Error("failed to write recovery report", "error", err)
+			return
+		}
+	}
+}
+
+func runProfileShow() error {
+	if _, err := fmt.Fprintf(os.Stdout, "👤 Active profile: %s\n", ActiveProfile()); err != nil {
+		return fmt.Errorf("writing profile output: %w", err)
+	}
+	return nil
+}
+
+func runProfileList() error {
+	profiles, err := ListProfiles()
+	if err != nil {
+		return err
+	}
+	active := ActiveProfile()
+	for _, name := range profiles {
+		marker := " "
+		if name == active {
+			marker = "*"
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "%s %s\n", marker, name); err != nil {
+			return fmt.Errorf("writing profile output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runProfileCreate(name string) error {
+	if err := CreateProfile(name); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "👤 Created profile %s; switch to it with 'kiki profile use %s'\n", name, name); err != nil {
+		return fmt.Errorf("writing profile output: %w", err)
+	}
+	return nil
+}
+
+func runProfileDelete(name string, skipConfirm bool) error {
+	if !skipConfirm {
+		confirmed, err := confirm(fmt.Sprintf("Delete profile %s and all of its tasks and notes?", name))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			if _, err := fmt.Fprintln(os.Stdout, "Delete cancelled."); err != nil {
+				return fmt.Errorf("writing profile output: %w", err)
+			}
+			return nil
+		}
+	}
+	if err := DeleteProfile(name); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "🗑️  Deleted profile %s\n", name); err != nil {
+		return fmt.Errorf("writing profile output: %w", err)
+	}
+	return nil
+}
+
+func runProfileUse(name string) error {
+	if err := UseProfile(name); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "👤 Switched to profile %s\n", name); err != nil {
+		return fmt.Errorf("writing profile output: %w", err)
+	}
+	if override := os.Getenv(profileEnv); override != "" && override != name {
+		if _, err := fmt.Fprintf(os.Stdout, "⚠️  %s=%s still takes precedence in this shell\n", profileEnv, override); err != nil {
+			return fmt.Errorf("writing profile output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runConfigList() error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📄 %s\n", ConfigPath()); err != nil {
+		return fmt.Errorf("writing config output: %w", err)
+	}
+	for _, key := range configKeys() {
+		value := config.Value(key)
+		if value == "" {
+			value = "(none)"
+		}
+		source := config.Source(key)
+		if source == ConfigPath() {
+			source = configFile
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "%-20s %-24s %s\n", key, value, source); err != nil {
+			return fmt.Errorf("writing config output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runConfigGet(key string) error {
+	if _, ok := findConfigSetting(key); !ok {
+		return unknownConfigKey(key)
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(os.Stdout, config.Value(key)); err != nil {
+		return fmt.Errorf("writing config output: %w", err)
+	}
+	return nil
+}
+
+func runConfigSet(key, value string) error {
+	if err := SetConfigValue(key, value); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("⚙️  Set %s to %s", key, value)
+	if value == "" {
+		message = fmt.Sprintf("⚙️  Reset %s to its default", key)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "%s in %s\n", message, ConfigPath()); err != nil {
+		return fmt.Errorf("writing config output: %w", err)
+	}
+	setting, _ := findConfigSetting(key)
+	if override := os.Getenv(setting.Env); override != "" {
+		if _, err := fmt.Fprintf(os.Stdout, "⚠️  %s=%s still takes precedence in this shell\n", setting.Env, override); err != nil {
+			return fmt.Errorf("writing config output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runConfigEdit() error {
+	if err := EditConfig(); err != nil {
+		return err
+	}
+	if _, err := LoadConfig(); err != nil {
+		return fmt.Errorf("%w; run 'kiki config edit' again to fix it", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "✅ %s is valid\n", ConfigPath()); err != nil {
+		return fmt.Errorf("writing config output: %w", err)
+	}
+	return nil
+}
+
+func runSync(remote string) error {
+	git := NewGitStore(appLogger)
+	if !git.Enabled() {
+		return errGitNotEnabled
+	}
+	if remote != "" {
+		if err := git.SetRemote(remote); err != nil {
+			return fmt.Errorf("setting remote: %w", err)
+		}
+	}
+
+	result, err := git.Sync()
+	if err != nil {
+		return fmt.Errorf("syncing: %w", err)
+	}
+	message := "🔄 Synced; no remote changes to pull"
+	switch {
+	case result.Merged:
+		message = "🔄 Synced; merged remote changes"
+	case result.Pulled:
+		message = "🔄 Synced; pulled remote changes"
+	}
+	if _, err := fmt.Fprintln(os.Stdout, message); err != nil {
+		return fmt.Errorf("writing sync output: %w", err)
+	}
+	return nil
+}
+
+func runRefresh() error {
+	storage, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, storage)
+
+	kiki, err := NewKiki(storage, appLogger, model)
+	if err != nil {
+		return fmt.Errorf("initializing Kiki: %w", err)
+	}
+	defer kiki.Close()
+
+	found, err := kiki.RefreshSession()
+	if err != nil {
+		return fmt.Errorf("refreshing session: %w", err)
+	}
+
+	message := "Kiki session refreshed."
+	if !found {
+		message = "No existing session found. Next prompt will start a fresh session."
 	}
 	if _, err := fmt.Fprintln(os.Stdout, message); err != nil {
 		return fmt.Errorf("writing refresh output: %w", err)
 	}
 	return nil
 }
+
+func runMigrateBackend(target string, dryRun bool) error {
+	if target != backendSQLite {
+		return fmt.Errorf("unsupported migration target %q (supported: %s)", target, backendSQLite)
+	}
+
+	result, err := MigrateToSQLite(appLogger, dryRun)
+	if err != nil {
+		return fmt.Errorf("migrating storage: %w", err)
+	}
+
+	if dryRun {
+		if _, err := fmt.Fprintf(os.Stdout, "Would migrate %d tasks and %d notes from %s to %s\n",
+			result.Tasks, result.Notes, result.From, result.To); err != nil {
+			return fmt.Errorf("writing migrate output: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(os.Stdout, "✅ Migrated %d tasks and %d notes from %s to %s\n",
+		result.Tasks, result.Notes, result.From, result.To); err != nil {
+		return fmt.Errorf("writing migrate output: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "🗄️  Database: %s/%s (JSON files kept as a backup)\n", GetConfigDir(), sqliteFile); err != nil {
+		return fmt.Errorf("writing migrate output: %w", err)
+	}
+	return nil
+}
+
+func runMigrateSchema(dryRun bool) error {
+	storage, err := NewStorage(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+
+	var plans []SchemaPlan
+	if dryRun {
+		plans, err = storage.PlanSchemaMigrations()
+	} else {
+		plans, err = storage.MigrateSchema()
+	}
+	if err != nil {
+		return fmt.Errorf("migrating schema: %w", err)
+	}
+
+	verb := "Migrated"
+	if dryRun {
+		verb = "Would migrate"
+	}
+	for _, plan := range plans {
+		if !plan.Pending() {
+			if _, err := fmt.Fprintf(os.Stdout, "%s is up to date (schema v%d)\n", plan.File, plan.From); err != nil {
+				return fmt.Errorf("writing migrate output: %w", err)
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "%s %s from schema v%d to v%d\n", verb, plan.File, plan.From, plan.To); err != nil {
+			return fmt.Errorf("writing migrate output: %w", err)
+		}
+		for _, step := range plan.Steps {
+			if _, err := fmt.Fprintf(os.Stdout, "  - %s\n", step); err != nil {
+				return fmt.Errorf("writing migrate output: %w", err)
+			}
+		}
+		if !dryRun {
+			if _, err := fmt.Fprintf(os.Stdout, "  backup: %s\n", plan.Backup); err != nil {
+				return fmt.Errorf("writing migrate output: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func runUndo(n int) error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	reverted, err := repo.Undo(n)
+	for _, entry := range reverted {
+		if _, printErr := fmt.Fprintf(os.Stdout, "↩️  Undid %s\n", entry.Summary); printErr != nil {
+			return fmt.Errorf("writing undo output: %w", printErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("undoing: %w", err)
+	}
+	if len(reverted) < n {
+		if _, err := fmt.Fprintf(os.Stdout, "Only %d change(s) could be undone.\n", len(reverted)); err != nil {
+			return fmt.Errorf("writing undo output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runRedo() error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	entry, err := repo.Redo()
+	if err != nil {
+		return fmt.Errorf("redoing: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "↪️  Redid %s\n", entry.Summary); err != nil {
+		return fmt.Errorf("writing redo output: %w", err)
+	}
+	return nil
+}
+
+func runHistory(limit int) error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	items, err := repo.History(limit)
+	if err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	if len(items) == 0 {
+		if _, err := fmt.Fprintln(os.Stdout, "No changes recorded yet."); err != nil {
+			return fmt.Errorf("writing history output: %w", err)
+		}
+		return nil
+	}
+
+	for _, item := range items {
+		marker := " "
+		if item.Undone {
+			marker = "↩"
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "%s %s  %s\n", marker,
+			item.Entry.Time.Local().Format(historyTimeLayout), item.Entry.Summary); err != nil {
+			return fmt.Errorf("writing history output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runTrashList() error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	items, err := ListTrash(repo)
+	if err != nil {
+		return fmt.Errorf("listing trash: %w", err)
+	}
+	if len(items) == 0 {
+		if _, err := fmt.Fprintln(os.Stdout, "The trash is empty."); err != nil {
+			return fmt.Errorf("writing trash output: %w", err)
+		}
+		return nil
+	}
+
+	for _, item := range items {
+		if _, err := fmt.Fprintf(os.Stdout, "%s [%s] %s (deleted %s, id %s)\n", item.ShortID,
+			item.Kind, item.Title, item.DeletedAt.Local().Format(historyTimeLayout), item.ID); err != nil {
+			return fmt.Errorf("writing trash output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runTrashRestore(query string) error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	item, err := RestoreFromTrash(repo, query)
+	if err != nil {
+		return fmt.Errorf("restoring from trash: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "♻️  Restored %s '%s'\n", item.Kind, item.Title); err != nil {
+		return fmt.Errorf("writing trash output: %w", err)
+	}
+	return nil
+}
+
+func runTrashEmpty() error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	tasks, notes, err := EmptyTrash(repo, time.Time{})
+	if err != nil {
+		return fmt.Errorf("emptying trash: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "🗑️  Permanently deleted %d tasks and %d notes\n", tasks, notes); err != nil {
+		return fmt.Errorf("writing trash output: %w", err)
+	}
+	return nil
+}
+
+func runBackupList() error {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return fmt.Errorf("listing snapshots: %w", err)
+	}
+	if len(snapshots) == 0 {
+		if _, err := fmt.Fprintln(os.Stdout, "No snapshots yet."); err != nil {
+			return fmt.Errorf("writing backup output: %w", err)
+		}
+		return nil
+	}
+
+	for _, snapshot := range snapshots {
+		if _, err := fmt.Fprintf(os.Stdout, "%s  %s  %d bytes\n", snapshot.ID,
+			snapshot.CreatedAt.Format(historyTimeLayout), snapshot.Size); err != nil {
+			return fmt.Errorf("writing backup output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runBackupCreate() error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	snapshot, err := CreateSnapshot(repo)
+	if err != nil {
+		return fmt.Errorf("creating snapshot: %w", err)
+	}
+	if _, err := PruneSnapshots(); err != nil {
+		appLogger.Error("failed to prune snapshots", "error", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📦 Snapshot %s saved to %s\n", snapshot.ID, snapshot.Path); err != nil {
+		return fmt.Errorf("writing backup output: %w", err)
+	}
+	return nil
+}
+
+func runBackupRestore(id string, skipConfirm bool) error {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return fmt.Errorf("initializing storage: %w", err)
+	}
+	defer closeRepository(appLogger, repo)
+
+	snapshot, err := FindSnapshot(id)
+	if err != nil {
+		return err
+	}
+	diff, err := DiffSnapshot(repo, snapshot)
+	if err != nil {
+		return fmt.Errorf("comparing snapshot: %w", err)
+	}
+	if diff.Empty() {
+		if _, err := fmt.Fprintf(os.Stdout, "Snapshot %s matches the current data; nothing to restore.\n", id); err != nil {
+			return fmt.Errorf("writing backup output: %w", err)
+		}
+		return nil
+	}
+
+	if err := printSnapshotDiff(diff); err != nil {
+		return err
+	}
+	if !skipConfirm {
+		confirmed, err := confirm(fmt.Sprintf("Restore snapshot %s?", id))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			if _, err := fmt.Fprintln(os.Stdout, "Restore cancelled."); err != nil {
+				return fmt.Errorf("writing backup output: %w", err)
+			}
+			return nil
+		}
+	}
+
+	if err := RestoreSnapshot(repo, snapshot); err != nil {
+		return fmt.Errorf("restoring snapshot: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "✅ Restored snapshot %s (run 'kiki undo' to revert)\n", id); err != nil {
+		return fmt.Errorf("writing backup output: %w", err)
+	}
+	return nil
+}
+
+func printSnapshotDiff(diff *SnapshotDiff) error {
+	sections := []struct {
+		label  string
+		titles []string
+	}{
+		{"Tasks added back", diff.TasksAdded},
+		{"Tasks removed", diff.TasksRemoved},
+		{"Tasks changed", diff.TasksChanged},
+		{"Notes added back", diff.NotesAdded},
+		{"Notes removed", diff.NotesRemoved},
+		{"Notes changed", diff.NotesChanged},
+	}
+	for _, section := range sections {
+		if len(section.titles) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "%s (%d):\n", section.label, len(section.titles)); err != nil {
+			return fmt.Errorf("writing backup output: %w", err)
+		}
+		for _, title := range section.titles {
+			if _, err := fmt.Fprintf(os.Stdout, "  - %s\n", title); err != nil {
+				return fmt.Errorf("writing backup output: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on stdout and reads the answer from stdin
+func confirm(question string) (bool, error) {
+	if _, err := fmt.Fprintf(os.Stdout, "%s [y/N] ", question); err != nil {
+		return false, fmt.Errorf("writing prompt: %w", err)
+	}
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("reading answer: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func closeRepository(logger *slog.Logger, repo Repository) {
+	if err := repo.Close(); err != nil {
+		logger.Error("failed to close storage", "error", err)
+	}
+}
+
+// openToolHandler opens the active store, and the global store inside a project,
+// for commands that work on tasks and notes without Copilot
+func openToolHandler() (*ToolHandler, func(), error) {
+	repo, err := NewRepository(appLogger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("initializing storage: %w", err)
+	}
+	global, err := NewGlobalRepository(appLogger)
+	if err != nil {
+		closeRepository(appLogger, repo)
+		return nil, nil, fmt.Errorf("initializing global storage: %w", err)
+	}
+	handler := NewToolHandler(repo, appLogger)
+	handler.SetGlobalStore(global)
+	return handler, func() {
+		closeRepository(appLogger, repo)
+		if global != nil {
+			closeRepository(appLogger, global)
+		}
+	}, nil
+}
+
+func runTaskAdd(title, due, priority string, tags []string) error {
+	if priority == "" {
+		priority = appConfig.DefaultPriority
+	}
+	var dueDate *string
+	if due != "" {
+		dueDate = &due
+	}
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.addTask(AddTaskParams{Title: title, DueDate: dueDate, Priority: &priority, Tags: tags})
+	if err != nil {
+		return fmt.Errorf("adding task: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "✅ %s (%s, id %s)\n", result.Message, result.ShortID, result.TaskID); err != nil {
+		return fmt.Errorf("writing task output: %w", err)
+	}
+	return nil
+}
+
+func runTaskList(filter, scope string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.listTasks(ListTasksParams{Filter: filter, Scope: scope})
+	if err != nil {
+		return fmt.Errorf("listing tasks: %w", err)
+	}
+	return printTasks(os.Stdout, result.Tasks)
+}
+
+// printTasks writes one line per task, numbered the way the tools number them
+func printTasks(w io.Writer, tasks []TaskSummary) error {
+	if len(tasks) == 0 {
+		if _, err := fmt.Fprintln(w, "No tasks found."); err != nil {
+			return fmt.Errorf("writing task output: %w", err)
+		}
+		return nil
+	}
+
+	for _, t := range tasks {
+		check := " "
+		if t.Completed {
+			check = "x"
+		}
+		details := []string{t.Priority}
+		if t.DueDate != nil {
+			details = append(details, "due "+*t.DueDate)
+		}
+		if t.Store != "" {
+			details = append(details, t.Store)
+		}
+		details = append(details, "id "+t.ID)
+		if _, err := fmt.Fprintf(w, "%s [%s] %s (%s)\n", t.ShortID, check, t.Title,
+			strings.Join(details, ", ")); err != nil {
+			return fmt.Errorf("writing task output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runTaskDone(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.completeTask(CompleteTaskParams{Query: query})
+	if err != nil {
+		return fmt.Errorf("completing task: %w", err)
+	}
+	if !result.Success {
+		return errors.New(result.Message)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "✅ %s\n", result.Message); err != nil {
+		return fmt.Errorf("writing task output: %w", err)
+	}
+	return nil
+}
+
+func runTaskRm(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.deleteTask(DeleteTaskParams{Query: query})
+	if err != nil {
+		return fmt.Errorf("deleting task: %w", err)
+	}
+	if !result.Success {
+		return errors.New(result.Message)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "🗑️  %s\n", result.Message); err != nil {
+		return fmt.Errorf("writing task output: %w", err)
+	}
+	return nil
+}
+
+func runTaskEdit(query string, changes TaskChanges) error {
+	if changes.IsEmpty() {
+		return errors.New("nothing to change; pass --title, --due, --no-due, --priority or --tag")
+	}
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	_, task, store, err := handler.editTask(query, changes)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("no task found matching '%s'", query)
+	}
+	if _, ok := ambiguityFrom(err); ok {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("editing task: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "✏️  Task '%s' updated%s\n", task.Title, inStore(store)); err != nil {
+		return fmt.Errorf("writing task output: %w", err)
+	}
+	return nil
+}
+
+func runNoteAdd(title, content string, tags []string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.addNote(AddNoteParams{Title: title, Content: content, Tags: tags})
+	if err != nil {
+		return fmt.Errorf("adding note: %w", err)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "📝 %s (%s, id %s)\n", result.Message, result.ShortID, result.NoteID); err != nil {
+		return fmt.Errorf("writing note output: %w", err)
+	}
+	return nil
+}
+
+func runNoteList(filter string, tag *string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.listNotes(ListNotesParams{Filter: filter, Tag: tag})
+	if err != nil {
+		return fmt.Errorf("listing notes: %w", err)
+	}
+	return printNoteSummaries(result.Notes)
+}
+
+func runNoteSearch(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.searchNotes(SearchNotesParams{Query: query})
+	if err != nil {
+		return fmt.Errorf("searching notes: %w", err)
+	}
+	return printNoteSummaries(result.Notes)
+}
+
+func runSearch(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	if !searchOnlyNotes {
+		result, err := handler.searchTasks(SearchTasksParams{Query: query, Scope: searchScope, Limit: searchLimit})
+		if err != nil {
+			return fmt.Errorf("searching tasks: %w", err)
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "✅ %s\n", result.Message); err != nil {
+			return fmt.Errorf("writing search output: %w", err)
+		}
+		if err := printTasks(os.Stdout, result.Tasks); err != nil {
+			return err
+		}
+	}
+	if !searchOnlyTasks {
+		result, err := handler.searchNotes(SearchNotesParams{Query: query, Scope: searchScope, Limit: searchLimit})
+		if err != nil {
+			return fmt.Errorf("searching notes: %w", err)
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "📝 %s\n", result.Message); err != nil {
+			return fmt.Errorf("writing search output: %w", err)
+		}
+		if err := printNoteSummaries(result.Notes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printNoteSummaries(notes []NoteSummary) error {
+	if len(notes) == 0 {
+		if _, err := fmt.Fprintln(os.Stdout, "No notes found."); err != nil {
+			return fmt.Errorf("writing note output: %w", err)
+		}
+		return nil
+	}
+	for _, n := range notes {
+		details := []string{n.CreatedAt}
+		if len(n.Tags) > 0 {
+			details = append(details, "tags "+strings.Join(n.Tags, ", "))
+		}
+		if n.Store != "" {
+			details = append(details, n.Store)
+		}
+		details = append(details, "id "+n.ID)
+		if _, err := fmt.Fprintf(os.Stdout, "%s %s (%s)\n", n.ShortID, n.Title, strings.Join(details, "; ")); err != nil {
+			return fmt.Errorf("writing note output: %w", err)
+		}
+		text := n.Preview
+		if n.Snippet != "" {
+			text = n.Snippet
+		}
+		if text == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(os.Stdout, "   %s\n", strings.ReplaceAll(text, "\n", " ")); err != nil {
+			return fmt.Errorf("writing note output: %w", err)
+		}
+	}
+	return nil
+}
+
+func runNoteShow(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	note, store, err := handler.findNote(query)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("no note found matching '%s'", query)
+	}
+	if _, ok := ambiguityFrom(err); ok {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("loading notes: %w", err)
+	}
+
+	header := fmt.Sprintf("📝 %s %s\nCreated %s, id %s\n", storeShortID(note.ShortID(), store), note.Title,
+		note.CreatedAt.Local().Format(historyTimeLayout), note.ID)
+	if len(note.Tags) > 0 {
+		header += "Tags: " + strings.Join(note.Tags, ", ") + "\n"
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "%s\n%s\n", header, note.Content); err != nil {
+		return fmt.Errorf("writing note output: %w", err)
+	}
+	return nil
+}
+
+func runNoteRm(query string) error {
+	handler, closeStores, err := openToolHandler()
+	if err != nil {
+		return err
+	}
+	defer closeStores()
+
+	result, err := handler.deleteNote(DeleteNoteParams{Query: query})
+	if err != nil {
+		return fmt.Errorf("deleting note: %w", err)
+	}
+	if !result.Success {
+		return errors.New(result.Message)
+	}
+	if _, err := fmt.Fprintf(os.Stdout, "🗑️  %s\n", result.Message); err != nil {
+		return fmt.Errorf("writing note output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// recordKey exposes the identity and last modification time of a record for merging
+type recordKey[T any] func(T) (string, time.Time)
+
+func taskKey(t Task) (string, time.Time) { return t.ID, t.UpdatedAt }
+
+func noteKey(n Note) (string, time.Time) { return n.ID, n.UpdatedAt }
+
+// mergeRecords performs a three-way merge of two record lists against their
+// common ancestor, matching records by ID rather than by line:
+//   - a record changed on one side only takes that side's version
+//   - a record changed on both sides takes the most recently updated version
+//   - a record deleted on one side and edited on the other keeps the edit
+//   - records added on either side are kept
+//
+// The result follows ours' order with records only present in theirs appended.
+func mergeRecords[T any](base, ours, theirs []T, key recordKey[T]) ([]T, error) {
+	baseImages, err := imagesByID(base, key)
+	if err != nil {
+		return nil, err
+	}
+	ourImages, err := imagesByID(ours, key)
+	if err != nil {
+		return nil, err
+	}
+	theirImages, err := imagesByID(theirs, key)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make([]T, 0, len(ours)+len(theirs))
+	for _, ourRec := range ours {
+		id, ourUpdated := key(ourRec)
+		baseImage, inBase := baseImages[id]
+		theirImage, inTheirs := theirImages[id]
+
+		switch {
+		case !inTheirs:
+			// Deleted by them: drop it unless we edited it since the ancestor
+			if inBase && bytes.Equal(baseImage, ourImages[id]) {
+				continue
+			}
+			merged = append(merged, ourRec)
+		case bytes.Equal(ourImages[id], theirImage), inBase && bytes.Equal(baseImage, theirImage):
+			merged = append(merged, ourRec)
+		case inBase && bytes.Equal(baseImage, ourImages[id]):
+			theirRec, err := recordAt(theirs, id, key)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, theirRec)
+		default:
+			theirRec, err := recordAt(theirs, id, key)
+			if err != nil {
+				return nil, err
+			}
+			if _, theirUpdated := key(theirRec); theirUpdated.After(ourUpdated) {
+				merged = append(merged, theirRec)
+			} else {
+				merged = append(merged, ourRec)
+			}
+		}
+	}
+
+	for _, theirRec := range theirs {
+		id, _ := key(theirRec)
+		if _, inOurs := ourImages[id]; inOurs {
+			continue
+		}
+		// Deleted by us: drop it unless they edited it since the ancestor
+		if baseImage, inBase := baseImages[id]; inBase && bytes.Equal(baseImage, theirImages[id]) {
+			continue
+		}
+		merged = append(merged, theirRec)
+	}
+	return merged, nil
+}
+
+func imagesByID[T any](records []T, key recordKey[T]) (map[string][]byte, error) {
+	images := make(map[string][]byte, len(records))
+	for _, rec := range records {
+		id, _ := key(rec)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record: %w", err)
+		}
+		images[id] = data
+	}
+	return images, nil
+}
+
+func recordAt[T any](records []T, id string, key recordKey[T]) (T, error) {
+	for _, rec := range records {
+		if recID, _ := key(rec); recID == id {
+			return rec, nil
+		}
+	}
+	var zero T
+	return zero, fmt.Errorf("record %s not found", id)
+}
+
+// mergeTaskFiles merges three versions of tasks.json. Missing versions are treated as empty.
+func mergeTaskFiles(base, ours, theirs []byte) ([]byte, error) {
+	lists := make([]TaskList, 3)
+	for i, data := range [][]byte{base, ours, theirs} {
+		if err := decodeMergeInput(data, &lists[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse tasks: %w", err)
+		}
+	}
+	merged, err := mergeRecords(lists[0].Tasks, lists[1].Tasks, lists[2].Tasks, taskKey)
+	if err != nil {
+		return nil, err
+	}
+	tombs := retainedTombstones(taskReplica, merged, mergeTombstones(lists[1].Tombstones, lists[2].Tombstones))
+	list := &TaskList{
+		SchemaVersion: currentSchemaVersion,
+		LastNumber:    max(lists[1].LastNumber, lists[2].LastNumber),
+		Tasks:         merged,
+		Tombstones:    tombs,
+	}
+	numberTasks(list)
+	return json.MarshalIndent(list, "", "  ")
+}
+
+// mergeNoteFiles merges three versions of notes.json. Missing versions are treated as empty.
+func mergeNoteFiles(base, ours, theirs []byte) ([]byte, error) {
+	lists := make([]NoteList, 3)
+	for i, data := range [][]byte{base, ours, theirs} {
+		if err := decodeMergeInput(data, &lists[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse notes: %w", err)
+		}
+	}
+	merged, err := mergeRecords(lists[0].Notes, lists[1].Notes, lists[2].Notes, noteKey)
+	if err != nil {
+		return nil, err
+	}
+	tombs := retainedTombstones(noteReplica, merged, mergeTombstones(lists[1].Tombstones, lists[2].Tombstones))
+	list := &NoteList{
+		SchemaVersion: currentSchemaVersion,
+		LastNumber:    max(lists[1].LastNumber, lists[2].LastNumber),
+		Notes:         merged,
+		Tombstones:    tombs,
+	}
+	numberNotes(list)
+	return json.MarshalIndent(list, "", "  ")
+}
+
+func decodeMergeInput(data []byte, out any) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
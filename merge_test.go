@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeRecords(t *testing.T) {
+	t.Run("merges per record by ID", func(t *testing.T) {
+		// arrange
+		earlier := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+		later := earlier.Add(time.Hour)
+		base := []Task{
+			{ID: "a", Title: "A", UpdatedAt: earlier},
+			{ID: "b", Title: "B", UpdatedAt: earlier},
+			{ID: "c", Title: "C", UpdatedAt: earlier},
+			{ID: "d", Title: "D", UpdatedAt: earlier},
+		}
+		ours := []Task{
+			{ID: "a", Title: "A ours", UpdatedAt: earlier.Add(time.Minute)},
+			{ID: "c", Title: "C", UpdatedAt: earlier},
+			{ID: "d", Title: "D", UpdatedAt: earlier},
+			{ID: "e", Title: "E", UpdatedAt: later},
+		}
+		theirs := []Task{
+			{ID: "a", Title: "A theirs", UpdatedAt: later},
+			{ID: "b", Title: "B theirs", UpdatedAt: later},
+			{ID: "c", Title: "C theirs", UpdatedAt: later},
+			{ID: "f", Title: "F", UpdatedAt: later},
+		}
+
+		// act
+		merged, err := mergeRecords(base, ours, theirs, taskKey)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		titles := make([]string, 0, len(merged))
+		for _, task := range merged {
+			titles = append(titles, task.Title)
+		}
+		// a: both edited, theirs is newer; b: edit beats our delete; c: only they edited;
+		// d: they deleted it untouched; e and f: added on one side
+		want := []string{"A theirs", "C theirs", "E", "B theirs", "F"}
+		if !equalStrings(titles, want) {
+			t.Fatalf("expected %v, got %v", want, titles)
+		}
+	})
+
+	t.Run("mergeTaskFiles treats missing versions as empty", func(t *testing.T) {
+		// arrange
+		theirs := []byte(`{"schema_version": 2, "tasks": [{"id": "a", "title": "A"}]}`)
+
+		// act
+		data, err := mergeTaskFiles(nil, nil, theirs)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		var tasks TaskList
+		if err := decodeMergeInput(data, &tasks); err != nil {
+			t.Fatalf("failed to parse merged tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 1 || tasks.Tasks[0].ID != "a" {
+			t.Fatalf("expected task a, got %+v", tasks.Tasks)
+		}
+	})
+}
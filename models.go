@@ -4,32 +4,61 @@ import "time"
 
 // Task represents a todo item with metadata
 type Task struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
-	Completed bool      `json:"completed"`
-	DueDate   *string   `json:"due_date,omitempty"` // YYYY-MM-DD format
-	Priority  string    `json:"priority"`           // low, medium, high
-	Tags      []string  `json:"tags"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        string      `json:"id"`
+	Number    int         `json:"number,omitempty"` // shown as the short ID t<number>
+	Title     string      `json:"title"`
+	Completed bool        `json:"completed"`
+	DueDate   *string     `json:"due_date,omitempty"` // YYYY-MM-DD format
+	Priority  string      `json:"priority"`           // low, medium, high
+	Tags      []string    `json:"tags"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty"` // set while the task is in the trash
+	Clock     VectorClock `json:"clock,omitempty"`      // edits per device, for merging synced copies
 }
 
 // Note represents a text note with metadata
 type Note struct {
+	ID        string      `json:"id"`
+	Number    int         `json:"number,omitempty"` // shown as the short ID n<number>
+	Title     string      `json:"title"`
+	Content   string      `json:"content"`
+	Tags      []string    `json:"tags"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty"` // set while the note is in the trash
+	Clock     VectorClock `json:"clock,omitempty"`      // edits per device, for merging synced copies
+}
+
+// VectorClock counts the edits each device has made to a record
+type VectorClock map[string]uint64
+
+// Tombstone remembers a permanently removed record so synced copies that
+// still hold it drop it instead of bringing it back
+type Tombstone struct {
 	ID        string    `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	Tags      []string  `json:"tags"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at"`
 }
 
 // TaskList holds all tasks
 type TaskList struct {
-	Tasks []Task `json:"tasks"`
+	SchemaVersion int         `json:"schema_version"`
+	LastNumber    int         `json:"last_number,omitempty"` // highest task number handed out, never reused
+	Tasks         []Task      `json:"tasks"`
+	Tombstones    []Tombstone `json:"tombstones,omitempty"`
+
+	// stamp fingerprints the file contents the list was read from or last
+	// saved as, so the search index can tell it is current; 0 when unknown
+	stamp uint64
 }
 
 // NoteList holds all notes
 type NoteList struct {
-	Notes []Note `json:"notes"`
+	SchemaVersion int         `json:"schema_version"`
+	LastNumber    int         `json:"last_number,omitempty"` // highest note number handed out, never reused
+	Notes         []Note      `json:"notes"`
+	Tombstones    []Tombstone `json:"tombstones,omitempty"`
+
+	// stamp is TaskList.stamp for notes
+	stamp uint64
 }
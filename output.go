@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputQuiet = "quiet"
+
+	// exitToolFailureCode means the prompt ran but at least one tool call failed
+	exitToolFailureCode = 2
+
+	changedTask = "task"
+	changedNote = "note"
+
+	changeCreated = "created"
+	changeUpdated = "changed"
+	changeDeleted = "deleted"
+)
+
+// errToolFailed marks a prompt during which a tool call failed
+var errToolFailed = errors.New("tool call failed")
+
+// outputFormat holds the --output flag
+var outputFormat string
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputQuiet:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format '%s': use text, json or quiet", format)
+	}
+}
+
+// PromptReport describes a prompt for --output json
+type PromptReport struct {
+	Message    string     `json:"message"`
+	Model      string     `json:"model"`
+	SessionID  string     `json:"session_id"`
+	StartedAt  time.Time  `json:"started_at"`
+	DurationMs int64      `json:"duration_ms"`
+	ToolCalls  []ToolCall `json:"tool_calls"`
+	Changes    ChangeSet  `json:"changes"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// FailedCalls counts the tool calls that failed
+func (r *PromptReport) FailedCalls() int {
+	failed := 0
+	for _, call := range r.ToolCalls {
+		if !call.Success {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ChangeSet lists the tasks and notes a prompt created, changed or deleted.
+// Moving an item to the trash counts as deleting it.
+type ChangeSet struct {
+	Created []ChangedRecord `json:"created"`
+	Changed []ChangedRecord `json:"changed"`
+	Deleted []ChangedRecord `json:"deleted"`
+}
+
+// ChangedRecord identifies a task or note in a ChangeSet
+type ChangedRecord struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	Store string `json:"store,omitempty"`
+}
+
+// promptRecorder collects the tool calls and journaled changes of a prompt.
+// Tools may run concurrently, so everything is guarded by mu.
+type promptRecorder struct {
+	mu      sync.Mutex
+	calls   []ToolCall
+	changes []ChangedRecord
+	// states holds whether each changed record was created, changed or deleted
+	states map[ChangedRecord]string
+}
+
+func newPromptRecorder() *promptRecorder {
+	return &promptRecorder{calls: []ToolCall{}, states: map[ChangedRecord]string{}}
+}
+
+// Record reports Kiki's tool calls and the changes they make to r. Call it
+// before Run, since tools are handed to the session when it is opened.
+func (k *Kiki) Record(r *promptRecorder) {
+	k.tools.SetRecorder(r.recordCall)
+	if journaled, ok := k.storage.(*JournaledRepository); ok {
+		journaled.OnChange(r.recordChange(k.tools.activeStore()))
+	}
+	if journaled, ok := k.tools.global.(*JournaledRepository); ok {
+		journaled.OnChange(r.recordChange(storeGlobal))
+	}
+}
+
+func (r *promptRecorder) recordCall(call ToolCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+// recordChange returns a journal observer for the named store
+func (r *promptRecorder) recordChange(store string) func(JournalEntry) {
+	return func(entry JournalEntry) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, change := range entry.Changes {
+			kind := changedTask
+			if entry.changeKind(change) == journalKindNotes {
+				kind = changedNote
+			}
+			record := ChangedRecord{Kind: kind, ID: change.ID, Store: store}
+			state := changeState(change)
+			previous, seen := r.states[record]
+			if !seen {
+				r.changes = append(r.changes, record)
+			}
+			// An item created during the prompt stays created unless it is deleted again
+			if previous == changeCreated && state == changeUpdated {
+				continue
+			}
+			r.states[record] = state
+		}
+	}
+}
+
+// changeState classifies a record change as created, changed or deleted
+func changeState(change RecordChange) string {
+	switch {
+	case len(change.Before) == 0:
+		return changeCreated
+	case len(change.After) == 0 || (!isTrashed(change.Before) && isTrashed(change.After)):
+		return changeDeleted
+	default:
+		return changeUpdated
+	}
+}
+
+// isTrashed reports whether a task or note image has deleted_at set
+func isTrashed(image json.RawMessage) bool {
+	var record struct {
+		DeletedAt json.RawMessage `json:"deleted_at"`
+	}
+	return json.Unmarshal(image, &record) == nil && len(record.DeletedAt) > 0 &&
+		!bytes.Equal(record.DeletedAt, []byte("null"))
+}
+
+// report assembles the recorded calls and changes
+func (r *promptRecorder) report(message, model, sessionID string, started time.Time) *PromptReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := ChangeSet{Created: []ChangedRecord{}, Changed: []ChangedRecord{}, Deleted: []ChangedRecord{}}
+	for _, record := range r.changes {
+		switch r.states[record] {
+		case changeCreated:
+			changes.Created = append(changes.Created, record)
+		case changeDeleted:
+			changes.Deleted = append(changes.Deleted, record)
+		default:
+			changes.Changed = append(changes.Changed, record)
+		}
+	}
+	return &PromptReport{
+		Message:    message,
+		Model:      model,
+		SessionID:  sessionID,
+		StartedAt:  started,
+		DurationMs: time.Since(started).Milliseconds(),
+		ToolCalls:  append([]ToolCall{}, r.calls...),
+		Changes:    changes,
+	}
+}
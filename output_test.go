@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	copilot "github.com/github/copilot-sdk/go"
+)
+
+func findTool(t *testing.T, tools []copilot.Tool, name string) copilot.Tool {
+	t.Helper()
+	for _, tool := range tools {
+		if tool.Name == name {
+			return tool
+		}
+	}
+	t.Fatalf("no %s tool", name)
+	return copilot.Tool{}
+}
+
+func TestPromptRecorder(t *testing.T) {
+	t.Run("records tool calls and whether they failed", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		handler := NewToolHandler(repo, newTestLogger())
+		recorder := newPromptRecorder()
+		handler.SetRecorder(recorder.recordCall)
+		tools := handler.GetAllTools()
+
+		// act
+		_, addErr := findTool(t, tools, "add_task").Handler(copilot.ToolInvocation{
+			Arguments: map[string]any{"title": "Ship it"}})
+		_, completeErr := findTool(t, tools, "complete_task").Handler(copilot.ToolInvocation{
+			Arguments: map[string]any{"query": "nothing like this"}})
+		report := recorder.report("Done.", defaultModel, "kiki-test", time.Now())
+
+		// assert
+		if addErr != nil || completeErr != nil {
+			t.Fatalf("unexpected tool errors: %v, %v", addErr, completeErr)
+		}
+		if len(report.ToolCalls) != 2 || !report.ToolCalls[0].Success || report.ToolCalls[1].Success {
+			t.Fatalf("unexpected tool calls: %+v", report.ToolCalls)
+		}
+		var added AddTaskResult
+		if err := json.Unmarshal(report.ToolCalls[0].Result, &added); err != nil || added.TaskID == "" {
+			t.Fatalf("expected the add_task result, got %s", report.ToolCalls[0].Result)
+		}
+		if string(report.ToolCalls[1].Params) != `{"query":"nothing like this"}` || report.FailedCalls() != 1 {
+			t.Fatalf("unexpected failed call: %+v", report.ToolCalls[1])
+		}
+	})
+
+	t.Run("counts list tools that cannot load their data as failed", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, name := range []string{tasksFile, notesFile} {
+			if err := os.Mkdir(filepath.Join(GetConfigDir(), name), configDirPerm); err != nil {
+				t.Fatalf("failed to block %s: %v", name, err)
+			}
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		recorder := newPromptRecorder()
+		handler.SetRecorder(recorder.recordCall)
+		tools := handler.GetAllTools()
+
+		// act
+		_, tasksErr := findTool(t, tools, "list_tasks").Handler(copilot.ToolInvocation{
+			Arguments: map[string]any{"filter": "all"}})
+		_, notesErr := findTool(t, tools, "list_notes").Handler(copilot.ToolInvocation{
+			Arguments: map[string]any{}})
+		report := recorder.report("Done.", defaultModel, "kiki-test", time.Now())
+
+		// assert
+		if tasksErr != nil || notesErr != nil {
+			t.Fatalf("unexpected tool errors: %v, %v", tasksErr, notesErr)
+		}
+		if report.FailedCalls() != 2 {
+			t.Fatalf("expected both list calls to fail, got %+v", report.ToolCalls)
+		}
+		var listed ListTasksResult
+		if err := json.Unmarshal(report.ToolCalls[0].Result, &listed); err != nil || listed.Message == "" {
+			t.Fatalf("expected the load error in the result, got %s", report.ToolCalls[0].Result)
+		}
+	})
+
+	t.Run("sorts journaled changes into created, changed and deleted", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		existing, err := repo.AddTask("Existing", nil, "low", nil)
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		trashed, err := repo.AddTask("Trashed", nil, "low", nil)
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		recorder := newPromptRecorder()
+		repo.OnChange(recorder.recordChange(""))
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		added, err := handler.addTask(AddTaskParams{Title: "New"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		for _, query := range []string{"New", existing.ID} {
+			if _, err := handler.completeTask(CompleteTaskParams{Query: query}); err != nil {
+				t.Fatalf("failed to complete task: %v", err)
+			}
+		}
+		if _, err := handler.deleteTask(DeleteTaskParams{Query: trashed.ID}); err != nil {
+			t.Fatalf("failed to delete task: %v", err)
+		}
+		changes := recorder.report("", defaultModel, "kiki-test", time.Now()).Changes
+
+		// assert
+		if len(changes.Created) != 1 || changes.Created[0].ID != added.TaskID || changes.Created[0].Kind != changedTask {
+			t.Fatalf("unexpected created: %+v", changes.Created)
+		}
+		if len(changes.Changed) != 1 || changes.Changed[0].ID != existing.ID {
+			t.Fatalf("unexpected changed: %+v", changes.Changed)
+		}
+		if len(changes.Deleted) != 1 || changes.Deleted[0].ID != trashed.ID {
+			t.Fatalf("unexpected deleted: %+v", changes.Deleted)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const passphraseEnv = "KIKI_PASSPHRASE"
+
+// readPassphrase returns KIKI_PASSPHRASE when set, and otherwise prompts on
+// stderr and reads a line from stdin without echoing it on a terminal
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if _, err := fmt.Fprint(os.Stderr, prompt); err != nil {
+		return "", fmt.Errorf("writing passphrase prompt: %w", err)
+	}
+	passphrase, err := readHiddenLine(os.Stdin)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return "", errEmptyPassphrase
+	}
+	return passphrase, nil
+}
+
+// readLine reads up to a newline one byte at a time, so nothing past the line
+// is consumed from a pipe
+func readLine(r io.Reader) (string, error) {
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line.WriteByte(buf[0])
+		}
+		if errors.Is(err, io.EOF) {
+			if line.Len() == 0 {
+				return "", err
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(line.String(), "\r"), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	profilesDir       = "profiles"
+	activeProfileFile = "profile"
+	defaultProfile    = "default"
+	profileEnv        = "KIKI_PROFILE"
+)
+
+// profileFlag holds the --profile flag, which takes precedence over KIKI_PROFILE
+var profileFlag string
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+var errDefaultProfile = errors.New("the default profile cannot be deleted")
+
+// getKikiRoot returns the top-level kiki directory using XDG_CONFIG_HOME. It
+// holds config.toml and the active profile choice.
+func getKikiRoot() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			configHome = "."
+		} else {
+			configHome = filepath.Join(homeDir, ".config")
+		}
+	}
+	return filepath.Join(configHome, kikiDir)
+}
+
+// getDataRoot returns the data_dir setting, or else the top-level kiki
+// directory. The default profile lives here and named profiles live under profiles/.
+func getDataRoot() string {
+	if appConfig.DataDir != "" {
+		return appConfig.DataDir
+	}
+	return getKikiRoot()
+}
+
+// ActiveProfile returns the selected profile: the --profile flag, then
+// KIKI_PROFILE, then the one chosen with 'kiki profile use', then the default
+func ActiveProfile() string {
+	if profileFlag != "" {
+		return profileFlag
+	}
+	if name := os.Getenv(profileEnv); name != "" {
+		return name
+	}
+	if data, err := os.ReadFile(filepath.Join(getKikiRoot(), activeProfileFile)); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" {
+			return name
+		}
+	}
+	return defaultProfile
+}
+
+// profileDir returns the data directory of a profile
+func profileDir(name string) string {
+	if name == defaultProfile {
+		return getDataRoot()
+	}
+	return filepath.Join(getDataRoot(), profilesDir, name)
+}
+
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// profileExists reports whether a profile has been created. The default profile always exists.
+func profileExists(name string) bool {
+	if name == defaultProfile {
+		return true
+	}
+	info, err := os.Stat(profileDir(name))
+	return err == nil && info.IsDir()
+}
+
+// CheckActiveProfile fails when the selected profile is invalid or was never created
+func CheckActiveProfile() error {
+	name := ActiveProfile()
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if !profileExists(name) {
+		return fmt.Errorf("profile %q does not exist; create it with 'kiki profile create %s'", name, name)
+	}
+	return nil
+}
+
+// ListProfiles returns the default profile followed by named profiles in order
+func ListProfiles() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(getDataRoot(), profilesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validateProfileName(entry.Name()) == nil && entry.Name() != defaultProfile {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return append([]string{defaultProfile}, names...), nil
+}
+
+// CreateProfile creates an empty profile
+func CreateProfile(name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if profileExists(name) {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	if err := os.MkdirAll(profileDir(name), configDirPerm); err != nil {
+		return fmt.Errorf("failed to create profile %q: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteProfile removes a profile and all of its data
+func DeleteProfile(name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if name == defaultProfile {
+		return errDefaultProfile
+	}
+	if !profileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if name == ActiveProfile() {
+		return fmt.Errorf("profile %q is active; switch to another profile first", name)
+	}
+	if err := os.RemoveAll(profileDir(name)); err != nil {
+		return fmt.Errorf("failed to delete profile %q: %w", name, err)
+	}
+	return nil
+}
+
+// UseProfile makes name the profile used when neither --profile nor KIKI_PROFILE is set
+func UseProfile(name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if !profileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	path := filepath.Join(getKikiRoot(), activeProfileFile)
+	if name == defaultProfile {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to switch profile: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(getKikiRoot(), configDirPerm); err != nil {
+		return fmt.Errorf("failed to create kiki directory: %w", err)
+	}
+	return writeFileAtomic(path, []byte(name+"\n"), dataFilePerm)
+}
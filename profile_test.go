@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestActiveProfile(t *testing.T) {
+	t.Run("prefers the flag, then the env var, then the saved choice", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv(profileEnv, "")
+		for _, name := range []string{"work", "personal"} {
+			if err := CreateProfile(name); err != nil {
+				t.Fatalf("failed to create profile: %v", err)
+			}
+		}
+		if err := UseProfile("work"); err != nil {
+			t.Fatalf("failed to use profile: %v", err)
+		}
+
+		// act
+		saved := ActiveProfile()
+		t.Setenv(profileEnv, "personal")
+		fromEnv := ActiveProfile()
+		profileFlag = defaultProfile
+		t.Cleanup(func() { profileFlag = "" })
+		fromFlag := ActiveProfile()
+
+		// assert
+		if saved != "work" || fromEnv != "personal" || fromFlag != defaultProfile {
+			t.Fatalf("unexpected resolution: saved=%s env=%s flag=%s", saved, fromEnv, fromFlag)
+		}
+	})
+
+	t.Run("scopes the data directory and session to the profile", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		t.Setenv(profileEnv, "work")
+		if err := CreateProfile("work"); err != nil {
+			t.Fatalf("failed to create profile: %v", err)
+		}
+
+		// act
+		dir := GetConfigDir()
+		sessionID := getDailySessionID()
+
+		// assert
+		if want := filepath.Join(configHome, kikiDir, profilesDir, "work"); dir != want {
+			t.Fatalf("expected %q, got %q", want, dir)
+		}
+		if sessionID != "kiki-work-"+todayString() {
+			t.Fatalf("unexpected session ID %q", sessionID)
+		}
+	})
+}
+
+func TestProfiles(t *testing.T) {
+	t.Run("lists, protects and deletes profiles", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv(profileEnv, "")
+		for _, name := range []string{"work", "personal"} {
+			if err := CreateProfile(name); err != nil {
+				t.Fatalf("failed to create profile: %v", err)
+			}
+		}
+		if err := UseProfile("work"); err != nil {
+			t.Fatalf("failed to use profile: %v", err)
+		}
+
+		// act
+		listed, listErr := ListProfiles()
+		activeErr := DeleteProfile("work")
+		defaultErr := DeleteProfile(defaultProfile)
+		deleteErr := DeleteProfile("personal")
+
+		// assert
+		if listErr != nil || !equalStrings(listed, []string{defaultProfile, "personal", "work"}) {
+			t.Fatalf("unexpected profiles %v: %v", listed, listErr)
+		}
+		if activeErr == nil || !strings.Contains(activeErr.Error(), "is active") {
+			t.Fatalf("expected active profile to be protected, got %v", activeErr)
+		}
+		if defaultErr != errDefaultProfile {
+			t.Fatalf("expected errDefaultProfile, got %v", defaultErr)
+		}
+		if deleteErr != nil || profileExists("personal") {
+			t.Fatalf("expected personal to be deleted, got %v", deleteErr)
+		}
+	})
+
+	t.Run("rejects names that are not plain identifiers", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+		// act
+		err := CreateProfile("../escape")
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "invalid profile name") {
+			t.Fatalf("expected invalid name error, got %v", err)
+		}
+	})
+
+	t.Run("profile commands manage the profiles under data_dir", func(t *testing.T) {
+		// arrange
+		dataDir := t.TempDir()
+		newTestConfigFile(t, "data_dir = \""+dataDir+"\"\n")
+		t.Setenv(profileEnv, "")
+		keepAppConfig(t)
+		if err := profileCmd.PersistentPreRunE(profileListCmd, nil); err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+
+		// act
+		createErr := CreateProfile("work")
+		listed, listErr := ListProfiles()
+
+		// assert
+		if createErr != nil || listErr != nil || !equalStrings(listed, []string{defaultProfile, "work"}) {
+			t.Fatalf("unexpected profiles %v: %v, %v", listed, createErr, listErr)
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, profilesDir, "work")); err != nil {
+			t.Fatalf("expected the profile under data_dir: %v", err)
+		}
+	})
+
+	t.Run("CheckActiveProfile fails for a profile that was never created", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv(profileEnv, "missing")
+
+		// act
+		err := CheckActiveProfile()
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Fatalf("expected missing profile error, got %v", err)
+		}
+	})
+}
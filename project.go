@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const (
+	projectDirName = ".kiki"
+	storeProject   = "project"
+	storeGlobal    = "global"
+)
+
+// globalFlag holds the --global flag, which ignores any project store
+var globalFlag bool
+
+// findProjectDir walks up from start looking for a .kiki directory, the way git
+// finds .git. The ~/.kiki log directory is never treated as a project store.
+// It returns an empty string when none is found.
+func findProjectDir(start string) string {
+	logDir, _ := GetLogDir()
+	dir := start
+	for {
+		candidate := filepath.Join(dir, projectDirName)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() && candidate != logDir {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ProjectDir returns the project store that applies to the working directory,
+// or an empty string when there is none or --global is set
+func ProjectDir() string {
+	if globalFlag {
+		return ""
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return findProjectDir(cwd)
+}
+
+// GetGlobalConfigDir returns the data directory of the active profile,
+// ignoring any project store
+func GetGlobalConfigDir() string {
+	return profileDir(ActiveProfile())
+}
+
+// ActiveStore names the store that new items go to: project or global
+func ActiveStore() string {
+	if ProjectDir() != "" {
+		return storeProject
+	}
+	return storeGlobal
+}
+
+// createProjectDir makes a .kiki project store in the working directory.
+// Machine-local files are ignored so the data files can be committed with the project.
+func createProjectDir() error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	dir := filepath.Join(cwd, projectDirName)
+	if err := os.MkdirAll(dir, configDirPerm); err != nil {
+		return fmt.Errorf("failed to create project directory: %w", err)
+	}
+	ignorePath := filepath.Join(dir, gitIgnoreFile)
+	if _, err := os.Stat(ignorePath); os.IsNotExist(err) {
+		if err := os.WriteFile(ignorePath, []byte(gitIgnoreContents), dataFilePerm); err != nil {
+			return fmt.Errorf("failed to write %s: %w", gitIgnoreFile, err)
+		}
+	}
+	return nil
+}
+
+// NewGlobalRepository opens the profile store alongside an active project
+// store. It returns nil when no project store is active.
+func NewGlobalRepository(logger *slog.Logger) (Repository, error) {
+	if ProjectDir() == "" {
+		return nil, nil
+	}
+	repo, err := openRepository(GetGlobalConfigDir(), logger)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProjectDir(t *testing.T) {
+	t.Run("finds the nearest .kiki walking up from the working directory", func(t *testing.T) {
+		// arrange
+		root := t.TempDir()
+		nested := filepath.Join(root, "src", "pkg")
+		if err := os.MkdirAll(nested, configDirPerm); err != nil {
+			t.Fatalf("failed to create directories: %v", err)
+		}
+		if err := os.Mkdir(filepath.Join(root, projectDirName), configDirPerm); err != nil {
+			t.Fatalf("failed to create project dir: %v", err)
+		}
+		t.Chdir(nested)
+
+		// act
+		found := ProjectDir()
+
+		// assert
+		want, err := filepath.EvalSymlinks(filepath.Join(root, projectDirName))
+		if err != nil {
+			t.Fatalf("failed to resolve project dir: %v", err)
+		}
+		if got, _ := filepath.EvalSymlinks(found); got != want {
+			t.Fatalf("expected %q, got %q", want, found)
+		}
+	})
+
+	t.Run("--global ignores the project store", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		t.Setenv(profileEnv, "")
+		root := t.TempDir()
+		if err := os.Mkdir(filepath.Join(root, projectDirName), configDirPerm); err != nil {
+			t.Fatalf("failed to create project dir: %v", err)
+		}
+		t.Chdir(root)
+		globalFlag = true
+		t.Cleanup(func() { globalFlag = false })
+
+		// act
+		dir := GetConfigDir()
+
+		// assert
+		if want := filepath.Join(configHome, kikiDir); dir != want {
+			t.Fatalf("expected %q, got %q", want, dir)
+		}
+	})
+}
+
+func TestProjectStores(t *testing.T) {
+	newStores := func(t *testing.T) (*ToolHandler, Repository, Repository) {
+		t.Helper()
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		t.Setenv(profileEnv, "")
+		t.Chdir(t.TempDir())
+		if err := InitStorage(InitOptions{Project: true}); err != nil {
+			t.Fatalf("failed to init project store: %v", err)
+		}
+		project, err := NewRepository(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to open project store: %v", err)
+		}
+		global, err := NewGlobalRepository(newTestLogger())
+		if err != nil || global == nil {
+			t.Fatalf("failed to open global store: %v", err)
+		}
+		handler := NewToolHandler(project, newTestLogger())
+		handler.SetGlobalStore(global)
+		return handler, project, global
+	}
+
+	t.Run("merged listing labels and numbers items from both stores", func(t *testing.T) {
+		// arrange
+		handler, project, global := newStores(t)
+		if _, err := project.AddTask("Fix the build", nil, "high", nil); err != nil {
+			t.Fatalf("failed to add project task: %v", err)
+		}
+		if _, err := global.AddTask("Buy milk", nil, "low", nil); err != nil {
+			t.Fatalf("failed to add global task: %v", err)
+		}
+
+		// act
+		stores, err := handler.scopedStores(scopeAll)
+		if err != nil {
+			t.Fatalf("failed to resolve scope: %v", err)
+		}
+		var names, titles []string
+		for _, store := range stores {
+			tasks, err := store.repo.LoadTasks()
+			if err != nil {
+				t.Fatalf("failed to load tasks: %v", err)
+			}
+			for _, task := range tasks.Tasks {
+				names = append(names, store.name)
+				titles = append(titles, task.Title)
+			}
+		}
+
+		// assert
+		if len(titles) != 2 || titles[0] != "Fix the build" || names[0] != storeProject ||
+			titles[1] != "Buy milk" || names[1] != storeGlobal {
+			t.Fatalf("unexpected merged listing: %v %v", names, titles)
+		}
+		if _, err := handler.scopedStores("elsewhere"); err == nil {
+			t.Fatalf("expected an unknown scope to fail")
+		}
+	})
+
+	t.Run("changes reach the global store when only it has a match", func(t *testing.T) {
+		// arrange
+		handler, _, global := newStores(t)
+		if _, err := global.AddTask("Buy milk", nil, "low", nil); err != nil {
+			t.Fatalf("failed to add global task: %v", err)
+		}
+
+		// act
+		result, err := handler.completeTask(CompleteTaskParams{Query: "milk"})
+
+		// assert
+		if err != nil || result.Store != storeGlobal {
+			t.Fatalf("expected the global store to change, got %+v: %v", result, err)
+		}
+		tasks, err := global.LoadTasks()
+		if err != nil || !tasks.Tasks[0].Completed {
+			t.Fatalf("expected the global task to be completed: %v", err)
+		}
+		if handler.lastChanged != global {
+			t.Fatalf("expected undo to target the global store")
+		}
+	})
+
+	t.Run("matches in both stores are ambiguous", func(t *testing.T) {
+		// arrange
+		handler, project, global := newStores(t)
+		if _, err := project.AddNote("Standup", "project", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		if _, err := global.AddNote("Standup", "global", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+
+		// act
+		result, err := handler.deleteNote(DeleteNoteParams{Query: "standup"})
+
+		// assert
+		if err != nil || !result.Ambiguous || result.Candidates[0].Store != storeProject ||
+			result.Candidates[1].Store != storeGlobal {
+			t.Fatalf("expected a candidate from each store, got %+v: %v", result, err)
+		}
+		if result.Candidates[0].ShortID != "n1" || result.Candidates[1].ShortID != "g:n1" {
+			t.Fatalf("expected the global candidate to name its store, got %+v", result.Candidates)
+		}
+	})
+
+	t.Run("a short ID in both stores picks the project's unless it names the global store", func(t *testing.T) {
+		// arrange
+		handler, project, global := newStores(t)
+		if _, err := project.AddTask("Write tests", nil, "low", nil); err != nil {
+			t.Fatalf("failed to add project task: %v", err)
+		}
+		if _, err := global.AddTask("Buy milk", nil, "low", nil); err != nil {
+			t.Fatalf("failed to add global task: %v", err)
+		}
+
+		// act
+		listed, listErr := handler.listTasks(ListTasksParams{Filter: "all", Scope: scopeAll})
+		completions, _ := taskDoneCmd.ValidArgsFunction(taskDoneCmd, nil, "")
+		inProject, projectErr := handler.completeTask(CompleteTaskParams{Query: "t1"})
+		inGlobal, globalErr := handler.completeTask(CompleteTaskParams{Query: "g:t1"})
+
+		// assert
+		if listErr != nil || len(listed.Tasks) != 2 || listed.Tasks[0].ShortID != "t1" || listed.Tasks[1].ShortID != "g:t1" {
+			t.Fatalf("expected the listing to tell the stores apart, got %+v: %v", listed.Tasks, listErr)
+		}
+		if !reflect.DeepEqual(completions, []string{"t1\tWrite tests", "g:t1\tBuy milk"}) {
+			t.Fatalf("expected completion to tell the stores apart, got %v", completions)
+		}
+		wantMessage := "Task 'Write tests' marked as completed (project store)"
+		if projectErr != nil || inProject.Store != storeProject || inProject.Message != wantMessage {
+			t.Fatalf("expected t1 to pick the project task, got %+v: %v", inProject, projectErr)
+		}
+		if globalErr != nil || inGlobal.Store != storeGlobal {
+			t.Fatalf("expected g:t1 to pick the global task, got %+v: %v", inGlobal, globalErr)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxContextSourceBytes limits each piped input or file added to a prompt
+	maxContextSourceBytes = 64 * 1024
+	// maxContextBytes limits all of them together
+	maxContextBytes = 192 * 1024
+
+	stdinContextName = "stdin"
+	contextTag       = "user_supplied_context"
+)
+
+// ContextSource is text supplied alongside a prompt, from stdin or a --file
+type ContextSource struct {
+	Name    string
+	Content string
+	// Size is the length of the whole input; Content is shorter when it was truncated
+	Size int
+}
+
+// Truncated reports whether only part of the input is included
+func (c ContextSource) Truncated() bool {
+	return len(c.Content) < c.Size
+}
+
+// readPromptContext reads piped stdin, when stdin is not a terminal, and each
+// file, truncating them to the context limits
+func readPromptContext(stdin *os.File, files []string) ([]ContextSource, error) {
+	var sources []ContextSource
+	budget := maxContextBytes
+
+	if stdinIsPiped(stdin) {
+		source, err := readContextSource(stdinContextName, stdin, min(maxContextSourceBytes, budget))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		if strings.TrimSpace(source.Content) != "" {
+			sources = append(sources, source)
+			budget -= len(source.Content)
+		}
+	}
+
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open context file: %w", err)
+		}
+		source, err := readContextSource(path, file, min(maxContextSourceBytes, budget))
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read context file %s: %w", path, err)
+		}
+		sources = append(sources, source)
+		budget -= len(source.Content)
+	}
+	return sources, nil
+}
+
+// stdinIsPiped reports whether stdin is a pipe or a file. Terminals and
+// devices such as /dev/null are left alone, so nothing waits for input.
+func stdinIsPiped(stdin *os.File) bool {
+	info, err := stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0 && (info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular())
+}
+
+// readContextSource reads up to limit bytes of r, cut at a UTF-8 boundary, and
+// counts the rest without keeping it
+func readContextSource(name string, r io.Reader, limit int) (ContextSource, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)))
+	if err != nil {
+		return ContextSource{}, err
+	}
+	rest, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return ContextSource{}, err
+	}
+	size := len(data) + int(rest)
+	if rest > 0 {
+		data = trimPartialRune(data)
+	}
+	return ContextSource{Name: name, Content: string(data), Size: size}, nil
+}
+
+// trimPartialRune drops a multi-byte character cut off at the end of data
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// withPromptContext appends each source to prompt between user_supplied_context
+// tags, which the system prompt says hold data rather than instructions
+func withPromptContext(prompt string, sources []ContextSource) string {
+	if len(sources) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	b.WriteString(prompt)
+	for _, source := range sources {
+		// A closing tag inside the content must not end the block early
+		content := strings.ReplaceAll(source.Content, "</"+contextTag, "<\\/"+contextTag)
+		fmt.Fprintf(&b, "\n\n<%s name=%q>\n%s\n</%s>", contextTag, source.Name, strings.TrimRight(content, "\n"), contextTag)
+		if source.Truncated() {
+			fmt.Fprintf(&b, "\n(%s was truncated: only the first %d of %d bytes are included.)",
+				source.Name, len(source.Content), source.Size)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const corruptFileSuffix = ".corrupt-"
+
+// recordStart finds where a serialized task, note or tombstone begins; the ID is always written first
+var recordStart = regexp.MustCompile(`\{\s*"id"\s*:`)
+
+// lastNumberField finds the short ID counter of a data file
+var lastNumberField = regexp.MustCompile(`"last_number"\s*:\s*(\d+)`)
+
+// recoveryNotifier is told about every recovered data file so the CLI can report it
+var recoveryNotifier func(RecoveryReport)
+
+// CorruptFileError reports a data file that cannot be decrypted or parsed
+type CorruptFileError struct {
+	File string
+	Err  error
+}
+
+func (e *CorruptFileError) Error() string {
+	return fmt.Sprintf("%s is corrupt: %v", e.File, e.Err)
+}
+
+func (e *CorruptFileError) Unwrap() error {
+	return e.Err
+}
+
+// RecoveryReport describes how a corrupt data file was rebuilt
+type RecoveryReport struct {
+	File        string
+	Cause       string
+	CorruptCopy string
+	// Salvaged counts records read intact from the corrupt file
+	Salvaged int
+	// Unreadable counts damaged records that could not be read
+	Unreadable int
+	// Snapshot is the ID of the backup that filled in missing records, if any
+	Snapshot   string
+	SnapshotAt time.Time
+	// FromSnapshot counts records taken from the snapshot; changes made to them since are lost
+	FromSnapshot int
+}
+
+// recoverFile moves a corrupt data file aside and rebuilds it from the records
+// that can still be read, filling in from the newest readable snapshot when
+// records were damaged or nothing could be salvaged. The caller must hold the
+// storage lock.
+func (s *Storage) recoverFile(name string, cause error) (*RecoveryReport, error) {
+	path := filepath.Join(s.basePath, name)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	report := &RecoveryReport{
+		File:        name,
+		Cause:       cause.Error(),
+		CorruptCopy: path + corruptFileSuffix + time.Now().Format(backupTimeLayout),
+	}
+	if err := os.Rename(path, report.CorruptCopy); err != nil {
+		return nil, fmt.Errorf("failed to move corrupt %s aside: %w", name, err)
+	}
+
+	// Damaged ciphertext cannot be salvaged; only the snapshot can help
+	data := raw
+	if plain, err := s.vault.Open(raw); err == nil {
+		data = plain
+	}
+
+	switch name {
+	case tasksFile:
+		tasks, tombs, unreadable := salvageRecords[Task](data)
+		report.Salvaged, report.Unreadable = len(tasks), unreadable
+		last := salvageLastNumber(data)
+		if len(tasks) == 0 || unreadable > 0 {
+			if snapshot, _ := s.newestReadableSnapshot(report); snapshot != nil {
+				tasks, report.FromSnapshot = fillFromSnapshot(taskReplica, tasks, tombs, snapshot.Tasks)
+				last = max(last, snapshot.LastNumber)
+			}
+		}
+		// SaveTasks raises the counter to the highest recovered number
+		err = s.SaveTasks(&TaskList{LastNumber: last, Tasks: nonNil(tasks), Tombstones: tombs})
+	case notesFile:
+		notes, tombs, unreadable := salvageRecords[Note](data)
+		report.Salvaged, report.Unreadable = len(notes), unreadable
+		last := salvageLastNumber(data)
+		if len(notes) == 0 || unreadable > 0 {
+			if _, snapshot := s.newestReadableSnapshot(report); snapshot != nil {
+				notes, report.FromSnapshot = fillFromSnapshot(noteReplica, notes, tombs, snapshot.Notes)
+				last = max(last, snapshot.LastNumber)
+			}
+		}
+		err = s.SaveNotes(&NoteList{LastNumber: last, Notes: nonNil(notes), Tombstones: tombs})
+	default:
+		err = fmt.Errorf("unknown data file %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write recovered %s: %w", name, err)
+	}
+
+	s.logger.Warn("recovered corrupt data file", "file", name, "cause", report.Cause,
+		"corrupt_copy", report.CorruptCopy, "salvaged", report.Salvaged, "unreadable", report.Unreadable,
+		"snapshot", report.Snapshot, "from_snapshot", report.FromSnapshot)
+	if recoveryNotifier != nil {
+		recoveryNotifier(*report)
+	}
+	return report, nil
+}
+
+// newestReadableSnapshot returns the data of the newest snapshot that can be read,
+// recording which one it was in report
+func (s *Storage) newestReadableSnapshot(report *RecoveryReport) (*TaskList, *NoteList) {
+	snapshots, err := listSnapshotsIn(filepath.Join(s.basePath, backupDirName))
+	if err != nil {
+		s.logger.Error("failed to list snapshots for recovery", "error", err)
+		return nil, nil
+	}
+	for _, snapshot := range snapshots {
+		tasks, notes, err := readSnapshot(&snapshot, s.vault)
+		if err != nil {
+			s.logger.Warn("skipping unreadable snapshot", "id", snapshot.ID, "error", err)
+			continue
+		}
+		report.Snapshot, report.SnapshotAt = snapshot.ID, snapshot.CreatedAt
+		return tasks, notes
+	}
+	return nil, nil
+}
+
+// salvageRecords reads every intact record and tombstone out of a damaged data
+// file, skipping over damaged ones. It returns how many records were damaged.
+func salvageRecords[T any](data []byte) ([]T, []Tombstone, int) {
+	var (
+		records    []T
+		tombs      []Tombstone
+		unreadable int
+	)
+	for offset := 0; offset < len(data); {
+		loc := recordStart.FindIndex(data[offset:])
+		if loc == nil {
+			break
+		}
+		start := offset + loc[0]
+		dec := json.NewDecoder(bytes.NewReader(data[start:]))
+		var fields map[string]json.RawMessage
+		if err := dec.Decode(&fields); err != nil {
+			unreadable++
+			offset = start + 1
+			continue
+		}
+		offset = start + int(dec.InputOffset())
+		image := data[start:offset]
+
+		// Tombstones carry only an ID and deletion time; records always have a title
+		if _, isRecord := fields["title"]; !isRecord {
+			var tomb Tombstone
+			if err := json.Unmarshal(image, &tomb); err == nil && tomb.ID != "" {
+				tombs = append(tombs, tomb)
+			}
+			continue
+		}
+		var rec T
+		if err := json.Unmarshal(image, &rec); err != nil {
+			unreadable++
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, tombs, unreadable
+}
+
+// salvageLastNumber reads the short ID counter out of a damaged data file, or
+// returns 0 when it cannot be found, so numbers handed out before the damage
+// are not reused
+func salvageLastNumber(data []byte) int {
+	last := 0
+	for _, match := range lastNumberField.FindAllSubmatch(data, -1) {
+		if n, err := strconv.Atoi(string(match[1])); err == nil {
+			last = max(last, n)
+		}
+	}
+	return last
+}
+
+// fillFromSnapshot adds snapshot records missing from the salvaged ones, except
+// those deleted since. It returns the records and how many were added.
+func fillFromSnapshot[T any](r replica[T], salvaged []T, tombs []Tombstone, snapshot []T) ([]T, int) {
+	known := make(map[string]bool, len(salvaged)+len(tombs))
+	for _, rec := range salvaged {
+		id, _ := r.key(rec)
+		known[id] = true
+	}
+	for _, tomb := range tombs {
+		known[tomb.ID] = true
+	}
+	added := 0
+	for _, rec := range snapshot {
+		if id, _ := r.key(rec); !known[id] {
+			salvaged = append(salvaged, rec)
+			known[id] = true
+			added++
+		}
+	}
+	return salvaged, added
+}
+
+func nonNil[T any](records []T) []T {
+	if records == nil {
+		return []T{}
+	}
+	return records
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverCorruptFile(t *testing.T) {
+	t.Run("salvages intact records and moves the corrupt file aside", func(t *testing.T) {
+		// arrange
+		storage := newTestStorage(t)
+		for _, title := range []string{"first", "second", "third"} {
+			if _, err := storage.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		path := filepath.Join(storage.basePath, tasksFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read tasks: %v", err)
+		}
+		damaged := strings.Replace(string(data), `"title": "second"`, `"title": "sec`+"\x00"+`ond"`, 1)
+		if err := os.WriteFile(path, []byte(damaged), dataFilePerm); err != nil {
+			t.Fatalf("failed to damage tasks: %v", err)
+		}
+		var reports []RecoveryReport
+		recoveryNotifier = func(r RecoveryReport) { reports = append(reports, r) }
+		t.Cleanup(func() { recoveryNotifier = nil })
+
+		// act
+		tasks, err := storage.LoadTasks()
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected the file to be recovered, got %v", err)
+		}
+		if len(tasks.Tasks) != 2 || tasks.Tasks[0].Title != "first" || tasks.Tasks[1].Title != "third" {
+			t.Fatalf("unexpected salvaged tasks: %+v", tasks.Tasks)
+		}
+		if len(reports) != 1 || reports[0].Salvaged != 2 || reports[0].Unreadable != 1 {
+			t.Fatalf("unexpected recovery report: %+v", reports)
+		}
+		if _, err := os.Stat(reports[0].CorruptCopy); err != nil {
+			t.Fatalf("expected the corrupt copy to be kept: %v", err)
+		}
+	})
+
+	t.Run("falls back to the newest readable snapshot", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"backed up", "also backed up"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		if _, err := createSnapshotAt(repo, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		path := filepath.Join(GetConfigDir(), tasksFile)
+		if err := os.WriteFile(path, []byte("\x00\x00garbage"), dataFilePerm); err != nil {
+			t.Fatalf("failed to damage tasks: %v", err)
+		}
+		var reports []RecoveryReport
+		recoveryNotifier = func(r RecoveryReport) { reports = append(reports, r) }
+		t.Cleanup(func() { recoveryNotifier = nil })
+
+		// act
+		_, err := repo.AddTask("after recovery", nil, "", nil)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected the file to be recovered, got %v", err)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 3 {
+			t.Fatalf("expected 2 restored tasks and 1 new one, got %+v", tasks.Tasks)
+		}
+		if len(reports) != 1 || reports[0].FromSnapshot != 2 || reports[0].Snapshot == "" {
+			t.Fatalf("unexpected recovery report: %+v", reports)
+		}
+	})
+
+	t.Run("keeps counting short IDs from the damaged file", func(t *testing.T) {
+		// arrange
+		storage := newTestStorage(t)
+		for _, title := range []string{"first", "second", "third"} {
+			if _, err := storage.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		err := storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = tasks.Tasks[:2]
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to remove task: %v", err)
+		}
+		path := filepath.Join(storage.basePath, tasksFile)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read tasks: %v", err)
+		}
+		damaged := strings.Replace(string(data), `"title": "second"`, `"title": "sec`+"\x00"+`ond"`, 1)
+		if err := os.WriteFile(path, []byte(damaged), dataFilePerm); err != nil {
+			t.Fatalf("failed to damage tasks: %v", err)
+		}
+
+		// act
+		added, err := storage.AddTask("fourth", nil, "", nil)
+
+		// assert
+		if err != nil || added.ShortID() != "t4" {
+			t.Fatalf("expected t4 after recovery, got %+v: %v", added, err)
+		}
+	})
+
+	t.Run("keeps counting short IDs from the snapshot when the file is unreadable", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"first", "second", "third"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		err := repo.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = tasks.Tasks[:1]
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to remove tasks: %v", err)
+		}
+		if _, err := createSnapshotAt(repo, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("failed to create snapshot: %v", err)
+		}
+		path := filepath.Join(GetConfigDir(), tasksFile)
+		if err := os.WriteFile(path, []byte("\x00\x00garbage"), dataFilePerm); err != nil {
+			t.Fatalf("failed to damage tasks: %v", err)
+		}
+
+		// act
+		added, err := repo.AddTask("fourth", nil, "", nil)
+
+		// assert
+		if err != nil || added.ShortID() != "t4" {
+			t.Fatalf("expected t4 after recovery, got %+v: %v", added, err)
+		}
+	})
+}
+
+func TestFsck(t *testing.T) {
+	t.Run("reports and repairs inconsistencies", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		created := time.Now()
+		badDate, slashDate := "tomorrow", "2026/03/01"
+		task := Task{ID: "t1", Title: "dup", Priority: "HIGH", DueDate: &slashDate, CreatedAt: created, UpdatedAt: created}
+		err := repo.SaveTasks(&TaskList{Tasks: []Task{
+			task,
+			task,
+			{ID: "t1", Title: "different", Priority: "urgent", DueDate: &badDate, CreatedAt: created, UpdatedAt: created.Add(-time.Hour)},
+		}})
+		if err != nil {
+			t.Fatalf("failed to save tasks: %v", err)
+		}
+
+		// act
+		checked, err := Fsck(repo, false)
+		if err != nil {
+			t.Fatalf("failed to check: %v", err)
+		}
+		repaired, err := Fsck(repo, true)
+		if err != nil {
+			t.Fatalf("failed to repair: %v", err)
+		}
+		after, err := Fsck(repo, false)
+		if err != nil {
+			t.Fatalf("failed to recheck: %v", err)
+		}
+
+		// assert
+		// exact copy, differing duplicate, 2 priorities, 2 due dates, update time
+		if len(checked.Issues) != 7 || len(repaired.Issues) != 7 {
+			t.Fatalf("expected 7 issues, got %+v", checked.Issues)
+		}
+		if len(after.Issues) != 0 {
+			t.Fatalf("expected no issues after repair, got %+v", after.Issues)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 2 || tasks.Tasks[1].ID == "t1" {
+			t.Fatalf("expected the copy removed and the duplicate renumbered, got %+v", tasks.Tasks)
+		}
+		if tasks.Tasks[0].Priority != "high" || *tasks.Tasks[0].DueDate != "2026-03-01" ||
+			tasks.Tasks[1].Priority != "medium" || tasks.Tasks[1].DueDate != nil {
+			t.Fatalf("unexpected repairs: %+v", tasks.Tasks)
+		}
+	})
+}
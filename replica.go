@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deviceIDEnv      = "KIKI_DEVICE_ID"
+	defaultDeviceID  = "local"
+	conflictMarker   = "conflict"
+	conflictSuffix   = " (conflicted copy)"
+	dataFileExt      = ".json"
+	clockBefore      = -1
+	clockEqual       = 0
+	clockAfter       = 1
+	clockConcurrent  = 2
+	mergeKindTasks   = "tasks"
+	mergeKindNotes   = "notes"
+	conflictCopyGlob = "*" + dataFileExt
+)
+
+var (
+	deviceIDOnce sync.Once
+	deviceIDVal  string
+)
+
+// deviceID names this machine in record clocks. It comes from KIKI_DEVICE_ID or
+// the hostname, never from the data directory, which file-sync tools copy around.
+func deviceID() string {
+	deviceIDOnce.Do(func() {
+		deviceIDVal = os.Getenv(deviceIDEnv)
+		if deviceIDVal == "" {
+			if host, err := os.Hostname(); err == nil && host != "" {
+				deviceIDVal = host
+			} else {
+				deviceIDVal = defaultDeviceID
+			}
+		}
+	})
+	return deviceIDVal
+}
+
+// merge returns the element-wise maximum of two clocks
+func (c VectorClock) merge(other VectorClock) VectorClock {
+	merged := make(VectorClock, len(c)+len(other))
+	for device, n := range c {
+		merged[device] = n
+	}
+	for device, n := range other {
+		if n > merged[device] {
+			merged[device] = n
+		}
+	}
+	return merged
+}
+
+// compare orders two clocks: clockBefore, clockEqual, clockAfter or clockConcurrent
+func (c VectorClock) compare(other VectorClock) int {
+	less, greater := false, false
+	for device := range c.merge(other) {
+		switch mine, theirs := c[device], other[device]; {
+		case mine < theirs:
+			less = true
+		case mine > theirs:
+			greater = true
+		}
+	}
+	switch {
+	case less && greater:
+		return clockConcurrent
+	case less:
+		return clockBefore
+	case greater:
+		return clockAfter
+	default:
+		return clockEqual
+	}
+}
+
+// replica describes how to merge copies of one record type
+type replica[T any] struct {
+	key   recordKey[T]
+	clock func(*T) *VectorClock
+	fork  func(T) T
+}
+
+var taskReplica = replica[Task]{
+	key:   taskKey,
+	clock: func(t *Task) *VectorClock { return &t.Clock },
+	fork: func(t Task) Task {
+		t.ID, t.Number, t.Title, t.Clock = generateID(), 0, t.Title+conflictSuffix, nil
+		return t
+	},
+}
+
+var noteReplica = replica[Note]{
+	key:   noteKey,
+	clock: func(n *Note) *VectorClock { return &n.Clock },
+	fork: func(n Note) Note {
+		n.ID, n.Number, n.Title, n.Clock = generateID(), 0, n.Title+conflictSuffix, nil
+		return n
+	},
+}
+
+// stampRecords advances this device's clock on every record that differs from
+// its image before the change, and keeps tombstones in step with removals
+func stampRecords[T any](r replica[T], before map[string][]byte, beforeClocks map[string]VectorClock,
+	records []T, tombstones []Tombstone) ([]Tombstone, error) {
+	now := time.Now()
+	present := make(map[string]bool, len(records))
+	for i := range records {
+		id, _ := r.key(records[i])
+		present[id] = true
+		data, err := json.Marshal(records[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize record: %w", err)
+		}
+		if old, existed := before[id]; existed && bytes.Equal(old, data) {
+			continue
+		}
+		clock := r.clock(&records[i])
+		*clock = beforeClocks[id].merge(*clock)
+		(*clock)[deviceID()]++
+	}
+
+	kept := tombstones[:0]
+	for _, tomb := range tombstones {
+		if !present[tomb.ID] {
+			kept = append(kept, tomb)
+		}
+	}
+	for id := range before {
+		if !present[id] {
+			kept = append(kept, Tombstone{ID: id, DeletedAt: now})
+		}
+	}
+	return kept, nil
+}
+
+// recordClocks copies the clock of every record by ID
+func recordClocks[T any](r replica[T], records []T) map[string]VectorClock {
+	clocks := make(map[string]VectorClock, len(records))
+	for i := range records {
+		id, _ := r.key(records[i])
+		clocks[id] = *r.clock(&records[i])
+	}
+	return clocks
+}
+
+// MergeReport counts what merging a conflict copy changed in the canonical file
+type MergeReport struct {
+	File      string
+	Kind      string
+	Added     int
+	Updated   int
+	Removed   int
+	Conflicts int
+}
+
+// Changed reports whether the merge changed the canonical file
+func (r MergeReport) Changed() bool {
+	return r.Added+r.Updated+r.Removed+r.Conflicts > 0
+}
+
+// mergeReplicas merges two copies of a record list that have no common
+// ancestor, matching records by ID:
+//   - a record whose clock dominates the other copy's wins
+//   - concurrent edits keep the most recently updated version under its ID and
+//     the other as a new "(conflicted copy)" record, so no edit is lost
+//   - a record missing from one copy is removed only if that copy holds a
+//     tombstone newer than the record's last update
+func mergeReplicas[T any](r replica[T], ours, theirs []T, ourTombs, theirTombs []Tombstone) ([]T, []Tombstone, MergeReport, error) {
+	var report MergeReport
+	ourImages, err := imagesByID(ours, r.key)
+	if err != nil {
+		return nil, nil, report, err
+	}
+	theirImages, err := imagesByID(theirs, r.key)
+	if err != nil {
+		return nil, nil, report, err
+	}
+	tombs := mergeTombstones(ourTombs, theirTombs)
+
+	merged := make([]T, 0, len(ours)+len(theirs))
+	var forks []T
+	for _, ourRec := range ours {
+		id, ourUpdated := r.key(ourRec)
+		if _, inTheirs := theirImages[id]; !inTheirs {
+			if deletedAt, ok := tombs[id]; ok && !ourUpdated.After(deletedAt) {
+				report.Removed++
+				continue
+			}
+			merged = append(merged, ourRec)
+			continue
+		}
+		if bytes.Equal(ourImages[id], theirImages[id]) {
+			merged = append(merged, ourRec)
+			continue
+		}
+
+		theirRec, err := recordAt(theirs, id, r.key)
+		if err != nil {
+			return nil, nil, report, err
+		}
+		ourClock, theirClock := *r.clock(&ourRec), *r.clock(&theirRec)
+		switch ourClock.compare(theirClock) {
+		case clockAfter:
+			merged = append(merged, ourRec)
+		case clockBefore:
+			merged = append(merged, theirRec)
+			report.Updated++
+		default:
+			if same, err := sameContent(r, ourRec, theirRec); err != nil {
+				return nil, nil, report, err
+			} else if same {
+				*r.clock(&ourRec) = ourClock.merge(theirClock)
+				merged = append(merged, ourRec)
+				continue
+			}
+			winner, loser := ourRec, theirRec
+			if _, theirUpdated := r.key(theirRec); theirUpdated.After(ourUpdated) {
+				winner, loser = theirRec, ourRec
+				report.Updated++
+			}
+			*r.clock(&winner) = ourClock.merge(theirClock)
+			merged = append(merged, winner)
+			forks = append(forks, r.fork(loser))
+			report.Conflicts++
+		}
+	}
+
+	for _, theirRec := range theirs {
+		id, theirUpdated := r.key(theirRec)
+		if _, inOurs := ourImages[id]; inOurs {
+			continue
+		}
+		if deletedAt, ok := tombs[id]; ok && !theirUpdated.After(deletedAt) {
+			continue
+		}
+		merged = append(merged, theirRec)
+		report.Added++
+	}
+	merged = append(merged, forks...)
+	return merged, retainedTombstones(r, merged, tombs), report, nil
+}
+
+// sameContent reports whether two versions of a record differ only in their clocks
+func sameContent[T any](r replica[T], a, b T) (bool, error) {
+	*r.clock(&a), *r.clock(&b) = nil, nil
+	images, err := imagesByID([]T{a}, r.key)
+	if err != nil {
+		return false, err
+	}
+	other, err := json.Marshal(b)
+	if err != nil {
+		return false, fmt.Errorf("failed to serialize record: %w", err)
+	}
+	id, _ := r.key(a)
+	return bytes.Equal(images[id], other), nil
+}
+
+// retainedTombstones lists the tombstones whose records are absent from records, sorted by ID
+func retainedTombstones[T any](r replica[T], records []T, tombs map[string]time.Time) []Tombstone {
+	live := make(map[string]bool, len(records))
+	for _, rec := range records {
+		id, _ := r.key(rec)
+		live[id] = true
+	}
+	var kept []Tombstone
+	for id, deletedAt := range tombs {
+		if !live[id] {
+			kept = append(kept, Tombstone{ID: id, DeletedAt: deletedAt})
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ID < kept[j].ID })
+	return kept
+}
+
+// mergeTombstones indexes tombstones by ID, keeping the latest deletion
+func mergeTombstones(lists ...[]Tombstone) map[string]time.Time {
+	tombs := make(map[string]time.Time)
+	for _, list := range lists {
+		for _, tomb := range list {
+			if tomb.DeletedAt.After(tombs[tomb.ID]) {
+				tombs[tomb.ID] = tomb.DeletedAt
+			}
+		}
+	}
+	return tombs
+}
+
+// isConflictCopy reports whether name is a file-sync conflict copy of canonical,
+// such as "tasks (conflicted copy).json" from Dropbox or
+// "tasks.sync-conflict-20260101-120000-ABC1234.json" from Syncthing
+func isConflictCopy(name, canonical string) bool {
+	stem := strings.TrimSuffix(canonical, dataFileExt)
+	return name != canonical &&
+		strings.HasPrefix(name, stem) &&
+		strings.HasSuffix(name, dataFileExt) &&
+		strings.Contains(strings.ToLower(strings.TrimPrefix(name, stem)), conflictMarker)
+}
+
+// findConflictCopies lists the conflict copies of canonical in dir
+func findConflictCopies(dir, canonical string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, conflictCopyGlob))
+	if err != nil {
+		return nil, err
+	}
+	var copies []string
+	for _, path := range matches {
+		if isConflictCopy(filepath.Base(path), canonical) {
+			copies = append(copies, path)
+		}
+	}
+	return copies, nil
+}
+
+// decodeDataFile parses a tasks or notes file of any supported schema version
+func decodeDataFile(data []byte, migrations []schemaMigration, out any) error {
+	doc, version, err := decodeSchemaDocument(data)
+	if err != nil {
+		return err
+	}
+	if version > currentSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than supported version %d; upgrade kiki",
+			version, currentSchemaVersion)
+	}
+	if err := migrateSchemaDocument(doc, version, migrations); err != nil {
+		return err
+	}
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(migrated, out)
+}
+
+// dataFileKind reports whether a data file holds tasks or notes
+func dataFileKind(data []byte) (string, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	switch {
+	case doc[mergeKindTasks] != nil:
+		return mergeKindTasks, nil
+	case doc[mergeKindNotes] != nil:
+		return mergeKindNotes, nil
+	default:
+		return "", fmt.Errorf("file holds neither tasks nor notes")
+	}
+}
+
+// MergeFile merges a copy of tasks.json or notes.json into the canonical file
+func (s *Storage) MergeFile(path string) (MergeReport, error) {
+	data, err := s.readFile(path)
+	if err != nil {
+		return MergeReport{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	kind, err := dataFileKind(data)
+	if err != nil {
+		return MergeReport{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var report MergeReport
+	err = s.withLock(func() error {
+		if kind == mergeKindTasks {
+			report, err = s.mergeTasksFrom(data)
+		} else {
+			report, err = s.mergeNotesFrom(data)
+		}
+		return err
+	})
+	report.File = path
+	return report, err
+}
+
+// resolveConflictCopies merges and removes any conflict copies of canonical
+// left by a file-sync tool. Failures are logged so the canonical file stays usable.
+func (s *Storage) resolveConflictCopies(canonical string) {
+	copies, err := findConflictCopies(s.basePath, canonical)
+	if err != nil || len(copies) == 0 {
+		return
+	}
+	if err := s.withLock(func() error { return s.mergeConflictCopies(canonical) }); err != nil {
+		s.logger.Error("failed to merge conflict copies", "file", canonical, "error", err)
+	}
+}
+
+// mergeConflictCopies merges and removes conflict copies of canonical; the
+// caller must hold the storage lock
+func (s *Storage) mergeConflictCopies(canonical string) error {
+	copies, err := findConflictCopies(s.basePath, canonical)
+	if err != nil {
+		return err
+	}
+	for _, path := range copies {
+		data, err := s.readFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var report MergeReport
+		if canonical == tasksFile {
+			report, err = s.mergeTasksFrom(data)
+		} else {
+			report, err = s.mergeNotesFrom(data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", filepath.Base(path), err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove merged copy: %w", err)
+		}
+		s.logger.Info("merged conflict copy", "file", filepath.Base(path), "added", report.Added,
+			"updated", report.Updated, "removed", report.Removed, "conflicts", report.Conflicts)
+	}
+	return nil
+}
+
+func (s *Storage) mergeTasksFrom(data []byte) (MergeReport, error) {
+	var theirs TaskList
+	if err := decodeDataFile(data, taskSchemaMigrations, &theirs); err != nil {
+		return MergeReport{}, fmt.Errorf("failed to parse tasks: %w", err)
+	}
+	ours, err := s.loadTasks(true)
+	if err != nil {
+		return MergeReport{}, err
+	}
+	merged, tombs, report, err := mergeReplicas(taskReplica, ours.Tasks, theirs.Tasks, ours.Tombstones, theirs.Tombstones)
+	if err != nil {
+		return report, err
+	}
+	report.Kind = mergeKindTasks
+	if !report.Changed() && len(tombs) == len(ours.Tombstones) {
+		return report, nil
+	}
+	ours.Tasks, ours.Tombstones = merged, tombs
+	ours.LastNumber = max(ours.LastNumber, theirs.LastNumber)
+	return report, s.SaveTasks(ours)
+}
+
+func (s *Storage) mergeNotesFrom(data []byte) (MergeReport, error) {
+	var theirs NoteList
+	if err := decodeDataFile(data, noteSchemaMigrations, &theirs); err != nil {
+		return MergeReport{}, fmt.Errorf("failed to parse notes: %w", err)
+	}
+	ours, err := s.loadNotes(true)
+	if err != nil {
+		return MergeReport{}, err
+	}
+	merged, tombs, report, err := mergeReplicas(noteReplica, ours.Notes, theirs.Notes, ours.Tombstones, theirs.Tombstones)
+	if err != nil {
+		return report, err
+	}
+	report.Kind = mergeKindNotes
+	if !report.Changed() && len(tombs) == len(ours.Tombstones) {
+		return report, nil
+	}
+	ours.Notes, ours.Tombstones = merged, tombs
+	ours.LastNumber = max(ours.LastNumber, theirs.LastNumber)
+	return report, s.SaveNotes(ours)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	storage, err := NewStorage(newTestLogger())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return storage
+}
+
+func TestVectorClockCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b VectorClock
+		want int
+	}{
+		{"equal", VectorClock{"laptop": 1}, VectorClock{"laptop": 1}, clockEqual},
+		{"both empty", nil, VectorClock{}, clockEqual},
+		{"before", VectorClock{"laptop": 1}, VectorClock{"laptop": 2}, clockBefore},
+		{"after", VectorClock{"laptop": 2, "desktop": 1}, VectorClock{"laptop": 2}, clockAfter},
+		{"concurrent", VectorClock{"laptop": 2}, VectorClock{"laptop": 1, "desktop": 1}, clockConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// act
+			got := tt.a.compare(tt.b)
+
+			// assert
+			if got != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeReplicas(t *testing.T) {
+	t.Run("merges copies by ID without losing edits", func(t *testing.T) {
+		// arrange
+		earlier := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+		later := earlier.Add(time.Hour)
+		ours := []Task{
+			{ID: "a", Title: "A ours", UpdatedAt: earlier, Clock: VectorClock{"laptop": 2}},
+			{ID: "b", Title: "B ours", UpdatedAt: earlier, Clock: VectorClock{"laptop": 2}},
+			{ID: "c", Title: "C", UpdatedAt: earlier, Clock: VectorClock{"laptop": 1}},
+		}
+		theirs := []Task{
+			{ID: "a", Title: "A theirs", UpdatedAt: earlier, Clock: VectorClock{"laptop": 1}},
+			{ID: "b", Title: "B theirs", UpdatedAt: later, Clock: VectorClock{"laptop": 1, "desktop": 1}},
+			{ID: "d", Title: "D", UpdatedAt: later, Clock: VectorClock{"desktop": 1}},
+			{ID: "e", Title: "E", UpdatedAt: earlier, Clock: VectorClock{"desktop": 1}},
+		}
+		ourTombs := []Tombstone{{ID: "e", DeletedAt: later}}
+		theirTombs := []Tombstone{{ID: "c", DeletedAt: later}}
+
+		// act
+		merged, tombs, report, err := mergeReplicas(taskReplica, ours, theirs, ourTombs, theirTombs)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		titles := make([]string, 0, len(merged))
+		for _, task := range merged {
+			titles = append(titles, task.Title)
+		}
+		// a: our clock dominates; b: concurrent, theirs is newer and ours is kept as a copy;
+		// c and e: removed by tombstones newer than their last update; d: added by them
+		want := []string{"A ours", "B theirs", "D", "B ours" + conflictSuffix}
+		if !equalStrings(titles, want) {
+			t.Fatalf("expected %v, got %v", want, titles)
+		}
+		if merged[1].Clock.compare(VectorClock{"laptop": 2, "desktop": 1}) != clockEqual {
+			t.Fatalf("expected merged clock on the conflict winner, got %v", merged[1].Clock)
+		}
+		if merged[3].ID == "b" {
+			t.Fatalf("expected the conflicted copy to get a new ID")
+		}
+		if report.Added != 1 || report.Removed != 1 || report.Conflicts != 1 {
+			t.Fatalf("unexpected report %+v", report)
+		}
+		if len(tombs) != 2 || tombs[0].ID != "c" || tombs[1].ID != "e" {
+			t.Fatalf("expected tombstones for c and e, got %+v", tombs)
+		}
+	})
+
+	t.Run("keeps a record edited after it was removed elsewhere", func(t *testing.T) {
+		// arrange
+		deletedAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+		theirs := []Note{{ID: "n", Title: "Edited", UpdatedAt: deletedAt.Add(time.Minute)}}
+		ourTombs := []Tombstone{{ID: "n", DeletedAt: deletedAt}}
+
+		// act
+		merged, tombs, _, err := mergeReplicas(noteReplica, nil, theirs, ourTombs, nil)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if len(merged) != 1 || len(tombs) != 0 {
+			t.Fatalf("expected the edit to survive and its tombstone to be dropped, got %+v and %+v", merged, tombs)
+		}
+	})
+}
+
+func TestStorageConflictCopies(t *testing.T) {
+	t.Run("changes advance the device clock and leave tombstones", func(t *testing.T) {
+		// arrange
+		storage := newTestStorage(t)
+		task, err := storage.AddTask("Fix login bug", nil, "", nil)
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+
+		// act
+		err = storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = nil
+			return nil
+		})
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if task.Clock != nil {
+			t.Fatalf("expected the returned task to be unstamped, got %v", task.Clock)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tombstones) != 1 || tasks.Tombstones[0].ID != task.ID {
+			t.Fatalf("expected a tombstone for the removed task, got %+v", tasks.Tombstones)
+		}
+	})
+
+	t.Run("LoadTasks merges and removes conflict copies", func(t *testing.T) {
+		// arrange
+		storage := newTestStorage(t)
+		if _, err := storage.AddTask("Local", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if tasks.Tasks[0].Clock[deviceID()] != 1 {
+			t.Fatalf("expected clock %s:1, got %v", deviceID(), tasks.Tasks[0].Clock)
+		}
+		remote := newTask("Remote", nil, "", nil)
+		remote.Clock = VectorClock{"other-device": 1}
+		copyData, err := json.Marshal(&TaskList{SchemaVersion: currentSchemaVersion, Tasks: []Task{remote}})
+		if err != nil {
+			t.Fatalf("failed to serialize copy: %v", err)
+		}
+		copyPath := filepath.Join(storage.basePath, "tasks (laptop's conflicted copy 2026-03-01).json")
+		if err := os.WriteFile(copyPath, copyData, dataFilePerm); err != nil {
+			t.Fatalf("failed to write copy: %v", err)
+		}
+
+		// act
+		tasks, err = storage.LoadTasks()
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if len(tasks.Tasks) != 2 || tasks.Tasks[1].Title != "Remote" {
+			t.Fatalf("expected local and remote tasks, got %+v", tasks.Tasks)
+		}
+		if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+			t.Fatalf("expected conflict copy to be removed, got %v", err)
+		}
+	})
+
+	t.Run("recognizes Dropbox and Syncthing conflict names", func(t *testing.T) {
+		// arrange
+		names := map[string]bool{
+			"tasks (conflicted copy).json":                     true,
+			"tasks.sync-conflict-20260301-120000-ABC1234.json": true,
+			"tasks.json":                        false,
+			"notes (conflicted copy).json":      false,
+			"tasks.json.v1-20260301T120000.bak": false,
+		}
+
+		for name, want := range names {
+			// act
+			got := isConflictCopy(name, tasksFile)
+
+			// assert
+			if got != want {
+				t.Fatalf("isConflictCopy(%q) = %v, want %v", name, got, want)
+			}
+		}
+	})
+}
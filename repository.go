@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	backendJSON   = "json"
+	backendSQLite = "sqlite"
+)
+
+// TaskRepository persists tasks
+type TaskRepository interface {
+	LoadTasks() (*TaskList, error)
+	SaveTasks(tasks *TaskList) error
+	ModifyTasks(fn func(*TaskList) error) error
+	AddTask(title string, dueDate *string, priority string, tags []string) (*Task, error)
+	// UpdateTask applies changes to the task with id outside the trash and
+	// returns it before and after
+	UpdateTask(id string, changes TaskChanges) (*Task, *Task, error)
+}
+
+// NoteRepository persists notes
+type NoteRepository interface {
+	LoadNotes() (*NoteList, error)
+	SaveNotes(notes *NoteList) error
+	ModifyNotes(fn func(*NoteList) error) error
+	AddNote(title, content string, tags []string) (*Note, error)
+	// UpdateNote is UpdateTask for notes
+	UpdateNote(id string, changes NoteChanges) (*Note, *Note, error)
+}
+
+// Repository is a storage backend for tasks and notes
+type Repository interface {
+	TaskRepository
+	NoteRepository
+	Close() error
+}
+
+// TaskChanges lists the fields to change on a task; nil fields are left alone.
+// A clear flag empties its field first, so a value given with it still wins.
+type TaskChanges struct {
+	Title        *string
+	DueDate      *string
+	ClearDueDate bool
+	Priority     *string
+	Completed    *bool
+	// Tags replaces the task's tags when not nil
+	Tags      []string
+	ClearTags bool
+}
+
+// IsEmpty reports whether the changes leave every field alone
+func (c TaskChanges) IsEmpty() bool {
+	return c.Title == nil && c.DueDate == nil && !c.ClearDueDate && c.Priority == nil &&
+		c.Completed == nil && c.Tags == nil && !c.ClearTags
+}
+
+// Validate rejects the values 'kiki fsck' would flag and an empty title
+func (c TaskChanges) Validate() error {
+	if c.Title != nil && strings.TrimSpace(*c.Title) == "" {
+		return errors.New("the title must not be empty")
+	}
+	return validateTaskFields(c.DueDate, c.Priority)
+}
+
+// apply changes t and reports whether any field ended up different
+func (c TaskChanges) apply(t *Task) bool {
+	before := *t
+	if c.Title != nil {
+		t.Title = *c.Title
+	}
+	if c.ClearDueDate {
+		t.DueDate = nil
+	}
+	if c.DueDate != nil {
+		due := *c.DueDate
+		t.DueDate = &due
+	}
+	if c.Priority != nil {
+		t.Priority = *c.Priority
+	}
+	if c.Completed != nil {
+		t.Completed = *c.Completed
+	}
+	if c.ClearTags {
+		t.Tags = []string{}
+	}
+	if c.Tags != nil {
+		t.Tags = slices.Clone(c.Tags)
+	}
+	return t.Title != before.Title || !equalDates(t.DueDate, before.DueDate) || t.Priority != before.Priority ||
+		t.Completed != before.Completed || !slices.Equal(t.Tags, before.Tags)
+}
+
+// NoteChanges lists the fields to change on a note, like TaskChanges
+type NoteChanges struct {
+	Title        *string
+	Content      *string
+	ClearContent bool
+	// Tags replaces the note's tags when not nil
+	Tags      []string
+	ClearTags bool
+}
+
+// IsEmpty reports whether the changes leave every field alone
+func (c NoteChanges) IsEmpty() bool {
+	return c.Title == nil && c.Content == nil && !c.ClearContent && c.Tags == nil && !c.ClearTags
+}
+
+// Validate rejects an empty title
+func (c NoteChanges) Validate() error {
+	if c.Title != nil && strings.TrimSpace(*c.Title) == "" {
+		return errors.New("the title must not be empty")
+	}
+	return nil
+}
+
+// apply changes n and reports whether any field ended up different
+func (c NoteChanges) apply(n *Note) bool {
+	before := *n
+	if c.Title != nil {
+		n.Title = *c.Title
+	}
+	if c.ClearContent {
+		n.Content = ""
+	}
+	if c.Content != nil {
+		n.Content = *c.Content
+	}
+	if c.ClearTags {
+		n.Tags = []string{}
+	}
+	if c.Tags != nil {
+		n.Tags = slices.Clone(c.Tags)
+	}
+	return n.Title != before.Title || n.Content != before.Content || !slices.Equal(n.Tags, before.Tags)
+}
+
+func equalDates(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// updateTask implements UpdateTask on top of repo's ModifyTasks, so each
+// backend stamps and journals the change as it does any other. UpdatedAt only
+// moves when a field actually changed.
+func updateTask(repo TaskRepository, id string, changes TaskChanges) (*Task, *Task, error) {
+	if err := changes.Validate(); err != nil {
+		return nil, nil, err
+	}
+	var before, after Task
+	err := repo.ModifyTasks(func(tasks *TaskList) error {
+		for i := range tasks.Tasks {
+			t := &tasks.Tasks[i]
+			if t.ID != id || t.DeletedAt != nil {
+				continue
+			}
+			before = *t
+			before.Tags = slices.Clone(t.Tags)
+			if changes.apply(t) {
+				t.UpdatedAt = time.Now()
+			}
+			after = *t
+			return nil
+		}
+		return errNotFound
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &before, &after, nil
+}
+
+// updateNote is updateTask for notes
+func updateNote(repo NoteRepository, id string, changes NoteChanges) (*Note, *Note, error) {
+	if err := changes.Validate(); err != nil {
+		return nil, nil, err
+	}
+	var before, after Note
+	err := repo.ModifyNotes(func(notes *NoteList) error {
+		for i := range notes.Notes {
+			n := &notes.Notes[i]
+			if n.ID != id || n.DeletedAt != nil {
+				continue
+			}
+			before = *n
+			before.Tags = slices.Clone(n.Tags)
+			if changes.apply(n) {
+				n.UpdatedAt = time.Now()
+			}
+			after = *n
+			return nil
+		}
+		return errNotFound
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &before, &after, nil
+}
+
+// activeBackend reports which backend holds the data in the config directory.
+// The SQLite database takes precedence once it exists.
+func activeBackend() string {
+	return backendAt(GetConfigDir())
+}
+
+func backendAt(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, sqliteFile)); err == nil {
+		return backendSQLite
+	}
+	return backendJSON
+}
+
+// NewRepository opens the active storage backend with changes journaled for undo
+func NewRepository(logger *slog.Logger) (*JournaledRepository, error) {
+	return openRepository(GetConfigDir(), logger)
+}
+
+func openRepository(dir string, logger *slog.Logger) (*JournaledRepository, error) {
+	var (
+		backend Repository
+		err     error
+	)
+	switch backendAt(dir) {
+	case backendSQLite:
+		backend, err = newSQLiteStorageAt(dir, logger)
+	default:
+		backend, err = newStorageAt(dir, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	repo := NewJournaledRepository(backend, newJournalAt(dir), logger)
+	if git := newGitStoreAt(dir, logger); git.Enabled() {
+		repo.OnChange(git.CommitHook)
+	}
+	return repo, nil
+}
+
+// MigrateResult describes a completed backend migration
+type MigrateResult struct {
+	From  string
+	To    string
+	Tasks int
+	Notes int
+}
+
+// MigrateToSQLite copies all JSON tasks and notes into a new SQLite database.
+// The JSON files are left in place as a backup. With dryRun set, only the
+// record counts are reported.
+func MigrateToSQLite(logger *slog.Logger, dryRun bool) (*MigrateResult, error) {
+	if activeBackend() == backendSQLite {
+		return nil, fmt.Errorf("data is already stored in %s", sqliteFile)
+	}
+	if NewVault().Enabled() {
+		return nil, fmt.Errorf("encrypted data cannot be moved to %s; run 'kiki decrypt' first", sqliteFile)
+	}
+
+	source, err := NewStorage(logger)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := source.LoadTasks()
+	if err != nil {
+		return nil, err
+	}
+	notes, err := source.LoadNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrateResult{
+		From:  backendJSON,
+		To:    backendSQLite,
+		Tasks: len(tasks.Tasks),
+		Notes: len(notes.Notes),
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	target, err := NewSQLiteStorage(logger)
+	if err != nil {
+		return nil, err
+	}
+	copyErr := target.SaveTasks(tasks)
+	if copyErr == nil {
+		copyErr = target.SaveNotes(notes)
+	}
+	if err := target.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		if err := os.Remove(target.path); err != nil {
+			logger.Error("failed to remove partial database", "path", target.path, "error", err)
+		}
+		return nil, fmt.Errorf("failed to copy data to %s: %w", sqliteFile, copyErr)
+	}
+
+	return result, nil
+}
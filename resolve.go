@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// matchTier ranks how well a query matches a task or note; higher tiers win
+// and a query only resolves when one candidate alone holds the best tier
+type matchTier int
+
+const (
+	tierNone matchTier = iota
+	tierFuzzyTitle
+	tierTitleSubstring
+	tierTitleWords
+	tierTitlePhrase
+	tierExactTitle
+	tierShortID
+	tierIDPrefix
+	tierExactID
+)
+
+const (
+	// idPrefixMin keeps short words from being read as the start of an ID
+	idPrefixMin = 4
+	// fuzzyWordMin and fuzzyLongWordMin are the word lengths that allow one
+	// and two typos; shorter words must match exactly
+	fuzzyWordMin     = 4
+	fuzzyLongWordMin = 8
+	// fuzzyPrefixMin is the shortest query word that matches the start of a title word
+	fuzzyPrefixMin        = 3
+	ambiguousCandidateMax = 10
+)
+
+// MatchCandidate is a task or note a query could refer to
+type MatchCandidate struct {
+	ShortID string `json:"short_id,omitempty"`
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Store   string `json:"store,omitempty"`
+}
+
+// AmbiguousError reports a query that matches several tasks or notes equally well
+type AmbiguousError struct {
+	Kind       string
+	Query      string
+	Candidates []MatchCandidate
+}
+
+func (e *AmbiguousError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "'%s' matches %d %ss:", e.Query, len(e.Candidates), e.Kind)
+	shown := e.Candidates[:min(len(e.Candidates), ambiguousCandidateMax)]
+	for _, c := range shown {
+		b.WriteString("\n  ")
+		if c.ShortID != "" {
+			fmt.Fprintf(&b, "%s ", c.ShortID)
+		}
+		fmt.Fprintf(&b, "%s (id %s", c.Title, c.ID)
+		if c.Store != "" {
+			fmt.Fprintf(&b, ", %s", c.Store)
+		}
+		b.WriteString(")")
+	}
+	if hidden := len(e.Candidates) - len(shown); hidden > 0 {
+		fmt.Fprintf(&b, "\n  and %d more", hidden)
+	}
+	fmt.Fprintf(&b, "\nuse the short ID, ID or full title of the %s you mean", e.Kind)
+	return b.String()
+}
+
+// Ambiguity is part of the results of tools that act on one task or note, so
+// an ambiguous query comes back as the candidates to choose from instead of an action
+type Ambiguity struct {
+	Ambiguous  bool             `json:"ambiguous,omitempty"`
+	Candidates []MatchCandidate `json:"candidates,omitempty"`
+}
+
+// ambiguityFrom returns the candidates of err when it is an *AmbiguousError
+func ambiguityFrom(err error) (Ambiguity, bool) {
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) {
+		return Ambiguity{}, false
+	}
+	shown := ambiguous.Candidates[:min(len(ambiguous.Candidates), ambiguousCandidateMax)]
+	return Ambiguity{Ambiguous: true, Candidates: shown}, true
+}
+
+// resolveMatch returns the index of the candidate query refers to. It fails
+// with errNotFound when nothing matches and with an *AmbiguousError when
+// several candidates tie for the best match.
+func resolveMatch(kind, query string, candidates []MatchCandidate) (int, error) {
+	best := tierNone
+	var matches []int
+	for i, c := range candidates {
+		tier := scoreMatch(query, c)
+		switch {
+		case tier == tierNone || tier < best:
+		case tier > best:
+			best, matches = tier, []int{i}
+		default:
+			matches = append(matches, i)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return notFoundIndex, errNotFound
+	case 1:
+		return matches[0], nil
+	}
+	ambiguous := &AmbiguousError{Kind: kind, Query: strings.TrimSpace(query)}
+	for _, i := range matches {
+		ambiguous.Candidates = append(ambiguous.Candidates, candidates[i])
+	}
+	return notFoundIndex, ambiguous
+}
+
+// scoreMatch rates how well query picks out c, from an exact ID down to a
+// title that only matches with a typo or two
+func scoreMatch(query string, c MatchCandidate) matchTier {
+	q := normalizeMatchText(query)
+	if q == "" {
+		return tierNone
+	}
+	id := strings.ToLower(c.ID)
+	switch {
+	case q == id:
+		return tierExactID
+	case len(q) >= idPrefixMin && isIDFragment(q) && strings.HasPrefix(id, q):
+		return tierIDPrefix
+	case matchesShortID(q, c.ShortID):
+		return tierShortID
+	}
+
+	title := normalizeMatchText(c.Title)
+	if q == title {
+		return tierExactTitle
+	}
+	queryWords, titleWords := matchWords(q), matchWords(title)
+	switch {
+	case len(queryWords) > 0 && containsWordRun(titleWords, queryWords):
+		return tierTitlePhrase
+	case len(queryWords) > 0 && containsWords(titleWords, queryWords, equalWords):
+		return tierTitleWords
+	case strings.Contains(title, q):
+		return tierTitleSubstring
+	case len(queryWords) > 0 && containsWords(titleWords, queryWords, similarWords):
+		return tierFuzzyTitle
+	}
+	return tierNone
+}
+
+// normalizeMatchText lowercases text and collapses runs of whitespace
+func normalizeMatchText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// matchWords splits text into runs of letters and digits
+func matchWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesShortID accepts a short ID such as t42 in full or as its number
+// alone, 42 or #42. A global item inside a project only matches as g:t42.
+func matchesShortID(query, shortID string) bool {
+	if shortID == "" {
+		return false
+	}
+	if query == shortID {
+		return true
+	}
+	return !strings.HasPrefix(shortID, globalShortIDPrefix) && strings.TrimPrefix(query, "#") == shortID[1:]
+}
+
+// isIDFragment reports whether text could be part of a generated ID
+func isIDFragment(text string) bool {
+	for _, r := range text {
+		if !strings.ContainsRune("0123456789abcdef-", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsWordRun reports whether words appear in order and next to each other in title
+func containsWordRun(title, words []string) bool {
+	for start := 0; start+len(words) <= len(title); start++ {
+		if equalWordSlices(title[start:start+len(words)], words) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalWordSlices(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsWords reports whether every word matches some title word, in any order
+func containsWords(title, words []string, match func(titleWord, word string) bool) bool {
+	for _, word := range words {
+		found := false
+		for _, titleWord := range title {
+			if match(titleWord, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func equalWords(titleWord, word string) bool {
+	return titleWord == word
+}
+
+// similarWords accepts a title word that starts with word or is within a
+// typo or two of it, allowing more typos in longer words
+func similarWords(titleWord, word string) bool {
+	length := len([]rune(word))
+	if length >= fuzzyPrefixMin && strings.HasPrefix(titleWord, word) {
+		return true
+	}
+	allowed := 0
+	switch {
+	case length >= fuzzyLongWordMin:
+		allowed = 2
+	case length >= fuzzyWordMin:
+		allowed = 1
+	}
+	return editDistance(titleWord, word) <= allowed
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring characters that turn a into b
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	rows := make([][]int, len(x)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(y)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(x)][len(y)]
+}
+
+// taskCandidates lists the tasks eligible accepts as match candidates, with
+// the indexes they came from
+func taskCandidates(tasks []Task, store string, eligible func(Task) bool) ([]MatchCandidate, []int) {
+	var candidates []MatchCandidate
+	var indexes []int
+	for i, t := range tasks {
+		if eligible(t) {
+			candidates = append(candidates, MatchCandidate{
+				ShortID: storeShortID(t.ShortID(), store), ID: t.ID, Title: t.Title, Store: store,
+			})
+			indexes = append(indexes, i)
+		}
+	}
+	return candidates, indexes
+}
+
+// noteCandidates is taskCandidates for notes
+func noteCandidates(notes []Note, store string, eligible func(Note) bool) ([]MatchCandidate, []int) {
+	var candidates []MatchCandidate
+	var indexes []int
+	for i, n := range notes {
+		if eligible(n) {
+			candidates = append(candidates, MatchCandidate{
+				ShortID: storeShortID(n.ShortID(), store), ID: n.ID, Title: n.Title, Store: store,
+			})
+			indexes = append(indexes, i)
+		}
+	}
+	return candidates, indexes
+}
+
+func liveTask(t Task) bool      { return t.DeletedAt == nil }
+func openTask(t Task) bool      { return t.DeletedAt == nil && !t.Completed }
+func completedTask(t Task) bool { return t.DeletedAt == nil && t.Completed }
+func trashedTask(t Task) bool   { return t.DeletedAt != nil }
+func liveNote(n Note) bool      { return n.DeletedAt == nil }
+func trashedNote(n Note) bool   { return n.DeletedAt != nil }
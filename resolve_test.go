@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScoreMatch(t *testing.T) {
+	candidate := MatchCandidate{ShortID: "t3", ID: "0192f4c1-7b2e-7c3d-9a10-5e6f7a8b9c0d", Title: "Fix the login bug"}
+
+	t.Run("ranks each kind of match in order", func(t *testing.T) {
+		cases := []struct {
+			query string
+			want  matchTier
+		}{
+			{"0192f4c1-7b2e-7c3d-9a10-5e6f7a8b9c0d", tierExactID},
+			{"0192F4C1-7B2E-7C3D-9A10-5E6F7A8B9C0D", tierExactID},
+			{"0192f4c1", tierIDPrefix},
+			{"t3", tierShortID},
+			{"T3", tierShortID},
+			{"3", tierShortID},
+			{"#3", tierShortID},
+			{" fix  THE login bug ", tierExactTitle},
+			{"login bug", tierTitlePhrase},
+			{"bug login", tierTitleWords},
+			{"fix bug", tierTitleWords},
+			{"ogin b", tierTitleSubstring},
+			{"lgoin", tierFuzzyTitle},
+			{"fix logn", tierFuzzyTitle},
+			{"log", tierTitleSubstring},
+			{"gin bu", tierTitleSubstring},
+			{"login bugs", tierFuzzyTitle},
+		}
+		for _, c := range cases {
+			// act
+			got := scoreMatch(c.query, candidate)
+
+			// assert
+			if got != c.want {
+				t.Fatalf("expected tier %d for %q, got %d", c.want, c.query, got)
+			}
+		}
+	})
+
+	t.Run("rejects empty queries and weak matches", func(t *testing.T) {
+		cases := []string{
+			"", "   ", "019", "4", "t4", "n3", "fix the logout bug", "big", "lgn", "login bug report", "3f", "0192f4c2",
+		}
+		for _, query := range cases {
+			// act
+			got := scoreMatch(query, candidate)
+
+			// assert
+			if got != tierNone {
+				t.Fatalf("expected no match for %q, got tier %d", query, got)
+			}
+		}
+	})
+
+	t.Run("only reads hex-like queries as ID prefixes", func(t *testing.T) {
+		// arrange
+		c := MatchCandidate{ID: "cafe1234-0000-7000-8000-000000000000", Title: "Cafe visit"}
+
+		// act
+		word := scoreMatch("cafe", c)
+		prefix := scoreMatch("cafe12", c)
+		unnumbered := scoreMatch("0", MatchCandidate{ID: "x", Title: "Zero"})
+
+		// assert
+		if word != tierIDPrefix || prefix != tierIDPrefix {
+			t.Fatalf("expected ID prefixes, got %d and %d", word, prefix)
+		}
+		if got := scoreMatch("cafe visits", c); got != tierFuzzyTitle {
+			t.Fatalf("expected a word query to match the title, got %d", got)
+		}
+		if unnumbered != tierNone {
+			t.Fatalf("expected items without a short ID not to match one, got %d", unnumbered)
+		}
+	})
+
+	t.Run("allows more typos in longer words", func(t *testing.T) {
+		cases := []struct {
+			title, query string
+			want         bool
+		}{
+			{"Buy milk", "mlik", true},
+			{"Buy milk", "mulk", true},
+			{"Buy milk", "mxyk", false},
+			{"Buy cat food", "cot", false},
+			{"Quarterly planning", "quartrely", true},
+			{"Quarterly planning", "qaurtrely", true},
+			{"Quarterly planning", "qxxrterly", true},
+			{"Quarterly planning", "qxxxterly", false},
+			{"Deployment checklist", "deploy check", true},
+			{"Déploiement", "deploiement", true},
+		}
+		for _, c := range cases {
+			// act
+			got := scoreMatch(c.query, MatchCandidate{ID: "id", Title: c.title}) != tierNone
+
+			// assert
+			if got != c.want {
+				t.Fatalf("expected %q matching %q to be %v", c.query, c.title, c.want)
+			}
+		}
+	})
+
+	t.Run("counts swaps as one edit", func(t *testing.T) {
+		cases := map[[2]string]int{
+			{"login", "login"}: 0, {"login", "lgoin"}: 1, {"login", "logn"}: 1,
+			{"login", "logins"}: 1, {"login", "lagon"}: 2, {"", "abc"}: 3, {"日本語", "日語本"}: 1,
+		}
+		for words, want := range cases {
+			// act
+			got := editDistance(words[0], words[1])
+
+			// assert
+			if got != want {
+				t.Fatalf("expected %d edits from %q to %q, got %d", want, words[0], words[1], got)
+			}
+		}
+	})
+}
+
+func TestResolveMatch(t *testing.T) {
+	candidates := []MatchCandidate{
+		{ShortID: "t1", ID: "0192f4c1-aaaa-7000-8000-000000000001", Title: "Fix bug"},
+		{ShortID: "t2", ID: "0192f4c1-bbbb-7000-8000-000000000002", Title: "Fix the login bug"},
+		{ShortID: "t3", ID: "0192f4c9-cccc-7000-8000-000000000003", Title: "Bug bash"},
+		{ShortID: "t4", ID: "0192f4c9-dddd-7000-8000-000000000004", Title: "Write release notes"},
+	}
+
+	t.Run("picks the only candidate in the best tier", func(t *testing.T) {
+		cases := map[string]int{
+			"fix bug":                              0,
+			"login":                                1,
+			"t2":                                   1,
+			"bug bash":                             2,
+			"0192f4c9-c":                           2,
+			"0192f4c9-dddd-7000-8000-000000000004": 3,
+			"relase":                               3,
+		}
+		for query, want := range cases {
+			// act
+			got, err := resolveMatch("task", query, candidates)
+
+			// assert
+			if err != nil || got != want {
+				t.Fatalf("expected %q to resolve to %d, got %d: %v", query, want, got, err)
+			}
+		}
+	})
+
+	t.Run("reports ties in the best tier as ambiguous", func(t *testing.T) {
+		// act
+		_, err := resolveMatch("task", " bug ", candidates)
+		_, prefixErr := resolveMatch("task", "0192f4c1", candidates)
+
+		// assert
+		var ambiguous *AmbiguousError
+		if !errors.As(err, &ambiguous) || ambiguous.Query != "bug" || len(ambiguous.Candidates) != 3 {
+			t.Fatalf("expected three candidates for 'bug', got %v", err)
+		}
+		if !errors.As(prefixErr, &ambiguous) || len(ambiguous.Candidates) != 2 || ambiguous.Candidates[1].ShortID != "t2" {
+			t.Fatalf("expected the two IDs with that prefix, got %v", prefixErr)
+		}
+		message := err.Error()
+		if !strings.Contains(message, "'bug' matches 3 tasks") ||
+			!strings.Contains(message, "t2 Fix the login bug (id 0192f4c1-bbbb") {
+			t.Fatalf("expected the candidates in the message, got %q", message)
+		}
+	})
+
+	t.Run("finds nothing for empty queries or no match", func(t *testing.T) {
+		for _, query := range []string{"", "  ", "deploy", "9"} {
+			// act
+			got, err := resolveMatch("task", query, candidates)
+
+			// assert
+			if !errors.Is(err, errNotFound) || got != notFoundIndex {
+				t.Fatalf("expected nothing for %q, got %d: %v", query, got, err)
+			}
+		}
+	})
+
+	t.Run("lists at most ambiguousCandidateMax candidates", func(t *testing.T) {
+		// arrange
+		var many []MatchCandidate
+		for i := range ambiguousCandidateMax + 2 {
+			many = append(many, MatchCandidate{ShortID: shortID(noteShortIDPrefix, i+1), ID: generateID(), Title: "Standup"})
+		}
+		_, err := resolveMatch("note", "standup", many)
+
+		// act
+		ambiguity, ok := ambiguityFrom(err)
+
+		// assert
+		if !ok || len(ambiguity.Candidates) != ambiguousCandidateMax || !strings.Contains(err.Error(), "and 2 more") {
+			t.Fatalf("expected a capped candidate list, got %+v: %v", ambiguity, err)
+		}
+		if _, ok := ambiguityFrom(errNotFound); ok {
+			t.Fatalf("expected errNotFound not to be ambiguous")
+		}
+	})
+}
+
+func TestAmbiguousTools(t *testing.T) {
+	t.Run("complete_task asks instead of finishing the wrong task", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"Fix bug", "File bug report", "Buy milk"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		result, err := handler.completeTask(CompleteTaskParams{Query: "bug"})
+		data, marshalErr := json.Marshal(result)
+		exact, exactErr := handler.completeTask(CompleteTaskParams{Query: "fix bug"})
+		numbered, numberedErr := handler.deleteTask(DeleteTaskParams{Query: "t3"})
+
+		// assert
+		if err != nil || marshalErr != nil || result.Success || !result.Ambiguous || len(result.Candidates) != 2 {
+			t.Fatalf("expected an ambiguous result, got %+v: %v", result, err)
+		}
+		if !strings.Contains(string(data), `"ambiguous":true`) || !strings.Contains(string(data), `"short_id":"t2"`) {
+			t.Fatalf("expected the candidates in the tool result, got %s", data)
+		}
+		if exactErr != nil || !exact.Success || exact.Ambiguous || exact.Message != "Task 'Fix bug' marked as completed" {
+			t.Fatalf("expected the exact title to win, got %+v: %v", exact, exactErr)
+		}
+		if numberedErr != nil || numbered.Message != "Task 'Buy milk' moved to the trash" {
+			t.Fatalf("expected the short ID to pick the task, got %+v: %v", numbered, numberedErr)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil || tasks.Tasks[1].Completed {
+			t.Fatalf("expected the bug report to stay open: %v", err)
+		}
+	})
+
+	t.Run("restore from the trash resolves by short ID", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("Fix bug", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddNote("Bug notes", "", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		trashTask(t, repo, "Fix bug", time.Now().Add(-time.Hour))
+		handler := NewToolHandler(repo, newTestLogger())
+		if _, err := handler.deleteNote(DeleteNoteParams{Query: "bug notes"}); err != nil {
+			t.Fatalf("failed to delete note: %v", err)
+		}
+
+		// act
+		_, ambiguousErr := RestoreFromTrash(repo, "bug")
+		item, err := RestoreFromTrash(repo, "t1")
+
+		// assert
+		if _, ok := ambiguityFrom(ambiguousErr); !ok {
+			t.Fatalf("expected the task and note to be ambiguous, got %v", ambiguousErr)
+		}
+		if err != nil || item.Kind != trashKindTask || item.Title != "Fix bug" {
+			t.Fatalf("expected the task to be restored, got %+v: %v", item, err)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// currentSchemaVersion is the data file layout written by this build
+	currentSchemaVersion = 4
+	schemaVersionKey     = "schema_version"
+	backupTimeLayout     = "20060102T150405"
+)
+
+// schemaMigration upgrades a decoded data file from one schema version to the next
+type schemaMigration struct {
+	from        int
+	description string
+	apply       func(doc map[string]any) error
+}
+
+// taskSchemaMigrations upgrade tasks.json, one step per version
+var taskSchemaMigrations = []schemaMigration{
+	{
+		from:        0,
+		description: "add schema_version and ensure every task has a tags list",
+		apply:       ensureRecordTags("tasks"),
+	},
+	{
+		from:        1,
+		description: "allow deleted_at on tasks so deletes move them to the trash",
+		apply:       noSchemaChange,
+	},
+	{
+		from:        2,
+		description: "allow per-task clocks and tombstones for merging synced copies",
+		apply:       noSchemaChange,
+	},
+	{
+		from:        3,
+		description: "number every task in file order for short IDs such as t1",
+		apply:       numberRecords("tasks"),
+	},
+}
+
+// noteSchemaMigrations upgrade notes.json, one step per version
+var noteSchemaMigrations = []schemaMigration{
+	{
+		from:        0,
+		description: "add schema_version and ensure every note has a tags list",
+		apply:       ensureRecordTags("notes"),
+	},
+	{
+		from:        1,
+		description: "allow deleted_at on notes so deletes move them to the trash",
+		apply:       noSchemaChange,
+	},
+	{
+		from:        2,
+		description: "allow per-note clocks and tombstones for merging synced copies",
+		apply:       noSchemaChange,
+	},
+	{
+		from:        3,
+		description: "number every note in file order for short IDs such as n1",
+		apply:       numberRecords("notes"),
+	},
+}
+
+// schemaDataFiles are the versioned data files and the migrations for each
+var schemaDataFiles = []struct {
+	name       string
+	migrations []schemaMigration
+}{
+	{tasksFile, taskSchemaMigrations},
+	{notesFile, noteSchemaMigrations},
+}
+
+// SchemaPlan describes the migrations pending for one data file
+type SchemaPlan struct {
+	File   string
+	From   int
+	To     int
+	Steps  []string
+	Backup string
+}
+
+// Pending reports whether the file needs migrating
+func (p SchemaPlan) Pending() bool {
+	return p.From < p.To
+}
+
+// decodeSchemaDocument parses a data file and returns its schema version.
+// Files written before versioning existed have no version and report 0.
+func decodeSchemaDocument(data []byte) (map[string]any, int, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	raw, ok := doc[schemaVersionKey]
+	if !ok {
+		return doc, 0, nil
+	}
+	version, ok := raw.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return nil, 0, fmt.Errorf("invalid %s %v", schemaVersionKey, raw)
+	}
+	return doc, int(version), nil
+}
+
+// planSchemaMigration lists the steps needed to bring a file at version from up to date
+func planSchemaMigration(path string, from int, migrations []schemaMigration) (SchemaPlan, error) {
+	plan := SchemaPlan{File: filepath.Base(path), From: from, To: currentSchemaVersion}
+	if from > currentSchemaVersion {
+		return plan, fmt.Errorf("%s has schema version %d, newer than supported version %d; upgrade kiki",
+			plan.File, from, currentSchemaVersion)
+	}
+	if !plan.Pending() {
+		return plan, nil
+	}
+
+	for version := from; version < currentSchemaVersion; version++ {
+		step, ok := findSchemaMigration(migrations, version)
+		if !ok {
+			return plan, fmt.Errorf("no migration registered for %s from version %d", plan.File, version)
+		}
+		plan.Steps = append(plan.Steps, fmt.Sprintf("v%d → v%d: %s", version, version+1, step.description))
+	}
+	plan.Backup = fmt.Sprintf("%s.v%d-%s.bak", path, from, time.Now().Format(backupTimeLayout))
+	return plan, nil
+}
+
+// migrateSchemaDocument applies every step from version from up to the current version
+func migrateSchemaDocument(doc map[string]any, from int, migrations []schemaMigration) error {
+	for version := from; version < currentSchemaVersion; version++ {
+		step, ok := findSchemaMigration(migrations, version)
+		if !ok {
+			return fmt.Errorf("no migration registered from version %d", version)
+		}
+		if err := step.apply(doc); err != nil {
+			return fmt.Errorf("migrating from version %d: %w", version, err)
+		}
+		doc[schemaVersionKey] = version + 1
+	}
+	return nil
+}
+
+func findSchemaMigration(migrations []schemaMigration, from int) (schemaMigration, bool) {
+	for _, m := range migrations {
+		if m.from == from {
+			return m, true
+		}
+	}
+	return schemaMigration{}, false
+}
+
+// ensureRecordTags replaces missing or null tags with an empty list on every record under key
+func ensureRecordTags(key string) func(doc map[string]any) error {
+	return func(doc map[string]any) error {
+		raw, ok := doc[key]
+		if !ok || raw == nil {
+			doc[key] = []any{}
+			return nil
+		}
+		records, ok := raw.([]any)
+		if !ok {
+			return fmt.Errorf("%s is not a list", key)
+		}
+		for i, r := range records {
+			record, ok := r.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s[%d] is not an object", key, i)
+			}
+			if tags, ok := record["tags"]; !ok || tags == nil {
+				record["tags"] = []any{}
+			}
+		}
+		return nil
+	}
+}
+
+// numberRecords gives every record under key its position in the file as
+// its number, so short IDs follow the order the old list numbers used
+func numberRecords(key string) func(doc map[string]any) error {
+	return func(doc map[string]any) error {
+		records, ok := doc[key].([]any)
+		if !ok && doc[key] != nil {
+			return fmt.Errorf("%s is not a list", key)
+		}
+		for i, r := range records {
+			record, ok := r.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s[%d] is not an object", key, i)
+			}
+			record["number"] = i + 1
+		}
+		doc["last_number"] = len(records)
+		return nil
+	}
+}
+
+// noSchemaChange is used for version bumps that only add optional fields,
+// so older kiki builds refuse files they would misread
+func noSchemaChange(map[string]any) error {
+	return nil
+}
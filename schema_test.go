@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaMigrationRegistry(t *testing.T) {
+	t.Run("every version below current has a migration step", func(t *testing.T) {
+		for name, migrations := range map[string][]schemaMigration{
+			tasksFile: taskSchemaMigrations,
+			notesFile: noteSchemaMigrations,
+		} {
+			for version := 0; version < currentSchemaVersion; version++ {
+				if _, ok := findSchemaMigration(migrations, version); !ok {
+					t.Fatalf("%s: missing migration from version %d", name, version)
+				}
+			}
+		}
+	})
+}
+
+func TestStorageSchemaMigration(t *testing.T) {
+	legacyTasks := `{"tasks":[{"id":"task-1","title":"Legacy","completed":false,"priority":"low","tags":null,
+		"created_at":"2025-01-01T00:00:00Z","updated_at":"2025-01-01T00:00:00Z"}]}`
+
+	t.Run("LoadTasks upgrades an unversioned file in memory without writing", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		tasksPath := filepath.Join(storage.basePath, tasksFile)
+		if err := os.WriteFile(tasksPath, []byte(legacyTasks), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed tasks: %v", err)
+		}
+
+		// act
+		tasks, err := storage.LoadTasks()
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if tasks.SchemaVersion != currentSchemaVersion {
+			t.Fatalf("expected schema version %d, got %d", currentSchemaVersion, tasks.SchemaVersion)
+		}
+		if len(tasks.Tasks) != 1 || tasks.Tasks[0].Tags == nil {
+			t.Fatalf("expected one task with non-nil tags, got %+v", tasks.Tasks)
+		}
+		data, err := os.ReadFile(tasksPath)
+		if err != nil {
+			t.Fatalf("failed to read tasks: %v", err)
+		}
+		backups, err := filepath.Glob(tasksPath + ".v0-*.bak")
+		if err != nil {
+			t.Fatalf("failed to glob backups: %v", err)
+		}
+		if string(data) != legacyTasks || len(backups) != 0 {
+			t.Fatalf("expected a plain load to leave the file alone, got %d backups", len(backups))
+		}
+	})
+
+	t.Run("ModifyTasks writes the upgrade and a backup under the lock", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		tasksPath := filepath.Join(storage.basePath, tasksFile)
+		if err := os.WriteFile(tasksPath, []byte(legacyTasks), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed tasks: %v", err)
+		}
+
+		// act
+		err = storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].Title = "Upgraded"
+			return nil
+		})
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		backups, err := filepath.Glob(tasksPath + ".v0-*.bak")
+		if err != nil {
+			t.Fatalf("failed to glob backups: %v", err)
+		}
+		if len(backups) != 1 {
+			t.Fatalf("expected 1 backup, got %d", len(backups))
+		}
+		backup, err := os.ReadFile(backups[0])
+		if err != nil {
+			t.Fatalf("failed to read backup: %v", err)
+		}
+		if string(backup) != legacyTasks {
+			t.Fatalf("expected backup to hold the original file")
+		}
+	})
+
+	t.Run("MigrateSchema reports the backup it wrote", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		tasksPath := filepath.Join(storage.basePath, tasksFile)
+		if err := os.WriteFile(tasksPath, []byte(legacyTasks), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed tasks: %v", err)
+		}
+
+		// act
+		plans, err := storage.MigrateSchema()
+		again, againErr := storage.MigrateSchema()
+
+		// assert
+		if err != nil || len(plans) != 1 || !plans[0].Pending() {
+			t.Fatalf("expected one applied migration, got %+v: %v", plans, err)
+		}
+		backup, err := os.ReadFile(plans[0].Backup)
+		if err != nil || string(backup) != legacyTasks {
+			t.Fatalf("expected the reported backup to hold the original file: %v", err)
+		}
+		if againErr != nil || len(again) != 1 || again[0].Pending() {
+			t.Fatalf("expected the file to be up to date, got %+v: %v", again, againErr)
+		}
+	})
+
+	t.Run("PlanSchemaMigrations reports pending steps without writing", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		tasksPath := filepath.Join(storage.basePath, tasksFile)
+		if err := os.WriteFile(tasksPath, []byte(legacyTasks), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed tasks: %v", err)
+		}
+
+		// act
+		plans, err := storage.PlanSchemaMigrations()
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if len(plans) != 1 || !plans[0].Pending() || len(plans[0].Steps) != currentSchemaVersion {
+			t.Fatalf("unexpected plans: %+v", plans)
+		}
+		data, err := os.ReadFile(tasksPath)
+		if err != nil {
+			t.Fatalf("failed to read tasks: %v", err)
+		}
+		if string(data) != legacyTasks {
+			t.Fatalf("expected tasks.json to be unchanged")
+		}
+	})
+
+	t.Run("LoadTasks rejects a newer schema version", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		tasksPath := filepath.Join(storage.basePath, tasksFile)
+		if err := os.WriteFile(tasksPath, []byte(`{"schema_version":99,"tasks":[]}`), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed tasks: %v", err)
+		}
+
+		// act
+		_, err = storage.LoadTasks()
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "newer than supported") {
+			t.Fatalf("expected newer schema error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,821 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	searchIndexFile    = "search-index.json"
+	searchIndexVersion = 1
+
+	// bm25K1 and bm25B are the usual BM25 term frequency saturation and
+	// document length normalization
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// searchTitleWeight counts a term in a title this many times
+	searchTitleWeight = 2
+
+	snippetLength = 160
+	snippetLead   = 40
+	snippetMark   = "**"
+
+	searchLimitDefault = 20
+)
+
+// searchStopWords are left out of queries so that "notes about the API" does
+// not require every note to contain "the". Phrases keep them.
+var searchStopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "i": true, "in": true, "is": true, "it": true, "my": true,
+	"of": true, "on": true, "or": true, "that": true, "the": true, "this": true, "to": true,
+	"was": true, "what": true, "with": true,
+}
+
+// SearchIndex is an inverted index of the tasks and notes in one data
+// directory. It is kept on disk, sealed like the data files when encryption
+// is on, and only records that changed since it was written are indexed again.
+// Every change to it is made under the storage lock.
+type SearchIndex struct {
+	path   string
+	vault  *Vault
+	logger *slog.Logger
+}
+
+// searchIndexData is the index file. Records are numbered by their slot in
+// Docs, and each term lists the records holding it as the record's number
+// followed by the term's positions in it.
+type searchIndexData struct {
+	Version int                `json:"version"`
+	Docs    []indexedDoc       `json:"docs"`
+	Terms   map[string][][]int `json:"terms"`
+	// Stamps holds the data stamp of each kind the index is known to match,
+	// so a search against unchanged data skips checking every record
+	Stamps map[string]uint64 `json:"stamps,omitempty"`
+
+	// numbers finds the slot of a record by key; free lists empty slots
+	numbers map[string]int
+	free    []int
+}
+
+// indexedDoc describes an indexed record, keyed by kind and ID such as
+// tasks/<id>, or an empty slot when Key is empty. Positions below TitleLength
+// are in the title, and Hash covers the indexed fields so changed records are found.
+type indexedDoc struct {
+	Key         string   `json:"key,omitempty"`
+	Hash        uint64   `json:"hash,omitempty"`
+	Length      int      `json:"length,omitempty"`
+	TitleLength int      `json:"title_length,omitempty"`
+	Terms       []string `json:"terms,omitempty"`
+}
+
+// searchDoc is the searchable part of a task or note
+type searchDoc struct {
+	Key     string
+	Title   string
+	Body    string
+	Tags    []string
+	Created time.Time
+}
+
+// searchHit is a matching record by its position in the searched documents
+type searchHit struct {
+	Index   int
+	Score   float64
+	Snippet string
+}
+
+// Searcher ranks the tasks or notes outside the trash against a query
+type Searcher interface {
+	SearchTasks(query SearchQuery) ([]TaskHit, error)
+	SearchNotes(query SearchQuery) ([]NoteHit, error)
+}
+
+// TaskHit is a task matching a search, best matches first
+type TaskHit struct {
+	Task    Task
+	Score   float64
+	Snippet string
+}
+
+// NoteHit is a note matching a search, best matches first
+type NoteHit struct {
+	Note    Note
+	Score   float64
+	Snippet string
+}
+
+func newSearchIndexAt(dir string, logger *slog.Logger) *SearchIndex {
+	return &SearchIndex{path: filepath.Join(dir, searchIndexFile), vault: newVaultAt(dir), logger: logger}
+}
+
+// SearchTasks ranks the tasks outside the trash against query
+func (r *JournaledRepository) SearchTasks(query SearchQuery) ([]TaskHit, error) {
+	tasks, err := r.LoadTasks()
+	if err != nil {
+		return nil, err
+	}
+	var live []Task
+	var docs []searchDoc
+	for _, t := range tasks.Tasks {
+		if t.DeletedAt == nil {
+			live = append(live, t)
+			docs = append(docs, taskSearchDoc(t))
+		}
+	}
+	var hits []TaskHit
+	for _, hit := range r.index.search(journalKindTasks, tasks.stamp, docs, query) {
+		hits = append(hits, TaskHit{Task: live[hit.Index], Score: hit.Score, Snippet: hit.Snippet})
+	}
+	return hits, nil
+}
+
+// SearchNotes ranks the notes outside the trash against query
+func (r *JournaledRepository) SearchNotes(query SearchQuery) ([]NoteHit, error) {
+	notes, err := r.LoadNotes()
+	if err != nil {
+		return nil, err
+	}
+	var live []Note
+	var docs []searchDoc
+	for _, n := range notes.Notes {
+		if n.DeletedAt == nil {
+			live = append(live, n)
+			docs = append(docs, noteSearchDoc(n))
+		}
+	}
+	var hits []NoteHit
+	for _, hit := range r.index.search(journalKindNotes, notes.stamp, docs, query) {
+		hits = append(hits, NoteHit{Note: live[hit.Index], Score: hit.Score, Snippet: hit.Snippet})
+	}
+	return hits, nil
+}
+
+func taskSearchDoc(t Task) searchDoc {
+	return searchDoc{Key: journalKindTasks + "/" + t.ID, Title: t.Title, Tags: t.Tags, Created: t.CreatedAt}
+}
+
+func noteSearchDoc(n Note) searchDoc {
+	return searchDoc{Key: journalKindNotes + "/" + n.ID, Title: n.Title, Body: n.Content, Tags: n.Tags, Created: n.CreatedAt}
+}
+
+func (d searchDoc) hash() uint64 {
+	h := fnv.New64a()
+	for _, field := range append([]string{d.Title, d.Body}, d.Tags...) {
+		_, _ = h.Write([]byte(field))
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// load reads the index file. A missing, unreadable or outdated index starts
+// out empty and is rebuilt from the records.
+func (idx *SearchIndex) load() *searchIndexData {
+	fresh := &searchIndexData{
+		Version: searchIndexVersion, Terms: map[string][][]int{}, Stamps: map[string]uint64{}, numbers: map[string]int{},
+	}
+	data, err := os.ReadFile(idx.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			idx.logger.Warn("failed to read search index, rebuilding it", "error", err)
+		}
+		return fresh
+	}
+	if data, err = idx.vault.Open(data); err != nil {
+		idx.logger.Warn("failed to decrypt search index, rebuilding it", "error", err)
+		return fresh
+	}
+	var index searchIndexData
+	if err := json.Unmarshal(data, &index); err != nil || index.Version != searchIndexVersion || index.Terms == nil {
+		idx.logger.Warn("search index is outdated or damaged, rebuilding it", "error", err)
+		return fresh
+	}
+	if index.Stamps == nil {
+		index.Stamps = map[string]uint64{}
+	}
+	index.numbers = make(map[string]int, len(index.Docs))
+	for n, doc := range index.Docs {
+		if doc.Key == "" {
+			index.free = append(index.free, n)
+			continue
+		}
+		index.numbers[doc.Key] = n
+	}
+	return &index
+}
+
+func (idx *SearchIndex) save(index *searchIndexData) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to serialize search index: %w", err)
+	}
+	if data, err = idx.vault.Seal(data); err != nil {
+		return fmt.Errorf("failed to encrypt search index: %w", err)
+	}
+	return writeFileAtomic(idx.path, data, dataFilePerm)
+}
+
+// withLock runs fn while holding the storage lock of the index's data directory
+func (idx *SearchIndex) withLock(fn func() error) error {
+	return withFileLock(filepath.Join(filepath.Dir(idx.path), lockFile), fn)
+}
+
+// Update applies a saved change to records of kind as it happens: the after
+// images, or the before images of an undo. before and after are the data
+// stamps around the save; when the index matched the data before, it matches
+// the saved data now. Changes made without the journal, such as a sync, are
+// picked up by the next search instead.
+func (idx *SearchIndex) Update(kind string, changes []RecordChange, useBefore bool, before, after uint64) {
+	if len(changes) == 0 {
+		return
+	}
+	if _, err := os.Stat(idx.path); err != nil {
+		return
+	}
+	err := idx.withLock(func() error {
+		index := idx.load()
+		var docs []searchDoc
+		var keys []string
+		for _, change := range changes {
+			image := change.After
+			if useBefore {
+				image = change.Before
+			}
+			key := kind + "/" + change.ID
+			doc, live, err := searchDocFromImage(kind, image)
+			if err != nil {
+				idx.logger.Warn("failed to index change", "key", key, "error", err)
+				continue
+			}
+			keys = append(keys, key)
+			if live {
+				docs = append(docs, doc)
+			}
+		}
+		index.remove(keys...)
+		for _, doc := range docs {
+			index.add(doc)
+		}
+		if before != 0 && index.Stamps[kind] == before {
+			index.Stamps[kind] = after
+		} else {
+			delete(index.Stamps, kind)
+		}
+		return idx.save(index)
+	})
+	if err != nil {
+		idx.logger.Warn("failed to update search index", "error", err)
+	}
+}
+
+// searchDocFromImage reads a journaled after image. Records that were removed
+// or moved to the trash are not live and leave the index.
+func searchDocFromImage(kind string, image json.RawMessage) (searchDoc, bool, error) {
+	if image == nil {
+		return searchDoc{}, false, nil
+	}
+	switch kind {
+	case journalKindTasks:
+		var t Task
+		if err := json.Unmarshal(image, &t); err != nil {
+			return searchDoc{}, false, err
+		}
+		return taskSearchDoc(t), t.DeletedAt == nil, nil
+	case journalKindNotes:
+		var n Note
+		if err := json.Unmarshal(image, &n); err != nil {
+			return searchDoc{}, false, err
+		}
+		return noteSearchDoc(n), n.DeletedAt == nil, nil
+	default:
+		return searchDoc{}, false, fmt.Errorf("unknown record kind '%s'", kind)
+	}
+}
+
+// sync brings the index in line with docs, the live records of kind read
+// with the given data stamp, and saves it when anything changed. When the
+// index already matches that stamp the records are not checked at all.
+func (idx *SearchIndex) sync(kind string, stamp uint64, docs []searchDoc) *searchIndexData {
+	var index *searchIndexData
+	err := idx.withLock(func() error {
+		index = idx.load()
+		if stamp != 0 && index.Stamps[kind] == stamp {
+			return nil
+		}
+
+		current := make(map[string]bool, len(docs))
+		var stale []string
+		var changed []searchDoc
+		for _, doc := range docs {
+			current[doc.Key] = true
+			n, ok := index.numbers[doc.Key]
+			if ok && index.Docs[n].Hash == doc.hash() {
+				continue
+			}
+			if ok {
+				stale = append(stale, doc.Key)
+			}
+			changed = append(changed, doc)
+		}
+		for key := range index.numbers {
+			if strings.HasPrefix(key, kind+"/") && !current[key] {
+				stale = append(stale, key)
+			}
+		}
+		if stamp != 0 {
+			index.Stamps[kind] = stamp
+		} else if len(stale) == 0 && len(changed) == 0 {
+			return nil
+		}
+
+		index.remove(stale...)
+		for _, doc := range changed {
+			index.add(doc)
+		}
+		return idx.save(index)
+	})
+	if err != nil {
+		idx.logger.Warn("failed to save search index", "error", err)
+	}
+	return index
+}
+
+// remove takes records out of the index, visiting each of their terms once
+func (index *searchIndexData) remove(keys ...string) {
+	removed := map[int]bool{}
+	terms := map[string]bool{}
+	for _, key := range keys {
+		n, ok := index.numbers[key]
+		if !ok {
+			continue
+		}
+		removed[n] = true
+		for _, term := range index.Docs[n].Terms {
+			terms[term] = true
+		}
+		index.Docs[n] = indexedDoc{}
+		index.free = append(index.free, n)
+		delete(index.numbers, key)
+	}
+	for term := range terms {
+		postings := slices.DeleteFunc(index.Terms[term], func(p []int) bool { return removed[p[0]] })
+		if len(postings) == 0 {
+			delete(index.Terms, term)
+			continue
+		}
+		index.Terms[term] = postings
+	}
+}
+
+// add indexes a record that is not in the index yet. The title, tags and body
+// are numbered as one stream with a gap between them, so a phrase never spans
+// two fields.
+func (index *searchIndexData) add(doc searchDoc) {
+	n := len(index.Docs)
+	if len(index.free) > 0 {
+		n, index.free = index.free[len(index.free)-1], index.free[:len(index.free)-1]
+	} else {
+		index.Docs = append(index.Docs, indexedDoc{})
+	}
+
+	postings := map[string][]int{}
+	pos, length, titleLength := 0, 0, 0
+	for field, text := range []string{doc.Title, strings.Join(doc.Tags, " "), doc.Body} {
+		for _, tok := range tokenize(text) {
+			if postings[tok.term] == nil {
+				postings[tok.term] = []int{n}
+			}
+			postings[tok.term] = append(postings[tok.term], pos)
+			pos++
+			length++
+		}
+		if field == 0 {
+			titleLength = pos
+		}
+		pos++
+	}
+
+	terms := make([]string, 0, len(postings))
+	for term, posting := range postings {
+		terms = append(terms, term)
+		index.Terms[term] = append(index.Terms[term], posting)
+	}
+	sort.Strings(terms)
+	index.Docs[n] = indexedDoc{Key: doc.Key, Hash: doc.hash(), Length: length, TitleLength: titleLength, Terms: terms}
+	index.numbers[doc.Key] = n
+}
+
+// positions maps the records of kind holding term to the term's positions in them
+func (index *searchIndexData) positions(kind, term string) map[int][]int {
+	found := map[int][]int{}
+	for _, posting := range index.Terms[term] {
+		if strings.HasPrefix(index.Docs[posting[0]].Key, kind+"/") {
+			found[posting[0]] = posting[1:]
+		}
+	}
+	return found
+}
+
+// search ranks docs, the live records of kind read with the given data stamp,
+// against query with BM25. Every word, prefix and phrase must match, and so
+// must every qualifier.
+func (idx *SearchIndex) search(kind string, stamp uint64, docs []searchDoc, query SearchQuery) []searchHit {
+	index := idx.sync(kind, stamp, docs)
+
+	totalLength := 0
+	for _, doc := range docs {
+		totalLength += index.Docs[index.numbers[doc.Key]].Length
+	}
+	avgLength := 1.0
+	if len(docs) > 0 && totalLength > 0 {
+		avgLength = float64(totalLength) / float64(len(docs))
+	}
+	termPositions := map[string]map[int][]int{}
+	lookup := func(term string) map[int][]int {
+		found, ok := termPositions[term]
+		if !ok {
+			found = index.positions(kind, term)
+			termPositions[term] = found
+		}
+		return found
+	}
+	bm25 := func(term string, n int) float64 {
+		found := lookup(term)
+		at, ok := found[n]
+		if !ok {
+			return 0
+		}
+		doc := index.Docs[n]
+		tf := float64(len(at))
+		for _, p := range at {
+			if p < doc.TitleLength {
+				tf += searchTitleWeight - 1
+			}
+		}
+		df := float64(len(found))
+		idf := math.Log(1 + (float64(len(docs))-df+0.5)/(df+0.5))
+		norm := 1 - bm25B + bm25B*float64(doc.Length)/avgLength
+		return idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+	expansions := make([][]string, len(query.Prefixes))
+	for i, prefix := range query.Prefixes {
+		expansions[i] = index.expand(prefix)
+	}
+	hasPhrase := func(phrase []string, n int) bool {
+		for _, start := range lookup(phrase[0])[n] {
+			found := true
+			for offset, term := range phrase[1:] {
+				if !slices.Contains(lookup(term)[n], start+offset+1) {
+					found = false
+					break
+				}
+			}
+			if found {
+				return true
+			}
+		}
+		return false
+	}
+
+	var hits []searchHit
+	for i, doc := range docs {
+		if !query.matchesQualifiers(doc) {
+			continue
+		}
+		n := index.numbers[doc.Key]
+		score, matched := 0.0, true
+		for _, term := range query.Terms {
+			s := bm25(term, n)
+			score += s
+			matched = matched && s > 0
+		}
+		for _, terms := range expansions {
+			best := 0.0
+			for _, term := range terms {
+				best = math.Max(best, bm25(term, n))
+			}
+			score += best
+			matched = matched && best > 0
+		}
+		for _, phrase := range query.Phrases {
+			if !hasPhrase(phrase, n) {
+				matched = false
+				break
+			}
+			for _, term := range phrase {
+				score += bm25(term, n)
+			}
+		}
+		if matched {
+			hits = append(hits, searchHit{Index: i, Score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return docs[hits[a].Index].Created.After(docs[hits[b].Index].Created)
+	})
+	for i := range hits {
+		hits[i].Snippet = snippet(docs[hits[i].Index], query, expansions)
+	}
+	return hits
+}
+
+// expand lists the indexed terms that start with prefix
+func (index *searchIndexData) expand(prefix string) []string {
+	var terms []string
+	for term := range index.Terms {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// SearchQuery is a parsed search: words, "quoted phrases" and prefix* words
+// that must all match, plus tag:, after: and before: qualifiers
+type SearchQuery struct {
+	Terms    []string
+	Prefixes []string
+	Phrases  [][]string
+	Tags     []string
+	// After and Before bound the creation date, excluding the dates themselves
+	After  *time.Time
+	Before *time.Time
+}
+
+// ParseSearchQuery reads a query such as `deploy* "release notes" tag:infra after:2026-01-01`
+func ParseSearchQuery(text string) (SearchQuery, error) {
+	var q SearchQuery
+	for _, part := range splitSearchQuery(text) {
+		if strings.HasPrefix(part, `"`) {
+			var phrase []string
+			for _, tok := range tokenize(strings.Trim(part, `"`)) {
+				phrase = append(phrase, tok.term)
+			}
+			switch len(phrase) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, phrase[0])
+			default:
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		name, value, found := strings.Cut(part, ":")
+		switch name = strings.ToLower(name); {
+		case found && name == "tag" && value != "":
+			q.Tags = append(q.Tags, value)
+			continue
+		case found && (name == "after" || name == "before"):
+			date, err := time.ParseInLocation(dateLayout, value, time.Local)
+			if err != nil {
+				return SearchQuery{}, fmt.Errorf("invalid date in '%s': use %s:YYYY-MM-DD", part, name)
+			}
+			if name == "after" {
+				q.After = &date
+			} else {
+				q.Before = &date
+			}
+			continue
+		}
+
+		tokens := tokenize(part)
+		prefix := strings.HasSuffix(part, "*") && len(tokens) > 0
+		for i, tok := range tokens {
+			switch {
+			case prefix && i == len(tokens)-1:
+				q.Prefixes = append(q.Prefixes, stem(tok.word))
+			case !searchStopWords[tok.word] || len(tokens) > 1:
+				q.Terms = append(q.Terms, tok.term)
+			}
+		}
+	}
+	if q.isEmpty() {
+		return SearchQuery{}, errors.New("nothing to search for; give words, a \"phrase\", a prefix* or tag:, after: or before:")
+	}
+	return q, nil
+}
+
+func (q SearchQuery) isEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Prefixes) == 0 && len(q.Phrases) == 0 &&
+		len(q.Tags) == 0 && q.After == nil && q.Before == nil
+}
+
+// splitSearchQuery splits on spaces, keeping "quoted phrases" together
+func splitSearchQuery(text string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			if quoted {
+				current.WriteRune(r)
+				parts = append(parts, current.String())
+				current.Reset()
+			} else {
+				if current.Len() > 0 {
+					parts = append(parts, current.String())
+					current.Reset()
+				}
+				current.WriteRune(r)
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func (q SearchQuery) matchesQualifiers(doc searchDoc) bool {
+	for _, tag := range q.Tags {
+		if !hasTag(doc.Tags, tag) {
+			return false
+		}
+	}
+	created := doc.Created.Local()
+	day := time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, time.Local)
+	if q.After != nil && !day.After(*q.After) {
+		return false
+	}
+	return q.Before == nil || day.Before(*q.Before)
+}
+
+// matches reports whether a token counts as a hit for highlighting
+func (q SearchQuery) matches(tok searchToken, expansions [][]string) bool {
+	for _, term := range q.Terms {
+		if tok.term == term {
+			return true
+		}
+	}
+	for _, phrase := range q.Phrases {
+		for _, term := range phrase {
+			if tok.term == term {
+				return true
+			}
+		}
+	}
+	for _, terms := range expansions {
+		for _, term := range terms {
+			if tok.term == term {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// snippet shows the text around the first match in a note's content, or a
+// task's title, with the matching words marked in bold
+func snippet(doc searchDoc, query SearchQuery, expansions [][]string) string {
+	text := doc.Body
+	if text == "" {
+		text = doc.Title
+	}
+	tokens := tokenize(text)
+	first := slices.IndexFunc(tokens, func(tok searchToken) bool { return query.matches(tok, expansions) })
+	if first == -1 {
+		return strings.Join(strings.Fields(notePreview(doc.Body)), " ")
+	}
+
+	start := runeOffsetBefore(text, tokens[first].start, snippetLead)
+	for _, tok := range tokens[:first+1] {
+		if tok.start >= start {
+			start = tok.start
+			break
+		}
+	}
+	end := runeOffsetAfter(text, start, snippetLength)
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	at := start
+	for _, tok := range tokens[first:] {
+		if tok.end > end {
+			break
+		}
+		if query.matches(tok, expansions) {
+			b.WriteString(text[at:tok.start] + snippetMark + text[tok.start:tok.end] + snippetMark)
+			at = tok.end
+		}
+	}
+	b.WriteString(text[at:end])
+	if end < len(text) {
+		b.WriteString("...")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// runeOffsetBefore steps back up to n characters from the byte offset at
+func runeOffsetBefore(text string, at, n int) int {
+	for ; n > 0 && at > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(text[:at])
+		at -= size
+	}
+	return at
+}
+
+// runeOffsetAfter steps forward up to n characters from the byte offset at
+func runeOffsetAfter(text string, at, n int) int {
+	for ; n > 0 && at < len(text); n-- {
+		_, size := utf8.DecodeRuneInString(text[at:])
+		at += size
+	}
+	return at
+}
+
+// searchToken is a word in some text: the word lowercased, its stemmed term,
+// and its byte offsets
+type searchToken struct {
+	word  string
+	term  string
+	start int
+	end   int
+}
+
+// tokenize splits text into words of letters and digits
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			word := strings.ToLower(text[start:end])
+			tokens = append(tokens, searchToken{word: word, term: stem(word), start: start, end: end})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+	return tokens
+}
+
+// stem strips common English endings so that "deploying", "deployed" and
+// "deploys" all find "deploy". It is deliberately light: a few wrong merges
+// are cheaper than missing the note someone is looking for.
+func stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = strings.TrimSuffix(word, "s")
+	}
+	for _, suffix := range []string{"ing", "ed"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			word = undouble(strings.TrimSuffix(word, suffix))
+			break
+		}
+	}
+	if strings.HasSuffix(word, "e") && len(word) > 4 {
+		word = strings.TrimSuffix(word, "e")
+	}
+	return word
+}
+
+// undouble turns "plann" back into "plan" after "planned" loses its ending
+func undouble(word string) string {
+	n := len(word)
+	if n >= 2 && word[n-1] == word[n-2] && strings.IndexByte("bdfgmnprt", word[n-1]) >= 0 {
+		return word[:n-1]
+	}
+	return word
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func searchNoteTitles(t *testing.T, repo *JournaledRepository, text string) []string {
+	t.Helper()
+	query, err := ParseSearchQuery(text)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", text, err)
+	}
+	hits, err := repo.SearchNotes(query)
+	if err != nil {
+		t.Fatalf("failed to search %q: %v", text, err)
+	}
+	titles := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		titles = append(titles, hit.Note.Title)
+	}
+	return titles
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	t.Run("reads words, phrases, prefixes and qualifiers", func(t *testing.T) {
+		// act
+		q, err := ParseSearchQuery(`Deploying the "token refresh" auth* tag:Infra after:2026-01-01 before:2026-02-01`)
+
+		// assert
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(q.Terms, []string{"deploy"}) || !reflect.DeepEqual(q.Prefixes, []string{"auth"}) {
+			t.Fatalf("unexpected terms %v and prefixes %v", q.Terms, q.Prefixes)
+		}
+		if !reflect.DeepEqual(q.Phrases, [][]string{{"token", "refresh"}}) || !reflect.DeepEqual(q.Tags, []string{"Infra"}) {
+			t.Fatalf("unexpected phrases %v and tags %v", q.Phrases, q.Tags)
+		}
+		if q.After == nil || q.After.Format(dateLayout) != "2026-01-01" || q.Before == nil {
+			t.Fatalf("unexpected dates %v and %v", q.After, q.Before)
+		}
+	})
+
+	t.Run("rejects bad dates and empty queries", func(t *testing.T) {
+		cases := map[string]string{
+			"after:01/02/2026": "use after:YYYY-MM-DD",
+			"the":              "nothing to search for",
+			`""`:               "nothing to search for",
+		}
+		for text, want := range cases {
+			// act
+			_, err := ParseSearchQuery(text)
+
+			// assert
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected %q for %q, got %v", want, text, err)
+			}
+		}
+	})
+
+	t.Run("stems common endings", func(t *testing.T) {
+		cases := map[string]string{
+			"deploys": "deploy", "deployed": "deploy", "deploying": "deploy", "planned": "plan",
+			"queries": "query", "classes": "class", "status": "status", "create": "creat", "created": "creat",
+		}
+		for word, want := range cases {
+			// act
+			got := stem(word)
+
+			// assert
+			if got != want {
+				t.Fatalf("expected %q for %q, got %q", want, word, got)
+			}
+		}
+	})
+}
+
+func TestSearchIndex(t *testing.T) {
+	t.Run("ranks title matches first and applies every clause", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		notes := []struct{ title, content string }{
+			{"Standup", "We talked about the deploy of the API and lunch"},
+			{"Deploy checklist", "Steps to deploy the API safely"},
+			{"Lunch", "Tacos"},
+			{"Auth", "Token refresh happens hourly; refresh tokens expire after a week"},
+		}
+		for _, n := range notes {
+			if _, err := repo.AddNote(n.title, n.content, []string{"work"}); err != nil {
+				t.Fatalf("failed to add note: %v", err)
+			}
+		}
+
+		// act
+		ranked := searchNoteTitles(t, repo, "deploying api")
+		phrase := searchNoteTitles(t, repo, `"refresh happens"`)
+		notPhrase := searchNoteTitles(t, repo, `"happens refresh"`)
+		prefix := searchNoteTitles(t, repo, "tac*")
+		tagged := searchNoteTitles(t, repo, "tag:work after:2000-01-01")
+		untagged := searchNoteTitles(t, repo, "deploy tag:home")
+
+		// assert
+		if !reflect.DeepEqual(ranked, []string{"Deploy checklist", "Standup"}) {
+			t.Fatalf("expected the title match first, got %v", ranked)
+		}
+		if !reflect.DeepEqual(phrase, []string{"Auth"}) || len(notPhrase) != 0 {
+			t.Fatalf("expected only the words in order to match, got %v and %v", phrase, notPhrase)
+		}
+		if !reflect.DeepEqual(prefix, []string{"Lunch"}) || len(tagged) != 4 || len(untagged) != 0 {
+			t.Fatalf("unexpected prefix %v, tag %v or missing tag %v matches", prefix, tagged, untagged)
+		}
+	})
+
+	t.Run("highlights matches without splitting characters", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		content := strings.Repeat("日本語 ", 30) + "the déploiement plan " + strings.Repeat("ünïcode ", 40)
+		if _, err := repo.AddNote("Rollout", content, nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		query, err := ParseSearchQuery("déploiement")
+		if err != nil {
+			t.Fatalf("failed to parse query: %v", err)
+		}
+
+		// act
+		hits, err := repo.SearchNotes(query)
+
+		// assert
+		if err != nil || len(hits) != 1 {
+			t.Fatalf("expected one hit, got %v: %v", hits, err)
+		}
+		got := hits[0].Snippet
+		if !strings.HasPrefix(got, "...日本語") || !strings.Contains(got, "the **déploiement** plan") ||
+			!strings.HasSuffix(got, "...") {
+			t.Fatalf("unexpected snippet %q", got)
+		}
+	})
+
+	t.Run("follows journaled changes and changes made behind its back", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("Backlog", "grooming", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		searchNoteTitles(t, repo, "grooming")
+		indexPath := filepath.Join(GetConfigDir(), searchIndexFile)
+		before, err := os.ReadFile(indexPath)
+		if err != nil {
+			t.Fatalf("expected an index after the first search: %v", err)
+		}
+
+		// act
+		if _, err := repo.AddNote("Retro", "grooming went well", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		after, err := os.ReadFile(indexPath)
+		if err != nil {
+			t.Fatalf("failed to read index: %v", err)
+		}
+		err = repo.Repository.ModifyNotes(func(notes *NoteList) error {
+			notes.Notes[0].Content = "planning"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to change notes: %v", err)
+		}
+
+		// assert
+		if bytes.Equal(before, after) || !strings.Contains(string(after), "retro") {
+			t.Fatalf("expected the new note in the index right away")
+		}
+		if got := searchNoteTitles(t, repo, "grooming"); !reflect.DeepEqual(got, []string{"Retro"}) {
+			t.Fatalf("expected the unjournaled change to be picked up, got %v", got)
+		}
+	})
+
+	t.Run("follows undo and redo right away", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("Retro", "grooming went well", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		searchNoteTitles(t, repo, "grooming")
+		indexPath := filepath.Join(GetConfigDir(), searchIndexFile)
+
+		// act
+		if _, err := repo.Undo(1); err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+		undone, undoErr := os.ReadFile(indexPath)
+		if _, err := repo.Redo(); err != nil {
+			t.Fatalf("failed to redo: %v", err)
+		}
+		redone, redoErr := os.ReadFile(indexPath)
+
+		// assert
+		if undoErr != nil || strings.Contains(string(undone), "retro") {
+			t.Fatalf("expected the undone note out of the index: %v", undoErr)
+		}
+		if redoErr != nil || !strings.Contains(string(redone), "retro") {
+			t.Fatalf("expected the redone note back in the index: %v", redoErr)
+		}
+	})
+
+	t.Run("does not check records again while the data is unchanged", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("Retro", "grooming went well", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		searchNoteTitles(t, repo, "grooming")
+		if _, err := repo.AddNote("Backlog", "grooming", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		idx := newSearchIndexAt(GetConfigDir(), newTestLogger())
+		index := idx.load()
+		for n := range index.Docs {
+			index.Docs[n].Hash = 1
+		}
+		if err := idx.save(index); err != nil {
+			t.Fatalf("failed to save index: %v", err)
+		}
+
+		// act
+		found := searchNoteTitles(t, repo, "grooming")
+
+		// assert
+		if len(found) != 2 {
+			t.Fatalf("expected both notes found, got %v", found)
+		}
+		for _, doc := range idx.load().Docs {
+			if doc.Key != "" && doc.Hash != 1 {
+				t.Fatalf("expected %s not to be hashed again", doc.Key)
+			}
+		}
+	})
+
+	t.Run("is encrypted along with the data", func(t *testing.T) {
+		// arrange
+		repo := newTestVaultRepository(t)
+		searchNoteTitles(t, repo, "hunter2")
+		if _, err := EncryptData(newTestLogger(), "correct horse"); err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+		indexPath := filepath.Join(GetConfigDir(), searchIndexFile)
+		if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+			t.Fatalf("expected the plaintext index to be removed, got %v", err)
+		}
+
+		// act
+		found := searchNoteTitles(t, repo, "hunter2")
+		data, err := os.ReadFile(indexPath)
+
+		// assert
+		if err != nil || !isSealed(data) || bytes.Contains(data, []byte("hunter2")) {
+			t.Fatalf("expected a sealed index: %v", err)
+		}
+		if !reflect.DeepEqual(found, []string{"Infra"}) {
+			t.Fatalf("expected the note to be found, got %v", found)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	taskShortIDPrefix = "t"
+	noteShortIDPrefix = "n"
+	// globalShortIDPrefix marks the short ID of a global item inside a
+	// project, such as g:t3, so it is never confused with the project's t3
+	globalShortIDPrefix = "g:"
+)
+
+// ShortID is the task's stable short reference, such as t42
+func (t Task) ShortID() string {
+	return shortID(taskShortIDPrefix, t.Number)
+}
+
+// ShortID is the note's stable short reference, such as n7
+func (n Note) ShortID() string {
+	return shortID(noteShortIDPrefix, n.Number)
+}
+
+// storeShortID is a short ID as shown for an item of store
+func storeShortID(shortID, store string) string {
+	if shortID == "" || store != storeGlobal {
+		return shortID
+	}
+	return globalShortIDPrefix + shortID
+}
+
+func shortID(prefix string, number int) string {
+	if number <= 0 {
+		return ""
+	}
+	return prefix + strconv.Itoa(number)
+}
+
+// numberedRecord points at the number of a task or note being numbered
+type numberedRecord struct {
+	number  *int
+	created time.Time
+	id      string
+}
+
+// olderThan orders records by creation, then ID, so every copy of a synced
+// file agrees on which of two records keeps a shared number
+func (r numberedRecord) olderThan(other numberedRecord) bool {
+	if !r.created.Equal(other.created) {
+		return r.created.Before(other.created)
+	}
+	return r.id < other.id
+}
+
+// numberTasks gives the next free number to every task without one, and to
+// all but the oldest of tasks sharing a number as merged copies can
+func numberTasks(list *TaskList) {
+	records := make([]numberedRecord, len(list.Tasks))
+	for i := range list.Tasks {
+		t := &list.Tasks[i]
+		records[i] = numberedRecord{number: &t.Number, created: t.CreatedAt, id: t.ID}
+	}
+	assignNumbers(&list.LastNumber, records)
+}
+
+// numberNotes is numberTasks for notes
+func numberNotes(list *NoteList) {
+	records := make([]numberedRecord, len(list.Notes))
+	for i := range list.Notes {
+		n := &list.Notes[i]
+		records[i] = numberedRecord{number: &n.Number, created: n.CreatedAt, id: n.ID}
+	}
+	assignNumbers(&list.LastNumber, records)
+}
+
+// assignNumbers numbers records in order after the highest number in use or
+// ever handed out, so a number is never reused once its record is gone
+func assignNumbers(last *int, records []numberedRecord) {
+	owners := make(map[int]numberedRecord, len(records))
+	for _, r := range records {
+		*last = max(*last, *r.number)
+		if *r.number <= 0 {
+			continue
+		}
+		if owner, taken := owners[*r.number]; !taken || r.olderThan(owner) {
+			owners[*r.number] = r
+		}
+	}
+
+	// Copies of one record share its ID and get the same number; fsck removes them
+	assigned := make(map[string]int)
+	for _, r := range records {
+		if owner := owners[*r.number]; *r.number > 0 && (owner.number == r.number || owner.id == r.id) {
+			continue
+		}
+		if number, ok := assigned[r.id]; ok {
+			*r.number = number
+		} else {
+			*last++
+			*r.number = *last
+			assigned[r.id] = *last
+		}
+	}
+}
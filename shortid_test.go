@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func taskShortIDs(t *testing.T, repo Repository) []string {
+	t.Helper()
+	tasks, err := repo.LoadTasks()
+	if err != nil {
+		t.Fatalf("failed to load tasks: %v", err)
+	}
+	ids := make([]string, 0, len(tasks.Tasks))
+	for _, task := range tasks.Tasks {
+		ids = append(ids, task.ShortID())
+	}
+	return ids
+}
+
+func TestShortIDs(t *testing.T) {
+	t.Run("numbers tasks and notes in order and never reuses a number", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"Buy milk", "Walk dog", "Call mom"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		note, err := repo.AddNote("Standup", "", nil)
+		if err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		if _, err := handler.deleteTask(DeleteTaskParams{Query: "t1"}); err != nil {
+			t.Fatalf("failed to delete task: %v", err)
+		}
+		if _, _, err := EmptyTrash(repo, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("failed to empty trash: %v", err)
+		}
+
+		// act
+		added, err := repo.AddTask("Water plants", nil, "", nil)
+		listed, listErr := handler.listTasks(ListTasksParams{Filter: "all"})
+		completed, completeErr := handler.completeTask(CompleteTaskParams{Query: "t3"})
+
+		// assert
+		if err != nil || added.ShortID() != "t4" || note.ShortID() != "n1" {
+			t.Fatalf("expected t4 and n1, got %q and %q: %v", added.ShortID(), note.ShortID(), err)
+		}
+		if got := taskShortIDs(t, repo); !reflect.DeepEqual(got, []string{"t2", "t3", "t4"}) {
+			t.Fatalf("expected the purged t1 to stay unused, got %v", got)
+		}
+		if listErr != nil || listed.Tasks[0].ShortID != "t2" || listed.Tasks[2].ShortID != "t4" {
+			t.Fatalf("expected the listing to show short IDs, got %+v: %v", listed.Tasks, listErr)
+		}
+		if completeErr != nil || completed.Message != "Task 'Call mom' marked as completed" {
+			t.Fatalf("expected t3 to pick Call mom, got %+v: %v", completed, completeErr)
+		}
+	})
+
+	t.Run("renumbers the younger of two records sharing a number", func(t *testing.T) {
+		// arrange
+		older := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		list := TaskList{LastNumber: 2, Tasks: []Task{
+			{ID: "b", Number: 2, CreatedAt: older.Add(time.Hour)},
+			{ID: "a", Number: 2, CreatedAt: older},
+			{ID: "c", Number: 1, CreatedAt: older},
+			{ID: "c", Number: 1, CreatedAt: older},
+			{ID: "d"},
+		}}
+
+		// act
+		numberTasks(&list)
+
+		// assert
+		var got []int
+		for _, task := range list.Tasks {
+			got = append(got, task.Number)
+		}
+		if !reflect.DeepEqual(got, []int{3, 2, 1, 1, 4}) || list.LastNumber != 4 {
+			t.Fatalf("expected [3 2 1 1 4] up to 4, got %v up to %d", got, list.LastNumber)
+		}
+	})
+
+	t.Run("merging files that each added a task keeps both short IDs distinct", func(t *testing.T) {
+		// arrange
+		base := []byte(`{"schema_version": 4, "last_number": 1, "tasks": [
+			{"id": "a", "number": 1, "title": "A", "created_at": "2026-01-01T00:00:00Z"}]}`)
+		ours := []byte(`{"schema_version": 4, "last_number": 2, "tasks": [
+			{"id": "a", "number": 1, "title": "A", "created_at": "2026-01-01T00:00:00Z"},
+			{"id": "b", "number": 2, "title": "B", "created_at": "2026-01-02T00:00:00Z"}]}`)
+		theirs := []byte(`{"schema_version": 4, "last_number": 2, "tasks": [
+			{"id": "a", "number": 1, "title": "A", "created_at": "2026-01-01T00:00:00Z"},
+			{"id": "c", "number": 2, "title": "C", "created_at": "2026-01-03T00:00:00Z"}]}`)
+
+		// act
+		data, err := mergeTaskFiles(base, ours, theirs)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		var tasks TaskList
+		if err := decodeMergeInput(data, &tasks); err != nil {
+			t.Fatalf("failed to parse merged tasks: %v", err)
+		}
+		numbers := map[string]int{}
+		for _, task := range tasks.Tasks {
+			numbers[task.ID] = task.Number
+		}
+		if !reflect.DeepEqual(numbers, map[string]int{"a": 1, "b": 2, "c": 3}) || tasks.LastNumber != 3 {
+			t.Fatalf("expected the later task renumbered to 3, got %v up to %d", numbers, tasks.LastNumber)
+		}
+	})
+
+	t.Run("upgrading a version 3 file numbers records in file order", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		v3Notes := `{"schema_version": 3, "notes": [
+			{"id": "x", "title": "First", "content": "", "tags": [], "created_at": "2026-01-01T00:00:00Z"},
+			{"id": "y", "title": "Second", "content": "", "tags": [], "created_at": "2026-01-02T00:00:00Z"}]}`
+		if err := os.WriteFile(filepath.Join(storage.basePath, notesFile), []byte(v3Notes), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed notes: %v", err)
+		}
+
+		// act
+		notes, err := storage.LoadNotes()
+		added, addErr := storage.AddNote("Third", "", nil)
+
+		// assert
+		if err != nil || notes.Notes[0].ShortID() != "n1" || notes.Notes[1].ShortID() != "n2" || notes.LastNumber != 2 {
+			t.Fatalf("expected n1 and n2, got %+v: %v", notes, err)
+		}
+		if addErr != nil || added.ShortID() != "n3" {
+			t.Fatalf("expected the next note to be n3, got %+v: %v", added, addErr)
+		}
+	})
+}
+
+func TestSQLiteShortIDs(t *testing.T) {
+	t.Run("upgrades a version 2 database and keeps counting after deletes", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		if err := os.MkdirAll(GetConfigDir(), configDirPerm); err != nil {
+			t.Fatalf("failed to create config dir: %v", err)
+		}
+		db, err := sql.Open(sqliteDriver, filepath.Join(GetConfigDir(), sqliteFile))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		v2Schema := `CREATE TABLE tasks (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL, completed INTEGER NOT NULL DEFAULT 0, due_date TEXT, priority TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '[]', created_at TEXT NOT NULL, updated_at TEXT NOT NULL, deleted_at TEXT);
+		CREATE TABLE notes (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL UNIQUE, title TEXT NOT NULL,
+			content TEXT NOT NULL, tags TEXT NOT NULL DEFAULT '[]', created_at TEXT NOT NULL, updated_at TEXT NOT NULL,
+			deleted_at TEXT);
+		INSERT INTO tasks (id, title, priority, created_at, updated_at) VALUES
+			('a', 'First', 'low', '2026-01-01T00:00:00Z', '2026-01-01T00:00:00Z'),
+			('b', 'Second', 'low', '2026-01-02T00:00:00Z', '2026-01-02T00:00:00Z');
+		PRAGMA user_version = 2;`
+		if _, err := db.Exec(v2Schema); err != nil {
+			t.Fatalf("failed to create v2 schema: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %v", err)
+		}
+		storage, err := NewSQLiteStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer storage.Close()
+
+		// act
+		upgraded := taskShortIDs(t, storage)
+		err = storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = tasks.Tasks[:1]
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to delete task: %v", err)
+		}
+		added, addErr := storage.AddTask("Third", nil, "", nil)
+
+		// assert
+		if !reflect.DeepEqual(upgraded, []string{"t1", "t2"}) {
+			t.Fatalf("expected existing rows numbered t1 and t2, got %v", upgraded)
+		}
+		if addErr != nil || added.ShortID() != "t3" {
+			t.Fatalf("expected the deleted t2 to stay unused, got %+v: %v", added, addErr)
+		}
+		if got := taskShortIDs(t, storage); !reflect.DeepEqual(got, []string{"t1", "t3"}) {
+			t.Fatalf("expected t1 and t3, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteFile        = "kiki.db"
+	sqliteDriver      = "sqlite"
+	sqliteBusyTimeout = 5 * time.Second
+	sqliteTimeLayout  = time.RFC3339Nano
+	sqliteTasksTable  = "tasks"
+	sqliteNotesTable  = "notes"
+	// sqliteSchemaVersion is stored in PRAGMA user_version
+	sqliteSchemaVersion = 3
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS tasks (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	id         TEXT NOT NULL UNIQUE,
+	number     INTEGER NOT NULL DEFAULT 0,
+	title      TEXT NOT NULL,
+	completed  INTEGER NOT NULL DEFAULT 0,
+	due_date   TEXT,
+	priority   TEXT NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '[]',
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	deleted_at TEXT
+);
+CREATE TABLE IF NOT EXISTS notes (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	id         TEXT NOT NULL UNIQUE,
+	number     INTEGER NOT NULL DEFAULT 0,
+	title      TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '[]',
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	deleted_at TEXT
+);
+CREATE TABLE IF NOT EXISTS counters (
+	name  TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);`
+
+// sqliteMigrations upgrade an existing database from the keyed version to the next
+var sqliteMigrations = map[int][]string{
+	1: {
+		`ALTER TABLE tasks ADD COLUMN deleted_at TEXT`,
+		`ALTER TABLE notes ADD COLUMN deleted_at TEXT`,
+	},
+	2: {
+		`ALTER TABLE tasks ADD COLUMN number INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE notes ADD COLUMN number INTEGER NOT NULL DEFAULT 0`,
+		`UPDATE tasks SET number = (SELECT COUNT(*) FROM tasks AS earlier WHERE earlier.seq <= tasks.seq)`,
+		`UPDATE notes SET number = (SELECT COUNT(*) FROM notes AS earlier WHERE earlier.seq <= notes.seq)`,
+		`CREATE TABLE IF NOT EXISTS counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`,
+		`INSERT INTO counters (name, value) SELECT 'tasks', COUNT(*) FROM tasks`,
+		`INSERT INTO counters (name, value) SELECT 'notes', COUNT(*) FROM notes`,
+	},
+}
+
+// SQLiteStorage stores tasks and notes in a single SQLite database.
+// Rows are kept in insertion order so listings match the JSON backend.
+type SQLiteStorage struct {
+	db     *sql.DB
+	path   string
+	logger *slog.Logger
+}
+
+// NewSQLiteStorage opens (creating if needed) the kiki SQLite database
+func NewSQLiteStorage(logger *slog.Logger) (*SQLiteStorage, error) {
+	return newSQLiteStorageAt(GetConfigDir(), logger)
+}
+
+func newSQLiteStorageAt(basePath string, logger *slog.Logger) (*SQLiteStorage, error) {
+	if err := os.MkdirAll(basePath, configDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create kiki directory: %w", err)
+	}
+
+	path := filepath.Join(basePath, sqliteFile)
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(%d)",
+		filepath.ToSlash(path), sqliteBusyTimeout.Milliseconds())
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := ensureSQLiteSchema(db); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	return &SQLiteStorage{db: db, path: path, logger: logger}, nil
+}
+
+// ensureSQLiteSchema creates the tables or upgrades an older database and
+// records the schema version, refusing databases written by a newer kiki
+func ensureSQLiteSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > sqliteSchemaVersion {
+		return fmt.Errorf("%s has schema version %d, newer than supported version %d; upgrade kiki",
+			sqliteFile, version, sqliteSchemaVersion)
+	}
+
+	// A fresh database reports version 0 and gets the latest schema directly
+	if version > 0 {
+		for v := version; v < sqliteSchemaVersion; v++ {
+			for _, stmt := range sqliteMigrations[v] {
+				if _, err := db.Exec(stmt); err != nil {
+					return fmt.Errorf("failed to migrate schema from version %d: %w", v, err)
+				}
+			}
+		}
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, sqliteSchemaVersion)); err != nil {
+		return fmt.Errorf("failed to write schema version: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// LoadTasks reads all tasks in insertion order
+func (s *SQLiteStorage) LoadTasks() (*TaskList, error) {
+	return loadSQLiteTasks(s.db)
+}
+
+// SaveTasks replaces the stored tasks with the given list
+func (s *SQLiteStorage) SaveTasks(tasks *TaskList) error {
+	return s.ModifyTasks(func(current *TaskList) error {
+		current.Tasks = tasks.Tasks
+		current.LastNumber = max(current.LastNumber, tasks.LastNumber)
+		return nil
+	})
+}
+
+// ModifyTasks loads tasks, applies fn and writes back only the rows that
+// changed, all inside one write transaction. Nothing is saved when fn returns an error.
+func (s *SQLiteStorage) ModifyTasks(fn func(*TaskList) error) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		tasks, err := loadSQLiteTasks(tx)
+		if err != nil {
+			return err
+		}
+		before := make(map[string][]byte, len(tasks.Tasks))
+		for _, t := range tasks.Tasks {
+			if before[t.ID], err = json.Marshal(t); err != nil {
+				return fmt.Errorf("failed to serialize task: %w", err)
+			}
+		}
+
+		last := tasks.LastNumber
+		if err := fn(tasks); err != nil {
+			return err
+		}
+		numberTasks(tasks)
+		if tasks.LastNumber != last {
+			if err := saveSQLiteLastNumber(tx, sqliteTasksTable, tasks.LastNumber); err != nil {
+				return err
+			}
+		}
+
+		seen := make(map[string]bool, len(tasks.Tasks))
+		for _, t := range tasks.Tasks {
+			seen[t.ID] = true
+			old, exists := before[t.ID]
+			if exists && sameJSON(old, t) {
+				continue
+			}
+			if err := upsertSQLiteTask(tx, t, exists); err != nil {
+				return err
+			}
+		}
+		for id := range before {
+			if !seen[id] {
+				if _, err := tx.Exec(`DELETE FROM tasks WHERE id = ?`, id); err != nil {
+					return fmt.Errorf("failed to delete task: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AddTask inserts a new task
+func (s *SQLiteStorage) AddTask(title string, dueDate *string, priority string, tags []string) (*Task, error) {
+	task := newTask(title, dueDate, priority, tags)
+	err := s.inTx(func(tx *sql.Tx) error {
+		last, err := sqliteLastNumber(tx, sqliteTasksTable)
+		if err != nil {
+			return err
+		}
+		task.Number = last + 1
+		if err := saveSQLiteLastNumber(tx, sqliteTasksTable, task.Number); err != nil {
+			return err
+		}
+		return upsertSQLiteTask(tx, task, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// LoadNotes reads all notes in insertion order
+func (s *SQLiteStorage) LoadNotes() (*NoteList, error) {
+	return loadSQLiteNotes(s.db)
+}
+
+// SaveNotes replaces the stored notes with the given list
+func (s *SQLiteStorage) SaveNotes(notes *NoteList) error {
+	return s.ModifyNotes(func(current *NoteList) error {
+		current.Notes = notes.Notes
+		current.LastNumber = max(current.LastNumber, notes.LastNumber)
+		return nil
+	})
+}
+
+// ModifyNotes loads notes, applies fn and writes back only the rows that
+// changed, all inside one write transaction. Nothing is saved when fn returns an error.
+func (s *SQLiteStorage) ModifyNotes(fn func(*NoteList) error) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		notes, err := loadSQLiteNotes(tx)
+		if err != nil {
+			return err
+		}
+		before := make(map[string][]byte, len(notes.Notes))
+		for _, n := range notes.Notes {
+			if before[n.ID], err = json.Marshal(n); err != nil {
+				return fmt.Errorf("failed to serialize note: %w", err)
+			}
+		}
+
+		last := notes.LastNumber
+		if err := fn(notes); err != nil {
+			return err
+		}
+		numberNotes(notes)
+		if notes.LastNumber != last {
+			if err := saveSQLiteLastNumber(tx, sqliteNotesTable, notes.LastNumber); err != nil {
+				return err
+			}
+		}
+
+		seen := make(map[string]bool, len(notes.Notes))
+		for _, n := range notes.Notes {
+			seen[n.ID] = true
+			old, exists := before[n.ID]
+			if exists && sameJSON(old, n) {
+				continue
+			}
+			if err := upsertSQLiteNote(tx, n, exists); err != nil {
+				return err
+			}
+		}
+		for id := range before {
+			if !seen[id] {
+				if _, err := tx.Exec(`DELETE FROM notes WHERE id = ?`, id); err != nil {
+					return fmt.Errorf("failed to delete note: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AddNote inserts a new note
+func (s *SQLiteStorage) AddNote(title, content string, tags []string) (*Note, error) {
+	note := newNote(title, content, tags)
+	err := s.inTx(func(tx *sql.Tx) error {
+		last, err := sqliteLastNumber(tx, sqliteNotesTable)
+		if err != nil {
+			return err
+		}
+		note.Number = last + 1
+		if err := saveSQLiteLastNumber(tx, sqliteNotesTable, note.Number); err != nil {
+			return err
+		}
+		return upsertSQLiteNote(tx, note, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// UpdateTask changes the fields of a task outside the trash
+func (s *SQLiteStorage) UpdateTask(id string, changes TaskChanges) (*Task, *Task, error) {
+	return updateTask(s, id, changes)
+}
+
+// UpdateNote changes the fields of a note outside the trash
+func (s *SQLiteStorage) UpdateNote(id string, changes NoteChanges) (*Note, *Note, error) {
+	return updateNote(s, id, changes)
+}
+
+// inTx runs fn in a write transaction, committing only if fn succeeds
+func (s *SQLiteStorage) inTx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			s.logger.Error("failed to roll back transaction", "error", rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// sqliteLastNumber reads the highest number handed out to rows of table
+func sqliteLastNumber(q sqlQueryer, table string) (int, error) {
+	var last int
+	err := q.QueryRow(`SELECT value FROM counters WHERE name = ?`, table).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s counter: %w", table, err)
+	}
+	return last, nil
+}
+
+func saveSQLiteLastNumber(tx *sql.Tx, table string, last int) error {
+	_, err := tx.Exec(`INSERT INTO counters (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value`, table, last)
+	if err != nil {
+		return fmt.Errorf("failed to write %s counter: %w", table, err)
+	}
+	return nil
+}
+
+func loadSQLiteTasks(q sqlQueryer) (*TaskList, error) {
+	last, err := sqliteLastNumber(q, sqliteTasksTable)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`SELECT id, number, title, completed, due_date, priority, tags, created_at, updated_at,
+		deleted_at FROM tasks ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := &TaskList{SchemaVersion: currentSchemaVersion, LastNumber: last, Tasks: []Task{}}
+	for rows.Next() {
+		var (
+			t                    Task
+			dueDate, deletedAt   sql.NullString
+			tags                 string
+			createdAt, updatedAt string
+		)
+		if err := rows.Scan(&t.ID, &t.Number, &t.Title, &t.Completed, &dueDate, &t.Priority, &tags, &createdAt, &updatedAt, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse tasks: %w", err)
+		}
+		if dueDate.Valid {
+			t.DueDate = &dueDate.String
+		}
+		if err := scanSQLiteMeta(tags, createdAt, updatedAt, &t.Tags, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse task %s: %w", t.ID, err)
+		}
+		if t.DeletedAt, err = parseSQLiteNullTime(deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse task %s: %w", t.ID, err)
+		}
+		tasks.Tasks = append(tasks.Tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+func loadSQLiteNotes(q sqlQueryer) (*NoteList, error) {
+	last, err := sqliteLastNumber(q, sqliteNotesTable)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`SELECT id, number, title, content, tags, created_at, updated_at, deleted_at
+		FROM notes ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	defer rows.Close()
+
+	notes := &NoteList{SchemaVersion: currentSchemaVersion, LastNumber: last, Notes: []Note{}}
+	for rows.Next() {
+		var (
+			n                    Note
+			tags                 string
+			createdAt, updatedAt string
+			deletedAt            sql.NullString
+		)
+		if err := rows.Scan(&n.ID, &n.Number, &n.Title, &n.Content, &tags, &createdAt, &updatedAt, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse notes: %w", err)
+		}
+		if err := scanSQLiteMeta(tags, createdAt, updatedAt, &n.Tags, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse note %s: %w", n.ID, err)
+		}
+		if n.DeletedAt, err = parseSQLiteNullTime(deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse note %s: %w", n.ID, err)
+		}
+		notes.Notes = append(notes.Notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	return notes, nil
+}
+
+func upsertSQLiteTask(tx *sql.Tx, t Task, exists bool) error {
+	tags, err := json.Marshal(nonNilTags(t.Tags))
+	if err != nil {
+		return fmt.Errorf("failed to serialize task tags: %w", err)
+	}
+	created := t.CreatedAt.Format(sqliteTimeLayout)
+	updated := t.UpdatedAt.Format(sqliteTimeLayout)
+	deleted := formatSQLiteNullTime(t.DeletedAt)
+
+	if exists {
+		_, err = tx.Exec(`UPDATE tasks SET number = ?, title = ?, completed = ?, due_date = ?, priority = ?,
+			tags = ?, created_at = ?, updated_at = ?, deleted_at = ? WHERE id = ?`,
+			t.Number, t.Title, t.Completed, t.DueDate, t.Priority, string(tags), created, updated, deleted, t.ID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO tasks (id, number, title, completed, due_date, priority, tags, created_at,
+			updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.ID, t.Number, t.Title, t.Completed, t.DueDate, t.Priority, string(tags), created, updated, deleted)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write task: %w", err)
+	}
+	return nil
+}
+
+func upsertSQLiteNote(tx *sql.Tx, n Note, exists bool) error {
+	tags, err := json.Marshal(nonNilTags(n.Tags))
+	if err != nil {
+		return fmt.Errorf("failed to serialize note tags: %w", err)
+	}
+	created := n.CreatedAt.Format(sqliteTimeLayout)
+	updated := n.UpdatedAt.Format(sqliteTimeLayout)
+	deleted := formatSQLiteNullTime(n.DeletedAt)
+
+	if exists {
+		_, err = tx.Exec(`UPDATE notes SET number = ?, title = ?, content = ?, tags = ?, created_at = ?,
+			updated_at = ?, deleted_at = ? WHERE id = ?`,
+			n.Number, n.Title, n.Content, string(tags), created, updated, deleted, n.ID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO notes (id, number, title, content, tags, created_at, updated_at, deleted_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			n.ID, n.Number, n.Title, n.Content, string(tags), created, updated, deleted)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write note: %w", err)
+	}
+	return nil
+}
+
+func scanSQLiteMeta(tags, createdAt, updatedAt string, outTags *[]string, outCreated, outUpdated *time.Time) error {
+	if err := json.Unmarshal([]byte(tags), outTags); err != nil {
+		return fmt.Errorf("invalid tags: %w", err)
+	}
+	created, err := time.Parse(sqliteTimeLayout, createdAt)
+	if err != nil {
+		return fmt.Errorf("invalid created_at: %w", err)
+	}
+	updated, err := time.Parse(sqliteTimeLayout, updatedAt)
+	if err != nil {
+		return fmt.Errorf("invalid updated_at: %w", err)
+	}
+	*outCreated, *outUpdated = created, updated
+	return nil
+}
+
+func formatSQLiteNullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(sqliteTimeLayout)
+}
+
+func parseSQLiteNullTime(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(sqliteTimeLayout, value.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	return &t, nil
+}
+
+// sameJSON reports whether v serializes to data
+func sameJSON(data []byte, v any) bool {
+	current, err := json.Marshal(v)
+	return err == nil && bytes.Equal(data, current)
+}
+
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	storage, err := NewSQLiteStorage(newTestLogger())
+	if err != nil {
+		t.Fatalf("failed to create sqlite storage: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := storage.Close(); err != nil {
+			t.Errorf("failed to close sqlite storage: %v", err)
+		}
+	})
+	return storage
+}
+
+func TestSQLiteStorageTasks(t *testing.T) {
+	t.Run("AddTask persists task in insertion order", func(t *testing.T) {
+		// arrange
+		storage := newTestSQLiteStorage(t)
+		dueDate := "2032-03-04"
+
+		// act
+		first, err := storage.AddTask("First", &dueDate, "", []string{"a"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := storage.AddTask("Second", nil, "high", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		tasks, err := storage.LoadTasks()
+
+		// assert
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 2 {
+			t.Fatalf("expected 2 tasks, got %d", len(tasks.Tasks))
+		}
+		got := tasks.Tasks[0]
+		if got.ID != first.ID || got.Title != "First" || got.Priority != "medium" {
+			t.Fatalf("unexpected first task: %+v", got)
+		}
+		if got.DueDate == nil || *got.DueDate != dueDate {
+			t.Fatalf("expected due date %q, got %v", dueDate, got.DueDate)
+		}
+		if len(got.Tags) != 1 || got.Tags[0] != "a" {
+			t.Fatalf("expected tags [a], got %v", got.Tags)
+		}
+		if !got.CreatedAt.Equal(first.CreatedAt) {
+			t.Fatalf("expected CreatedAt %v, got %v", first.CreatedAt, got.CreatedAt)
+		}
+		if tasks.Tasks[1].DueDate != nil || tasks.Tasks[1].Tags == nil {
+			t.Fatalf("expected nil due date and empty tags, got %+v", tasks.Tasks[1])
+		}
+	})
+
+	t.Run("ModifyTasks updates and deletes rows", func(t *testing.T) {
+		// arrange
+		storage := newTestSQLiteStorage(t)
+		for _, title := range []string{"Keep", "Complete", "Delete"} {
+			if _, err := storage.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+
+		// act
+		err := storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[1].Completed = true
+			tasks.Tasks = tasks.Tasks[:2]
+			return nil
+		})
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 2 {
+			t.Fatalf("expected 2 tasks, got %d", len(tasks.Tasks))
+		}
+		if tasks.Tasks[0].Completed || !tasks.Tasks[1].Completed {
+			t.Fatalf("expected only second task completed, got %+v", tasks.Tasks)
+		}
+	})
+
+	t.Run("ModifyTasks rolls back when fn fails", func(t *testing.T) {
+		// arrange
+		storage := newTestSQLiteStorage(t)
+		if _, err := storage.AddTask("Keep me", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		wantErr := errors.New("boom")
+
+		// act
+		err := storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = nil
+			return wantErr
+		})
+
+		// assert
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("expected %v, got %v", wantErr, err)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 1 {
+			t.Fatalf("expected 1 task, got %d", len(tasks.Tasks))
+		}
+	})
+	t.Run("UpdateTask skips trashed tasks", func(t *testing.T) {
+		// arrange
+		storage := newTestSQLiteStorage(t)
+		task, err := storage.AddTask("Old", nil, "low", []string{"a"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		title := "New"
+
+		// act
+		before, after, err := storage.UpdateTask(task.ID, TaskChanges{Title: &title, ClearTags: true})
+		if err := storage.ModifyTasks(func(tasks *TaskList) error {
+			now := time.Now()
+			tasks.Tasks[0].DeletedAt = &now
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to trash task: %v", err)
+		}
+		_, _, trashedErr := storage.UpdateTask(task.ID, TaskChanges{Title: &title})
+
+		// assert
+		if err != nil || before.Title != "Old" || after.Title != "New" || len(before.Tags) != 1 || len(after.Tags) != 0 {
+			t.Fatalf("unexpected update %+v -> %+v: %v", before, after, err)
+		}
+		if !errors.Is(trashedErr, errNotFound) {
+			t.Fatalf("expected %v for a trashed task, got %v", errNotFound, trashedErr)
+		}
+	})
+}
+
+func TestSQLiteStorageSchema(t *testing.T) {
+	t.Run("upgrades a version 1 database and stores deleted_at", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		if err := os.MkdirAll(GetConfigDir(), configDirPerm); err != nil {
+			t.Fatalf("failed to create config dir: %v", err)
+		}
+		db, err := sql.Open(sqliteDriver, filepath.Join(GetConfigDir(), sqliteFile))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		v1Schema := `CREATE TABLE tasks (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL, completed INTEGER NOT NULL DEFAULT 0, due_date TEXT, priority TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '[]', created_at TEXT NOT NULL, updated_at TEXT NOT NULL);
+		CREATE TABLE notes (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL UNIQUE, title TEXT NOT NULL,
+			content TEXT NOT NULL, tags TEXT NOT NULL DEFAULT '[]', created_at TEXT NOT NULL, updated_at TEXT NOT NULL);
+		PRAGMA user_version = 1;`
+		if _, err := db.Exec(v1Schema); err != nil {
+			t.Fatalf("failed to create v1 schema: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %v", err)
+		}
+
+		// act
+		storage, err := NewSQLiteStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		defer storage.Close()
+		if _, err := storage.AddTask("Trash me", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		deletedAt := time.Now()
+		err = storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks[0].DeletedAt = &deletedAt
+			return nil
+		})
+
+		// assert
+		if err != nil {
+			t.Fatalf("failed to trash task: %v", err)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if tasks.Tasks[0].DeletedAt == nil || !tasks.Tasks[0].DeletedAt.Equal(deletedAt) {
+			t.Fatalf("expected deleted_at %v, got %v", deletedAt, tasks.Tasks[0].DeletedAt)
+		}
+	})
+}
+
+func TestSQLiteStorageNotes(t *testing.T) {
+	t.Run("AddNote and ModifyNotes round trip", func(t *testing.T) {
+		// arrange
+		storage := newTestSQLiteStorage(t)
+		if _, err := storage.AddNote("API", "Uses OAuth", []string{"infra"}); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+
+		// act
+		err := storage.ModifyNotes(func(notes *NoteList) error {
+			notes.Notes[0].Content = "Uses OAuth 2.0"
+			return nil
+		})
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		notes, err := storage.LoadNotes()
+		if err != nil {
+			t.Fatalf("failed to load notes: %v", err)
+		}
+		if len(notes.Notes) != 1 || notes.Notes[0].Content != "Uses OAuth 2.0" {
+			t.Fatalf("unexpected notes: %+v", notes.Notes)
+		}
+	})
+}
+
+func TestMigrateToSQLite(t *testing.T) {
+	t.Run("copies JSON data and switches the active backend", func(t *testing.T) {
+		// arrange
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+		source, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		if _, err := source.AddTask("Task", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := source.AddNote("Note", "Content", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+
+		// act
+		result, err := MigrateToSQLite(newTestLogger(), false)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if result.Tasks != 1 || result.Notes != 1 {
+			t.Fatalf("expected 1 task and 1 note, got %+v", result)
+		}
+		if got := activeBackend(); got != backendSQLite {
+			t.Fatalf("expected active backend %q, got %q", backendSQLite, got)
+		}
+		repo, err := NewRepository(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to open repository: %v", err)
+		}
+		defer repo.Close()
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 1 || tasks.Tasks[0].Title != "Task" {
+			t.Fatalf("unexpected tasks: %+v", tasks.Tasks)
+		}
+		if _, err := MigrateToSQLite(newTestLogger(), false); err == nil {
+			t.Fatalf("expected error migrating twice")
+		}
+	})
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -15,8 +17,9 @@ const (
 	kikiDir       = "kiki"
 	tasksFile     = "tasks.json"
 	notesFile     = "notes.json"
+	lockFile      = "kiki.lock"
 	configDirPerm = 0o755
-	dataFilePerm  = 0o644
+	dataFilePerm  = 0o600
 	dateLayout    = "2006-01-02"
 )
 
@@ -24,33 +27,50 @@ const (
 type Storage struct {
 	basePath string
 	logger   *slog.Logger
+	vault    *Vault
 }
 
-// GetConfigDir returns the kiki config directory path using XDG_CONFIG_HOME
+// GetConfigDir returns the active data directory: the project store found
+// from the working directory, or else the data directory of the active profile
 func GetConfigDir() string {
-	configHome := os.Getenv("XDG_CONFIG_HOME")
-	if configHome == "" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			configHome = "."
-		} else {
-			configHome = filepath.Join(homeDir, ".config")
-		}
+	if dir := ProjectDir(); dir != "" {
+		return dir
 	}
-	return filepath.Join(configHome, kikiDir)
+	return GetGlobalConfigDir()
 }
 
 // NewStorage creates a new Storage instance and ensures the directory exists
 func NewStorage(logger *slog.Logger) (*Storage, error) {
-	basePath := GetConfigDir()
+	return newStorageAt(GetConfigDir(), logger)
+}
+
+func newStorageAt(basePath string, logger *slog.Logger) (*Storage, error) {
 	if err := os.MkdirAll(basePath, configDirPerm); err != nil {
 		return nil, fmt.Errorf("failed to create kiki directory: %w", err)
 	}
-	return &Storage{basePath: basePath, logger: logger}, nil
+	return &Storage{basePath: basePath, logger: logger, vault: newVaultAt(basePath)}, nil
+}
+
+// InitOptions configures optional features set up by InitStorage
+type InitOptions struct {
+	// Git versions the data directory in a git repository, committing every change
+	Git bool
+	// Remote is the git remote used by 'kiki sync'; it implies Git
+	Remote string
+	// Project creates a .kiki project store in the working directory
+	Project bool
 }
 
 // InitStorage creates all required directories and files
-func InitStorage() error {
+func InitStorage(opts InitOptions) error {
+	if opts.Project {
+		if globalFlag {
+			return errors.New("a project store cannot be created with --global")
+		}
+		if err := createProjectDir(); err != nil {
+			return err
+		}
+	}
 	basePath := GetConfigDir()
 
 	if err := os.MkdirAll(basePath, configDirPerm); err != nil {
@@ -59,7 +79,7 @@ func InitStorage() error {
 
 	tasksPath := filepath.Join(basePath, tasksFile)
 	if _, err := os.Stat(tasksPath); os.IsNotExist(err) {
-		emptyTasks := &TaskList{Tasks: []Task{}}
+		emptyTasks := &TaskList{SchemaVersion: currentSchemaVersion, Tasks: []Task{}}
 		data, err := json.MarshalIndent(emptyTasks, "", "  ")
 		if err != nil {
 			return fmt.Errorf("failed to serialize tasks.json: %w", err)
@@ -71,7 +91,7 @@ func InitStorage() error {
 
 	notesPath := filepath.Join(basePath, notesFile)
 	if _, err := os.Stat(notesPath); os.IsNotExist(err) {
-		emptyNotes := &NoteList{Notes: []Note{}}
+		emptyNotes := &NoteList{SchemaVersion: currentSchemaVersion, Notes: []Note{}}
 		data, err := json.MarshalIndent(emptyNotes, "", "  ")
 		if err != nil {
 			return fmt.Errorf("failed to serialize notes.json: %w", err)
@@ -81,6 +101,16 @@ func InitStorage() error {
 		}
 	}
 
+	if opts.Git || opts.Remote != "" {
+		if err := NewGitStore(slog.Default()).Init(opts.Remote); err != nil {
+			return fmt.Errorf("failed to set up git history: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases storage resources; the JSON backend holds none between calls
+func (s *Storage) Close() error {
 	return nil
 }
 
@@ -93,121 +123,457 @@ func generateID() string {
 	return id.String()
 }
 
-// LoadTasks reads tasks from tasks.json
+// newTask builds a task with defaults applied
+func newTask(title string, dueDate *string, priority string, tags []string) Task {
+	if priority == "" {
+		priority = "medium"
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return Task{
+		ID:        generateID(),
+		Title:     title,
+		Completed: false,
+		DueDate:   dueDate,
+		Priority:  priority,
+		Tags:      tags,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// newNote builds a note with defaults applied
+func newNote(title, content string, tags []string) Note {
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return Note{
+		ID:        generateID(),
+		Title:     title,
+		Content:   content,
+		Tags:      tags,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// LoadTasks reads tasks from tasks.json, upgrading older schema versions in
+// memory and merging in any conflict copies left by a file-sync tool. A
+// corrupt file is moved aside and recovered.
 func (s *Storage) LoadTasks() (*TaskList, error) {
+	s.resolveConflictCopies(tasksFile)
+	tasks, err := s.loadTasks(false)
+	if errors.As(err, new(*CorruptFileError)) {
+		err = s.withLock(func() error {
+			tasks, err = s.loadTasksRecovering()
+			return err
+		})
+	}
+	return tasks, err
+}
+
+// loadTasks reads tasks.json; with upgrade set the caller holds the storage
+// lock and an older file is also backed up and rewritten at the current version
+func (s *Storage) loadTasks(upgrade bool) (*TaskList, error) {
 	path := filepath.Join(s.basePath, tasksFile)
-	data, err := os.ReadFile(path)
+	data, _, err := s.readDataFile(path, taskSchemaMigrations, upgrade)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &TaskList{Tasks: []Task{}}, nil
+			return &TaskList{SchemaVersion: currentSchemaVersion, Tasks: []Task{}}, nil
 		}
 		return nil, fmt.Errorf("failed to read tasks: %w", err)
 	}
 
 	var tasks TaskList
 	if err := json.Unmarshal(data, &tasks); err != nil {
-		return nil, fmt.Errorf("failed to parse tasks: %w", err)
+		return nil, &CorruptFileError{File: tasksFile, Err: err}
 	}
+	tasks.stamp = dataStamp(data)
 	return &tasks, nil
 }
 
-// SaveTasks writes tasks to tasks.json
+// loadTasksRecovering is loadTasks that recovers a corrupt file. The caller must hold the storage lock.
+func (s *Storage) loadTasksRecovering() (*TaskList, error) {
+	tasks, err := s.loadTasks(true)
+	var corrupt *CorruptFileError
+	if !errors.As(err, &corrupt) {
+		return tasks, err
+	}
+	if _, err := s.recoverFile(tasksFile, corrupt); err != nil {
+		return nil, err
+	}
+	return s.loadTasks(true)
+}
+
+// SaveTasks atomically writes tasks to tasks.json, numbering any new tasks
 func (s *Storage) SaveTasks(tasks *TaskList) error {
+	tasks.SchemaVersion = currentSchemaVersion
+	numberTasks(tasks)
 	path := filepath.Join(s.basePath, tasksFile)
 	data, err := json.MarshalIndent(tasks, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to serialize tasks: %w", err)
 	}
-	return os.WriteFile(path, data, dataFilePerm)
+	stamp := dataStamp(data)
+	if data, err = s.vault.Seal(data); err != nil {
+		return fmt.Errorf("failed to encrypt tasks: %w", err)
+	}
+	if err := writeFileAtomic(path, data, dataFilePerm); err != nil {
+		return err
+	}
+	tasks.stamp = stamp
+	return nil
 }
 
-// LoadNotes reads notes from notes.json
+// ModifyTasks loads tasks, applies fn and saves the result while holding the
+// storage lock. Nothing is saved when fn returns an error.
+func (s *Storage) ModifyTasks(fn func(*TaskList) error) error {
+	return s.withLock(func() error {
+		if err := s.mergeConflictCopies(tasksFile); err != nil {
+			s.logger.Error("failed to merge conflict copies", "file", tasksFile, "error", err)
+		}
+		tasks, err := s.loadTasksRecovering()
+		if err != nil {
+			return err
+		}
+		before, err := imagesByID(tasks.Tasks, taskReplica.key)
+		if err != nil {
+			return err
+		}
+		clocks := recordClocks(taskReplica, tasks.Tasks)
+		if err := fn(tasks); err != nil {
+			return err
+		}
+		tasks.Tombstones, err = stampRecords(taskReplica, before, clocks, tasks.Tasks, tasks.Tombstones)
+		if err != nil {
+			return err
+		}
+		return s.SaveTasks(tasks)
+	})
+}
+
+// LoadNotes reads notes from notes.json, upgrading older schema versions in
+// memory and merging in any conflict copies left by a file-sync tool. A
+// corrupt file is moved aside and recovered.
 func (s *Storage) LoadNotes() (*NoteList, error) {
+	s.resolveConflictCopies(notesFile)
+	notes, err := s.loadNotes(false)
+	if errors.As(err, new(*CorruptFileError)) {
+		err = s.withLock(func() error {
+			notes, err = s.loadNotesRecovering()
+			return err
+		})
+	}
+	return notes, err
+}
+
+// loadNotes is loadTasks for notes.json
+func (s *Storage) loadNotes(upgrade bool) (*NoteList, error) {
 	path := filepath.Join(s.basePath, notesFile)
-	data, err := os.ReadFile(path)
+	data, _, err := s.readDataFile(path, noteSchemaMigrations, upgrade)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &NoteList{Notes: []Note{}}, nil
+			return &NoteList{SchemaVersion: currentSchemaVersion, Notes: []Note{}}, nil
 		}
 		return nil, fmt.Errorf("failed to read notes: %w", err)
 	}
 
 	var notes NoteList
 	if err := json.Unmarshal(data, &notes); err != nil {
-		return nil, fmt.Errorf("failed to parse notes: %w", err)
+		return nil, &CorruptFileError{File: notesFile, Err: err}
 	}
+	notes.stamp = dataStamp(data)
 	return &notes, nil
 }
 
-// SaveNotes writes notes to notes.json
+// loadNotesRecovering is loadNotes that recovers a corrupt file. The caller must hold the storage lock.
+func (s *Storage) loadNotesRecovering() (*NoteList, error) {
+	notes, err := s.loadNotes(true)
+	var corrupt *CorruptFileError
+	if !errors.As(err, &corrupt) {
+		return notes, err
+	}
+	if _, err := s.recoverFile(notesFile, corrupt); err != nil {
+		return nil, err
+	}
+	return s.loadNotes(true)
+}
+
+// SaveNotes atomically writes notes to notes.json, numbering any new notes
 func (s *Storage) SaveNotes(notes *NoteList) error {
+	notes.SchemaVersion = currentSchemaVersion
+	numberNotes(notes)
 	path := filepath.Join(s.basePath, notesFile)
 	data, err := json.MarshalIndent(notes, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to serialize notes: %w", err)
 	}
-	return os.WriteFile(path, data, dataFilePerm)
+	stamp := dataStamp(data)
+	if data, err = s.vault.Seal(data); err != nil {
+		return fmt.Errorf("failed to encrypt notes: %w", err)
+	}
+	if err := writeFileAtomic(path, data, dataFilePerm); err != nil {
+		return err
+	}
+	notes.stamp = stamp
+	return nil
+}
+
+// ModifyNotes loads notes, applies fn and saves the result while holding the
+// storage lock. Nothing is saved when fn returns an error.
+func (s *Storage) ModifyNotes(fn func(*NoteList) error) error {
+	return s.withLock(func() error {
+		if err := s.mergeConflictCopies(notesFile); err != nil {
+			s.logger.Error("failed to merge conflict copies", "file", notesFile, "error", err)
+		}
+		notes, err := s.loadNotesRecovering()
+		if err != nil {
+			return err
+		}
+		before, err := imagesByID(notes.Notes, noteReplica.key)
+		if err != nil {
+			return err
+		}
+		clocks := recordClocks(noteReplica, notes.Notes)
+		if err := fn(notes); err != nil {
+			return err
+		}
+		notes.Tombstones, err = stampRecords(noteReplica, before, clocks, notes.Notes, notes.Tombstones)
+		if err != nil {
+			return err
+		}
+		return s.SaveNotes(notes)
+	})
 }
 
 // AddTask creates a new task and saves it
 func (s *Storage) AddTask(title string, dueDate *string, priority string, tags []string) (*Task, error) {
-	tasks, err := s.LoadTasks()
+	task := newTask(title, dueDate, priority, tags)
+	err := s.ModifyTasks(func(tasks *TaskList) error {
+		tasks.Tasks = append(tasks.Tasks, task)
+		numberTasks(tasks)
+		task = tasks.Tasks[len(tasks.Tasks)-1]
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if priority == "" {
-		priority = "medium"
-	}
-	if tags == nil {
-		tags = []string{}
+	return &task, nil
+}
+
+// AddNote creates a new note and saves it
+func (s *Storage) AddNote(title, content string, tags []string) (*Note, error) {
+	note := newNote(title, content, tags)
+	err := s.ModifyNotes(func(notes *NoteList) error {
+		notes.Notes = append(notes.Notes, note)
+		numberNotes(notes)
+		note = notes.Notes[len(notes.Notes)-1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	task := Task{
-		ID:        generateID(),
-		Title:     title,
-		Completed: false,
-		DueDate:   dueDate,
-		Priority:  priority,
-		Tags:      tags,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	return &note, nil
+}
+
+// UpdateTask changes the fields of a task outside the trash
+func (s *Storage) UpdateTask(id string, changes TaskChanges) (*Task, *Task, error) {
+	return updateTask(s, id, changes)
+}
+
+// UpdateNote changes the fields of a note outside the trash
+func (s *Storage) UpdateNote(id string, changes NoteChanges) (*Note, *Note, error) {
+	return updateNote(s, id, changes)
+}
+
+// PlanSchemaMigrations reports the schema migrations pending for each data file
+// without changing anything
+func (s *Storage) PlanSchemaMigrations() ([]SchemaPlan, error) {
+	plans := make([]SchemaPlan, 0, len(schemaDataFiles))
+	for _, f := range schemaDataFiles {
+		path := filepath.Join(s.basePath, f.name)
+		data, err := s.readFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.name, err)
+		}
+		_, version, err := decodeSchemaDocument(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", f.name, err)
+		}
+		plan, err := planSchemaMigration(path, version, f.migrations)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
 	}
+	return plans, nil
+}
 
-	tasks.Tasks = append(tasks.Tasks, task)
-	if err := s.SaveTasks(tasks); err != nil {
+// MigrateSchema upgrades every data file to the current schema version while
+// holding the storage lock, and reports the migrations it applied
+func (s *Storage) MigrateSchema() ([]SchemaPlan, error) {
+	plans := make([]SchemaPlan, 0, len(schemaDataFiles))
+	err := s.withLock(func() error {
+		for _, f := range schemaDataFiles {
+			path := filepath.Join(s.basePath, f.name)
+			data, plan, err := s.readDataFile(path, f.migrations, true)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if _, _, err := decodeSchemaDocument(data); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", f.name, err)
+			}
+			plans = append(plans, plan)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	return plans, nil
+}
 
-	return &task, nil
+// readDataFile returns the contents of a data file at the current schema
+// version and the migrations that brought it there. Older files are migrated
+// in memory; with upgrade set the caller holds the storage lock, and the
+// original is also backed up next to the file and the file rewritten.
+func (s *Storage) readDataFile(path string, migrations []schemaMigration, upgrade bool) ([]byte, SchemaPlan, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, SchemaPlan{}, err
+	}
+	data, err := s.vault.Open(raw)
+	if errors.Is(err, errDamagedData) {
+		return nil, SchemaPlan{}, &CorruptFileError{File: filepath.Base(path), Err: err}
+	}
+	if err != nil {
+		return nil, SchemaPlan{}, fmt.Errorf("failed to decrypt %s: %w", filepath.Base(path), err)
+	}
+
+	doc, version, err := decodeSchemaDocument(data)
+	if err != nil {
+		// Leave parse errors to the caller so they are reported as such
+		return data, SchemaPlan{}, nil
+	}
+	plan, err := planSchemaMigration(path, version, migrations)
+	if err != nil {
+		return nil, plan, err
+	}
+	if !plan.Pending() {
+		return data, plan, nil
+	}
+
+	if err := migrateSchemaDocument(doc, version, migrations); err != nil {
+		return nil, plan, fmt.Errorf("failed to migrate %s: %w", plan.File, err)
+	}
+	migrated, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, plan, fmt.Errorf("failed to serialize %s: %w", plan.File, err)
+	}
+	if !upgrade {
+		return migrated, plan, nil
+	}
+
+	if err := os.WriteFile(plan.Backup, raw, dataFilePerm); err != nil {
+		return nil, plan, fmt.Errorf("failed to back up %s: %w", plan.File, err)
+	}
+	sealed, err := s.vault.Seal(migrated)
+	if err != nil {
+		return nil, plan, fmt.Errorf("failed to encrypt %s: %w", plan.File, err)
+	}
+	if err := writeFileAtomic(path, sealed, dataFilePerm); err != nil {
+		return nil, plan, err
+	}
+
+	s.logger.Info("migrated data file", "file", plan.File, "from", plan.From, "to", plan.To, "backup", plan.Backup)
+	return migrated, plan, nil
 }
 
-// AddNote creates a new note and saves it
-func (s *Storage) AddNote(title, content string, tags []string) (*Note, error) {
-	notes, err := s.LoadNotes()
+// readFile reads a file, decrypting it if it is encrypted
+func (s *Storage) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	plain, err := s.vault.Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", filepath.Base(path), err)
+	}
+	return plain, nil
+}
 
-	if tags == nil {
-		tags = []string{}
+// dataStamp fingerprints the plaintext of a data file
+func dataStamp(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64()
+}
+
+// withLock runs fn while holding an exclusive advisory lock on the data directory
+func (s *Storage) withLock(fn func() error) error {
+	return withFileLock(filepath.Join(s.basePath, lockFile), fn)
+}
+
+// withFileLock runs fn while holding an exclusive advisory lock on the file at path
+func withFileLock(path string, fn func() error) (err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, dataFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
 	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close lock file: %w", closeErr)
+		}
+	}()
 
-	note := Note{
-		ID:        generateID(),
-		Title:     title,
-		Content:   content,
-		Tags:      tags,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if err := acquireFileLock(f); err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
+	defer func() {
+		if unlockErr := releaseFileLock(f); unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to release lock: %w", unlockErr)
+		}
+	}()
 
-	notes.Notes = append(notes.Notes, note)
-	if err := s.SaveNotes(notes); err != nil {
-		return nil, err
+	return fn()
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and renames
+// it over path, so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
+	tmpPath := tmp.Name()
 
-	return &note, nil
+	if _, err := tmp.Write(data); err != nil {
+		return errors.Join(fmt.Errorf("failed to write temp file: %w", err), tmp.Close(), os.Remove(tmpPath))
+	}
+	if err := tmp.Sync(); err != nil {
+		return errors.Join(fmt.Errorf("failed to sync temp file: %w", err), tmp.Close(), os.Remove(tmpPath))
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to close temp file: %w", err), os.Remove(tmpPath))
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return errors.Join(fmt.Errorf("failed to set file mode: %w", err), os.Remove(tmpPath))
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Join(fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err), os.Remove(tmpPath))
+	}
+	return nil
 }
 
 // isToday checks if a date string (YYYY-MM-DD) or time is today
@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -82,7 +85,7 @@ func TestInitStorage(t *testing.T) {
 		basePath := filepath.Join(configHome, kikiDir)
 
 		// act
-		err := InitStorage()
+		err := InitStorage(InitOptions{})
 
 		// assert
 		if err != nil {
@@ -362,6 +365,128 @@ func TestStorageAddNote(t *testing.T) {
 	})
 }
 
+func TestStorageConcurrentWrites(t *testing.T) {
+	t.Run("concurrent writers do not lose updates", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		const writers = 8
+		const tasksPerWriter = 10
+		storages := make([]*Storage, writers)
+		for i := range storages {
+			storage, err := NewStorage(newTestLogger())
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			storages[i] = storage
+		}
+
+		// act
+		var wg sync.WaitGroup
+		errs := make(chan error, writers*tasksPerWriter*2)
+		for i, storage := range storages {
+			wg.Add(1)
+			go func(writer int, storage *Storage) {
+				defer wg.Done()
+				for j := 0; j < tasksPerWriter; j++ {
+					if _, err := storage.AddTask(fmt.Sprintf("task %d-%d", writer, j), nil, "", nil); err != nil {
+						errs <- err
+					}
+					if _, err := storage.AddNote(fmt.Sprintf("note %d-%d", writer, j), "content", nil); err != nil {
+						errs <- err
+					}
+				}
+			}(i, storage)
+		}
+		wg.Wait()
+		close(errs)
+
+		// assert
+		for err := range errs {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+		tasks, err := storages[0].LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != writers*tasksPerWriter {
+			t.Fatalf("expected %d tasks, got %d", writers*tasksPerWriter, len(tasks.Tasks))
+		}
+		notes, err := storages[0].LoadNotes()
+		if err != nil {
+			t.Fatalf("failed to load notes: %v", err)
+		}
+		if len(notes.Notes) != writers*tasksPerWriter {
+			t.Fatalf("expected %d notes, got %d", writers*tasksPerWriter, len(notes.Notes))
+		}
+	})
+
+	t.Run("ModifyTasks does not save when fn fails", func(t *testing.T) {
+		// arrange
+		configHome := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", configHome)
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		if _, err := storage.AddTask("Keep me", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		wantErr := errors.New("boom")
+
+		// act
+		err = storage.ModifyTasks(func(tasks *TaskList) error {
+			tasks.Tasks = nil
+			return wantErr
+		})
+
+		// assert
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("expected %v, got %v", wantErr, err)
+		}
+		tasks, err := storage.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if len(tasks.Tasks) != 1 {
+			t.Fatalf("expected 1 task, got %d", len(tasks.Tasks))
+		}
+	})
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Run("replaces file contents and leaves no temp files", func(t *testing.T) {
+		// arrange
+		dir := t.TempDir()
+		path := filepath.Join(dir, tasksFile)
+		if err := os.WriteFile(path, []byte("old"), dataFilePerm); err != nil {
+			t.Fatalf("failed to seed file: %v", err)
+		}
+
+		// act
+		err := writeFileAtomic(path, []byte("new"), dataFilePerm)
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(data) != "new" {
+			t.Fatalf("expected %q, got %q", "new", string(data))
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read dir: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected only %s in dir, got %d entries", tasksFile, len(entries))
+		}
+	})
+}
+
 func TestGenerateID(t *testing.T) {
 	t.Run("generateID returns non-empty string", func(t *testing.T) {
 		// arrange
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readHiddenLine reads a line from f with terminal echo turned off. Input that
+// is not a terminal is read as is.
+func readHiddenLine(f *os.File) (string, error) {
+	fd := int(f.Fd())
+	state, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return readLine(f)
+	}
+	hidden := *state
+	hidden.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &hidden); err != nil {
+		return "", err
+	}
+	defer func() { _ = unix.IoctlSetTermios(fd, ioctlWriteTermios, state) }()
+	return readLine(f)
+}
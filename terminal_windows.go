@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// readHiddenLine reads a line from f with console echo turned off. Input that
+// is not a console is read as is.
+func readHiddenLine(f *os.File) (string, error) {
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return readLine(f)
+	}
+	if err := windows.SetConsoleMode(handle, mode&^windows.ENABLE_ECHO_INPUT); err != nil {
+		return "", err
+	}
+	defer func() { _ = windows.SetConsoleMode(handle, mode) }()
+	return readLine(f)
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,23 +14,52 @@ import (
 )
 
 const (
-	taskNumberOffset = 1
-	noteNumberStart  = 0
-	notFoundIndex    = -1
-	notePreviewMax   = 100
+	notFoundIndex  = -1
+	notePreviewMax = 100
+	noteChunkMax   = 8000
+	scopeAll       = "all"
 )
 
-// ToolHandler wraps storage for tool operations
+// errNotFound aborts a storage modification when no item matches the query
+var errNotFound = errors.New("not found")
+
+// ToolHandler wraps storage for tool operations. Inside a project, storage is
+// the project store and global is the profile store listed alongside it.
 type ToolHandler struct {
-	storage *Storage
-	logger  *slog.Logger
+	storage Repository
+	global  Repository
+	// lastChanged is the store touched by the most recent change, which undo reverts
+	lastChanged Repository
+	logger      *slog.Logger
+	// record, when set, is told about every tool invocation
+	record func(ToolCall)
 }
 
 // NewToolHandler creates a new tool handler
-func NewToolHandler(storage *Storage, logger *slog.Logger) *ToolHandler {
+func NewToolHandler(storage Repository, logger *slog.Logger) *ToolHandler {
 	return &ToolHandler{storage: storage, logger: logger}
 }
 
+// SetGlobalStore makes the profile store available next to the project store
+func (h *ToolHandler) SetGlobalStore(global Repository) {
+	h.global = global
+}
+
+// SetRecorder has every tool invocation reported to record
+func (h *ToolHandler) SetRecorder(record func(ToolCall)) {
+	h.record = record
+}
+
+// ToolCall is one tool invocation as reported by --output json
+type ToolCall struct {
+	Name       string          `json:"name"`
+	Params     json.RawMessage `json:"params"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Success    bool            `json:"success"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+}
+
 // AddTaskParams parameters for add_task tool
 type AddTaskParams struct {
 	Title    string   `json:"title" jsonschema:"The task title"`
@@ -39,16 +72,21 @@ type AddTaskParams struct {
 type AddTaskResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
 	TaskID  string `json:"task_id,omitempty"`
+	ShortID string `json:"short_id,omitempty"`
 }
 
 // ListTasksParams parameters for list_tasks tool
 type ListTasksParams struct {
-	Filter string `json:"filter" jsonschema:"Filter: all, today, incomplete, or completed"`
+	Filter string  `json:"filter" jsonschema:"Filter: all, today, incomplete, or completed"`
+	Scope  string  `json:"scope,omitempty" jsonschema:"Inside a project: project (default), global, or all to list both"`
+	Tag    *string `json:"tag,omitempty" jsonschema:"Optional tag to filter by"`
 }
 
 // ListTasksResult result from list_tasks tool
 type ListTasksResult struct {
+	Success bool          `json:"success"`
 	Tasks   []TaskSummary `json:"tasks"`
 	Count   int           `json:"count"`
 	Message string        `json:"message"`
@@ -56,34 +94,41 @@ type ListTasksResult struct {
 
 // TaskSummary simplified task for listing
 type TaskSummary struct {
-	Number    int     `json:"number"`
-	ID        string  `json:"id"`
-	Title     string  `json:"title"`
-	Completed bool    `json:"completed"`
-	DueDate   *string `json:"due_date,omitempty"`
-	Priority  string  `json:"priority"`
+	ShortID   string   `json:"short_id"`
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Completed bool     `json:"completed"`
+	DueDate   *string  `json:"due_date,omitempty"`
+	Priority  string   `json:"priority"`
+	Tags      []string `json:"tags,omitempty"`
+	Store     string   `json:"store,omitempty"`
+	Snippet   string   `json:"snippet,omitempty"`
 }
 
 // CompleteTaskParams parameters for complete_task tool
 type CompleteTaskParams struct {
-	Query string `json:"query" jsonschema:"Task ID or title substring to match"`
+	Query string `json:"query" jsonschema:"Task short ID (such as t3, or g:t3 for a global task inside a project), ID, or title to match"`
 }
 
 // CompleteTaskResult result from complete_task tool
 type CompleteTaskResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
 }
 
 // DeleteTaskParams parameters for delete_task tool
 type DeleteTaskParams struct {
-	Query string `json:"query" jsonschema:"Task ID or title substring to match"`
+	Query string `json:"query" jsonschema:"Task short ID (such as t3, or g:t3 for a global task inside a project), ID, or title to match"`
 }
 
 // DeleteTaskResult result from delete_task tool
 type DeleteTaskResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
 }
 
 // AddNoteParams parameters for add_note tool
@@ -97,7 +142,9 @@ type AddNoteParams struct {
 type AddNoteResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
 	NoteID  string `json:"note_id,omitempty"`
+	ShortID string `json:"short_id,omitempty"`
 }
 
 // ListNotesParams parameters for list_notes tool
@@ -108,6 +155,7 @@ type ListNotesParams struct {
 
 // ListNotesResult result from list_notes tool
 type ListNotesResult struct {
+	Success bool          `json:"success"`
 	Notes   []NoteSummary `json:"notes"`
 	Count   int           `json:"count"`
 	Message string        `json:"message"`
@@ -115,48 +163,241 @@ type ListNotesResult struct {
 
 // NoteSummary simplified note for listing
 type NoteSummary struct {
-	Number    int      `json:"number"`
+	ShortID   string   `json:"short_id"`
 	ID        string   `json:"id"`
 	Title     string   `json:"title"`
 	Preview   string   `json:"preview"`
+	Snippet   string   `json:"snippet,omitempty"`
 	Tags      []string `json:"tags"`
 	CreatedAt string   `json:"created_at"`
+	Store     string   `json:"store,omitempty"`
 }
 
 // SearchNotesParams parameters for search_notes tool
 type SearchNotesParams struct {
-	Query string `json:"query" jsonschema:"Search term to find in title or content"`
+	Query string `json:"query" jsonschema:"Words to find, all of which must match. Supports \"exact phrases\", prefix* words, tag:name, after:YYYY-MM-DD and before:YYYY-MM-DD (creation date)"`
+	Scope string `json:"scope,omitempty" jsonschema:"Inside a project: project (default), global, or all to search both"`
+	Limit int    `json:"limit,omitempty" jsonschema:"Most results to return, best first (default 20)"`
 }
 
 // SearchNotesResult result from search_notes tool
 type SearchNotesResult struct {
+	Success bool          `json:"success"`
 	Notes   []NoteSummary `json:"notes"`
 	Count   int           `json:"count"`
 	Message string        `json:"message"`
 }
 
+// SearchTasksParams parameters for search_tasks tool
+type SearchTasksParams struct {
+	Query string `json:"query" jsonschema:"Words to find, all of which must match. Supports \"exact phrases\", prefix* words, tag:name, after:YYYY-MM-DD and before:YYYY-MM-DD (creation date)"`
+	Scope string `json:"scope,omitempty" jsonschema:"Inside a project: project (default), global, or all to search both"`
+	Limit int    `json:"limit,omitempty" jsonschema:"Most results to return, best first (default 20)"`
+}
+
+// SearchTasksResult result from search_tasks tool
+type SearchTasksResult struct {
+	Success bool          `json:"success"`
+	Tasks   []TaskSummary `json:"tasks"`
+	Count   int           `json:"count"`
+	Message string        `json:"message"`
+}
+
+// GetNoteParams parameters for get_note tool
+type GetNoteParams struct {
+	Query  string `json:"query" jsonschema:"Note short ID (such as n3, or g:n3 for a global note inside a project), ID, or title to match"`
+	Offset int    `json:"offset,omitempty" jsonschema:"Character to start reading from, as given by next_offset for long notes"`
+	Limit  int    `json:"limit,omitempty" jsonschema:"Most characters to return, up to 8000 (the default)"`
+}
+
+// GetNoteResult result from get_note tool. Content holds the characters from
+// Offset on; NextOffset is set while more of the note remains.
+type GetNoteResult struct {
+	Success    bool     `json:"success"`
+	Message    string   `json:"message"`
+	Store      string   `json:"store,omitempty"`
+	NoteID     string   `json:"note_id,omitempty"`
+	ShortID    string   `json:"short_id,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+	Content    string   `json:"content"`
+	Offset     int      `json:"offset"`
+	Length     int      `json:"length"`
+	NextOffset *int     `json:"next_offset,omitempty"`
+	Ambiguity
+}
+
 // DeleteNoteParams parameters for delete_note tool
 type DeleteNoteParams struct {
-	Query string `json:"query" jsonschema:"Note ID or title substring to match"`
+	Query string `json:"query" jsonschema:"Note short ID (such as n3, or g:n3 for a global note inside a project), ID, or title to match"`
 }
 
 // DeleteNoteResult result from delete_note tool
 type DeleteNoteResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
+}
+
+// RestoreTaskParams parameters for restore_task tool
+type RestoreTaskParams struct {
+	Query string `json:"query" jsonschema:"Trashed task short ID, ID, or title to match"`
+}
+
+// RestoreTaskResult result from restore_task tool
+type RestoreTaskResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
+}
+
+// RestoreNoteParams parameters for restore_note tool
+type RestoreNoteParams struct {
+	Query string `json:"query" jsonschema:"Trashed note short ID, ID, or title to match"`
+}
+
+// RestoreNoteResult result from restore_note tool
+type RestoreNoteResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
+}
+
+// UndoLastChangeParams parameters for undo_last_change tool
+type UndoLastChangeParams struct{}
+
+// UndoLastChangeResult result from undo_last_change tool
+type UndoLastChangeResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// GetProfileParams parameters for get_profile tool
+type GetProfileParams struct{}
+
+// GetProfileResult result from get_profile tool
+type GetProfileResult struct {
+	Success  bool     `json:"success"`
+	Profile  string   `json:"profile"`
+	Profiles []string `json:"profiles,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// UpdateTaskParams parameters for update_task tool
+type UpdateTaskParams struct {
+	Query    string   `json:"query" jsonschema:"Task short ID (such as t3, or g:t3 for a global task inside a project), ID, or title to match"`
+	Title    *string  `json:"title,omitempty" jsonschema:"New title"`
+	DueDate  *string  `json:"due_date,omitempty" jsonschema:"New due date in YYYY-MM-DD format"`
+	Priority *string  `json:"priority,omitempty" jsonschema:"New priority: low, medium, or high"`
+	Tags     []string `json:"tags,omitempty" jsonschema:"Tags that replace the current ones"`
+	Clear    []string `json:"clear,omitempty" jsonschema:"Fields to clear: due_date, tags, or priority to reset it to the default"`
+}
+
+// UpdateTaskResult result from update_task tool
+type UpdateTaskResult struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Store   string        `json:"store,omitempty"`
+	TaskID  string        `json:"task_id,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+	Ambiguity
+}
+
+// UpdateNoteParams parameters for update_note tool
+type UpdateNoteParams struct {
+	Query   string   `json:"query" jsonschema:"Note short ID (such as n3, or g:n3 for a global note inside a project), ID, or title to match"`
+	Title   *string  `json:"title,omitempty" jsonschema:"New title"`
+	Content *string  `json:"content,omitempty" jsonschema:"New content, replacing all of the current content"`
+	Tags    []string `json:"tags,omitempty" jsonschema:"Tags that replace the current ones"`
+	Clear   []string `json:"clear,omitempty" jsonschema:"Fields to clear: content or tags"`
+}
+
+// UpdateNoteResult result from update_note tool
+type UpdateNoteResult struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Store   string        `json:"store,omitempty"`
+	NoteID  string        `json:"note_id,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+	Ambiguity
+}
+
+// FieldChange is one field of an updated task or note, before and after
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// ReopenTaskParams parameters for reopen_task tool
+type ReopenTaskParams struct {
+	Query string `json:"query" jsonschema:"Completed task short ID, ID, or title to match"`
+}
+
+// ReopenTaskResult result from reopen_task tool
+type ReopenTaskResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Store   string `json:"store,omitempty"`
+	Ambiguity
 }
 
 // GetAllTools returns all Kiki tools
 func (h *ToolHandler) GetAllTools() []copilot.Tool {
-	return []copilot.Tool{
+	tools := []copilot.Tool{
 		h.addTaskTool(),
 		h.listTasksTool(),
+		h.searchTasksTool(),
 		h.completeTaskTool(),
 		h.deleteTaskTool(),
+		h.updateTaskTool(),
+		h.reopenTaskTool(),
 		h.addNoteTool(),
 		h.listNotesTool(),
 		h.searchNotesTool(),
+		h.getNoteTool(),
 		h.deleteNoteTool(),
+		h.updateNoteTool(),
+		h.restoreTaskTool(),
+		h.restoreNoteTool(),
+		h.undoLastChangeTool(),
+		h.getProfileTool(),
+	}
+	if h.record != nil {
+		for i := range tools {
+			tools[i].Handler = h.recorded(tools[i].Name, tools[i].Handler)
+		}
+	}
+	return tools
+}
+
+// recorded reports each invocation of handler. A tool fails when it returns an
+// error or a result with success set to false.
+func (h *ToolHandler) recorded(name string, handler copilot.ToolHandler) copilot.ToolHandler {
+	return func(inv copilot.ToolInvocation) (copilot.ToolResult, error) {
+		start := time.Now()
+		result, err := handler(inv)
+		call := ToolCall{Name: name, Success: err == nil, DurationMs: time.Since(start).Milliseconds()}
+		if params, marshalErr := json.Marshal(inv.Arguments); marshalErr == nil {
+			call.Params = params
+		}
+		if err != nil {
+			call.Error = err.Error()
+		} else if json.Valid([]byte(result.TextResultForLLM)) {
+			call.Result = json.RawMessage(result.TextResultForLLM)
+			var status struct {
+				Success *bool `json:"success"`
+			}
+			if json.Unmarshal(call.Result, &status) == nil && status.Success != nil {
+				call.Success = *status.Success
+			}
+		}
+		h.record(call)
+		return result, err
 	}
 }
 
@@ -165,292 +406,1033 @@ func (h *ToolHandler) addTaskTool() copilot.Tool {
 		"add_task",
 		"Create a new task with optional due date, priority, and tags",
 		func(params AddTaskParams, inv copilot.ToolInvocation) (AddTaskResult, error) {
-			priority := "medium"
-			if params.Priority != nil {
-				priority = *params.Priority
-			}
-
-			task, err := h.storage.AddTask(params.Title, params.DueDate, priority, params.Tags)
+			result, err := h.addTask(params)
 			if err != nil {
 				return AddTaskResult{Success: false, Message: err.Error()}, nil
 			}
-
-			return AddTaskResult{
-				Success: true,
-				Message: fmt.Sprintf("Task '%s' created with %s priority", task.Title, task.Priority),
-				TaskID:  task.ID,
-			}, nil
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) addTask(params AddTaskParams) (AddTaskResult, error) {
+	priority := appConfig.DefaultPriority
+	if params.Priority != nil {
+		priority = *params.Priority
+	}
+	if strings.TrimSpace(params.Title) == "" {
+		return AddTaskResult{}, errors.New("the title must not be empty")
+	}
+	if err := validateTaskFields(params.DueDate, &priority); err != nil {
+		return AddTaskResult{}, err
+	}
+
+	task, err := h.storage.AddTask(params.Title, params.DueDate, priority, params.Tags)
+	if err != nil {
+		return AddTaskResult{}, err
+	}
+	h.lastChanged = h.storage
+
+	store := h.activeStore()
+	return AddTaskResult{
+		Success: true,
+		Message: fmt.Sprintf("Task '%s' created with %s priority%s", task.Title, task.Priority, inStore(store)),
+		TaskID:  task.ID,
+		ShortID: task.ShortID(),
+		Store:   store,
+	}, nil
+}
+
 func (h *ToolHandler) listTasksTool() copilot.Tool {
 	return copilot.DefineTool(
 		"list_tasks",
-		"List tasks with filter: all, today (due or created today), incomplete, or completed. Returns numbered list for easy reference.",
+		"List tasks with filter: all, today (due or created today), incomplete, or completed. Optionally only tasks with a tag. Inside a project, scope selects project, global, or all tasks. Each item has a short ID to refer to it by.",
 		func(params ListTasksParams, inv copilot.ToolInvocation) (ListTasksResult, error) {
-			taskList, err := h.storage.LoadTasks()
+			result, err := h.listTasks(params)
 			if err != nil {
-				return ListTasksResult{Message: err.Error()}, nil
-			}
-
-			filtered := make([]TaskSummary, 0, len(taskList.Tasks))
-			for i, t := range taskList.Tasks {
-				include := false
-				switch params.Filter {
-				case "all":
-					include = true
-				case "today":
-					include = isToday(t.DueDate) || isTodayTime(t.CreatedAt)
-				case "incomplete":
-					include = !t.Completed
-				case "completed":
-					include = t.Completed
-				default:
-					include = true
-				}
-
-				if include {
-					filtered = append(filtered, TaskSummary{
-						Number:    i + taskNumberOffset,
-						ID:        t.ID,
-						Title:     t.Title,
-						Completed: t.Completed,
-						DueDate:   t.DueDate,
-						Priority:  t.Priority,
-					})
-				}
-			}
-
-			return ListTasksResult{
-				Tasks:   filtered,
-				Count:   len(filtered),
-				Message: fmt.Sprintf("Found %d tasks", len(filtered)),
-			}, nil
+				return ListTasksResult{Success: false, Message: err.Error()}, nil
+			}
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) listTasks(params ListTasksParams) (ListTasksResult, error) {
+	stores, err := h.scopedStores(params.Scope)
+	if err != nil {
+		return ListTasksResult{}, err
+	}
+
+	filtered := []TaskSummary{}
+	for _, store := range stores {
+		taskList, err := store.repo.LoadTasks()
+		if err != nil {
+			return ListTasksResult{}, err
+		}
+
+		for _, t := range taskList.Tasks {
+			if t.DeletedAt != nil || !taskMatchesFilter(t, params.Filter) ||
+				(params.Tag != nil && !hasTag(t.Tags, *params.Tag)) {
+				continue
+			}
+			filtered = append(filtered, taskSummaryFrom(t, store.name))
+		}
+	}
+
+	return ListTasksResult{
+		Success: true,
+		Tasks:   filtered,
+		Count:   len(filtered),
+		Message: fmt.Sprintf("Found %d tasks", len(filtered)),
+	}, nil
+}
+
 func (h *ToolHandler) completeTaskTool() copilot.Tool {
 	return copilot.DefineTool(
 		"complete_task",
 		"Mark a task as completed by ID or title match",
 		func(params CompleteTaskParams, inv copilot.ToolInvocation) (CompleteTaskResult, error) {
-			taskList, err := h.storage.LoadTasks()
+			result, err := h.completeTask(params)
 			if err != nil {
 				return CompleteTaskResult{Success: false, Message: err.Error()}, nil
 			}
-
-			foundIndex, matchedTitle := findTaskIndex(taskList.Tasks, params.Query)
-			if foundIndex == notFoundIndex {
-				return CompleteTaskResult{
-					Success: false,
-					Message: fmt.Sprintf("No task found matching '%s'", params.Query),
-				}, nil
-			}
-
-			taskList.Tasks[foundIndex].Completed = true
-			taskList.Tasks[foundIndex].UpdatedAt = time.Now()
-
-			if err := h.storage.SaveTasks(taskList); err != nil {
-				return CompleteTaskResult{Success: false, Message: err.Error()}, nil
-			}
-
-			return CompleteTaskResult{
-				Success: true,
-				Message: fmt.Sprintf("Task '%s' marked as completed", matchedTitle),
-			}, nil
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) completeTask(params CompleteTaskParams) (CompleteTaskResult, error) {
+	done := true
+	_, after, store, err := h.editMatchingTask(params.Query, openTask, TaskChanges{Completed: &done})
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return CompleteTaskResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return CompleteTaskResult{
+			Success: false,
+			Message: fmt.Sprintf("No open task found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return CompleteTaskResult{}, err
+	}
+
+	return CompleteTaskResult{
+		Success: true,
+		Message: fmt.Sprintf("Task '%s' marked as completed%s", after.Title, inStore(store)),
+		Store:   store,
+	}, nil
+}
+
 func (h *ToolHandler) deleteTaskTool() copilot.Tool {
 	return copilot.DefineTool(
 		"delete_task",
-		"Delete a task by ID or title match",
+		"Move a task to the trash by ID or title match. It can be brought back with restore_task.",
 		func(params DeleteTaskParams, inv copilot.ToolInvocation) (DeleteTaskResult, error) {
-			taskList, err := h.storage.LoadTasks()
+			result, err := h.deleteTask(params)
 			if err != nil {
 				return DeleteTaskResult{Success: false, Message: err.Error()}, nil
 			}
+			return result, nil
+		},
+	)
+}
 
-			foundIndex, matchedTitle := findTaskIndex(taskList.Tasks, params.Query)
-			if foundIndex == notFoundIndex {
-				return DeleteTaskResult{
-					Success: false,
-					Message: fmt.Sprintf("No task found matching '%s'", params.Query),
-				}, nil
-			}
+func (h *ToolHandler) deleteTask(params DeleteTaskParams) (DeleteTaskResult, error) {
+	task, store, err := h.locateTask(params.Query, liveTask)
+	if err == nil {
+		err = h.changeTask(store, task.ID, func(t *Task) {
+			now := time.Now()
+			t.DeletedAt = &now
+			t.UpdatedAt = now
+		})
+	}
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return DeleteTaskResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return DeleteTaskResult{
+			Success: false,
+			Message: fmt.Sprintf("No task found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return DeleteTaskResult{}, err
+	}
 
-			taskList.Tasks = append(taskList.Tasks[:foundIndex], taskList.Tasks[foundIndex+1:]...)
+	return DeleteTaskResult{
+		Success: true,
+		Message: fmt.Sprintf("Task '%s' moved to the trash%s", task.Title, inStore(store.name)),
+		Store:   store.name,
+	}, nil
+}
 
-			if err := h.storage.SaveTasks(taskList); err != nil {
-				return DeleteTaskResult{Success: false, Message: err.Error()}, nil
+func (h *ToolHandler) updateTaskTool() copilot.Tool {
+	return copilot.DefineTool(
+		"update_task",
+		"Change a task's title, due date, priority or tags by ID or title match, or clear fields. Only the fields given change.",
+		func(params UpdateTaskParams, inv copilot.ToolInvocation) (UpdateTaskResult, error) {
+			result, err := h.updateTask(params)
+			if err != nil {
+				return UpdateTaskResult{Success: false, Message: err.Error()}, nil
 			}
+			return result, nil
+		},
+	)
+}
 
-			return DeleteTaskResult{
-				Success: true,
-				Message: fmt.Sprintf("Task '%s' deleted", matchedTitle),
-			}, nil
+func (h *ToolHandler) updateTask(params UpdateTaskParams) (UpdateTaskResult, error) {
+	changes := TaskChanges{Title: params.Title, DueDate: params.DueDate, Priority: params.Priority, Tags: params.Tags}
+	for _, field := range params.Clear {
+		switch field {
+		case "due_date":
+			changes.ClearDueDate = true
+		case "tags":
+			changes.ClearTags = true
+		case "priority":
+			if changes.Priority == nil {
+				priority := appConfig.DefaultPriority
+				changes.Priority = &priority
+			}
+		case "title":
+			return UpdateTaskResult{}, errors.New("a task needs a title; set a new one instead of clearing it")
+		default:
+			return UpdateTaskResult{}, fmt.Errorf("cannot clear '%s': use due_date, tags, or priority", field)
+		}
+	}
+	if changes.IsEmpty() {
+		return UpdateTaskResult{}, errors.New("nothing to change; give a title, due_date, priority, tags, or fields to clear")
+	}
+
+	before, after, store, err := h.editTask(params.Query, changes)
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return UpdateTaskResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return UpdateTaskResult{
+			Success: false,
+			Message: fmt.Sprintf("No task found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return UpdateTaskResult{}, err
+	}
+
+	fields := taskFieldChanges(before, after)
+	message := fmt.Sprintf("Task '%s' updated%s", after.Title, inStore(store))
+	if len(fields) == 0 {
+		message = fmt.Sprintf("Task '%s' already had those values%s", after.Title, inStore(store))
+	}
+	return UpdateTaskResult{Success: true, Message: message, Store: store, TaskID: after.ID, Changes: fields}, nil
+}
+
+func (h *ToolHandler) reopenTaskTool() copilot.Tool {
+	return copilot.DefineTool(
+		"reopen_task",
+		"Mark a completed task as not done again by ID or title match",
+		func(params ReopenTaskParams, inv copilot.ToolInvocation) (ReopenTaskResult, error) {
+			result, err := h.reopenTask(params)
+			if err != nil {
+				return ReopenTaskResult{Success: false, Message: err.Error()}, nil
+			}
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) reopenTask(params ReopenTaskParams) (ReopenTaskResult, error) {
+	open := false
+	_, after, store, err := h.editMatchingTask(params.Query, completedTask, TaskChanges{Completed: &open})
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return ReopenTaskResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return ReopenTaskResult{
+			Success: false,
+			Message: fmt.Sprintf("No completed task found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return ReopenTaskResult{}, err
+	}
+
+	return ReopenTaskResult{
+		Success: true,
+		Message: fmt.Sprintf("Task '%s' reopened%s", after.Title, inStore(store)),
+		Store:   store,
+	}, nil
+}
+
 func (h *ToolHandler) addNoteTool() copilot.Tool {
 	return copilot.DefineTool(
 		"add_note",
 		"Create a new note with title, content, and optional tags",
 		func(params AddNoteParams, inv copilot.ToolInvocation) (AddNoteResult, error) {
-			note, err := h.storage.AddNote(params.Title, params.Content, params.Tags)
+			result, err := h.addNote(params)
 			if err != nil {
 				return AddNoteResult{Success: false, Message: err.Error()}, nil
 			}
-
-			return AddNoteResult{
-				Success: true,
-				Message: fmt.Sprintf("Note '%s' created", note.Title),
-				NoteID:  note.ID,
-			}, nil
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) addNote(params AddNoteParams) (AddNoteResult, error) {
+	note, err := h.storage.AddNote(params.Title, params.Content, params.Tags)
+	if err != nil {
+		return AddNoteResult{}, err
+	}
+	h.lastChanged = h.storage
+
+	store := h.activeStore()
+	return AddNoteResult{
+		Success: true,
+		Message: fmt.Sprintf("Note '%s' created%s", note.Title, inStore(store)),
+		NoteID:  note.ID,
+		ShortID: note.ShortID(),
+		Store:   store,
+	}, nil
+}
+
 func (h *ToolHandler) listNotesTool() copilot.Tool {
 	return copilot.DefineTool(
 		"list_notes",
-		"List notes with optional filter (all or today) and tag. Returns numbered list for easy reference.",
+		"List notes with optional filter (all or today) and tag. Returns each note with its short ID and a content preview; use get_note for the full content.",
 		func(params ListNotesParams, inv copilot.ToolInvocation) (ListNotesResult, error) {
-			noteList, err := h.storage.LoadNotes()
+			result, err := h.listNotes(params)
 			if err != nil {
-				return ListNotesResult{Message: err.Error()}, nil
-			}
-
-			filtered := make([]NoteSummary, 0, len(noteList.Notes))
-			noteNum := noteNumberStart
-			for _, n := range noteList.Notes {
-				include := true
-
-				// Apply date filter
-				if params.Filter == "today" {
-					include = isTodayTime(n.CreatedAt)
-				}
-
-				// Apply tag filter
-				if include && params.Tag != nil {
-					hasTag := false
-					for _, tag := range n.Tags {
-						if strings.EqualFold(tag, *params.Tag) {
-							hasTag = true
-							break
-						}
-					}
-					include = hasTag
-				}
-
-				if include {
-					noteNum++
-					filtered = append(filtered, noteSummaryFrom(n, noteNum))
-				}
-			}
-
-			return ListNotesResult{
-				Notes:   filtered,
-				Count:   len(filtered),
-				Message: fmt.Sprintf("Found %d notes", len(filtered)),
-			}, nil
+				return ListNotesResult{Success: false, Message: err.Error()}, nil
+			}
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) listNotes(params ListNotesParams) (ListNotesResult, error) {
+	noteList, err := h.storage.LoadNotes()
+	if err != nil {
+		return ListNotesResult{}, err
+	}
+
+	filtered := make([]NoteSummary, 0, len(noteList.Notes))
+	for _, n := range noteList.Notes {
+		if n.DeletedAt != nil || !noteMatchesFilter(n, params.Filter, params.Tag) {
+			continue
+		}
+		filtered = append(filtered, noteSummaryFrom(n))
+	}
+
+	return ListNotesResult{
+		Success: true,
+		Notes:   filtered,
+		Count:   len(filtered),
+		Message: fmt.Sprintf("Found %d notes", len(filtered)),
+	}, nil
+}
+
 func (h *ToolHandler) searchNotesTool() copilot.Tool {
 	return copilot.DefineTool(
 		"search_notes",
-		"Search notes by keyword in title or content. Returns numbered list for easy reference.",
+		"Search notes by words in their title, tags or content, best matches first, with the matching text highlighted. Each item has a short ID to refer to it by; use get_note for the full content.",
 		func(params SearchNotesParams, inv copilot.ToolInvocation) (SearchNotesResult, error) {
-			noteList, err := h.storage.LoadNotes()
+			result, err := h.searchNotes(params)
 			if err != nil {
-				return SearchNotesResult{Message: err.Error()}, nil
+				return SearchNotesResult{Success: false, Message: err.Error()}, nil
 			}
+			return result, nil
+		},
+	)
+}
 
-			query := strings.ToLower(params.Query)
-			filtered := make([]NoteSummary, 0, len(noteList.Notes))
-			noteNum := noteNumberStart
+func (h *ToolHandler) searchNotes(params SearchNotesParams) (SearchNotesResult, error) {
+	query, err := ParseSearchQuery(params.Query)
+	if err != nil {
+		return SearchNotesResult{}, err
+	}
+	stores, err := h.scopedStores(params.Scope)
+	if err != nil {
+		return SearchNotesResult{}, err
+	}
 
-			for _, n := range noteList.Notes {
-				if strings.Contains(strings.ToLower(n.Title), query) ||
-					strings.Contains(strings.ToLower(n.Content), query) {
-					noteNum++
-					filtered = append(filtered, noteSummaryFrom(n, noteNum))
-				}
+	var hits []NoteSummary
+	var scores []float64
+	for _, store := range stores {
+		searcher, ok := store.repo.(Searcher)
+		if !ok {
+			return SearchNotesResult{}, errors.New("search is not available")
+		}
+		found, err := searcher.SearchNotes(query)
+		if err != nil {
+			return SearchNotesResult{}, err
+		}
+		for _, hit := range found {
+			summary := noteSummaryFrom(hit.Note)
+			summary.ShortID = storeShortID(summary.ShortID, store.name)
+			summary.Snippet, summary.Store = hit.Snippet, store.name
+			hits = append(hits, summary)
+			scores = append(scores, hit.Score)
+		}
+	}
+	hits, total := rankSearchHits(hits, scores, params.Limit)
+
+	return SearchNotesResult{
+		Success: true,
+		Notes:   hits,
+		Count:   len(hits),
+		Message: searchMessage("notes", params.Query, len(hits), total),
+	}, nil
+}
+
+func (h *ToolHandler) searchTasksTool() copilot.Tool {
+	return copilot.DefineTool(
+		"search_tasks",
+		"Search tasks by words in their title or tags, best matches first. Each item has a short ID to refer to it by.",
+		func(params SearchTasksParams, inv copilot.ToolInvocation) (SearchTasksResult, error) {
+			result, err := h.searchTasks(params)
+			if err != nil {
+				return SearchTasksResult{Success: false, Message: err.Error()}, nil
 			}
+			return result, nil
+		},
+	)
+}
 
-			return SearchNotesResult{
-				Notes:   filtered,
-				Count:   len(filtered),
-				Message: fmt.Sprintf("Found %d notes matching '%s'", len(filtered), params.Query),
-			}, nil
+func (h *ToolHandler) searchTasks(params SearchTasksParams) (SearchTasksResult, error) {
+	query, err := ParseSearchQuery(params.Query)
+	if err != nil {
+		return SearchTasksResult{}, err
+	}
+	stores, err := h.scopedStores(params.Scope)
+	if err != nil {
+		return SearchTasksResult{}, err
+	}
+
+	var hits []TaskSummary
+	var scores []float64
+	for _, store := range stores {
+		searcher, ok := store.repo.(Searcher)
+		if !ok {
+			return SearchTasksResult{}, errors.New("search is not available")
+		}
+		found, err := searcher.SearchTasks(query)
+		if err != nil {
+			return SearchTasksResult{}, err
+		}
+		for _, hit := range found {
+			t := hit.Task
+			summary := taskSummaryFrom(t, store.name)
+			summary.Snippet = hit.Snippet
+			hits = append(hits, summary)
+			scores = append(scores, hit.Score)
+		}
+	}
+	hits, total := rankSearchHits(hits, scores, params.Limit)
+
+	return SearchTasksResult{
+		Success: true,
+		Tasks:   hits,
+		Count:   len(hits),
+		Message: searchMessage("tasks", params.Query, len(hits), total),
+	}, nil
+}
+
+// rankSearchHits orders hits from several stores by score and keeps the best
+// limit of them, or searchLimitDefault when limit is not positive. It also
+// returns how many there were.
+func rankSearchHits[T any](hits []T, scores []float64, limit int) ([]T, int) {
+	order := make([]int, len(hits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	if limit <= 0 {
+		limit = searchLimitDefault
+	}
+	ranked := make([]T, 0, min(limit, len(hits)))
+	for _, i := range order[:min(limit, len(hits))] {
+		ranked = append(ranked, hits[i])
+	}
+	return ranked, len(hits)
+}
+
+func searchMessage(kind, query string, shown, total int) string {
+	if shown < total {
+		return fmt.Sprintf("Found %d %s matching '%s', showing the best %d", total, kind, query, shown)
+	}
+	return fmt.Sprintf("Found %d %s matching '%s'", total, kind, query)
+}
+
+func (h *ToolHandler) getNoteTool() copilot.Tool {
+	return copilot.DefineTool(
+		"get_note",
+		"Read the full content of a note by ID or title match. Long notes come in chunks: call again with offset set to next_offset to read on.",
+		func(params GetNoteParams, inv copilot.ToolInvocation) (GetNoteResult, error) {
+			result, err := h.getNote(params)
+			if err != nil {
+				return GetNoteResult{Success: false, Message: err.Error()}, nil
+			}
+			return result, nil
 		},
 	)
 }
 
+func (h *ToolHandler) getNote(params GetNoteParams) (GetNoteResult, error) {
+	limit := params.Limit
+	if limit <= 0 || limit > noteChunkMax {
+		limit = noteChunkMax
+	}
+	note, store, err := h.findNote(params.Query)
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return GetNoteResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return GetNoteResult{
+			Success: false,
+			Message: fmt.Sprintf("No note found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return GetNoteResult{}, err
+	}
+
+	content := []rune(note.Content)
+	if params.Offset < 0 || params.Offset > len(content) {
+		return GetNoteResult{}, fmt.Errorf("offset %d is outside note '%s', which has %d characters",
+			params.Offset, note.Title, len(content))
+	}
+	end := noteChunkEnd(content, params.Offset, limit)
+
+	result := GetNoteResult{
+		Success:   true,
+		Message:   fmt.Sprintf("Note '%s'%s", note.Title, inStore(store)),
+		Store:     store,
+		NoteID:    note.ID,
+		ShortID:   storeShortID(note.ShortID(), store),
+		Title:     note.Title,
+		Tags:      note.Tags,
+		CreatedAt: note.CreatedAt.Format("2006-01-02"),
+		Content:   string(content[params.Offset:end]),
+		Offset:    params.Offset,
+		Length:    len(content),
+	}
+	if end < len(content) {
+		result.NextOffset = &end
+		result.Message = fmt.Sprintf("Note '%s'%s, characters %d to %d of %d; call get_note with offset %d for the rest",
+			note.Title, inStore(store), params.Offset, end, len(content), end)
+	}
+	return result, nil
+}
+
+// noteChunkEnd returns where a chunk of at most limit characters starting at
+// offset ends. A chunk that stops short of the end of the note breaks after a
+// line in its second half when there is one, so paragraphs stay whole.
+func noteChunkEnd(content []rune, offset, limit int) int {
+	end := offset + limit
+	if end >= len(content) {
+		return len(content)
+	}
+	for i := end; i > offset+limit/2; i-- {
+		if content[i-1] == '\n' {
+			return i
+		}
+	}
+	return end
+}
+
 func (h *ToolHandler) deleteNoteTool() copilot.Tool {
 	return copilot.DefineTool(
 		"delete_note",
-		"Delete a note by ID or title match",
+		"Move a note to the trash by ID or title match. It can be brought back with restore_note.",
 		func(params DeleteNoteParams, inv copilot.ToolInvocation) (DeleteNoteResult, error) {
-			noteList, err := h.storage.LoadNotes()
+			result, err := h.deleteNote(params)
 			if err != nil {
 				return DeleteNoteResult{Success: false, Message: err.Error()}, nil
 			}
+			return result, nil
+		},
+	)
+}
+
+func (h *ToolHandler) deleteNote(params DeleteNoteParams) (DeleteNoteResult, error) {
+	note, store, err := h.locateNote(params.Query, liveNote)
+	if err == nil {
+		err = h.changeNote(store, note.ID, func(n *Note) {
+			now := time.Now()
+			n.DeletedAt = &now
+			n.UpdatedAt = now
+		})
+	}
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return DeleteNoteResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return DeleteNoteResult{
+			Success: false,
+			Message: fmt.Sprintf("No note found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return DeleteNoteResult{}, err
+	}
+
+	return DeleteNoteResult{
+		Success: true,
+		Message: fmt.Sprintf("Note '%s' moved to the trash%s", note.Title, inStore(store.name)),
+		Store:   store.name,
+	}, nil
+}
+
+func (h *ToolHandler) updateNoteTool() copilot.Tool {
+	return copilot.DefineTool(
+		"update_note",
+		"Change a note's title, content or tags by ID or title match, or clear fields. Only the fields given change.",
+		func(params UpdateNoteParams, inv copilot.ToolInvocation) (UpdateNoteResult, error) {
+			result, err := h.updateNote(params)
+			if err != nil {
+				return UpdateNoteResult{Success: false, Message: err.Error()}, nil
+			}
+			return result, nil
+		},
+	)
+}
 
-			foundIndex, matchedTitle := findNoteIndex(noteList.Notes, params.Query)
-			if foundIndex == notFoundIndex {
-				return DeleteNoteResult{
+func (h *ToolHandler) updateNote(params UpdateNoteParams) (UpdateNoteResult, error) {
+	changes := NoteChanges{Title: params.Title, Content: params.Content, Tags: params.Tags}
+	for _, field := range params.Clear {
+		switch field {
+		case "content":
+			changes.ClearContent = true
+		case "tags":
+			changes.ClearTags = true
+		case "title":
+			return UpdateNoteResult{}, errors.New("a note needs a title; set a new one instead of clearing it")
+		default:
+			return UpdateNoteResult{}, fmt.Errorf("cannot clear '%s': use content or tags", field)
+		}
+	}
+	if changes.IsEmpty() {
+		return UpdateNoteResult{}, errors.New("nothing to change; give a title, content, tags, or fields to clear")
+	}
+
+	before, after, store, err := h.editNote(params.Query, changes)
+	if ambiguity, ok := ambiguityFrom(err); ok {
+		return UpdateNoteResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+	}
+	if errors.Is(err, errNotFound) {
+		return UpdateNoteResult{
+			Success: false,
+			Message: fmt.Sprintf("No note found matching '%s'", params.Query),
+		}, nil
+	}
+	if err != nil {
+		return UpdateNoteResult{}, err
+	}
+
+	fields := noteFieldChanges(before, after)
+	message := fmt.Sprintf("Note '%s' updated%s", after.Title, inStore(store))
+	if len(fields) == 0 {
+		message = fmt.Sprintf("Note '%s' already had those values%s", after.Title, inStore(store))
+	}
+	return UpdateNoteResult{Success: true, Message: message, Store: store, NoteID: after.ID, Changes: fields}, nil
+}
+
+func (h *ToolHandler) restoreTaskTool() copilot.Tool {
+	return copilot.DefineTool(
+		"restore_task",
+		"Bring a deleted task back from the trash by ID or title match",
+		func(params RestoreTaskParams, inv copilot.ToolInvocation) (RestoreTaskResult, error) {
+			task, store, err := h.locateTask(params.Query, trashedTask)
+			if err == nil {
+				err = h.changeTask(store, task.ID, func(t *Task) {
+					t.DeletedAt = nil
+					t.UpdatedAt = time.Now()
+				})
+			}
+			if ambiguity, ok := ambiguityFrom(err); ok {
+				return RestoreTaskResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+			}
+			if errors.Is(err, errNotFound) {
+				return RestoreTaskResult{
 					Success: false,
-					Message: fmt.Sprintf("No note found matching '%s'", params.Query),
+					Message: fmt.Sprintf("No task in the trash matching '%s'", params.Query),
 				}, nil
 			}
+			if err != nil {
+				return RestoreTaskResult{Success: false, Message: err.Error()}, nil
+			}
 
-			noteList.Notes = append(noteList.Notes[:foundIndex], noteList.Notes[foundIndex+1:]...)
+			return RestoreTaskResult{
+				Success: true,
+				Message: fmt.Sprintf("Task '%s' restored from the trash%s", task.Title, inStore(store.name)),
+				Store:   store.name,
+			}, nil
+		},
+	)
+}
 
-			if err := h.storage.SaveNotes(noteList); err != nil {
-				return DeleteNoteResult{Success: false, Message: err.Error()}, nil
+func (h *ToolHandler) restoreNoteTool() copilot.Tool {
+	return copilot.DefineTool(
+		"restore_note",
+		"Bring a deleted note back from the trash by ID or title match",
+		func(params RestoreNoteParams, inv copilot.ToolInvocation) (RestoreNoteResult, error) {
+			note, store, err := h.locateNote(params.Query, trashedNote)
+			if err == nil {
+				err = h.changeNote(store, note.ID, func(n *Note) {
+					n.DeletedAt = nil
+					n.UpdatedAt = time.Now()
+				})
+			}
+			if ambiguity, ok := ambiguityFrom(err); ok {
+				return RestoreNoteResult{Success: false, Message: err.Error(), Ambiguity: ambiguity}, nil
+			}
+			if errors.Is(err, errNotFound) {
+				return RestoreNoteResult{
+					Success: false,
+					Message: fmt.Sprintf("No note in the trash matching '%s'", params.Query),
+				}, nil
+			}
+			if err != nil {
+				return RestoreNoteResult{Success: false, Message: err.Error()}, nil
+			}
+
+			return RestoreNoteResult{
+				Success: true,
+				Message: fmt.Sprintf("Note '%s' restored from the trash%s", note.Title, inStore(store.name)),
+				Store:   store.name,
+			}, nil
+		},
+	)
+}
+
+func (h *ToolHandler) undoLastChangeTool() copilot.Tool {
+	return copilot.DefineTool(
+		"undo_last_change",
+		"Undo the most recent change to tasks or notes, such as an accidental add, complete, or delete",
+		func(params UndoLastChangeParams, inv copilot.ToolInvocation) (UndoLastChangeResult, error) {
+			target := h.storage
+			if h.lastChanged != nil {
+				target = h.lastChanged
+			}
+			undoer, ok := target.(Undoer)
+			if !ok {
+				return UndoLastChangeResult{Success: false, Message: "Undo is not available"}, nil
+			}
+
+			reverted, err := undoer.Undo(1)
+			if errors.Is(err, errNothingToUndo) {
+				return UndoLastChangeResult{Success: false, Message: "There is nothing to undo"}, nil
+			}
+			if err != nil {
+				return UndoLastChangeResult{Success: false, Message: err.Error()}, nil
 			}
 
-			return DeleteNoteResult{
+			return UndoLastChangeResult{
 				Success: true,
-				Message: fmt.Sprintf("Note '%s' deleted", matchedTitle),
+				Message: fmt.Sprintf("Undid %s", reverted[0].Summary),
 			}, nil
 		},
 	)
 }
 
-func findTaskIndex(tasks []Task, query string) (int, string) {
-	return findIndexByIDOrTitle(query, len(tasks), func(i int) (string, string) {
-		return tasks[i].ID, tasks[i].Title
+func (h *ToolHandler) getProfileTool() copilot.Tool {
+	return copilot.DefineTool(
+		"get_profile",
+		"Report which profile (such as work or personal) is active and which profiles exist",
+		func(params GetProfileParams, inv copilot.ToolInvocation) (GetProfileResult, error) {
+			active := ActiveProfile()
+			profiles, err := ListProfiles()
+			if err != nil {
+				return GetProfileResult{Success: false, Profile: active, Message: err.Error()}, nil
+			}
+
+			return GetProfileResult{
+				Success:  true,
+				Profile:  active,
+				Profiles: profiles,
+				Message:  fmt.Sprintf("Active profile is '%s'", active),
+			}, nil
+		},
+	)
+}
+
+// validateTaskFields rejects due dates and priorities that 'kiki fsck' would flag
+func validateTaskFields(dueDate, priority *string) error {
+	if dueDate != nil {
+		if _, err := time.Parse(dateLayout, *dueDate); err != nil {
+			return fmt.Errorf("invalid due date '%s': use YYYY-MM-DD", *dueDate)
+		}
+	}
+	if priority != nil && !isValidPriority(*priority) {
+		return fmt.Errorf("invalid priority '%s': use low, medium or high", *priority)
+	}
+	return nil
+}
+
+// editTask applies changes to the task query resolves to in either store and returns it before and after, with its store
+func (h *ToolHandler) editTask(query string, changes TaskChanges) (Task, Task, string, error) {
+	return h.editMatchingTask(query, liveTask, changes)
+}
+
+// editMatchingTask is editTask limited to the tasks eligible accepts
+func (h *ToolHandler) editMatchingTask(query string, eligible func(Task) bool, changes TaskChanges) (Task, Task, string, error) {
+	if err := changes.Validate(); err != nil {
+		return Task{}, Task{}, "", err
+	}
+	task, store, err := h.locateTask(query, eligible)
+	if err != nil {
+		return Task{}, Task{}, "", err
+	}
+	before, after, err := store.repo.UpdateTask(task.ID, changes)
+	if err != nil {
+		return Task{}, Task{}, "", err
+	}
+	h.lastChanged = store.repo
+	return *before, *after, store.name, nil
+}
+
+// editNote is editTask for notes
+func (h *ToolHandler) editNote(query string, changes NoteChanges) (Note, Note, string, error) {
+	if err := changes.Validate(); err != nil {
+		return Note{}, Note{}, "", err
+	}
+	note, store, err := h.locateNote(query, liveNote)
+	if err != nil {
+		return Note{}, Note{}, "", err
+	}
+	before, after, err := store.repo.UpdateNote(note.ID, changes)
+	if err != nil {
+		return Note{}, Note{}, "", err
+	}
+	h.lastChanged = store.repo
+	return *before, *after, store.name, nil
+}
+
+// locateTask resolves query to one of the tasks eligible accepts in the
+// project and global stores
+func (h *ToolHandler) locateTask(query string, eligible func(Task) bool) (Task, namedStore, error) {
+	stores, err := h.scopedStores(scopeAll)
+	if err != nil {
+		return Task{}, namedStore{}, err
+	}
+	var candidates []MatchCandidate
+	var tasks []Task
+	var owners []namedStore
+	for _, store := range stores {
+		taskList, err := store.repo.LoadTasks()
+		if err != nil {
+			return Task{}, namedStore{}, err
+		}
+		found, indexes := taskCandidates(taskList.Tasks, store.name, eligible)
+		candidates = append(candidates, found...)
+		for _, i := range indexes {
+			tasks = append(tasks, taskList.Tasks[i])
+			owners = append(owners, store)
+		}
+	}
+
+	i, err := resolveMatch("task", query, candidates)
+	if err != nil {
+		return Task{}, namedStore{}, err
+	}
+	return tasks[i], owners[i], nil
+}
+
+// locateNote is locateTask for notes
+func (h *ToolHandler) locateNote(query string, eligible func(Note) bool) (Note, namedStore, error) {
+	stores, err := h.scopedStores(scopeAll)
+	if err != nil {
+		return Note{}, namedStore{}, err
+	}
+	var candidates []MatchCandidate
+	var notes []Note
+	var owners []namedStore
+	for _, store := range stores {
+		noteList, err := store.repo.LoadNotes()
+		if err != nil {
+			return Note{}, namedStore{}, err
+		}
+		found, indexes := noteCandidates(noteList.Notes, store.name, eligible)
+		candidates = append(candidates, found...)
+		for _, i := range indexes {
+			notes = append(notes, noteList.Notes[i])
+			owners = append(owners, store)
+		}
+	}
+
+	i, err := resolveMatch("note", query, candidates)
+	if err != nil {
+		return Note{}, namedStore{}, err
+	}
+	return notes[i], owners[i], nil
+}
+
+// changeTask applies fn to the task with id in store
+func (h *ToolHandler) changeTask(store namedStore, id string, fn func(*Task)) error {
+	err := store.repo.ModifyTasks(func(taskList *TaskList) error {
+		for i := range taskList.Tasks {
+			if taskList.Tasks[i].ID == id {
+				fn(&taskList.Tasks[i])
+				return nil
+			}
+		}
+		return errNotFound
 	})
+	if err == nil {
+		h.lastChanged = store.repo
+	}
+	return err
 }
 
-func findNoteIndex(notes []Note, query string) (int, string) {
-	return findIndexByIDOrTitle(query, len(notes), func(i int) (string, string) {
-		return notes[i].ID, notes[i].Title
+// changeNote is changeTask for notes
+func (h *ToolHandler) changeNote(store namedStore, id string, fn func(*Note)) error {
+	err := store.repo.ModifyNotes(func(noteList *NoteList) error {
+		for i := range noteList.Notes {
+			if noteList.Notes[i].ID == id {
+				fn(&noteList.Notes[i])
+				return nil
+			}
+		}
+		return errNotFound
 	})
+	if err == nil {
+		h.lastChanged = store.repo
+	}
+	return err
+}
+
+// findNote returns the note query resolves to and the name of its store
+func (h *ToolHandler) findNote(query string) (Note, string, error) {
+	note, store, err := h.locateNote(query, liveNote)
+	return note, store.name, err
+}
+
+// taskFieldChanges lists the fields an update changed, with their values before and after
+func taskFieldChanges(before, after Task) []FieldChange {
+	var changes []FieldChange
+	if before.Title != after.Title {
+		changes = append(changes, FieldChange{Field: "title", Before: before.Title, After: after.Title})
+	}
+	if !equalDates(before.DueDate, after.DueDate) {
+		changes = append(changes, FieldChange{Field: "due_date", Before: before.DueDate, After: after.DueDate})
+	}
+	if before.Priority != after.Priority {
+		changes = append(changes, FieldChange{Field: "priority", Before: before.Priority, After: after.Priority})
+	}
+	if before.Completed != after.Completed {
+		changes = append(changes, FieldChange{Field: "completed", Before: before.Completed, After: after.Completed})
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		changes = append(changes, FieldChange{Field: "tags", Before: before.Tags, After: after.Tags})
+	}
+	return changes
+}
+
+// noteFieldChanges is taskFieldChanges for notes, showing content as a preview
+func noteFieldChanges(before, after Note) []FieldChange {
+	var changes []FieldChange
+	if before.Title != after.Title {
+		changes = append(changes, FieldChange{Field: "title", Before: before.Title, After: after.Title})
+	}
+	if before.Content != after.Content {
+		changes = append(changes, FieldChange{
+			Field: "content", Before: notePreview(before.Content), After: notePreview(after.Content),
+		})
+	}
+	if !slices.Equal(before.Tags, after.Tags) {
+		changes = append(changes, FieldChange{Field: "tags", Before: before.Tags, After: after.Tags})
+	}
+	return changes
+}
+
+// namedStore is a repository labelled with the store it represents in tool results
+type namedStore struct {
+	name string
+	repo Repository
+}
+
+// activeStore names the store new items go to, or is empty outside a project
+func (h *ToolHandler) activeStore() string {
+	if h.global == nil {
+		return ""
+	}
+	return storeProject
 }
 
-func findIndexByIDOrTitle(query string, length int, accessor func(int) (string, string)) (int, string) {
-	queryLower := strings.ToLower(query)
-	for i := 0; i < length; i++ {
-		id, title := accessor(i)
-		if id == query || strings.Contains(strings.ToLower(title), queryLower) {
-			return i, title
+// scopedStores returns the stores a listing covers. Outside a project there is
+// only one store and the scope is ignored.
+func (h *ToolHandler) scopedStores(scope string) ([]namedStore, error) {
+	if h.global == nil {
+		return []namedStore{{repo: h.storage}}, nil
+	}
+	switch scope {
+	case "", storeProject:
+		return []namedStore{{name: storeProject, repo: h.storage}}, nil
+	case storeGlobal:
+		return []namedStore{{name: storeGlobal, repo: h.global}}, nil
+	case scopeAll:
+		return []namedStore{{name: storeProject, repo: h.storage}, {name: storeGlobal, repo: h.global}}, nil
+	default:
+		return nil, fmt.Errorf("unknown scope '%s': use project, global, or all", scope)
+	}
+}
+
+// inStore describes where an item lives for tool messages
+func inStore(store string) string {
+	if store == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s store)", store)
+}
+
+// taskMatchesFilter applies a list_tasks filter: all, today, incomplete or completed.
+// Unknown filters match every task.
+func taskMatchesFilter(t Task, filter string) bool {
+	switch filter {
+	case "today":
+		return isToday(t.DueDate) || isTodayTime(t.CreatedAt)
+	case "incomplete":
+		return !t.Completed
+	case "completed":
+		return t.Completed
+	default:
+		return true
+	}
+}
+
+// noteMatchesFilter applies a list_notes filter (all or today) and optional tag
+func noteMatchesFilter(n Note, filter string, tag *string) bool {
+	if filter == "today" && !isTodayTime(n.CreatedAt) {
+		return false
+	}
+	return tag == nil || hasTag(n.Tags, *tag)
+}
+
+// hasTag reports whether tags contains tag, ignoring case
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
 		}
 	}
-	return notFoundIndex, ""
+	return false
 }
 
-func noteSummaryFrom(note Note, number int) NoteSummary {
+func taskSummaryFrom(task Task, store string) TaskSummary {
+	return TaskSummary{
+		ShortID:   storeShortID(task.ShortID(), store),
+		ID:        task.ID,
+		Title:     task.Title,
+		Completed: task.Completed,
+		DueDate:   task.DueDate,
+		Priority:  task.Priority,
+		Tags:      task.Tags,
+		Store:     store,
+	}
+}
+
+func noteSummaryFrom(note Note) NoteSummary {
 	return NoteSummary{
-		Number:    number,
+		ShortID:   note.ShortID(),
 		ID:        note.ID,
 		Title:     note.Title,
 		Preview:   notePreview(note.Content),
@@ -459,9 +1441,15 @@ func noteSummaryFrom(note Note, number int) NoteSummary {
 	}
 }
 
+// notePreview returns the first notePreviewMax characters of content, never
+// cutting a multi-byte character in half
 func notePreview(content string) string {
-	if len(content) > notePreviewMax {
-		return content[:notePreviewMax] + "..."
+	count := 0
+	for i := range content {
+		if count == notePreviewMax {
+			return content[:i] + "..."
+		}
+		count++
 	}
 	return content
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTaskFilters(t *testing.T) {
+	today := time.Now().Format(dateLayout)
+	old := time.Now().AddDate(0, 0, -3)
+	tests := []struct {
+		name   string
+		task   Task
+		filter string
+		want   bool
+	}{
+		{"today matches a due date of today", Task{DueDate: &today, CreatedAt: old}, "today", true},
+		{"today skips older tasks", Task{CreatedAt: old}, "today", false},
+		{"incomplete skips completed tasks", Task{Completed: true}, "incomplete", false},
+		{"completed matches completed tasks", Task{Completed: true}, "completed", true},
+		{"unknown filters match everything", Task{}, "someday", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// act
+			got := taskMatchesFilter(tt.task, tt.filter)
+
+			// assert
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAddTask(t *testing.T) {
+	t.Run("rejects values fsck would flag", func(t *testing.T) {
+		priority, due := "urgent", "tomorrow"
+		cases := map[string]AddTaskParams{
+			"invalid priority 'urgent'":   {Title: "Ship it", Priority: &priority},
+			"invalid due date 'tomorrow'": {Title: "Ship it", DueDate: &due},
+			"the title must not be empty": {Title: "  "},
+		}
+		for want, params := range cases {
+			// arrange
+			repo := newTestJournaledRepository(t)
+			handler := NewToolHandler(repo, newTestLogger())
+
+			// act
+			_, err := handler.addTask(params)
+
+			// assert
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected %q, got %v", want, err)
+			}
+			if got := taskTitles(t, repo); len(got) != 0 {
+				t.Fatalf("expected no task to be added, got %v", got)
+			}
+		}
+	})
+}
+
+func TestEditTask(t *testing.T) {
+	t.Run("changes only the given fields", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		due := "2026-03-01"
+		if _, err := repo.AddTask("Write report", &due, "low", []string{"work"}); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		priority := "high"
+
+		// act
+		_, after, _, err := handler.editTask("report", TaskChanges{Priority: &priority, ClearDueDate: true})
+
+		// assert
+		if err != nil || after.Title != "Write report" {
+			t.Fatalf("unexpected edit result %q: %v", after.Title, err)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		got := tasks.Tasks[0]
+		if got.Priority != "high" || got.DueDate != nil || len(got.Tags) != 1 {
+			t.Fatalf("unexpected task after edit: %+v", got)
+		}
+	})
+
+	t.Run("rejects values fsck would flag", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		handler := NewToolHandler(repo, newTestLogger())
+		due := "next week"
+
+		// act
+		_, _, _, err := handler.editTask("anything", TaskChanges{DueDate: &due})
+
+		// assert
+		if err == nil {
+			t.Fatalf("expected an invalid due date to be rejected")
+		}
+	})
+}
+
+func TestUpdateTools(t *testing.T) {
+	t.Run("update_task reports before and after and clears fields", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		due := "2026-03-01"
+		task, err := repo.AddTask("Write report", &due, "high", []string{"work"})
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		title := "Write the quarterly report"
+
+		// act
+		result, err := handler.updateTask(UpdateTaskParams{
+			Query: task.ID, Title: &title, Clear: []string{"due_date", "tags", "priority"},
+		})
+
+		// assert
+		if err != nil || !result.Success {
+			t.Fatalf("unexpected update result %+v: %v", result, err)
+		}
+		fields := map[string]FieldChange{}
+		for _, c := range result.Changes {
+			fields[c.Field] = c
+		}
+		if len(fields) != 4 || fields["title"].Before != "Write report" || fields["priority"].After != "medium" {
+			t.Fatalf("unexpected changes: %+v", result.Changes)
+		}
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		got := tasks.Tasks[0]
+		if got.Title != title || got.DueDate != nil || len(got.Tags) != 0 || !got.UpdatedAt.After(task.UpdatedAt) {
+			t.Fatalf("unexpected task after update: %+v", got)
+		}
+	})
+
+	t.Run("update_task rejects clearing the title and unchanged values keep UpdatedAt", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		task, err := repo.AddTask("Write report", nil, "low", nil)
+		if err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		same := "low"
+
+		// act
+		_, clearErr := handler.updateTask(UpdateTaskParams{Query: "report", Clear: []string{"title"}})
+		result, err := handler.updateTask(UpdateTaskParams{Query: "report", Priority: &same})
+
+		// assert
+		if clearErr == nil {
+			t.Fatalf("expected clearing the title to be rejected")
+		}
+		if err != nil || !result.Success || len(result.Changes) != 0 {
+			t.Fatalf("expected no changes, got %+v: %v", result, err)
+		}
+		_, after, err := repo.UpdateTask(task.ID, TaskChanges{Priority: &same})
+		if err != nil || !after.UpdatedAt.Equal(task.UpdatedAt) {
+			t.Fatalf("expected UpdatedAt to stay %v, got %v: %v", task.UpdatedAt, after.UpdatedAt, err)
+		}
+	})
+
+	t.Run("update_note replaces content and undo restores it", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("Standup", "Old agenda", []string{"team"}); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		content := "New agenda"
+
+		// act
+		result, err := handler.updateNote(UpdateNoteParams{Query: "standup", Content: &content, Clear: []string{"tags"}})
+		if _, undoErr := repo.Undo(1); undoErr != nil {
+			t.Fatalf("failed to undo: %v", undoErr)
+		}
+
+		// assert
+		if err != nil || !result.Success || len(result.Changes) != 2 {
+			t.Fatalf("unexpected update result %+v: %v", result, err)
+		}
+		if result.Changes[0].Before != "Old agenda" || result.Changes[0].After != "New agenda" {
+			t.Fatalf("unexpected content change: %+v", result.Changes[0])
+		}
+		notes, err := repo.LoadNotes()
+		if err != nil {
+			t.Fatalf("failed to load notes: %v", err)
+		}
+		if notes.Notes[0].Content != "Old agenda" || len(notes.Notes[0].Tags) != 1 {
+			t.Fatalf("expected undo to restore the note, got %+v", notes.Notes[0])
+		}
+	})
+
+	t.Run("reopen_task only matches completed tasks", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("Deploy the API", nil, "high", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		if _, err := repo.AddTask("Deploy the docs", nil, "low", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+		if _, err := handler.completeTask(CompleteTaskParams{Query: "docs"}); err != nil {
+			t.Fatalf("failed to complete task: %v", err)
+		}
+
+		// act
+		result, err := handler.reopenTask(ReopenTaskParams{Query: "deploy"})
+		again, againErr := handler.reopenTask(ReopenTaskParams{Query: "deploy"})
+
+		// assert
+		if err != nil || !result.Success || result.Message != "Task 'Deploy the docs' reopened" {
+			t.Fatalf("unexpected reopen result %+v: %v", result, err)
+		}
+		if againErr != nil || again.Success {
+			t.Fatalf("expected nothing left to reopen, got %+v: %v", again, againErr)
+		}
+	})
+}
+
+func TestGetNote(t *testing.T) {
+	t.Run("pages through long notes on line breaks", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		line := strings.Repeat("é", 99) + "\n"
+		content := strings.Repeat(line, 100)
+		if _, err := repo.AddNote("API design", content, nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		var read strings.Builder
+		var chunks []GetNoteResult
+		params := GetNoteParams{Query: "api", Limit: 2550}
+		for {
+			result, err := handler.getNote(params)
+			if err != nil || !result.Success {
+				t.Fatalf("unexpected result %+v: %v", result, err)
+			}
+			chunks = append(chunks, result)
+			read.WriteString(result.Content)
+			if result.NextOffset == nil {
+				break
+			}
+			params.Offset = *result.NextOffset
+		}
+
+		// assert
+		if read.String() != content {
+			t.Fatalf("expected the chunks to add up to the whole note")
+		}
+		if len(chunks) != 4 || chunks[0].Length != 10000 || *chunks[0].NextOffset != 2500 {
+			t.Fatalf("expected 4 chunks ending on lines, got %d, first %+v", len(chunks), chunks[0].NextOffset)
+		}
+	})
+
+	t.Run("rejects an offset past the end", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("Short", "hi", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		_, err := handler.getNote(GetNoteParams{Query: "short", Offset: 3})
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "has 2 characters") {
+			t.Fatalf("expected an offset error, got %v", err)
+		}
+	})
+
+	t.Run("previews keep multi-byte characters whole", func(t *testing.T) {
+		// act
+		preview := notePreview("a" + strings.Repeat("日本", 100))
+
+		// assert
+		if !utf8.ValidString(preview) || utf8.RuneCountInString(preview) != notePreviewMax+len("...") {
+			t.Fatalf("unexpected preview %q", preview)
+		}
+	})
+}
+
+func TestSearchTools(t *testing.T) {
+	t.Run("search_tasks ranks the best matches and reports the rest", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"Review the deploy script", "Deploy", "Deploy docs", "Buy milk"} {
+			if _, err := repo.AddTask(title, nil, "low", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		result, err := handler.searchTasks(SearchTasksParams{Query: "deploy", Limit: 2})
+
+		// assert
+		if err != nil || result.Count != 2 || result.Message != "Found 3 tasks matching 'deploy', showing the best 2" {
+			t.Fatalf("unexpected result %+v: %v", result, err)
+		}
+		if result.Tasks[0].Title != "Deploy" || result.Tasks[0].ShortID != "t2" || result.Tasks[0].Snippet != "**Deploy**" {
+			t.Fatalf("expected the shortest title first, got %+v", result.Tasks[0])
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	trashKindTask = "task"
+	trashKindNote = "note"
+
+	defaultTrashRetentionDays = 30
+	hoursPerDay               = 24
+)
+
+// errNoChange aborts a storage modification that turned out to have nothing to do
+var errNoChange = errors.New("no change")
+
+// TrashItem is a deleted task or note waiting in the trash
+type TrashItem struct {
+	Kind      string
+	ID        string
+	ShortID   string
+	Title     string
+	DeletedAt time.Time
+}
+
+// trashRetention returns how long trashed items are kept before being purged,
+// from the trash_retention_days setting; 0 disables automatic purging.
+func trashRetention() time.Duration {
+	return time.Duration(appConfig.TrashRetentionDays) * hoursPerDay * time.Hour
+}
+
+// ListTrash returns every trashed task and note, most recently deleted first
+func ListTrash(repo Repository) ([]TrashItem, error) {
+	tasks, err := repo.LoadTasks()
+	if err != nil {
+		return nil, err
+	}
+	notes, err := repo.LoadNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TrashItem
+	for _, t := range tasks.Tasks {
+		if t.DeletedAt != nil {
+			items = append(items, TrashItem{
+				Kind: trashKindTask, ID: t.ID, ShortID: t.ShortID(), Title: t.Title, DeletedAt: *t.DeletedAt,
+			})
+		}
+	}
+	for _, n := range notes.Notes {
+		if n.DeletedAt != nil {
+			items = append(items, TrashItem{
+				Kind: trashKindNote, ID: n.ID, ShortID: n.ShortID(), Title: n.Title, DeletedAt: *n.DeletedAt,
+			})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// RestoreFromTrash brings back the trashed task or note query resolves to,
+// by ID, short ID or title
+func RestoreFromTrash(repo Repository, query string) (*TrashItem, error) {
+	items, err := ListTrash(repo)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]MatchCandidate, 0, len(items))
+	for _, item := range items {
+		candidates = append(candidates, MatchCandidate{ShortID: item.ShortID, ID: item.ID, Title: item.Title})
+	}
+	i, err := resolveMatch("item", query, candidates)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("nothing in the trash matches %q", query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	restored := items[i]
+	if restored.Kind == trashKindTask {
+		err = repo.ModifyTasks(func(tasks *TaskList) error {
+			for i := range tasks.Tasks {
+				if tasks.Tasks[i].ID == restored.ID {
+					tasks.Tasks[i].DeletedAt = nil
+					tasks.Tasks[i].UpdatedAt = time.Now()
+					return nil
+				}
+			}
+			return errNotFound
+		})
+	} else {
+		err = repo.ModifyNotes(func(notes *NoteList) error {
+			for i := range notes.Notes {
+				if notes.Notes[i].ID == restored.ID {
+					notes.Notes[i].DeletedAt = nil
+					notes.Notes[i].UpdatedAt = time.Now()
+					return nil
+				}
+			}
+			return errNotFound
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// EmptyTrash permanently removes trashed items deleted before cutoff.
+// A zero cutoff removes everything in the trash.
+func EmptyTrash(repo Repository, cutoff time.Time) (int, int, error) {
+	expired := func(deletedAt *time.Time) bool {
+		return deletedAt != nil && (cutoff.IsZero() || deletedAt.Before(cutoff))
+	}
+
+	purgedTasks := 0
+	err := repo.ModifyTasks(func(tasks *TaskList) error {
+		kept := tasks.Tasks[:0]
+		for _, t := range tasks.Tasks {
+			if expired(t.DeletedAt) {
+				purgedTasks++
+				continue
+			}
+			kept = append(kept, t)
+		}
+		if purgedTasks == 0 {
+			return errNoChange
+		}
+		tasks.Tasks = kept
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		return 0, 0, err
+	}
+
+	purgedNotes := 0
+	err = repo.ModifyNotes(func(notes *NoteList) error {
+		kept := notes.Notes[:0]
+		for _, n := range notes.Notes {
+			if expired(n.DeletedAt) {
+				purgedNotes++
+				continue
+			}
+			kept = append(kept, n)
+		}
+		if purgedNotes == 0 {
+			return errNoChange
+		}
+		notes.Notes = kept
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		return purgedTasks, 0, err
+	}
+	return purgedTasks, purgedNotes, nil
+}
+
+// PurgeExpiredTrash removes trashed items older than the configured retention period
+func PurgeExpiredTrash(repo Repository) (int, int, error) {
+	retention := trashRetention()
+	if retention == 0 {
+		return 0, 0, nil
+	}
+	return EmptyTrash(repo, time.Now().Add(-retention))
+}
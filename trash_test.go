@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func trashTask(t *testing.T, repo Repository, title string, deletedAt time.Time) {
+	t.Helper()
+	err := repo.ModifyTasks(func(tasks *TaskList) error {
+		for i := range tasks.Tasks {
+			if tasks.Tasks[i].Title == title && tasks.Tasks[i].DeletedAt == nil {
+				tasks.Tasks[i].DeletedAt = &deletedAt
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	if err != nil {
+		t.Fatalf("failed to trash task %q: %v", title, err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	t.Run("trashed tasks are skipped by lookups and listed in the trash", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddTask("Fix bug", nil, "", nil); err != nil {
+			t.Fatalf("failed to add task: %v", err)
+		}
+		trashTask(t, repo, "Fix bug", time.Now())
+		handler := NewToolHandler(repo, newTestLogger())
+
+		// act
+		_, _, liveErr := handler.locateTask("bug", liveTask)
+		trashed, _, trashErr := handler.locateTask("bug", trashedTask)
+		items, err := ListTrash(repo)
+
+		// assert
+		if err != nil || trashErr != nil {
+			t.Fatalf("expected nil errors, got %v and %v", err, trashErr)
+		}
+		if !errors.Is(liveErr, errNotFound) {
+			t.Fatalf("expected trashed task to be skipped, got %v", liveErr)
+		}
+		if trashed.Title != "Fix bug" {
+			t.Fatalf("expected the trashed task, got %+v", trashed)
+		}
+		if len(items) != 1 || items[0].Kind != trashKindTask || items[0].Title != "Fix bug" {
+			t.Fatalf("unexpected trash items: %+v", items)
+		}
+	})
+
+	t.Run("RestoreFromTrash brings back a note", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		if _, err := repo.AddNote("API", "OAuth", nil); err != nil {
+			t.Fatalf("failed to add note: %v", err)
+		}
+		err := repo.ModifyNotes(func(notes *NoteList) error {
+			now := time.Now()
+			notes.Notes[0].DeletedAt = &now
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to trash note: %v", err)
+		}
+
+		// act
+		item, err := RestoreFromTrash(repo, "api")
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if item.Kind != trashKindNote || item.Title != "API" {
+			t.Fatalf("unexpected restored item: %+v", item)
+		}
+		notes, err := repo.LoadNotes()
+		if err != nil {
+			t.Fatalf("failed to load notes: %v", err)
+		}
+		if notes.Notes[0].DeletedAt != nil {
+			t.Fatalf("expected note to be out of the trash")
+		}
+		history, err := repo.History(1)
+		if err != nil {
+			t.Fatalf("failed to read history: %v", err)
+		}
+		if history[0].Entry.Summary != "restore_note: API" {
+			t.Fatalf("expected restore_note journal entry, got %q", history[0].Entry.Summary)
+		}
+	})
+
+	t.Run("RestoreFromTrash fails when nothing matches", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+
+		// act
+		_, err := RestoreFromTrash(repo, "missing")
+
+		// assert
+		if err == nil || !strings.Contains(err.Error(), "nothing in the trash") {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	})
+
+	t.Run("EmptyTrash removes only items deleted before the cutoff", func(t *testing.T) {
+		// arrange
+		repo := newTestJournaledRepository(t)
+		for _, title := range []string{"old", "recent", "live"} {
+			if _, err := repo.AddTask(title, nil, "", nil); err != nil {
+				t.Fatalf("failed to add task: %v", err)
+			}
+		}
+		trashTask(t, repo, "old", time.Now().AddDate(0, 0, -40))
+		trashTask(t, repo, "recent", time.Now())
+
+		// act
+		purgedTasks, purgedNotes, err := EmptyTrash(repo, time.Now().AddDate(0, 0, -30))
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if purgedTasks != 1 || purgedNotes != 0 {
+			t.Fatalf("expected 1 task and 0 notes purged, got %d and %d", purgedTasks, purgedNotes)
+		}
+		if got := taskTitles(t, repo); !equalStrings(got, []string{"recent", "live"}) {
+			t.Fatalf("expected [recent live], got %v", got)
+		}
+	})
+}
+
+func TestTrashRetention(t *testing.T) {
+	t.Run("defaults to 30 days", func(t *testing.T) {
+		// arrange
+		keepAppConfig(t)
+		appConfig = defaultConfig()
+
+		// act
+		got := trashRetention()
+
+		// assert
+		if got != defaultTrashRetentionDays*hoursPerDay*time.Hour {
+			t.Fatalf("unexpected retention %v", got)
+		}
+	})
+
+	t.Run("follows the trash_retention_days setting", func(t *testing.T) {
+		// arrange
+		keepAppConfig(t)
+		newTestConfigFile(t, "trash_retention_days = \"7\"\n")
+		if err := loadAppConfig(); err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+
+		// act
+		got := trashRetention()
+
+		// assert
+		if got != 7*hoursPerDay*time.Hour {
+			t.Fatalf("expected 7 days, got %v", got)
+		}
+	})
+}
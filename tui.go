@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	paneTasks = iota
+	paneNotes
+)
+
+const (
+	modeBrowse = iota
+	// modeNote shows one note in full
+	modeNote
+	// modeInput gives the bottom bar the keyboard
+	modeInput
+)
+
+const (
+	inputPrompt = "prompt"
+	inputDue    = "due"
+	inputTag    = "tag"
+
+	tuiResponseLines = 4
+)
+
+const (
+	tuiBrowseHelp = "tab pane • j/k move • space done • p priority • d due • f filter • t tag • enter open • a ask • q quit"
+	tuiNoteHelp   = "j/k scroll • esc back"
+	tuiInputHelp  = "enter confirm • esc cancel"
+)
+
+// taskFilters are the list_tasks filters, in the order f cycles through them
+var taskFilters = []string{"incomplete", "today", "completed", "all"}
+
+var (
+	tuiTitleStyle    = lipgloss.NewStyle().Bold(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiDimStyle      = lipgloss.NewStyle().Faint(true)
+	tuiPaneStyle     = lipgloss.NewStyle().Border(lipgloss.RoundedBorder())
+	tuiFocusColor    = lipgloss.Color("12")
+)
+
+// storeChangedMsg reports a journaled change to tasks or notes
+type storeChangedMsg struct{}
+
+// responseChunkMsg is streamed output of a prompt
+type responseChunkMsg string
+
+// promptDoneMsg ends a prompt
+type promptDoneMsg struct{ err error }
+
+// chunkWriter hands what Kiki.Run streams to the TUI
+type chunkWriter chan<- tea.Msg
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	w <- responseChunkMsg(p)
+	return len(p), nil
+}
+
+// tuiModel is the state of 'kiki tui'. Browsing and editing go through a
+// ToolHandler, so they use the same filters and matching as the tools.
+type tuiModel struct {
+	tools *ToolHandler
+	// ask sends a prompt to Kiki.Run, streaming the response to out
+	ask func(prompt string, out io.Writer) error
+	// changes receives a value after every change to storage, including tool calls
+	changes <-chan struct{}
+
+	tasks      []TaskSummary
+	notes      []NoteSummary
+	taskFilter int
+	tag        string
+
+	pane      int
+	cursors   [2]int
+	mode      int
+	input     textinput.Model
+	inputKind string
+	viewer    viewport.Model
+	noteTitle string
+
+	response string
+	chunks   chan tea.Msg
+	busy     bool
+	status   string
+
+	width, height int
+}
+
+func newTUIModel(tools *ToolHandler, ask func(string, io.Writer) error, changes <-chan struct{}) *tuiModel {
+	input := textinput.New()
+	input.Prompt = ""
+	m := &tuiModel{tools: tools, ask: ask, changes: changes, input: input}
+	m.reload()
+	return m
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return waitForChange(m.changes)
+}
+
+func waitForChange(changes <-chan struct{}) tea.Cmd {
+	if changes == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		<-changes
+		return storeChangedMsg{}
+	}
+}
+
+func waitForChunk(chunks <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-chunks
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// reload lists tasks and notes with the current filter and tag
+func (m *tuiModel) reload() {
+	var tag *string
+	if m.tag != "" {
+		tag = &m.tag
+	}
+	tasks, err := m.tools.listTasks(ListTasksParams{Filter: taskFilters[m.taskFilter], Scope: scopeAll, Tag: tag})
+	if err != nil {
+		m.status = "❌ " + err.Error()
+		return
+	}
+	notes, err := m.tools.listNotes(ListNotesParams{Filter: "all", Tag: tag})
+	if err != nil {
+		m.status = "❌ " + err.Error()
+		return
+	}
+	m.tasks, m.notes = tasks.Tasks, notes.Notes
+	m.cursors[paneTasks] = clampCursor(m.cursors[paneTasks], len(m.tasks))
+	m.cursors[paneNotes] = clampCursor(m.cursors[paneNotes], len(m.notes))
+}
+
+func clampCursor(cursor, length int) int {
+	return max(0, min(cursor, length-1))
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.viewer.Width, m.viewer.Height = msg.Width, max(1, msg.Height-2)
+		return m, nil
+	case storeChangedMsg:
+		m.reload()
+		return m, waitForChange(m.changes)
+	case responseChunkMsg:
+		m.response += string(msg)
+		return m, waitForChunk(m.chunks)
+	case promptDoneMsg:
+		m.busy = false
+		if msg.err != nil {
+			m.status = "❌ " + msg.err.Error()
+		}
+		m.reload()
+		return m, nil
+	case tea.KeyMsg:
+		switch m.mode {
+		case modeInput:
+			return m.updateInput(msg)
+		case modeNote:
+			return m.updateNote(msg)
+		default:
+			return m.updateBrowse(msg)
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.mode == modeInput {
+		m.input, cmd = m.input.Update(msg)
+	}
+	return m, cmd
+}
+
+func (m *tuiModel) updateBrowse(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.status = ""
+	length := len(m.tasks)
+	if m.pane == paneNotes {
+		length = len(m.notes)
+	}
+
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "tab", "shift+tab":
+		m.pane = 1 - m.pane
+	case "up", "k":
+		m.cursors[m.pane] = clampCursor(m.cursors[m.pane]-1, length)
+	case "down", "j":
+		m.cursors[m.pane] = clampCursor(m.cursors[m.pane]+1, length)
+	case "f":
+		m.taskFilter = (m.taskFilter + 1) % len(taskFilters)
+		m.reload()
+	case "t":
+		return m, m.openInput(inputTag, "Tag (empty for all): ", m.tag)
+	case "r":
+		m.reload()
+	case "a", ":":
+		if m.busy {
+			m.status = "Kiki is still answering"
+			return m, nil
+		}
+		return m, m.openInput(inputPrompt, "Ask Kiki: ", "")
+	}
+
+	if m.pane == paneNotes {
+		if msg.String() == "enter" {
+			m.openNote()
+		}
+		return m, nil
+	}
+	task, ok := m.selectedTask()
+	if !ok {
+		return m, nil
+	}
+	switch msg.String() {
+	case " ", "x":
+		done := !task.Completed
+		m.editSelected(TaskChanges{Completed: &done})
+	case "p":
+		next := validPriorities[0]
+		for i, p := range validPriorities {
+			if p == task.Priority {
+				next = validPriorities[(i+1)%len(validPriorities)]
+			}
+		}
+		m.editSelected(TaskChanges{Priority: &next})
+	case "d":
+		due := ""
+		if task.DueDate != nil {
+			due = *task.DueDate
+		}
+		return m, m.openInput(inputDue, "Due date (YYYY-MM-DD, empty to clear): ", due)
+	}
+	return m, nil
+}
+
+func (m *tuiModel) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		m.closeInput()
+		return m, nil
+	case "enter":
+	default:
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+
+	value := strings.TrimSpace(m.input.Value())
+	kind := m.inputKind
+	m.closeInput()
+	switch kind {
+	case inputPrompt:
+		if value == "" {
+			return m, nil
+		}
+		m.busy, m.response = true, ""
+		m.chunks = make(chan tea.Msg)
+		return m, tea.Batch(m.runPrompt(value, m.chunks), waitForChunk(m.chunks))
+	case inputDue:
+		if value == "" {
+			m.editSelected(TaskChanges{ClearDueDate: true})
+		} else {
+			m.editSelected(TaskChanges{DueDate: &value})
+		}
+	case inputTag:
+		m.tag = value
+		m.reload()
+	}
+	return m, nil
+}
+
+func (m *tuiModel) updateNote(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "enter":
+		m.mode = modeBrowse
+		return m, nil
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	var cmd tea.Cmd
+	m.viewer, cmd = m.viewer.Update(msg)
+	return m, cmd
+}
+
+// runPrompt sends prompt to Kiki on its own goroutine, streaming into chunks
+func (m *tuiModel) runPrompt(prompt string, chunks chan tea.Msg) tea.Cmd {
+	ask := m.ask
+	return func() tea.Msg {
+		err := ask(prompt, chunkWriter(chunks))
+		close(chunks)
+		return promptDoneMsg{err: err}
+	}
+}
+
+func (m *tuiModel) openInput(kind, prompt, value string) tea.Cmd {
+	m.mode, m.inputKind = modeInput, kind
+	m.input.Prompt = prompt
+	m.input.SetValue(value)
+	m.input.CursorEnd()
+	return m.input.Focus()
+}
+
+func (m *tuiModel) closeInput() {
+	m.mode, m.inputKind = modeBrowse, ""
+	m.input.Blur()
+	m.input.SetValue("")
+}
+
+func (m *tuiModel) selectedTask() (TaskSummary, bool) {
+	if len(m.tasks) == 0 {
+		return TaskSummary{}, false
+	}
+	return m.tasks[m.cursors[paneTasks]], true
+}
+
+// editSelected applies changes to the selected task by its ID
+func (m *tuiModel) editSelected(changes TaskChanges) {
+	task, ok := m.selectedTask()
+	if !ok {
+		return
+	}
+	if _, _, _, err := m.tools.editTask(task.ID, changes); err != nil {
+		m.status = "❌ " + err.Error()
+		return
+	}
+	m.reload()
+}
+
+func (m *tuiModel) openNote() {
+	if len(m.notes) == 0 {
+		return
+	}
+	note, store, err := m.tools.findNote(m.notes[m.cursors[paneNotes]].ID)
+	if err != nil {
+		m.status = "❌ " + err.Error()
+		return
+	}
+	details := []string{"Created " + note.CreatedAt.Local().Format(historyTimeLayout)}
+	if len(note.Tags) > 0 {
+		details = append(details, "tags "+strings.Join(note.Tags, ", "))
+	}
+	if store != "" {
+		details = append(details, store+" store")
+	}
+	body := tuiDimStyle.Render(strings.Join(details, " • ")) + "\n\n" + note.Content
+	m.noteTitle = note.Title
+	m.viewer = viewport.New(m.width, max(1, m.height-2))
+	m.viewer.SetContent(lipgloss.NewStyle().Width(m.width).Render(body))
+	m.mode = modeNote
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 || m.height == 0 {
+		return ""
+	}
+	if m.mode == modeNote {
+		return lipgloss.JoinVertical(lipgloss.Left,
+			tuiTitleStyle.Render(ansi.Truncate("📝 "+m.noteTitle, m.width, "…")),
+			m.viewer.View(),
+			tuiDimStyle.Render(ansi.Truncate(tuiNoteHelp, m.width, "…")))
+	}
+
+	// Two border rows per pane, then the response, bottom bar and help
+	paneHeight := max(1, m.height-tuiResponseLines-4)
+	leftWidth := m.width / 2
+
+	taskRows := make([]string, len(m.tasks))
+	for i, t := range m.tasks {
+		taskRows[i] = taskRow(t)
+	}
+	noteRows := make([]string, len(m.notes))
+	for i, n := range m.notes {
+		noteRows[i] = noteRow(n)
+	}
+	title := "Tasks: " + taskFilters[m.taskFilter]
+	notesTitle := "Notes"
+	if m.tag != "" {
+		title += " #" + m.tag
+		notesTitle += " #" + m.tag
+	}
+	panes := lipgloss.JoinHorizontal(lipgloss.Top,
+		m.renderPane(title, taskRows, paneTasks, leftWidth, paneHeight),
+		m.renderPane(notesTitle, noteRows, paneNotes, m.width-leftWidth, paneHeight))
+
+	bar := m.status
+	if bar == "" && m.busy {
+		bar = tuiDimStyle.Render("Kiki is thinking...")
+	}
+	help := tuiBrowseHelp
+	if m.mode == modeInput {
+		bar, help = m.input.View(), tuiInputHelp
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		panes,
+		m.renderResponse(),
+		ansi.Truncate(bar, m.width, "…"),
+		tuiDimStyle.Render(ansi.Truncate(help, m.width, "…")))
+}
+
+// renderPane draws a bordered list, scrolled so the cursor stays visible
+func (m *tuiModel) renderPane(title string, rows []string, pane, width, height int) string {
+	inner := max(1, width-2)
+	visible := max(1, height-1)
+	cursor := m.cursors[pane]
+	start := max(0, cursor-visible+1)
+
+	lines := []string{tuiTitleStyle.Render(ansi.Truncate(title, inner, "…"))}
+	for i := start; i < len(rows) && i < start+visible; i++ {
+		row := ansi.Truncate(rows[i], inner, "…")
+		if i == cursor {
+			row = tuiSelectedStyle.Render(row + strings.Repeat(" ", max(0, inner-ansi.StringWidth(row))))
+		}
+		lines = append(lines, row)
+	}
+	if len(rows) == 0 {
+		lines = append(lines, tuiDimStyle.Render("nothing here"))
+	}
+
+	style := tuiPaneStyle.Width(inner).Height(height)
+	if pane == m.pane {
+		style = style.BorderForeground(tuiFocusColor)
+	}
+	return style.Render(strings.Join(lines, "\n"))
+}
+
+// renderResponse shows the end of Kiki's latest response
+func (m *tuiModel) renderResponse() string {
+	wrapped := strings.Split(lipgloss.NewStyle().Width(m.width).Render(strings.TrimSpace(m.response)), "\n")
+	if len(wrapped) > tuiResponseLines {
+		wrapped = wrapped[len(wrapped)-tuiResponseLines:]
+	}
+	for len(wrapped) < tuiResponseLines {
+		wrapped = append(wrapped, "")
+	}
+	return strings.Join(wrapped, "\n")
+}
+
+func taskRow(t TaskSummary) string {
+	check := " "
+	if t.Completed {
+		check = "x"
+	}
+	row := fmt.Sprintf("%s [%s] %s  %s", t.ShortID, check, t.Title, t.Priority)
+	if t.DueDate != nil {
+		row += "  due " + *t.DueDate
+	}
+	for _, tag := range t.Tags {
+		row += " #" + tag
+	}
+	if t.Store == storeGlobal {
+		row += "  (global)"
+	}
+	return row
+}
+
+func noteRow(n NoteSummary) string {
+	row := n.ShortID + " " + n.Title + "  " + n.CreatedAt
+	for _, tag := range n.Tags {
+		row += " #" + tag
+	}
+	return row
+}
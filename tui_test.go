@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func tuiKey(key string) tea.KeyMsg {
+	switch key {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "tab":
+		return tea.KeyMsg{Type: tea.KeyTab}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+}
+
+// pressKeys sends keys to the model, typing any key longer than one rune as text
+func pressKeys(m *tuiModel, keys ...string) tea.Cmd {
+	var cmd tea.Cmd
+	for _, key := range keys {
+		_, cmd = m.Update(tuiKey(key))
+	}
+	return cmd
+}
+
+// runTUICmd runs cmd and every command it leads to, feeding their messages to m
+func runTUICmd(m *tuiModel, cmd tea.Cmd) {
+	msgs := make(chan tea.Msg)
+	pending := 0
+	launch := func(c tea.Cmd) {
+		if c == nil {
+			return
+		}
+		pending++
+		go func() { msgs <- c() }()
+	}
+	launch(cmd)
+	for pending > 0 {
+		msg := <-msgs
+		pending--
+		switch msg := msg.(type) {
+		case nil:
+		case tea.BatchMsg:
+			for _, c := range msg {
+				launch(c)
+			}
+		default:
+			_, next := m.Update(msg)
+			launch(next)
+		}
+	}
+}
+
+func newTestTUI(t *testing.T, ask func(string, io.Writer) error) (*tuiModel, Repository) {
+	t.Helper()
+	repo := newTestJournaledRepository(t)
+	tools := NewToolHandler(repo, newTestLogger())
+	if _, err := tools.addTask(AddTaskParams{Title: "Write report", Tags: []string{"work"}}); err != nil {
+		t.Fatalf("failed to add task: %v", err)
+	}
+	if _, err := tools.addTask(AddTaskParams{Title: "Water plants", Tags: []string{"home"}}); err != nil {
+		t.Fatalf("failed to add task: %v", err)
+	}
+	if _, err := tools.addNote(AddNoteParams{Title: "API auth", Content: "Uses OAuth 2.0", Tags: []string{"work"}}); err != nil {
+		t.Fatalf("failed to add note: %v", err)
+	}
+	m := newTUIModel(NewToolHandler(repo, newTestLogger()), ask, nil)
+	m.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	return m, repo
+}
+
+func TestTUIEditing(t *testing.T) {
+	t.Run("toggles completion and edits priority and due date", func(t *testing.T) {
+		// arrange
+		m, repo := newTestTUI(t, nil)
+
+		// act
+		pressKeys(m, "p", "d", "2026-03-01", "enter", "x")
+
+		// assert
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		got := tasks.Tasks[0]
+		if !got.Completed || got.Priority != "high" || got.DueDate == nil || *got.DueDate != "2026-03-01" {
+			t.Fatalf("unexpected task: %+v", got)
+		}
+		if len(m.tasks) != 1 || m.tasks[0].Title != "Water plants" {
+			t.Fatalf("expected the completed task to leave the incomplete list, got %+v", m.tasks)
+		}
+	})
+
+	t.Run("rejects a malformed due date", func(t *testing.T) {
+		// arrange
+		m, repo := newTestTUI(t, nil)
+
+		// act
+		pressKeys(m, "d", "soon", "enter")
+
+		// assert
+		tasks, err := repo.LoadTasks()
+		if err != nil {
+			t.Fatalf("failed to load tasks: %v", err)
+		}
+		if tasks.Tasks[0].DueDate != nil || !strings.Contains(m.status, "invalid due date") {
+			t.Fatalf("expected the due date to be rejected, status %q", m.status)
+		}
+	})
+}
+
+func TestTUIBrowsing(t *testing.T) {
+	t.Run("filters by tag and opens a note", func(t *testing.T) {
+		// arrange
+		m, _ := newTestTUI(t, nil)
+
+		// act
+		pressKeys(m, "t", "work", "enter", "tab", "enter")
+
+		// assert
+		if len(m.tasks) != 1 || m.tasks[0].Title != "Write report" || len(m.notes) != 1 {
+			t.Fatalf("unexpected tag filtering: %+v %+v", m.tasks, m.notes)
+		}
+		if m.mode != modeNote || !strings.Contains(m.View(), "Uses OAuth 2.0") {
+			t.Fatalf("expected the note viewer, got:\n%s", m.View())
+		}
+	})
+
+	t.Run("cycles through the list_tasks filters", func(t *testing.T) {
+		// arrange
+		m, _ := newTestTUI(t, nil)
+		pressKeys(m, "x")
+
+		// act
+		pressKeys(m, "f", "f")
+
+		// assert
+		if taskFilters[m.taskFilter] != "completed" || len(m.tasks) != 1 || !m.tasks[0].Completed {
+			t.Fatalf("unexpected tasks for %s: %+v", taskFilters[m.taskFilter], m.tasks)
+		}
+	})
+}
+
+func TestTUIPrompt(t *testing.T) {
+	t.Run("streams the response and refreshes the lists", func(t *testing.T) {
+		// arrange
+		var m *tuiModel
+		m, _ = newTestTUI(t, func(prompt string, out io.Writer) error {
+			if _, err := m.tools.addTask(AddTaskParams{Title: "Buy milk"}); err != nil {
+				return err
+			}
+			_, err := fmt.Fprint(out, "Added, ", "obviously.")
+			return err
+		})
+
+		// act
+		runTUICmd(m, pressKeys(m, "a", "add task: buy milk", "enter"))
+
+		// assert
+		if m.busy || m.response != "Added, obviously." {
+			t.Fatalf("unexpected response %q (busy %v)", m.response, m.busy)
+		}
+		if len(m.tasks) != 3 {
+			t.Fatalf("expected the new task to be listed, got %+v", m.tasks)
+		}
+	})
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	vaultFile       = "encryption.json"
+	vaultVersion    = 1
+	vaultKDF        = "pbkdf2-sha256"
+	vaultKeyLength  = 32
+	vaultSaltLength = 16
+
+	// sealedPrefix marks encrypted data; the rest of the line is base64 of nonce and ciphertext
+	sealedPrefix = "kiki-encrypted:v1:"
+	vaultCheck   = "kiki"
+
+	keyCacheDirPerm     = 0o700
+	keyCacheFilePerm    = 0o600
+	keyCacheExt         = ".key"
+	defaultKeyCacheMins = 15
+)
+
+// vaultKDFIterations is the PBKDF2 work factor for new vaults
+var vaultKDFIterations = 600_000
+
+var (
+	errWrongPassphrase = errors.New("wrong passphrase")
+	errVaultEnabled    = errors.New("data is already encrypted")
+	errVaultDisabled   = errors.New("data is not encrypted")
+	errEmptyPassphrase = errors.New("passphrase must not be empty")
+	errDamagedData     = errors.New("encrypted data is damaged")
+)
+
+// vaultParams is stored in encryption.json. It holds no secrets: the salt and
+// work factor to derive the key, and a sealed value to check a passphrase against.
+type vaultParams struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Check      string `json:"check"`
+}
+
+// keyCacheEntry is an unlocked key cached outside the data directory
+type keyCacheEntry struct {
+	Key       []byte    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Vault encrypts data files at rest with AES-256-GCM under a key derived from a
+// passphrase. While encryption is off it passes data through unchanged.
+type Vault struct {
+	dir string
+	key []byte
+}
+
+// NewVault returns the vault for the kiki config directory
+func NewVault() *Vault {
+	return newVaultAt(GetConfigDir())
+}
+
+func newVaultAt(dir string) *Vault {
+	return &Vault{dir: dir}
+}
+
+// Enabled reports whether data files are encrypted
+func (v *Vault) Enabled() bool {
+	_, err := os.Stat(filepath.Join(v.dir, vaultFile))
+	return err == nil
+}
+
+// Seal encrypts data when encryption is enabled and returns it unchanged otherwise
+func (v *Vault) Seal(data []byte) ([]byte, error) {
+	if !v.Enabled() {
+		return data, nil
+	}
+	if err := v.unlock(); err != nil {
+		return nil, err
+	}
+	return sealWithKey(v.key, data)
+}
+
+// Open decrypts sealed data and returns plaintext data unchanged, so encrypted
+// and plaintext files can be read the same way
+func (v *Vault) Open(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if err := v.unlock(); err != nil {
+		return nil, err
+	}
+	return openWithKey(v.key, data)
+}
+
+// Lock forgets the unlocked key, including any cached copy
+func (v *Vault) Lock() error {
+	v.key = nil
+	if err := os.Remove(v.keyCachePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached key: %w", err)
+	}
+	return nil
+}
+
+// create sets up encryption with a key derived from passphrase
+func (v *Vault) create(passphrase string) error {
+	if passphrase == "" {
+		return errEmptyPassphrase
+	}
+	salt := make([]byte, vaultSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	params := vaultParams{Version: vaultVersion, KDF: vaultKDF, Iterations: vaultKDFIterations, Salt: salt}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return err
+	}
+	check, err := sealWithKey(key, []byte(vaultCheck))
+	if err != nil {
+		return err
+	}
+	params.Check = string(check)
+
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", vaultFile, err)
+	}
+	if err := writeFileAtomic(filepath.Join(v.dir, vaultFile), data, dataFilePerm); err != nil {
+		return err
+	}
+	v.key = key
+	v.cacheKey()
+	return nil
+}
+
+// unlock makes the key available, from memory, the key cache or the passphrase
+func (v *Vault) unlock() error {
+	if v.key != nil {
+		return nil
+	}
+	params, err := v.params()
+	if err != nil {
+		return err
+	}
+	if key := v.cachedKey(); key != nil && checkKey(key, params) {
+		v.key = key
+		return nil
+	}
+
+	passphrase, err := readPassphrase("🔑 Passphrase: ")
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return err
+	}
+	if !checkKey(key, params) {
+		return errWrongPassphrase
+	}
+	v.key = key
+	v.cacheKey()
+	return nil
+}
+
+func (v *Vault) params() (vaultParams, error) {
+	var params vaultParams
+	data, err := os.ReadFile(filepath.Join(v.dir, vaultFile))
+	if err != nil {
+		return params, fmt.Errorf("failed to read %s: %w", vaultFile, err)
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return params, fmt.Errorf("failed to parse %s: %w", vaultFile, err)
+	}
+	if params.Version != vaultVersion || params.KDF != vaultKDF {
+		return params, fmt.Errorf("unsupported encryption version %d (%s)", params.Version, params.KDF)
+	}
+	return params, nil
+}
+
+// keyCachePath names the cached key after the data directory, outside of it
+// so that file-sync tools and git never see it
+func (v *Vault) keyCachePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(v.dir))
+	return filepath.Join(cacheDir, kikiDir, hex.EncodeToString(sum[:8])+keyCacheExt)
+}
+
+func (v *Vault) cachedKey() []byte {
+	data, err := os.ReadFile(v.keyCachePath())
+	if err != nil {
+		return nil
+	}
+	var entry keyCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		_ = os.Remove(v.keyCachePath())
+		return nil
+	}
+	return entry.Key
+}
+
+// cacheKey keeps the unlocked key for the key_cache_minutes setting; failures
+// only mean the passphrase is asked for again
+func (v *Vault) cacheKey() {
+	minutes := appConfig.KeyCacheMinutes
+	if minutes == 0 {
+		return
+	}
+	entry := keyCacheEntry{Key: v.key, ExpiresAt: time.Now().Add(time.Duration(minutes) * time.Minute)}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	path := v.keyCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), keyCacheDirPerm); err != nil {
+		return
+	}
+	_ = writeFileAtomic(path, data, keyCacheFilePerm)
+}
+
+func deriveKey(passphrase string, params vaultParams) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, params.Salt, params.Iterations, vaultKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+func checkKey(key []byte, params vaultParams) bool {
+	plain, err := openWithKey(key, []byte(params.Check))
+	return err == nil && string(plain) == vaultCheck
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedPrefix))
+}
+
+func sealWithKey(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return append([]byte(sealedPrefix), base64.StdEncoding.EncodeToString(sealed)...), nil
+}
+
+func openWithKey(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(sealedPrefix):])))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed encoding", errDamagedData)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDamagedData, err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptResult lists what encrypting or decrypting converted
+type EncryptResult struct {
+	Files     []string
+	Snapshots int
+	GitWarned bool
+}
+
+// EncryptData turns on encryption with a key derived from passphrase and
+// encrypts the data files, journal, schema backups and snapshots in place
+func EncryptData(logger *slog.Logger, passphrase string) (*EncryptResult, error) {
+	storage, err := vaultStorage(logger)
+	if err != nil {
+		return nil, err
+	}
+	if storage.vault.Enabled() {
+		return nil, errVaultEnabled
+	}
+	err = storage.withLock(func() error { return storage.vault.create(passphrase) })
+	if err != nil {
+		return nil, err
+	}
+	return convertVaultFiles(storage, storage.vault.Seal)
+}
+
+// DecryptData writes every encrypted file back as plaintext and turns encryption off
+func DecryptData(logger *slog.Logger) (*EncryptResult, error) {
+	storage, err := vaultStorage(logger)
+	if err != nil {
+		return nil, err
+	}
+	if !storage.vault.Enabled() {
+		return nil, errVaultDisabled
+	}
+	if err := storage.vault.unlock(); err != nil {
+		return nil, err
+	}
+	plaintext := func(data []byte) ([]byte, error) { return data, nil }
+	result, err := convertVaultFiles(storage, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	// Only drop the key parameters once nothing depends on them
+	if err := storage.vault.Lock(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(filepath.Join(storage.basePath, vaultFile)); err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %w", vaultFile, err)
+	}
+	return result, nil
+}
+
+func vaultStorage(logger *slog.Logger) (*Storage, error) {
+	if activeBackend() == backendSQLite {
+		return nil, fmt.Errorf("encryption needs the JSON backend, but data is stored in %s", sqliteFile)
+	}
+	return NewStorage(logger)
+}
+
+// convertVaultFiles rewrites every file that holds tasks or notes, decrypting
+// its contents and passing them through seal
+func convertVaultFiles(storage *Storage, seal func([]byte) ([]byte, error)) (*EncryptResult, error) {
+	vault := storage.vault
+	convert := func(data []byte) ([]byte, error) {
+		plain, err := vault.Open(data)
+		if err != nil {
+			return nil, err
+		}
+		return seal(plain)
+	}
+	result := &EncryptResult{GitWarned: NewGitStore(storage.logger).Enabled()}
+
+	// The search index is rebuilt by the next search, sealed or not as the data is
+	if err := os.Remove(filepath.Join(storage.basePath, searchIndexFile)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove %s: %w", searchIndexFile, err)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(storage.basePath, "*.bak"))
+	if err != nil {
+		return nil, err
+	}
+	err = storage.withLock(func() error {
+		for _, path := range append([]string{
+			filepath.Join(storage.basePath, tasksFile),
+			filepath.Join(storage.basePath, notesFile),
+		}, backups...) {
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+			}
+			if data, err = convert(data); err != nil {
+				return fmt.Errorf("%s: %w", filepath.Base(path), err)
+			}
+			if err := writeFileAtomic(path, data, dataFilePerm); err != nil {
+				return err
+			}
+			result.Files = append(result.Files, filepath.Base(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	journal := NewJournal()
+	journal.vault = vault
+	converted, err := journal.rewrite(convert)
+	if err != nil {
+		return nil, err
+	}
+	if converted {
+		result.Files = append(result.Files, journalFile)
+	}
+
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if err := rewriteSnapshot(snapshot.Path, convert); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", snapshot.ID, err)
+		}
+		result.Snapshots++
+	}
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestVaultRepository(t *testing.T) *JournaledRepository {
+	t.Helper()
+	repo := newTestJournaledRepository(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv(passphraseEnv, "correct horse")
+	iterations := vaultKDFIterations
+	vaultKDFIterations = 1000
+	t.Cleanup(func() { vaultKDFIterations = iterations })
+
+	if _, err := repo.AddNote("Infra", "db password hunter2", nil); err != nil {
+		t.Fatalf("failed to add note: %v", err)
+	}
+	if _, err := CreateSnapshot(repo); err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	return repo
+}
+
+func TestVault(t *testing.T) {
+	t.Run("EncryptData encrypts every copy of the data and reads it back", func(t *testing.T) {
+		// arrange
+		newTestVaultRepository(t)
+
+		// act
+		result, err := EncryptData(newTestLogger(), "correct horse")
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if result.Snapshots != 1 {
+			t.Fatalf("expected 1 snapshot converted, got %d", result.Snapshots)
+		}
+		for _, name := range []string{notesFile, journalFile} {
+			data, err := os.ReadFile(filepath.Join(GetConfigDir(), name))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+			if bytes.Contains(data, []byte("hunter2")) {
+				t.Fatalf("expected %s to be encrypted", name)
+			}
+		}
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		notes, err := storage.LoadNotes()
+		if err != nil {
+			t.Fatalf("failed to load notes: %v", err)
+		}
+		if notes.Notes[0].Content != "db password hunter2" {
+			t.Fatalf("unexpected note content %q", notes.Notes[0].Content)
+		}
+		snapshots, err := ListSnapshots()
+		if err != nil {
+			t.Fatalf("failed to list snapshots: %v", err)
+		}
+		if _, snapNotes, err := ReadSnapshot(&snapshots[0]); err != nil || len(snapNotes.Notes) != 1 {
+			t.Fatalf("expected snapshot to read back, got %v", err)
+		}
+		entries, err := NewJournal().Entries()
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("expected 1 journal entry, got %d: %v", len(entries), err)
+		}
+	})
+
+	t.Run("a wrong passphrase is rejected once the cached key is gone", func(t *testing.T) {
+		// arrange
+		newTestVaultRepository(t)
+		if _, err := EncryptData(newTestLogger(), "correct horse"); err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+		if err := NewVault().Lock(); err != nil {
+			t.Fatalf("failed to lock: %v", err)
+		}
+		t.Setenv(passphraseEnv, "wrong")
+		storage, err := NewStorage(newTestLogger())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+
+		// act
+		_, err = storage.LoadNotes()
+
+		// assert
+		if !errors.Is(err, errWrongPassphrase) {
+			t.Fatalf("expected errWrongPassphrase, got %v", err)
+		}
+	})
+
+	t.Run("DecryptData restores plaintext and turns encryption off", func(t *testing.T) {
+		// arrange
+		newTestVaultRepository(t)
+		if _, err := EncryptData(newTestLogger(), "correct horse"); err != nil {
+			t.Fatalf("failed to encrypt: %v", err)
+		}
+
+		// act
+		_, err := DecryptData(newTestLogger())
+
+		// assert
+		if err != nil {
+			t.Fatalf("expected nil error, got %v", err)
+		}
+		if NewVault().Enabled() {
+			t.Fatalf("expected encryption to be off")
+		}
+		data, err := os.ReadFile(filepath.Join(GetConfigDir(), notesFile))
+		if err != nil {
+			t.Fatalf("failed to read notes: %v", err)
+		}
+		if !bytes.Contains(data, []byte("hunter2")) {
+			t.Fatalf("expected notes.json to be plaintext again")
+		}
+	})
+}